@@ -0,0 +1,589 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/fatih/color"
+	"github.com/urfave/cli/v2"
+
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/nbd-wtf/go-nostr/nip19"
+	"github.com/nbd-wtf/go-nostr/sdk"
+)
+
+// profileBadgesID is the d tag of the kind 30008 profile badges event.
+const profileBadgesID = "profile_badges"
+
+// badgeDefinitionOpts captures everything buildBadgeDefinitionEvent needs.
+type badgeDefinitionOpts struct {
+	ID          string
+	Name        string
+	Description string
+	Image       string
+	ImageDim    string
+	Thumbs      []string // "url" or "url dimensions"
+}
+
+// buildBadgeDefinitionEvent constructs an unsigned kind 30009 (NIP-58) badge
+// definition.
+func buildBadgeDefinitionEvent(pubkey string, opts badgeDefinitionOpts, createdAt nostr.Timestamp) (*nostr.Event, error) {
+	if opts.ID == "" {
+		return nil, errors.New("badge id is required")
+	}
+	if strings.ContainsAny(opts.ID, ": ") {
+		return nil, fmt.Errorf("invalid badge id '%s'", opts.ID)
+	}
+	ev := &nostr.Event{
+		PubKey:    pubkey,
+		CreatedAt: createdAt,
+		Kind:      nostr.KindBadgeDefinition,
+		Tags:      nostr.Tags{{"d", opts.ID}},
+	}
+	clientTag(ev)
+	if opts.Name != "" {
+		ev.Tags = append(ev.Tags, nostr.Tag{"name", opts.Name})
+	}
+	if opts.Description != "" {
+		ev.Tags = append(ev.Tags, nostr.Tag{"description", opts.Description})
+	}
+	if opts.Image != "" {
+		tag := nostr.Tag{"image", opts.Image}
+		if opts.ImageDim != "" {
+			tag = append(tag, opts.ImageDim)
+		}
+		ev.Tags = append(ev.Tags, tag)
+	}
+	for _, thumb := range opts.Thumbs {
+		ev.Tags = append(ev.Tags, append(nostr.Tag{"thumb"}, strings.Fields(thumb)...))
+	}
+	return ev, nil
+}
+
+// buildBadgeAwardEvent constructs an unsigned kind 8 award of the badge at ep
+// to the given pubkeys. Only the badge's author can award it.
+func buildBadgeAwardEvent(pubkey string, ep *nostr.EntityPointer, awardees []string, createdAt nostr.Timestamp) (*nostr.Event, error) {
+	if ep.Kind != nostr.KindBadgeDefinition {
+		return nil, fmt.Errorf("kind %d is not a badge definition", ep.Kind)
+	}
+	if ep.PublicKey != pubkey {
+		return nil, errors.New("only the badge's author can award it")
+	}
+	if len(awardees) == 0 {
+		return nil, errors.New("no one to award")
+	}
+	ev := &nostr.Event{
+		PubKey:    pubkey,
+		CreatedAt: createdAt,
+		Kind:      nostr.KindBadgeAward,
+		Tags:      nostr.Tags{{"a", addressValue(ep)}},
+	}
+	clientTag(ev)
+	for _, p := range awardees {
+		ev.Tags = ev.Tags.AppendUnique(nostr.Tag{"p", p})
+	}
+	return ev, nil
+}
+
+// profileBadge is one entry of a profile badges event: a badge definition
+// address paired with the award event that granted it.
+type profileBadge struct {
+	Address string `json:"a"`
+	AwardID string `json:"e"`
+	Relay   string `json:"relay,omitempty"`
+}
+
+// parseProfileBadges reads the consecutive a/e tag pairs of a kind 30008
+// event. Unpaired tags are ignored, as NIP-58 requires.
+func parseProfileBadges(ev *nostr.Event) []profileBadge {
+	var out []profileBadge
+	for i := 0; i+1 < len(ev.Tags); i++ {
+		a, e := ev.Tags[i], ev.Tags[i+1]
+		if len(a) < 2 || len(e) < 2 || a[0] != "a" || e[0] != "e" {
+			continue
+		}
+		pb := profileBadge{Address: a[1], AwardID: e[1]}
+		if len(e) >= 3 {
+			pb.Relay = e[2]
+		}
+		out = append(out, pb)
+		i++
+	}
+	return out
+}
+
+// buildProfileBadgesEvent constructs an unsigned kind 30008 event displaying
+// badges in order. Tags of prev other than the badge pairs are kept.
+func buildProfileBadgesEvent(pubkey string, prev *nostr.Event, badges []profileBadge, createdAt nostr.Timestamp) *nostr.Event {
+	ev := &nostr.Event{
+		PubKey:    pubkey,
+		CreatedAt: createdAt,
+		Kind:      nostr.KindProfileBadges,
+		Tags:      nostr.Tags{{"d", profileBadgesID}},
+	}
+	clientTag(ev)
+	if prev != nil {
+		ev.Content = prev.Content
+		for _, tag := range prev.Tags {
+			if len(tag) == 0 {
+				continue
+			}
+			switch tag[0] {
+			case "d", "a", "e", "client":
+				continue
+			}
+			ev.Tags = append(ev.Tags, tag)
+		}
+	}
+	for _, b := range badges {
+		ev.Tags = append(ev.Tags, nostr.Tag{"a", b.Address})
+		if b.Relay != "" {
+			ev.Tags = append(ev.Tags, nostr.Tag{"e", b.AwardID, b.Relay})
+		} else {
+			ev.Tags = append(ev.Tags, nostr.Tag{"e", b.AwardID})
+		}
+	}
+	return ev
+}
+
+// validAward reports whether award is a kind 8 event by the author of the
+// badge at address that names pubkey as a recipient.
+func validAward(award *nostr.Event, address, pubkey string) bool {
+	if award == nil || award.Kind != nostr.KindBadgeAward {
+		return false
+	}
+	parts := strings.SplitN(address, ":", 3)
+	if len(parts) != 3 || parts[1] != award.PubKey {
+		return false
+	}
+	if a := award.Tags.Find("a"); a == nil || a[1] != address {
+		return false
+	}
+	for p := range award.Tags.FindAll("p") {
+		if p[1] == pubkey {
+			return true
+		}
+	}
+	return false
+}
+
+// fetchProfileBadges returns the latest kind 30008 event of pubkey, or nil.
+func fetchProfileBadges(ctx context.Context, cfg *Config, pubkey string) (*nostr.Event, error) {
+	evs, err := cfg.QueryEvents(ctx, nostr.Filters{{
+		Kinds:   []int{nostr.KindProfileBadges},
+		Authors: []string{pubkey},
+		Tags:    nostr.TagMap{"d": []string{profileBadgesID}},
+	}})
+	if err != nil {
+		return nil, err
+	}
+	var latest *nostr.Event
+	for _, ev := range evs {
+		if latest == nil || ev.CreatedAt > latest.CreatedAt {
+			latest = ev
+		}
+	}
+	return latest, nil
+}
+
+// displayedBadge is a verified badge with its definition.
+type displayedBadge struct {
+	profileBadge
+	Definition *nostr.Event `json:"definition"`
+}
+
+func (b *displayedBadge) name() string {
+	if name := tagValue(b.Definition.Tags, "name"); name != "" {
+		return name
+	}
+	return tagValue(b.Definition.Tags, "d")
+}
+
+// fetchDisplayedBadges returns the badges pubkey displays, dropping those
+// whose award or definition cannot be found or does not check out.
+func fetchDisplayedBadges(ctx context.Context, cfg *Config, pubkey string) ([]displayedBadge, error) {
+	pev, err := fetchProfileBadges(ctx, cfg, pubkey)
+	if err != nil || pev == nil {
+		return nil, err
+	}
+	badges := parseProfileBadges(pev)
+	if len(badges) == 0 {
+		return nil, nil
+	}
+
+	ids := make([]string, 0, len(badges))
+	var eps []*nostr.EntityPointer
+	for _, b := range badges {
+		ids = append(ids, b.AwardID)
+		if ep, err := nostr.EntityPointerFromTag(nostr.Tag{"a", b.Address}); err == nil {
+			eps = append(eps, &ep)
+		}
+	}
+	awards, err := cfg.QueryEvents(ctx, nostr.Filters{{Kinds: []int{nostr.KindBadgeAward}, IDs: ids}})
+	if err != nil {
+		return nil, err
+	}
+	awardByID := map[string]*nostr.Event{}
+	for _, ev := range awards {
+		awardByID[ev.ID] = ev
+	}
+	defs, err := fetchAddressable(ctx, cfg, eps)
+	if err != nil {
+		return nil, err
+	}
+	defByAddr := map[string]*nostr.Event{}
+	for _, ev := range defs {
+		defByAddr[fmt.Sprintf("%d:%s:%s", ev.Kind, ev.PubKey, tagValue(ev.Tags, "d"))] = ev
+	}
+
+	var out []displayedBadge
+	for _, b := range badges {
+		def, ok := defByAddr[b.Address]
+		if !ok || !validAward(awardByID[b.AwardID], b.Address, pubkey) {
+			continue
+		}
+		out = append(out, displayedBadge{profileBadge: b, Definition: def})
+	}
+	return out, nil
+}
+
+func doBadgeDefine(cCtx *cli.Context) error {
+	cfg := cCtx.App.Metadata["config"].(*Config)
+	ctx := context.Background()
+
+	_, pub, err := getSkAndPub(cfg)
+	if err != nil {
+		return err
+	}
+
+	opts := badgeDefinitionOpts{
+		ID:          cCtx.String("id"),
+		Name:        cCtx.String("name"),
+		Description: cCtx.String("description"),
+		Image:       cCtx.String("image"),
+	}
+	// A local image is uploaded to the configured file-servers (or --server);
+	// a URL is used as is.
+	var paths []string
+	localImage := opts.Image != "" && !strings.HasPrefix(opts.Image, "http://") && !strings.HasPrefix(opts.Image, "https://")
+	if localImage {
+		paths = append(paths, opts.Image)
+	}
+	for _, thumb := range cCtx.StringSlice("thumb") {
+		if strings.HasPrefix(thumb, "http://") || strings.HasPrefix(thumb, "https://") {
+			opts.Thumbs = append(opts.Thumbs, thumb)
+		} else {
+			paths = append(paths, thumb)
+		}
+	}
+	bds, err := uploadImages(cfg, paths, cCtx.StringSlice("server"), false)
+	if err != nil {
+		return err
+	}
+	if localImage {
+		opts.Image = bds[0].URL
+		bds = bds[1:]
+	}
+	for _, bd := range bds {
+		opts.Thumbs = append(opts.Thumbs, bd.URL)
+	}
+	opts.ImageDim = cCtx.String("dim")
+
+	ev, err := buildBadgeDefinitionEvent(pub, opts, nostr.Now())
+	if err != nil {
+		return err
+	}
+	if err := cfg.signEvent(ev); err != nil {
+		return err
+	}
+	if cfg.publishEvent(ctx, ev) == 0 {
+		return errors.New("cannot define badge")
+	}
+	naddr, err := nip19.EncodeEntity(pub, ev.Kind, opts.ID, []string{firstWriteRelay(cfg)})
+	if err != nil {
+		return err
+	}
+	fmt.Println(naddr)
+	return nil
+}
+
+func doBadgeAward(cCtx *cli.Context) error {
+	if cCtx.Args().Len() == 0 {
+		return cli.ShowSubcommandHelp(cCtx)
+	}
+	cfg := cCtx.App.Metadata["config"].(*Config)
+	ctx := context.Background()
+
+	_, pub, err := getSkAndPub(cfg)
+	if err != nil {
+		return err
+	}
+	ep, err := decodeNaddr(cCtx.String("id"))
+	if err != nil {
+		return err
+	}
+	var awardees []string
+	for _, u := range cCtx.Args().Slice() {
+		pp := sdk.InputToProfile(ctx, u)
+		if pp == nil {
+			return fmt.Errorf("failed to parse pubkey from '%s'", u)
+		}
+		awardees = append(awardees, pp.PublicKey)
+	}
+
+	ev, err := buildBadgeAwardEvent(pub, ep, awardees, nostr.Now())
+	if err != nil {
+		return err
+	}
+	if err := cfg.signEvent(ev); err != nil {
+		return err
+	}
+	if cfg.publishEvent(ctx, ev) == 0 {
+		return errors.New("cannot award badge")
+	}
+	if cfg.verbose {
+		if id, err := nip19.EncodeNote(ev.ID); err == nil {
+			fmt.Println(id)
+		}
+	}
+	return nil
+}
+
+// updateProfileBadges fetches our profile badges, applies edit and publishes
+// the result.
+func updateProfileBadges(ctx context.Context, cfg *Config, edit func(pub string, badges []profileBadge) ([]profileBadge, error)) error {
+	_, pub, err := getSkAndPub(cfg)
+	if err != nil {
+		return err
+	}
+	prev, err := fetchProfileBadges(ctx, cfg, pub)
+	if err != nil {
+		return err
+	}
+	var badges []profileBadge
+	if prev != nil {
+		badges = parseProfileBadges(prev)
+	}
+	badges, err = edit(pub, badges)
+	if err != nil {
+		return err
+	}
+	ev := buildProfileBadgesEvent(pub, prev, badges, nostr.Now())
+	if err := cfg.signEvent(ev); err != nil {
+		return err
+	}
+	if cfg.publishEvent(ctx, ev) == 0 {
+		return errors.New("cannot update profile badges")
+	}
+	return nil
+}
+
+func doBadgeAccept(cCtx *cli.Context) error {
+	if cCtx.Args().Len() == 0 {
+		return cli.ShowSubcommandHelp(cCtx)
+	}
+	cfg := cCtx.App.Metadata["config"].(*Config)
+	ctx := context.Background()
+
+	var ids []string
+	for _, arg := range cCtx.Args().Slice() {
+		id, err := resolveChannelID(arg)
+		if err != nil {
+			return err
+		}
+		ids = append(ids, id)
+	}
+	awards, err := cfg.QueryEvents(ctx, nostr.Filters{{Kinds: []int{nostr.KindBadgeAward}, IDs: ids}})
+	if err != nil {
+		return err
+	}
+	byID := map[string]*nostr.Event{}
+	for _, ev := range awards {
+		byID[ev.ID] = ev
+	}
+
+	return updateProfileBadges(ctx, cfg, func(pub string, badges []profileBadge) ([]profileBadge, error) {
+		for _, id := range ids {
+			award, ok := byID[id]
+			if !ok {
+				return nil, fmt.Errorf("badge award %s not found", id)
+			}
+			a := award.Tags.Find("a")
+			if a == nil || !validAward(award, a[1], pub) {
+				return nil, fmt.Errorf("%s is not a badge award for you", id)
+			}
+			// Replace an earlier award of the same badge in place.
+			replaced := false
+			for i := range badges {
+				if badges[i].Address == a[1] {
+					badges[i].AwardID = id
+					replaced = true
+				}
+			}
+			if !replaced {
+				badges = append(badges, profileBadge{Address: a[1], AwardID: id})
+			}
+		}
+		return badges, nil
+	})
+}
+
+func doBadgeHide(cCtx *cli.Context) error {
+	if cCtx.Args().Len() == 0 {
+		return cli.ShowSubcommandHelp(cCtx)
+	}
+	cfg := cCtx.App.Metadata["config"].(*Config)
+	ctx := context.Background()
+
+	// Accept either the badge's naddr or the award's id.
+	var addrs, ids []string
+	for _, arg := range cCtx.Args().Slice() {
+		if ep, err := decodeNaddr(arg); err == nil {
+			addrs = append(addrs, addressValue(ep))
+			continue
+		}
+		id, err := resolveChannelID(arg)
+		if err != nil {
+			return err
+		}
+		ids = append(ids, id)
+	}
+
+	return updateProfileBadges(ctx, cfg, func(pub string, badges []profileBadge) ([]profileBadge, error) {
+		kept := badges[:0]
+		for _, b := range badges {
+			if !slices.Contains(addrs, b.Address) && !slices.Contains(ids, b.AwardID) {
+				kept = append(kept, b)
+			}
+		}
+		if len(kept) == len(badges) {
+			return nil, errors.New("no matching badge is displayed")
+		}
+		return kept, nil
+	})
+}
+
+// printBadges prints the badges a profile displays.
+func printBadges(badges []displayedBadge) {
+	for _, b := range badges {
+		color.Set(color.FgHiYellow)
+		fmt.Print("  " + b.name())
+		color.Set(color.Reset)
+		if desc := tagValue(b.Definition.Tags, "description"); desc != "" {
+			fmt.Print(": " + desc)
+		}
+		fmt.Println()
+	}
+}
+
+func doBadgeList(cCtx *cli.Context) error {
+	cfg := cCtx.App.Metadata["config"].(*Config)
+	ctx := context.Background()
+	j := cCtx.Bool("json")
+
+	_, pub, err := getSkAndPub(cfg)
+	if err != nil {
+		return err
+	}
+	awards, err := cfg.QueryEvents(ctx, nostr.Filters{{
+		Kinds: []int{nostr.KindBadgeAward},
+		Tags:  nostr.TagMap{"p": []string{pub}},
+		Limit: cCtx.Int("n"),
+	}})
+	if err != nil {
+		return err
+	}
+	var shown []profileBadge
+	if pev, err := fetchProfileBadges(ctx, cfg, pub); err == nil && pev != nil {
+		shown = parseProfileBadges(pev)
+	}
+
+	for _, award := range awards {
+		a := award.Tags.Find("a")
+		if a == nil || !validAward(award, a[1], pub) {
+			continue
+		}
+		if j {
+			json.NewEncoder(os.Stdout).Encode(award)
+			continue
+		}
+		mark := " "
+		for _, b := range shown {
+			if b.AwardID == award.ID {
+				mark = "*"
+			}
+		}
+		note, err := nip19.EncodeNote(award.ID)
+		if err != nil {
+			note = award.ID
+		}
+		fmt.Printf("%s %s %s from %s\n", mark, note, strings.SplitN(a[1], ":", 3)[2], cfg.displayName(award.PubKey))
+	}
+	return nil
+}
+
+func badgeCommand() *cli.Command {
+	return &cli.Command{
+		Name:  "badge",
+		Usage: "badges (NIP-58)",
+		Action: func(cCtx *cli.Context) error {
+			return cli.ShowSubcommandHelp(cCtx)
+		},
+		Subcommands: []*cli.Command{
+			{
+				Name: "define",
+				Flags: []cli.Flag{
+					&cli.StringFlag{Name: "id", Required: true, Usage: "badge identifier (d tag)"},
+					&cli.StringFlag{Name: "name", Usage: "badge name"},
+					&cli.StringFlag{Name: "description", Usage: "badge description"},
+					&cli.StringFlag{Name: "image", Usage: "badge image (local file to upload, or URL)"},
+					&cli.StringFlag{Name: "dim", Usage: "image dimensions (e.g. 1024x1024)"},
+					&cli.StringSliceFlag{Name: "thumb", Usage: "thumbnail (local file to upload, or URL; repeatable)"},
+					&cli.StringSliceFlag{Name: "server", Aliases: []string{"s"}, Usage: "media server override (default: configured file-servers)"},
+				},
+				Usage:     "define a badge (kind 30009)",
+				UsageText: "algia badge define --id [id] --name [name] --image [file]",
+				Action:    doBadgeDefine,
+			},
+			{
+				Name: "award",
+				Flags: []cli.Flag{
+					&cli.StringFlag{Name: "id", Required: true, Usage: "badge definition (naddr)"},
+				},
+				Usage:     "award a badge (kind 8)",
+				UsageText: "algia badge award --id [naddr] [npub...]",
+				ArgsUsage: "[npub...]",
+				Action:    doBadgeAward,
+			},
+			{
+				Name: "list",
+				Flags: []cli.Flag{
+					&cli.IntFlag{Name: "n", Value: 100, Usage: "number of items"},
+					&cli.BoolFlag{Name: "json", Usage: "output JSON"},
+				},
+				Usage:     "list badges awarded to you (* marks displayed ones)",
+				UsageText: "algia badge list",
+				Action:    doBadgeList,
+			},
+			{
+				Name:      "accept",
+				Usage:     "display awarded badges on your profile (kind 30008)",
+				UsageText: "algia badge accept [award note/nevent...]",
+				ArgsUsage: "[award...]",
+				Action:    doBadgeAccept,
+			},
+			{
+				Name:      "hide",
+				Usage:     "stop displaying badges on your profile (kind 30008)",
+				UsageText: "algia badge hide [naddr or award note/nevent...]",
+				ArgsUsage: "[naddr or award...]",
+				Action:    doBadgeHide,
+			},
+		},
+	}
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+func TestBuildBadgeDefinitionEvent(t *testing.T) {
+	ev, err := buildBadgeDefinitionEvent(testPub, badgeDefinitionOpts{
+		ID:          "contributor",
+		Name:        "Contributor",
+		Description: "Landed a patch",
+		Image:       "https://example.com/badge.png",
+		ImageDim:    "1024x1024",
+		Thumbs:      []string{"https://example.com/thumb.png 256x256"},
+	}, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ev.Kind != nostr.KindBadgeDefinition {
+		t.Errorf("kind=%d", ev.Kind)
+	}
+	for name, want := range map[string]string{"d": "contributor", "name": "Contributor", "description": "Landed a patch"} {
+		if got := tagValue(ev.Tags, name); got != want {
+			t.Errorf("%s=%q want %q", name, got, want)
+		}
+	}
+	if img := findTag(ev.Tags, "image"); img == nil || len(img) != 3 || img[2] != "1024x1024" {
+		t.Errorf("image tag: %v", ev.Tags)
+	}
+	if th := findTag(ev.Tags, "thumb"); th == nil || len(th) != 3 || th[1] != "https://example.com/thumb.png" || th[2] != "256x256" {
+		t.Errorf("thumb tag: %v", ev.Tags)
+	}
+
+	for _, id := range []string{"", "a:b", "a b"} {
+		if _, err := buildBadgeDefinitionEvent(testPub, badgeDefinitionOpts{ID: id}, 100); err == nil {
+			t.Errorf("expected error for id %q", id)
+		}
+	}
+}
+
+func TestBuildBadgeAwardEvent(t *testing.T) {
+	ep := &nostr.EntityPointer{PublicKey: testPub, Kind: nostr.KindBadgeDefinition, Identifier: "contributor"}
+	ev, err := buildBadgeAwardEvent(testPub, ep, []string{"aa", "bb", "aa"}, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ev.Kind != nostr.KindBadgeAward {
+		t.Errorf("kind=%d", ev.Kind)
+	}
+	if got := tagValue(ev.Tags, "a"); got != "30009:"+testPub+":contributor" {
+		t.Errorf("a=%q", got)
+	}
+	if ps := findAllTags(ev.Tags, "p"); len(ps) != 2 {
+		t.Errorf("p tags: %v", ps)
+	}
+
+	if _, err := buildBadgeAwardEvent("other", ep, []string{"aa"}, 100); err == nil {
+		t.Error("expected error when awarding someone else's badge")
+	}
+	if _, err := buildBadgeAwardEvent(testPub, &nostr.EntityPointer{PublicKey: testPub, Kind: nostr.KindArticle}, []string{"aa"}, 100); err == nil {
+		t.Error("expected error for a non-badge address")
+	}
+	if _, err := buildBadgeAwardEvent(testPub, ep, nil, 100); err == nil {
+		t.Error("expected error for no awardees")
+	}
+}
+
+func TestProfileBadgesRoundTrip(t *testing.T) {
+	prev := &nostr.Event{
+		Kind: nostr.KindProfileBadges,
+		Tags: nostr.Tags{
+			{"d", profileBadgesID},
+			{"a", "30009:x:one"}, {"e", "e1", "wss://relay.example"},
+			{"a", "30009:x:orphan"},
+			{"a", "30009:x:two"}, {"e", "e2"},
+			{"e", "stray"},
+			{"alt", "profile badges"},
+		},
+	}
+	badges := parseProfileBadges(prev)
+	if len(badges) != 2 || badges[0].AwardID != "e1" || badges[0].Relay != "wss://relay.example" || badges[1].Address != "30009:x:two" {
+		t.Fatalf("badges=%+v", badges)
+	}
+
+	ev := buildProfileBadgesEvent(testPub, prev, badges[1:], 100)
+	if got := tagValue(ev.Tags, "d"); got != profileBadgesID {
+		t.Errorf("d=%q", got)
+	}
+	if findTag(ev.Tags, "alt") == nil {
+		t.Errorf("alt tag dropped: %v", ev.Tags)
+	}
+	got := parseProfileBadges(ev)
+	if len(got) != 1 || got[0].AwardID != "e2" || len(findAllTags(ev.Tags, "e")) != 1 {
+		t.Errorf("rebuilt=%v", ev.Tags)
+	}
+}
+
+func TestValidAward(t *testing.T) {
+	addr := "30009:" + testPub + ":contributor"
+	award := &nostr.Event{
+		PubKey: testPub,
+		Kind:   nostr.KindBadgeAward,
+		Tags:   nostr.Tags{{"a", addr}, {"p", "aa"}, {"p", "bb"}},
+	}
+	if !validAward(award, addr, "bb") {
+		t.Error("expected a valid award")
+	}
+	if validAward(award, addr, "cc") {
+		t.Error("award to someone else accepted")
+	}
+	if validAward(award, "30009:"+testPub+":other", "aa") {
+		t.Error("award for another badge accepted")
+	}
+	forged := *award
+	forged.PubKey = "mallory"
+	if validAward(&forged, addr, "aa") {
+		t.Error("award by a non-author accepted")
+	}
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/btcsuite/btcd/btcutil/bech32"
+)
+
+// bolt11Invoice is the part of a BOLT11 payment request algia needs to check
+// zap receipts and payments. The node signature is not verified.
+type bolt11Invoice struct {
+	MSat            int64 // 0 when the invoice leaves the amount to the payer
+	CreatedAt       int64
+	Expiry          int64 // seconds
+	PaymentHash     string
+	Description     string
+	DescriptionHash string
+}
+
+// bolt11SignatureLen is the length of the signature and recovery id at the
+// end of the data part, in 5-bit groups.
+const bolt11SignatureLen = 104
+
+// bolt11MSat converts the amount of the human-readable part, e.g. "2500u",
+// to millisatoshis.
+func bolt11MSat(amount string) (int64, error) {
+	if amount == "" {
+		return 0, nil
+	}
+	// msat per unit of the multiplier; "p" is a tenth of a msat.
+	multiplier := map[byte]int64{'m': 100_000_000, 'u': 100_000, 'n': 100, 'p': 1}
+	last := amount[len(amount)-1]
+	mul, ok := multiplier[last]
+	if ok {
+		amount = amount[:len(amount)-1]
+	} else {
+		mul = 100_000_000_000
+	}
+	n, err := strconv.ParseInt(amount, 10, 64)
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("invalid invoice amount %q", amount)
+	}
+	if last == 'p' {
+		if n%10 != 0 {
+			return 0, errors.New("invoice amount is not a whole msat")
+		}
+		return n / 10, nil
+	}
+	return n * mul, nil
+}
+
+// decodeBolt11 decodes a BOLT11 payment request.
+func decodeBolt11(pr string) (*bolt11Invoice, error) {
+	pr = strings.TrimPrefix(strings.ToLower(strings.TrimSpace(pr)), "lightning:")
+	hrp, data, err := bech32.DecodeNoLimit(pr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid invoice: %w", err)
+	}
+	if !strings.HasPrefix(hrp, "ln") {
+		return nil, errors.New("invalid invoice: not a lightning invoice")
+	}
+	// "ln" + currency (bc, tb, tbs, bcrt, ...) + optional amount.
+	amount := strings.TrimLeft(hrp[2:], "abcdefghijklmnopqrstuvwxyz")
+	inv := &bolt11Invoice{Expiry: 3600}
+	if inv.MSat, err = bolt11MSat(amount); err != nil {
+		return nil, err
+	}
+	if len(data) < 7+bolt11SignatureLen {
+		return nil, errors.New("invalid invoice: too short")
+	}
+	data = data[:len(data)-bolt11SignatureLen]
+	inv.CreatedAt = bolt11Int(data[:7])
+	data = data[7:]
+
+	for len(data) >= 3 {
+		typ := data[0]
+		n := int(data[1])<<5 | int(data[2])
+		data = data[3:]
+		if len(data) < n {
+			return nil, errors.New("invalid invoice: truncated field")
+		}
+		field := data[:n]
+		data = data[n:]
+		switch typ {
+		case 1: // p
+			if b, err := bech32.ConvertBits(field, 5, 8, false); err == nil && len(b) == 32 {
+				inv.PaymentHash = hex.EncodeToString(b)
+			}
+		case 13: // d
+			if b, err := bech32.ConvertBits(field, 5, 8, false); err == nil {
+				inv.Description = string(b)
+			}
+		case 23: // h
+			if b, err := bech32.ConvertBits(field, 5, 8, false); err == nil && len(b) == 32 {
+				inv.DescriptionHash = hex.EncodeToString(b)
+			}
+		case 6: // x
+			inv.Expiry = bolt11Int(field)
+		}
+	}
+	return inv, nil
+}
+
+func bolt11Int(groups []byte) int64 {
+	var n int64
+	for _, g := range groups {
+		n = n<<5 | int64(g)
+	}
+	return n
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"testing"
+
+	"github.com/btcsuite/btcd/btcutil/bech32"
+)
+
+// testBolt11 builds an unsigned BOLT11 invoice with the given description
+// hash; decodeBolt11 does not check the signature.
+func testBolt11(t *testing.T, hrp string, descriptionHash []byte) string {
+	t.Helper()
+	field := func(typ byte, b []byte) []byte {
+		groups, err := bech32.ConvertBits(b, 8, 5, true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return append([]byte{typ, byte(len(groups) >> 5), byte(len(groups) & 31)}, groups...)
+	}
+	data := []byte{0, 0, 0, 0, 0, 3, 7} // timestamp 103
+	data = append(data, field(1, bytes.Repeat([]byte{0xab}, 32))...)
+	data = append(data, field(23, descriptionHash)...)
+	data = append(data, 6, 0, 2, 1, 0) // expiry 32
+	data = append(data, make([]byte, bolt11SignatureLen)...)
+	pr, err := bech32.Encode(hrp, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pr
+}
+
+func TestDecodeBolt11(t *testing.T) {
+	sum := sha256.Sum256([]byte("zap request"))
+	inv, err := decodeBolt11("lightning:" + testBolt11(t, "lnbc2500u", sum[:]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if inv.MSat != 250_000_000 || inv.CreatedAt != 103 || inv.Expiry != 32 {
+		t.Errorf("inv=%+v", inv)
+	}
+	if inv.DescriptionHash != hex.EncodeToString(sum[:]) || inv.PaymentHash != hex.EncodeToString(bytes.Repeat([]byte{0xab}, 32)) {
+		t.Errorf("hashes=%s %s", inv.DescriptionHash, inv.PaymentHash)
+	}
+
+	inv, err = decodeBolt11(testBolt11(t, "lntbs", sum[:]))
+	if err != nil || inv.MSat != 0 {
+		t.Errorf("no amount: %+v %v", inv, err)
+	}
+	if _, err := decodeBolt11("lnbc1invalid"); err == nil {
+		t.Error("expected error for a broken invoice")
+	}
+}
+
+func TestBolt11MSat(t *testing.T) {
+	tests := map[string]int64{"": 0, "1": 100_000_000_000, "10m": 1_000_000_000, "21u": 2_100_000, "50n": 5_000, "10p": 1}
+	for in, want := range tests {
+		if got, err := bolt11MSat(in); err != nil || got != want {
+			t.Errorf("%q: got %d err=%v want %d", in, got, err, want)
+		}
+	}
+	for _, in := range []string{"15p", "0u", "xu"} {
+		if _, err := bolt11MSat(in); err == nil {
+			t.Errorf("%q: expected error", in)
+		}
+	}
+}
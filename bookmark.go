@@ -2,25 +2,177 @@ package main
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
+	"fmt"
+	"os"
+	"strings"
 
 	"github.com/urfave/cli/v2"
 
 	"github.com/nbd-wtf/go-nostr"
+	"github.com/nbd-wtf/go-nostr/nip19"
+	"github.com/nbd-wtf/go-nostr/sdk"
 )
 
-func doBMList(cCtx *cli.Context) error {
-	cfg := cCtx.App.Metadata["config"].(*Config)
-	eevs, err := callBookmarks(&bookmarksArg{
-		ctx: context.Background(),
-		cfg: cfg,
-		n:   cCtx.Int("n"),
+// legacyBookmarkID is the d tag of the kind 30001 list older clients used
+// before NIP-51 moved bookmarks to kind 10003.
+const legacyBookmarkID = "bookmark"
+
+// bookmarkTag converts a bookmark target into its kind 10003 tag: notes and
+// nevents become e, naddrs (articles) a, web URLs r and #hashtags t.
+func bookmarkTag(item string) (nostr.Tag, error) {
+	item = strings.TrimPrefix(strings.TrimSpace(item), "nostr:")
+	switch {
+	case strings.HasPrefix(item, "naddr1"):
+		ep, err := decodeNaddr(item)
+		if err != nil {
+			return nil, err
+		}
+		return nostr.Tag{"a", addressValue(ep)}, nil
+	case strings.HasPrefix(item, "https://") || strings.HasPrefix(item, "http://"):
+		return nostr.Tag{"r", item}, nil
+	case strings.HasPrefix(item, "#"):
+		if t := strings.ToLower(strings.TrimPrefix(item, "#")); t != "" {
+			return nostr.Tag{"t", t}, nil
+		}
+	default:
+		if evp := sdk.InputToEventPointer(item); evp != nil {
+			return nostr.Tag{"e", evp.ID}, nil
+		}
+	}
+	return nil, fmt.Errorf("cannot bookmark %q (expected note, nevent, naddr, URL or #hashtag)", item)
+}
+
+// isBookmarkItem reports whether tag is a bookmark rather than list metadata.
+func isBookmarkItem(tag nostr.Tag) bool {
+	if len(tag) < 2 {
+		return false
+	}
+	switch tag[0] {
+	case "e", "a", "r", "t":
+		return true
+	}
+	return false
+}
+
+func bookmarkKey(tag nostr.Tag) string {
+	return tag[0] + ":" + tag[1]
+}
+
+// withoutTags drops the tags whose key is in keys and reports how many went.
+func withoutTags(tags nostr.Tags, keys map[string]bool) (nostr.Tags, int) {
+	out := nostr.Tags{}
+	removed := 0
+	for _, tag := range tags {
+		if len(tag) >= 2 && keys[bookmarkKey(tag)] {
+			removed++
+			continue
+		}
+		out = append(out, tag)
+	}
+	return out, removed
+}
+
+// addBookmarks adds tags to the public or the private half. An item already
+// bookmarked on the other side moves, so it never appears twice.
+func addBookmarks(public, private nostr.Tags, tags []nostr.Tag, toPrivate bool) (nostr.Tags, nostr.Tags) {
+	keys := make(map[string]bool)
+	for _, tag := range tags {
+		keys[bookmarkKey(tag)] = true
+	}
+	public, _ = withoutTags(public, keys)
+	private, _ = withoutTags(private, keys)
+	for _, tag := range tags {
+		if toPrivate {
+			private = appendTagOnce(private, tag)
+		} else {
+			public = appendTagOnce(public, tag)
+		}
+	}
+	return public, private
+}
+
+// removeBookmarks drops tags from both halves and reports how many went.
+func removeBookmarks(public, private nostr.Tags, tags []nostr.Tag) (nostr.Tags, nostr.Tags, int) {
+	keys := make(map[string]bool)
+	for _, tag := range tags {
+		keys[bookmarkKey(tag)] = true
+	}
+	public, n := withoutTags(public, keys)
+	private, m := withoutTags(private, keys)
+	return public, private, n + m
+}
+
+// buildBookmarkListEvent constructs an unsigned kind 10003 list with the
+// given public tags and already encrypted private content.
+func buildBookmarkListEvent(pubkey string, public nostr.Tags, content string, createdAt nostr.Timestamp) *nostr.Event {
+	ev := &nostr.Event{
+		PubKey:    pubkey,
+		CreatedAt: createdAt,
+		Kind:      nostr.KindBookmarkList,
+		Content:   content,
+		Tags:      nostr.Tags{},
+	}
+	clientTag(ev)
+	for _, tag := range public {
+		if len(tag) > 0 && tag[0] != "client" {
+			ev.Tags = append(ev.Tags, tag)
+		}
+	}
+	return ev
+}
+
+// fetchBookmarkLists returns our newest kind 10003 list and legacy kind 30001
+// d=bookmark list; either may be nil.
+func fetchBookmarkLists(ctx context.Context, cfg *Config, pub string) (list, legacy *nostr.Event, err error) {
+	evs, err := cfg.QueryEvents(ctx, nostr.Filters{
+		{Kinds: []int{nostr.KindBookmarkList}, Authors: []string{pub}},
+		{Kinds: []int{nostr.KindCategorizedBookmarksList}, Authors: []string{pub}, Tags: nostr.TagMap{"d": []string{legacyBookmarkID}}},
 	})
 	if err != nil {
-		return err
+		return nil, nil, err
 	}
-	cfg.PrintEvents(eevs, nil, cCtx.Bool("json"), cCtx.Bool("extra"))
-	return nil
+	latest := latestByKind(evs)
+	return latest[nostr.KindBookmarkList], latest[nostr.KindCategorizedBookmarksList], nil
+}
+
+// bookmarkHalves splits a bookmark list into its public tags and decrypted
+// private tags. A nil list has neither.
+func bookmarkHalves(sk, pub string, ev *nostr.Event) (nostr.Tags, nostr.Tags, error) {
+	if ev == nil {
+		return nostr.Tags{}, nil, nil
+	}
+	private, err := decryptPrivateTags(sk, pub, ev.Content)
+	return ev.Tags, private, err
+}
+
+// bookmarkItem is one bookmark as listed by bm list.
+type bookmarkItem struct {
+	Tag     nostr.Tag `json:"tag"`
+	Private bool      `json:"private,omitempty"`
+	Legacy  bool      `json:"legacy,omitempty"`
+}
+
+// mergeBookmarks combines the kind 10003 and legacy kind 30001 halves in
+// that order, keeping the first occurrence of each item.
+func mergeBookmarks(public, private, legacyPublic, legacyPrivate nostr.Tags) []bookmarkItem {
+	seen := make(map[string]bool)
+	var items []bookmarkItem
+	add := func(tags nostr.Tags, isPrivate, isLegacy bool) {
+		for _, tag := range tags {
+			if !isBookmarkItem(tag) || seen[bookmarkKey(tag)] {
+				continue
+			}
+			seen[bookmarkKey(tag)] = true
+			items = append(items, bookmarkItem{Tag: tag, Private: isPrivate, Legacy: isLegacy})
+		}
+	}
+	add(public, false, false)
+	add(private, true, false)
+	add(legacyPublic, false, true)
+	add(legacyPrivate, true, true)
+	return items
 }
 
 type bookmarksArg struct {
@@ -29,40 +181,284 @@ type bookmarksArg struct {
 	n   int
 }
 
+// callBookmarkItems returns every bookmark from both the kind 10003 list and
+// the legacy kind 30001 list, private ones included.
+func callBookmarkItems(arg *bookmarksArg) ([]bookmarkItem, error) {
+	sk, pub, err := getSkAndPub(arg.cfg)
+	if err != nil {
+		return nil, err
+	}
+	list, legacy, err := fetchBookmarkLists(arg.ctx, arg.cfg, pub)
+	if err != nil {
+		return nil, err
+	}
+	public, private, err := bookmarkHalves(sk, pub, list)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+	}
+	legacyPublic, legacyPrivate, err := bookmarkHalves(sk, pub, legacy)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+	}
+	return mergeBookmarks(public, private, legacyPublic, legacyPrivate), nil
+}
+
+// callBookmarks returns the bookmarked notes and articles, at most the last
+// n in list order.
 func callBookmarks(arg *bookmarksArg) ([]*nostr.Event, error) {
-	_, pub, err := getSkAndPub(arg.cfg)
+	items, err := callBookmarkItems(arg)
 	if err != nil {
 		return nil, err
 	}
-	filter := nostr.Filter{
-		Kinds:   []int{nostr.KindCategorizedBookmarksList},
-		Authors: []string{pub},
-		Tags:    nostr.TagMap{"d": []string{"bookmark"}},
-		Limit:   arg.n,
+	return fetchBookmarked(arg, items)
+}
+
+// fetchBookmarked fetches the notes (e) and articles (a) among items.
+func fetchBookmarked(arg *bookmarksArg, items []bookmarkItem) ([]*nostr.Event, error) {
+	var ids []string
+	var eps []*nostr.EntityPointer
+	var targets []bookmarkItem
+	for _, item := range items {
+		if item.Tag[0] == "e" || item.Tag[0] == "a" {
+			targets = append(targets, item)
+		}
+	}
+	if arg.n > 0 && len(targets) > arg.n {
+		targets = targets[len(targets)-arg.n:]
+	}
+	for _, item := range targets {
+		if item.Tag[0] == "e" {
+			ids = append(ids, item.Tag[1])
+		} else if ep, err := nostr.EntityPointerFromTag(item.Tag); err == nil {
+			eps = append(eps, &ep)
+		}
+	}
+
+	var evs []*nostr.Event
+	var err error
+	if len(ids) > 0 {
+		evs, err = arg.cfg.QueryEvents(arg.ctx, nostr.Filters{{IDs: ids}})
+		if err != nil {
+			return nil, err
+		}
 	}
-	evs, err := arg.cfg.QueryEvents(arg.ctx, nostr.Filters{filter})
+	articles, err := fetchAddressable(arg.ctx, arg.cfg, eps)
 	if err != nil {
 		return nil, err
 	}
-	be := []string{}
-	for _, ev := range evs {
-		for _, tag := range ev.Tags {
-			if len(tag) > 1 && tag[0] == "e" {
-				be = append(be, tag[1:]...)
+	return append(evs, articles...), nil
+}
+
+func doBMList(cCtx *cli.Context) error {
+	j := cCtx.Bool("json")
+
+	cfg := cCtx.App.Metadata["config"].(*Config)
+	arg := &bookmarksArg{
+		ctx: context.Background(),
+		cfg: cfg,
+		n:   cCtx.Int("n"),
+	}
+
+	items, err := callBookmarkItems(arg)
+	if err != nil {
+		return err
+	}
+	if cCtx.Bool("items") {
+		for _, item := range items {
+			if j {
+				json.NewEncoder(os.Stdout).Encode(item)
+				continue
+			}
+			printBookmarkItem(item)
+		}
+		return nil
+	}
+
+	eevs, err := fetchBookmarked(arg, items)
+	if err != nil {
+		return err
+	}
+	cfg.PrintEvents(eevs, nil, j, cCtx.Bool("extra"))
+
+	// URLs and hashtags have no event to print.
+	for _, item := range items {
+		if item.Tag[0] != "r" && item.Tag[0] != "t" {
+			continue
+		}
+		if j {
+			json.NewEncoder(os.Stdout).Encode(item)
+		} else {
+			printBookmarkItem(item)
+		}
+	}
+	return nil
+}
+
+func printBookmarkItem(item bookmarkItem) {
+	var s string
+	switch item.Tag[0] {
+	case "e":
+		s, _ = nip19.EncodeNote(item.Tag[1])
+	case "a":
+		s = item.Tag[1]
+		if ep, err := nostr.EntityPointerFromTag(item.Tag); err == nil {
+			if naddr, err := nip19.EncodeEntity(ep.PublicKey, ep.Kind, ep.Identifier, nil); err == nil {
+				s = naddr
 			}
 		}
+	case "t":
+		s = "#" + item.Tag[1]
+	default:
+		s = item.Tag[1]
+	}
+	if item.Private {
+		s += " (private)"
 	}
-	if len(be) == 0 {
-		return nil, nil
+	if item.Legacy {
+		s += " (legacy)"
 	}
-	return arg.cfg.QueryEvents(arg.ctx, nostr.Filters{{
-		Kinds: []int{nostr.KindTextNote},
-		IDs:   be,
-	}})
+	fmt.Println(s)
 }
 
-func doBMPost(cCtx *cli.Context) error {
-	return errors.New("Not Implemented")
+// updateBookmarks fetches our kind 10003 list, applies change to its public
+// and private halves and publishes the result.
+func updateBookmarks(ctx context.Context, cfg *Config, change func(public, private nostr.Tags) (nostr.Tags, nostr.Tags, error)) error {
+	sk, pub, err := getSkAndPub(cfg)
+	if err != nil {
+		return err
+	}
+	list, _, err := fetchBookmarkLists(ctx, cfg, pub)
+	if err != nil {
+		return err
+	}
+	public, private, err := bookmarkHalves(sk, pub, list)
+	if err != nil {
+		// Publishing now would wipe the private items we failed to read.
+		return err
+	}
+	public, private, err = change(public, private)
+	if err != nil {
+		return err
+	}
+	content, err := encryptPrivateTags(sk, pub, private)
+	if err != nil {
+		return err
+	}
+	ev := buildBookmarkListEvent(pub, public, content, nostr.Now())
+	if err := cfg.signEvent(ev); err != nil {
+		return err
+	}
+	if cfg.publishEvent(ctx, ev) == 0 {
+		return errors.New("cannot publish bookmarks")
+	}
+	return nil
+}
+
+func bookmarkTags(items []string) ([]nostr.Tag, error) {
+	var tags []nostr.Tag
+	for _, item := range items {
+		tag, err := bookmarkTag(item)
+		if err != nil {
+			return nil, err
+		}
+		tags = append(tags, tag)
+	}
+	return tags, nil
+}
+
+func doBMAdd(cCtx *cli.Context) error {
+	if cCtx.Args().Len() == 0 {
+		return cli.ShowSubcommandHelp(cCtx)
+	}
+	tags, err := bookmarkTags(cCtx.Args().Slice())
+	if err != nil {
+		return err
+	}
+	cfg := cCtx.App.Metadata["config"].(*Config)
+	return updateBookmarks(context.Background(), cfg, func(public, private nostr.Tags) (nostr.Tags, nostr.Tags, error) {
+		public, private = addBookmarks(public, private, tags, cCtx.Bool("private"))
+		return public, private, nil
+	})
+}
+
+func doBMRemove(cCtx *cli.Context) error {
+	if cCtx.Args().Len() == 0 {
+		return cli.ShowSubcommandHelp(cCtx)
+	}
+	tags, err := bookmarkTags(cCtx.Args().Slice())
+	if err != nil {
+		return err
+	}
+	cfg := cCtx.App.Metadata["config"].(*Config)
+	return updateBookmarks(context.Background(), cfg, func(public, private nostr.Tags) (nostr.Tags, nostr.Tags, error) {
+		public, private, n := removeBookmarks(public, private, tags)
+		if n == 0 {
+			return nil, nil, errors.New("not bookmarked")
+		}
+		return public, private, nil
+	})
+}
+
+func doBMMigrate(cCtx *cli.Context) error {
+	cfg := cCtx.App.Metadata["config"].(*Config)
+	ctx := context.Background()
+
+	sk, pub, err := getSkAndPub(cfg)
+	if err != nil {
+		return err
+	}
+	_, legacy, err := fetchBookmarkLists(ctx, cfg, pub)
+	if err != nil {
+		return err
+	}
+	if legacy == nil {
+		return errors.New("no legacy kind 30001 bookmark list found")
+	}
+	legacyPublic, legacyPrivate, err := bookmarkHalves(sk, pub, legacy)
+	if err != nil {
+		return err
+	}
+
+	var migrated int
+	err = updateBookmarks(ctx, cfg, func(public, private nostr.Tags) (nostr.Tags, nostr.Tags, error) {
+		// Items already in the new list keep their place and visibility.
+		have := make(map[string]bool)
+		for _, half := range []nostr.Tags{public, private} {
+			for _, tag := range half {
+				if isBookmarkItem(tag) {
+					have[bookmarkKey(tag)] = true
+				}
+			}
+		}
+		for _, half := range []struct {
+			tags      nostr.Tags
+			toPrivate bool
+		}{{legacyPublic, false}, {legacyPrivate, true}} {
+			for _, tag := range half.tags {
+				if !isBookmarkItem(tag) || have[bookmarkKey(tag)] {
+					continue
+				}
+				have[bookmarkKey(tag)] = true
+				public, private = addBookmarks(public, private, []nostr.Tag{tag}, half.toPrivate)
+				migrated++
+			}
+		}
+		return public, private, nil
+	})
+	if err != nil {
+		return err
+	}
+	fmt.Printf("migrated %d bookmarks to kind %d\n", migrated, nostr.KindBookmarkList)
+
+	if cCtx.Bool("delete") {
+		return callListDelete(&listDeleteArg{
+			ctx:  ctx,
+			cfg:  cfg,
+			kind: nostr.KindCategorizedBookmarksList,
+			name: legacyBookmarkID,
+		})
+	}
+	return nil
 }
 
 // bmCommand returns the "bm" parent command with its subcommands.
@@ -74,18 +470,41 @@ func bmCommand() *cli.Command {
 			{
 				Name: "list",
 				Flags: []cli.Flag{
+					&cli.IntFlag{Name: "n", Value: 30, Usage: "number of bookmarked notes and articles"},
+					&cli.BoolFlag{Name: "items", Usage: "list the bookmark entries instead of fetching them"},
 					&cli.BoolFlag{Name: "json", Usage: "output JSON"},
+					&cli.BoolFlag{Name: "extra", Usage: "extra JSON"},
 				},
-				Usage:     "show bookmarks",
+				Usage:     "show bookmarks (kind 10003 and legacy kind 30001)",
 				UsageText: "algia bm list",
 				Action:    doBMList,
 			},
 			{
-				Name:      "post",
-				Usage:     "post bookmark",
-				UsageText: "algia bm post [note]",
-				ArgsUsage: "[note]",
-				Action:    doBMPost,
+				Name:    "add",
+				Aliases: []string{"post"},
+				Flags: []cli.Flag{
+					&cli.BoolFlag{Name: "private", Usage: "keep the bookmark in the encrypted content"},
+				},
+				Usage:     "add bookmarks",
+				UsageText: "algia bm add [--private] <note|nevent|naddr|url|#tag>...",
+				ArgsUsage: "<note|nevent|naddr|url|#tag>...",
+				Action:    doBMAdd,
+			},
+			{
+				Name:      "remove",
+				Usage:     "remove bookmarks (public or private)",
+				UsageText: "algia bm remove <note|nevent|naddr|url|#tag>...",
+				ArgsUsage: "<note|nevent|naddr|url|#tag>...",
+				Action:    doBMRemove,
+			},
+			{
+				Name: "migrate",
+				Flags: []cli.Flag{
+					&cli.BoolFlag{Name: "delete", Usage: "request deletion of the legacy list afterwards"},
+				},
+				Usage:     "copy the legacy kind 30001 bookmark list into kind 10003",
+				UsageText: "algia bm migrate [--delete]",
+				Action:    doBMMigrate,
 			},
 		},
 	}
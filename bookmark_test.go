@@ -0,0 +1,151 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/nbd-wtf/go-nostr/nip19"
+)
+
+func TestBookmarkTag(t *testing.T) {
+	note, _ := nip19.EncodeNote(testTargetID)
+	author, _ := nostr.GetPublicKey(nostr.GeneratePrivateKey())
+	naddr, _ := nip19.EncodeEntity(author, nostr.KindArticle, "slug", nil)
+
+	tests := []struct {
+		in   string
+		want nostr.Tag
+	}{
+		{note, nostr.Tag{"e", testTargetID}},
+		{"nostr:" + note, nostr.Tag{"e", testTargetID}},
+		{naddr, nostr.Tag{"a", "30023:" + author + ":slug"}},
+		{"https://example.com/post", nostr.Tag{"r", "https://example.com/post"}},
+		{"#Nostr", nostr.Tag{"t", "nostr"}},
+	}
+	for _, tt := range tests {
+		got, err := bookmarkTag(tt.in)
+		if err != nil {
+			t.Errorf("%s: %v", tt.in, err)
+			continue
+		}
+		if len(got) != 2 || got[0] != tt.want[0] || got[1] != tt.want[1] {
+			t.Errorf("%s: got %v want %v", tt.in, got, tt.want)
+		}
+	}
+	for _, in := range []string{"#", "hello", "wss://relay.example"} {
+		if _, err := bookmarkTag(in); err == nil {
+			t.Errorf("expected error for %q", in)
+		}
+	}
+}
+
+func TestAddRemoveBookmarks(t *testing.T) {
+	public := nostr.Tags{{"e", "n1"}, {"t", "go"}}
+	private := nostr.Tags{{"r", "https://example.com"}}
+
+	// Adding privately moves an existing public item.
+	public, private = addBookmarks(public, private, []nostr.Tag{{"e", "n1"}, {"e", "n2"}}, true)
+	if len(public) != 1 || len(private) != 3 {
+		t.Errorf("public=%v private=%v", public, private)
+	}
+	public, private = addBookmarks(public, private, []nostr.Tag{{"e", "n2"}}, false)
+	if len(public) != 2 || len(private) != 2 {
+		t.Errorf("public=%v private=%v", public, private)
+	}
+
+	public, private, n := removeBookmarks(public, private, []nostr.Tag{{"e", "n2"}, {"r", "https://example.com"}, {"e", "missing"}})
+	if n != 2 || len(public) != 1 || len(private) != 1 {
+		t.Errorf("n=%d public=%v private=%v", n, public, private)
+	}
+}
+
+func TestBookmarkPrivateRoundTrip(t *testing.T) {
+	sk := nostr.GeneratePrivateKey()
+	pub, _ := nostr.GetPublicKey(sk)
+	content, err := encryptPrivateTags(sk, pub, nostr.Tags{{"e", "n1"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ev := buildBookmarkListEvent(pub, nostr.Tags{{"t", "go"}, {"client", "old"}}, content, 100)
+	if ev.Kind != nostr.KindBookmarkList {
+		t.Errorf("kind=%d", ev.Kind)
+	}
+	if len(findAllTags(ev.Tags, "client")) > 1 {
+		t.Errorf("client tag carried over: %v", ev.Tags)
+	}
+	public, private, err := bookmarkHalves(sk, pub, ev)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(private) != 1 || private[0][1] != "n1" || tagValue(public, "t") != "go" {
+		t.Errorf("public=%v private=%v", public, private)
+	}
+	if content, _ := encryptPrivateTags(sk, pub, nil); content != "" {
+		t.Errorf("empty private half encrypted to %q", content)
+	}
+}
+
+func TestMergeBookmarks(t *testing.T) {
+	items := mergeBookmarks(
+		nostr.Tags{{"e", "n1"}, {"client", "algia"}},
+		nostr.Tags{{"a", "30023:x:slug"}},
+		nostr.Tags{{"d", "bookmark"}, {"e", "n1"}, {"e", "old"}},
+		nostr.Tags{{"r", "https://example.com"}},
+	)
+	if len(items) != 4 {
+		t.Fatalf("items=%v", items)
+	}
+	if items[0].Private || items[0].Legacy || !items[1].Private || !items[2].Legacy || items[2].Tag[1] != "old" || !items[3].Private || !items[3].Legacy {
+		t.Errorf("items=%+v", items)
+	}
+}
+
+func TestFetchLegacyBookmarksNIP44(t *testing.T) {
+	sk := nostr.GeneratePrivateKey()
+	pub, _ := nostr.GetPublicKey(sk)
+	nsec, _ := nip19.EncodePrivateKey(sk)
+	tr, url := newTestRelay(t, nil)
+	cfg := &Config{
+		PrivateKey: nsec,
+		Relays:     map[string]Relay{url: {Read: true, Bookmark: true}},
+		pool:       nostr.NewSimplePool(context.Background()),
+	}
+
+	// A legacy list re-sealed with NIP-44, as other clients and list edits do.
+	content, err := encryptPrivateTags(sk, pub, nostr.Tags{{"e", "n1"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	legacy := &nostr.Event{
+		Kind:      nostr.KindCategorizedBookmarksList,
+		CreatedAt: nostr.Now(),
+		Tags:      nostr.Tags{{"d", legacyBookmarkID}, {"e", "n2"}},
+		Content:   content,
+	}
+	if err := legacy.Sign(sk); err != nil {
+		t.Fatal(err)
+	}
+	tr.store(legacy)
+
+	list, got, err := fetchBookmarkLists(context.Background(), cfg, pub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if list != nil || got == nil || got.ID != legacy.ID || got.Content != content {
+		t.Fatalf("list=%v legacy=%v", list, got)
+	}
+	public, private, err := bookmarkHalves(sk, pub, got)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(private) != 1 || private[0][1] != "n1" || tagValue(public, "e") != "n2" {
+		t.Errorf("public=%v private=%v", public, private)
+	}
+
+	// Content that cannot be decrypted is an error, not an empty half.
+	got.Content = "not encrypted"
+	if _, _, err := bookmarkHalves(sk, pub, got); err == nil {
+		t.Error("undecryptable content must fail")
+	}
+}
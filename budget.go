@@ -0,0 +1,341 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/urfave/cli/v2"
+)
+
+// ZapBudget limits what zaps paid from a wallet may spend, in sats. Zero
+// means no limit. Days and weeks are calendar days and ISO weeks in local
+// time, as in "zap list".
+type ZapBudget struct {
+	MaxPerZap    int64  `json:"max-per-zap,omitempty"`
+	MaxPerDay    int64  `json:"max-per-day,omitempty"`
+	MaxPerWeek   int64  `json:"max-per-week,omitempty"`
+	ConfirmAbove int64  `json:"confirm-above,omitempty"` // ask before paying more than this
+	Wallet       string `json:"wallet,omitempty"`        // agent budget only: wallet the MCP server pays from
+}
+
+// defaultAgentBudget applies to the MCP server when agent-budget is not set.
+var defaultAgentBudget = ZapBudget{MaxPerZap: 100, MaxPerDay: 1000}
+
+func (cfg *Config) agentBudget() *ZapBudget {
+	if cfg.AgentBudget != nil {
+		return cfg.AgentBudget
+	}
+	b := defaultAgentBudget
+	return &b
+}
+
+// ledgerEntry is a zap paid from a wallet. Sats includes the pending
+// payments, which count as spent until the wallet says they failed.
+type ledgerEntry struct {
+	Time      int64            `json:"time"`
+	Sats      int64            `json:"sats"`
+	Recipient string           `json:"recipient"`
+	Event     string           `json:"event,omitempty"`
+	Wallet    string           `json:"wallet,omitempty"`
+	Agent     bool             `json:"agent,omitempty"` // paid through the MCP server
+	Pending   []pendingPayment `json:"pending,omitempty"`
+}
+
+// pendingPayment is an invoice whose pay_invoice got no answer in time.
+type pendingPayment struct {
+	Invoice string `json:"invoice"`
+	Sats    int64  `json:"sats"`
+}
+
+// pendingCheckTimeout bounds looking up pending payments before a zap.
+const pendingCheckTimeout = 10 * time.Second
+
+// zapLedger is the local record of zaps paid from a wallet, one entry per
+// line, that the budgets are checked against. mu is held from the budget
+// check until the zap is recorded, so concurrent MCP calls cannot overspend.
+type zapLedger struct {
+	path string
+	mu   sync.Mutex
+}
+
+func ledgerFileName(profile string) string {
+	if profile == "" {
+		return "zaps.jsonl"
+	}
+	return "zaps-" + profile + ".jsonl"
+}
+
+// load reads every entry. A missing file is empty.
+func (l *zapLedger) load() ([]ledgerEntry, error) {
+	f, err := os.Open(l.path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer f.Close()
+
+	var entries []ledgerEntry
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		line := sc.Bytes()
+		if len(line) == 0 {
+			continue
+		}
+		var e ledgerEntry
+		if err := json.Unmarshal(line, &e); err != nil {
+			return nil, fmt.Errorf("%s: %w", l.path, err)
+		}
+		entries = append(entries, e)
+	}
+	return entries, sc.Err()
+}
+
+// save rewrites the whole ledger through a temporary file and a rename.
+func (l *zapLedger) save(entries []ledgerEntry) error {
+	var buf []byte
+	for _, e := range entries {
+		b, err := json.Marshal(e)
+		if err != nil {
+			return err
+		}
+		buf = append(append(buf, b...), '\n')
+	}
+	tmp := l.path + ".tmp"
+	if err := os.WriteFile(tmp, buf, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, l.path)
+}
+
+func (l *zapLedger) add(e ledgerEntry) error {
+	b, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(l.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(append(b, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// ledgerSpent sums the entries in the day or week of now that count accepts.
+func ledgerSpent(entries []ledgerEntry, now time.Time, period string, count func(ledgerEntry) bool) int64 {
+	label, _ := zapPeriod(now, period)
+	var sats int64
+	for _, e := range entries {
+		if l, _ := zapPeriod(time.Unix(e.Time, 0), period); l == label && count(e) {
+			sats += e.Sats
+		}
+	}
+	return sats
+}
+
+// settlePending asks the wallets about the pending payments of the last week
+// and drops the ones that were settled; failed or expired ones no longer
+// count as spent. Payments the wallet cannot tell about stay pending. It
+// reports whether entries changed.
+func (cfg *Config) settlePending(ctx context.Context, entries []ledgerEntry, now time.Time) bool {
+	ctx, cancel := context.WithTimeout(ctx, pendingCheckTimeout)
+	defer cancel()
+	wallets := map[string]*nwcClient{}
+	defer func() {
+		for _, w := range wallets {
+			if w != nil {
+				w.close()
+			}
+		}
+	}()
+
+	changed := false
+	for i := range entries {
+		e := &entries[i]
+		if len(e.Pending) == 0 || now.Sub(time.Unix(e.Time, 0)) > 8*24*time.Hour {
+			continue
+		}
+		w, ok := wallets[e.Wallet]
+		if !ok {
+			w, _ = cfg.wallet(e.Wallet)
+			wallets[e.Wallet] = w
+		}
+		if w == nil {
+			continue
+		}
+		var still []pendingPayment
+		for _, p := range e.Pending {
+			tx, err := w.lookupInvoice(ctx, p.Invoice)
+			switch {
+			case err != nil:
+				still = append(still, p)
+			case tx.State == "failed" || tx.State == "expired":
+				e.Sats -= p.Sats
+			case tx.State == "settled" || tx.SettledAt > 0:
+			default:
+				still = append(still, p)
+			}
+		}
+		if len(still) != len(e.Pending) {
+			e.Pending = still
+			changed = true
+		}
+	}
+	return changed
+}
+
+func allZaps(ledgerEntry) bool     { return true }
+func agentZaps(e ledgerEntry) bool { return e.Agent }
+
+// check returns why a zap of sats would break b, given the zaps in entries
+// that count accepts. ConfirmAbove is not checked here.
+func (b *ZapBudget) check(entries []ledgerEntry, sats int64, now time.Time, count func(ledgerEntry) bool) error {
+	if b == nil {
+		return nil
+	}
+	if b.MaxPerZap > 0 && sats > b.MaxPerZap {
+		return fmt.Errorf("%d sats is over the limit of %d sats per zap", sats, b.MaxPerZap)
+	}
+	for _, limit := range []struct {
+		period string
+		max    int64
+	}{{"day", b.MaxPerDay}, {"week", b.MaxPerWeek}} {
+		if limit.max <= 0 {
+			continue
+		}
+		if spent := ledgerSpent(entries, now, limit.period, count); spent+sats > limit.max {
+			return fmt.Errorf("%d sats would go over the limit of %d sats per %s (%d spent)", sats, limit.max, limit.period, spent)
+		}
+	}
+	return nil
+}
+
+// spendZap runs pay for the zap e if it fits the zap budget, and records what
+// pay sets as spent in e in the ledger, even when it fails part way. Zaps above
+// confirm-above are confirmed interactively unless yes is set. Zaps from the
+// MCP server must fit the agent budget too; nobody is there to confirm them,
+// so confirm-above (of either budget) refuses them instead. 'wallet pay' goes
+// through here as well, with no recipient.
+func (cfg *Config) spendZap(e ledgerEntry, yes bool, pay func(e *ledgerEntry) error) error {
+	l := cfg.ledger
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	entries, err := l.load()
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	if cfg.settlePending(context.Background(), entries, now) {
+		if err := l.save(entries); err != nil {
+			return err
+		}
+	}
+	if err := cfg.ZapBudget.check(entries, e.Sats, now, allZaps); err != nil {
+		return fmt.Errorf("zap budget: %w", err)
+	}
+	threshold := int64(0)
+	if cfg.ZapBudget != nil {
+		threshold = cfg.ZapBudget.ConfirmAbove
+	}
+	if e.Agent {
+		ab := cfg.agentBudget()
+		if err := ab.check(entries, e.Sats, now, agentZaps); err != nil {
+			return fmt.Errorf("agent budget: %w", err)
+		}
+		if ab.ConfirmAbove > 0 && (threshold == 0 || ab.ConfirmAbove < threshold) {
+			threshold = ab.ConfirmAbove
+		}
+		if threshold > 0 && e.Sats > threshold {
+			return fmt.Errorf("agent budget: zaps over %d sats need confirmation by hand", threshold)
+		}
+	} else if threshold > 0 && e.Sats > threshold && !yes {
+		prompt, canceled := fmt.Sprintf("Zap %d sats to %s?", e.Sats, cfg.displayName(e.Recipient)), "zap canceled"
+		if e.Recipient == "" {
+			prompt, canceled = fmt.Sprintf("Pay %d sats?", e.Sats), "payment canceled"
+		}
+		if !confirm(prompt) {
+			return errors.New(canceled)
+		}
+	}
+
+	spent := e
+	spent.Sats = 0
+	err = pay(&spent)
+	if spent.Sats > 0 {
+		spent.Time = now.Unix()
+		if err := l.add(spent); err != nil {
+			// The zap is paid; only the record is missing.
+			fmt.Fprintf(os.Stderr, "failed to record the zap in %s: %v\n", l.path, err)
+		}
+	}
+	return err
+}
+
+func budgetLimit(spent, max int64) string {
+	if max <= 0 {
+		return fmt.Sprintf("%d sats (no limit)", spent)
+	}
+	return fmt.Sprintf("%d of %d sats", spent, max)
+}
+
+func doZapBudget(cCtx *cli.Context) error {
+	cfg := cCtx.App.Metadata["config"].(*Config)
+	l := cfg.ledger
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	entries, err := l.load()
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	if cfg.settlePending(cCtx.Context, entries, now) {
+		if err := l.save(entries); err != nil {
+			return err
+		}
+	}
+	b := cfg.ZapBudget
+	if b == nil {
+		b = &ZapBudget{}
+	}
+	ab := cfg.agentBudget()
+	for _, who := range []struct {
+		name   string
+		budget *ZapBudget
+		count  func(ledgerEntry) bool
+	}{{"all zaps", b, allZaps}, {"agent (MCP)", ab, agentZaps}} {
+		fmt.Println(who.name)
+		perZap := "no limit"
+		if who.budget.MaxPerZap > 0 {
+			perZap = fmt.Sprintf("%d sats", who.budget.MaxPerZap)
+		}
+		fmt.Printf("  per zap:   %s\n", perZap)
+		fmt.Printf("  today:     %s\n", budgetLimit(ledgerSpent(entries, now, "day", who.count), who.budget.MaxPerDay))
+		fmt.Printf("  this week: %s\n", budgetLimit(ledgerSpent(entries, now, "week", who.count), who.budget.MaxPerWeek))
+		if who.budget.ConfirmAbove > 0 {
+			fmt.Printf("  confirm above %d sats\n", who.budget.ConfirmAbove)
+		}
+		if who.budget.Wallet != "" {
+			fmt.Printf("  wallet:    %s\n", who.budget.Wallet)
+		}
+	}
+	var pending int64
+	for _, e := range entries {
+		for _, p := range e.Pending {
+			pending += p.Sats
+		}
+	}
+	if pending > 0 {
+		fmt.Printf("%d sats in payments the wallet has not confirmed yet are counted as spent\n", pending)
+	}
+	return nil
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestZapBudgetCheck(t *testing.T) {
+	now := time.Date(2026, 3, 4, 12, 0, 0, 0, time.Local) // a Wednesday
+	entries := []ledgerEntry{
+		{Time: now.Add(-time.Hour).Unix(), Sats: 300},
+		{Time: now.Add(-time.Hour).Unix(), Sats: 50, Agent: true},
+		{Time: now.AddDate(0, 0, -2).Unix(), Sats: 400},
+		{Time: now.AddDate(0, 0, -9).Unix(), Sats: 5000},
+	}
+	if got := ledgerSpent(entries, now, "day", allZaps); got != 350 {
+		t.Errorf("day=%d", got)
+	}
+	if got := ledgerSpent(entries, now, "week", allZaps); got != 750 {
+		t.Errorf("week=%d", got)
+	}
+	if got := ledgerSpent(entries, now, "day", agentZaps); got != 50 {
+		t.Errorf("agent day=%d", got)
+	}
+
+	var none *ZapBudget
+	if err := none.check(entries, 1_000_000, now, allZaps); err != nil {
+		t.Errorf("no budget: %v", err)
+	}
+	b := &ZapBudget{MaxPerZap: 500, MaxPerDay: 500, MaxPerWeek: 1000}
+	for _, tc := range []struct {
+		sats int64
+		want string
+	}{
+		{100, ""},
+		{150, ""},
+		{151, "per day (350 spent)"},
+		{501, "per zap"},
+	} {
+		err := b.check(entries, tc.sats, now, allZaps)
+		if tc.want == "" && err != nil || tc.want != "" && (err == nil || !strings.Contains(err.Error(), tc.want)) {
+			t.Errorf("%d sats: err=%v, want %q", tc.sats, err, tc.want)
+		}
+	}
+	b = &ZapBudget{MaxPerWeek: 800}
+	if err := b.check(entries, 51, now, allZaps); err == nil || !strings.Contains(err.Error(), "per week") {
+		t.Errorf("week limit: %v", err)
+	}
+}
+
+func TestSpendZap(t *testing.T) {
+	cfg := &Config{
+		ledger:    &zapLedger{path: filepath.Join(t.TempDir(), ledgerFileName(""))},
+		ZapBudget: &ZapBudget{MaxPerDay: 1100, ConfirmAbove: 300},
+	}
+	paid := 0
+	pay := func(sats int64) func(*ledgerEntry) error {
+		return func(e *ledgerEntry) error { paid++; e.Sats = sats; return nil }
+	}
+
+	if err := cfg.spendZap(ledgerEntry{Sats: 600, Recipient: "bob"}, true, pay(600)); err != nil {
+		t.Fatal(err)
+	}
+	if err := cfg.spendZap(ledgerEntry{Sats: 501, Recipient: "bob"}, true, pay(501)); err == nil || !strings.Contains(err.Error(), "zap budget") {
+		t.Errorf("over the day: %v", err)
+	}
+	if err := cfg.spendZap(ledgerEntry{Sats: 10}, true, func(*ledgerEntry) error { return errors.New("no route") }); err == nil {
+		t.Error("failed payment must fail")
+	}
+	// A split zap that failed part way records what was paid.
+	if err := cfg.spendZap(ledgerEntry{Sats: 30}, true, func(e *ledgerEntry) error { e.Sats = 20; return errors.New("1 of 2 zaps failed") }); err == nil {
+		t.Error("partly failed payment must fail")
+	}
+
+	// The agent gets the default budget, and cannot confirm.
+	if err := cfg.spendZap(ledgerEntry{Sats: 101, Agent: true}, true, pay(101)); err == nil || !strings.Contains(err.Error(), "agent budget") {
+		t.Errorf("agent over default per zap: %v", err)
+	}
+	cfg.AgentBudget = &ZapBudget{MaxPerZap: 400, MaxPerDay: 350}
+	if err := cfg.spendZap(ledgerEntry{Sats: 350, Agent: true}, true, pay(350)); err == nil || !strings.Contains(err.Error(), "confirmation") {
+		t.Errorf("agent above confirm-above: %v", err)
+	}
+	if err := cfg.spendZap(ledgerEntry{Sats: 100, Agent: true}, true, pay(100)); err != nil {
+		t.Fatal(err)
+	}
+	if err := cfg.spendZap(ledgerEntry{Sats: 300, Agent: true}, true, pay(300)); err == nil || !strings.Contains(err.Error(), "per day (100 spent)") {
+		t.Errorf("agent over its day: %v", err)
+	}
+
+	if paid != 2 {
+		t.Errorf("paid=%d", paid)
+	}
+	entries, err := cfg.ledger.load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 3 || entries[0].Sats != 600 || entries[1].Sats != 20 || entries[0].Agent || !entries[2].Agent || entries[2].Time == 0 {
+		t.Errorf("entries=%+v", entries)
+	}
+}
+
+func TestWalletURI(t *testing.T) {
+	cfg := &Config{NwcURI: "nostr+walletconnect://default", Wallets: map[string]string{"tips": "nostr+walletconnect://tips"}}
+	if uri, err := cfg.walletURI(""); err != nil || uri != cfg.NwcURI {
+		t.Errorf("default: %q %v", uri, err)
+	}
+	if uri, err := cfg.walletURI("tips"); err != nil || uri != "nostr+walletconnect://tips" {
+		t.Errorf("tips: %q %v", uri, err)
+	}
+	if _, err := cfg.walletURI("savings"); err == nil || !strings.Contains(err.Error(), "have tips") {
+		t.Errorf("unknown: %v", err)
+	}
+	if _, err := (&Config{}).wallet(""); err == nil {
+		t.Error("no wallet must fail")
+	}
+}
+
+func TestSettlePending(t *testing.T) {
+	tw, _ := newTestWallet(t, "lookup_invoice", "nip44_v2")
+	now := time.Now()
+	cfg := &Config{
+		Wallets: map[string]string{"tips": tw.uri},
+		ledger:  &zapLedger{path: filepath.Join(t.TempDir(), ledgerFileName(""))},
+	}
+	entries := []ledgerEntry{
+		{Time: now.Unix(), Sats: 30, Wallet: "tips", Pending: []pendingPayment{{"lnfailed", 10}, {"lnsettled", 10}, {"lnunknown", 10}}},
+		{Time: now.Unix(), Sats: 5},
+	}
+	if !cfg.settlePending(context.Background(), entries, now) {
+		t.Fatal("nothing settled")
+	}
+	if entries[0].Sats != 20 || len(entries[0].Pending) != 1 || entries[0].Pending[0].Invoice != "lnunknown" {
+		t.Errorf("entry=%+v", entries[0])
+	}
+	if cfg.settlePending(context.Background(), entries, now) {
+		t.Error("unknown payment must stay pending")
+	}
+
+	// A timed-out payment is counted until the wallet says otherwise.
+	cfg.ZapBudget = &ZapBudget{MaxPerDay: 35}
+	if err := cfg.ledger.save(entries); err != nil {
+		t.Fatal(err)
+	}
+	err := cfg.spendZap(ledgerEntry{Sats: 10, Wallet: "tips"}, true, func(e *ledgerEntry) error {
+		e.Sats = 10
+		e.Pending = []pendingPayment{{"lnslow", 10}}
+		return &zapPendingError{Invoice: "lnslow", err: errNWCTimeout}
+	})
+	if !errors.Is(err, errNWCTimeout) {
+		t.Errorf("err=%v", err)
+	}
+	got, _ := cfg.ledger.load()
+	if len(got) != 3 || got[2].Sats != 10 || len(got[2].Pending) != 1 {
+		t.Fatalf("ledger=%+v", got)
+	}
+	if err := cfg.ZapBudget.check(got, 1, now, allZaps); err == nil {
+		t.Error("pending payments must count against the budget")
+	}
+}
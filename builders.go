@@ -119,7 +119,7 @@ func buildPostEvent(arg *postArg, pubkey string, mentionPubkeys []string, now no
 	}
 	for _, entry := range extractEmojis(ev.Content) {
 		name := strings.Trim(entry.text, ":")
-		if icon, ok := arg.cfg.Emojis[name]; ok {
+		if icon, ok := arg.emojis[name]; ok {
 			ev.Tags = ev.Tags.AppendUnique(nostr.Tag{"emoji", name, icon})
 		}
 	}
@@ -159,7 +159,7 @@ func TestBuildRepostEvent_NoRelayHint(t *testing.T) {
 
 func newPostArg(content string) *postArg {
 	return &postArg{
-		cfg:     &Config{Emojis: map[string]string{}},
+		cfg:     &Config{},
 		content: content,
 	}
 }
@@ -226,7 +226,7 @@ func TestBuildPostEvent_MultipleHashtags(t *testing.T) {
 
 func TestBuildPostEvent_EmojiFlagAndInline(t *testing.T) {
 	arg := newPostArg("hi :heart: there :wave: now")
-	arg.cfg.Emojis = map[string]string{"heart": "https://e.example/h.png"}
+	arg.emojis = map[string]string{"heart": "https://e.example/h.png"}
 	arg.emoji = []string{"wave=https://e.example/w.png"}
 	ev, err := buildPostEvent(arg, testPub, nil, 0)
 	if err != nil {
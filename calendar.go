@@ -0,0 +1,685 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/fatih/color"
+	"github.com/urfave/cli/v2"
+
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/nbd-wtf/go-nostr/nip19"
+)
+
+const calendarDateLayout = "2006-01-02"
+
+// calendarEvent is the parsed form of a NIP-52 kind 31922 / 31923 event.
+type calendarEvent struct {
+	Event        *nostr.Event
+	Title        string
+	Start        time.Time // for date-based events, midnight UTC of the start date
+	End          time.Time // zero when not given; exclusive for date-based events
+	DateBased    bool
+	TZ           string
+	Location     string
+	Geohash      string
+	Participants []string
+}
+
+// calendarEventOpts captures everything buildCalendarEvent needs.
+type calendarEventOpts struct {
+	Name         string // d tag
+	Title        string
+	Description  string
+	Start        string // YYYY-MM-DD for a date-based event, date and time for a time-based one
+	End          string
+	TZ           string // IANA timezone the times are given in; "" for local time
+	Location     string
+	Geohash      string
+	Participants []string // hex pubkeys
+	Hashtags     []string
+}
+
+// parseCalendarTime parses a start/end value. A bare date makes a date-based
+// event; anything else is a time in loc (see parseScheduleTime for formats).
+func parseCalendarTime(s string, loc *time.Location) (t time.Time, dateOnly bool, err error) {
+	if d, err := time.Parse(calendarDateLayout, s); err == nil {
+		return d, true, nil
+	}
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, false, nil
+	}
+	for _, layout := range scheduleTimeLayouts {
+		if t, err := time.ParseInLocation(layout, s, loc); err == nil {
+			return t, false, nil
+		}
+	}
+	return time.Time{}, false, fmt.Errorf("invalid time %q (use 2026-11-01 or 2026-11-01T19:00)", s)
+}
+
+// buildCalendarEvent constructs an unsigned NIP-52 calendar event: kind 31922
+// when Start is a date, kind 31923 (with start_tzid and D tags) when it is a
+// time.
+func buildCalendarEvent(pubkey string, opts calendarEventOpts, createdAt nostr.Timestamp) (*nostr.Event, error) {
+	if strings.TrimSpace(opts.Title) == "" {
+		return nil, errors.New("title is empty")
+	}
+	if opts.Name == "" {
+		return nil, errors.New("name is empty")
+	}
+	loc := time.Local
+	if opts.TZ != "" {
+		l, err := time.LoadLocation(opts.TZ)
+		if err != nil {
+			return nil, err
+		}
+		loc = l
+	}
+	start, dateOnly, err := parseCalendarTime(opts.Start, loc)
+	if err != nil {
+		return nil, err
+	}
+	var end time.Time
+	if opts.End != "" {
+		var endDateOnly bool
+		end, endDateOnly, err = parseCalendarTime(opts.End, loc)
+		if err != nil {
+			return nil, err
+		}
+		if endDateOnly != dateOnly {
+			return nil, errors.New("start and end must both be dates or both be times")
+		}
+		if !end.After(start) {
+			return nil, errors.New("end must be after start")
+		}
+	}
+
+	ev := &nostr.Event{
+		PubKey:    pubkey,
+		CreatedAt: createdAt,
+		Content:   opts.Description,
+		Tags:      nostr.Tags{{"d", opts.Name}, {"title", opts.Title}},
+	}
+	clientTag(ev)
+
+	if dateOnly {
+		ev.Kind = nostr.KindDateCalendarEvent
+		ev.Tags = append(ev.Tags, nostr.Tag{"start", start.Format(calendarDateLayout)})
+		if !end.IsZero() {
+			ev.Tags = append(ev.Tags, nostr.Tag{"end", end.Format(calendarDateLayout)})
+		}
+	} else {
+		ev.Kind = nostr.KindTimeCalendarEvent
+		ev.Tags = append(ev.Tags, nostr.Tag{"start", strconv.FormatInt(start.Unix(), 10)})
+		if !end.IsZero() {
+			ev.Tags = append(ev.Tags, nostr.Tag{"end", strconv.FormatInt(end.Unix(), 10)})
+		}
+		if opts.TZ != "" {
+			ev.Tags = append(ev.Tags, nostr.Tag{"start_tzid", opts.TZ})
+			if !end.IsZero() {
+				ev.Tags = append(ev.Tags, nostr.Tag{"end_tzid", opts.TZ})
+			}
+		}
+		// NIP-52: one D tag per day (days since the epoch) the event touches,
+		// so clients can query a day range.
+		last := start
+		if !end.IsZero() {
+			last = end.Add(-time.Second)
+		}
+		for d := start.Unix() / 86400; d <= last.Unix()/86400; d++ {
+			ev.Tags = append(ev.Tags, nostr.Tag{"D", strconv.FormatInt(d, 10)})
+		}
+	}
+
+	if opts.Location != "" {
+		ev.Tags = append(ev.Tags, nostr.Tag{"location", opts.Location})
+	}
+	if opts.Geohash != "" {
+		ev.Tags = append(ev.Tags, nostr.Tag{"g", opts.Geohash})
+	}
+	for _, p := range opts.Participants {
+		ev.Tags = ev.Tags.AppendUnique(nostr.Tag{"p", p, "", "participant"})
+	}
+	for _, t := range opts.Hashtags {
+		ev.Tags = ev.Tags.AppendUnique(nostr.Tag{"t", strings.TrimPrefix(t, "#")})
+	}
+	for _, m := range extractTags(ev.Content) {
+		ev.Tags = ev.Tags.AppendUnique(nostr.Tag{"t", m.text})
+	}
+	for _, entry := range extractLinks(ev.Content) {
+		ev.Tags = ev.Tags.AppendUnique(nostr.Tag{"r", entry.text})
+	}
+	return ev, nil
+}
+
+// parseCalendarEvent reads a kind 31922 / 31923 event. Older events that use
+// "name" instead of "title" are accepted.
+func parseCalendarEvent(ev *nostr.Event) (*calendarEvent, error) {
+	ce := &calendarEvent{
+		Event:     ev,
+		Title:     tagValue(ev.Tags, "title"),
+		DateBased: ev.Kind == nostr.KindDateCalendarEvent,
+		TZ:        tagValue(ev.Tags, "start_tzid"),
+		Location:  tagValue(ev.Tags, "location"),
+		Geohash:   tagValue(ev.Tags, "g"),
+	}
+	if ce.Title == "" {
+		ce.Title = tagValue(ev.Tags, "name")
+	}
+	for p := range ev.Tags.FindAll("p") {
+		ce.Participants = append(ce.Participants, p[1])
+	}
+	parse := func(s string) (time.Time, error) {
+		if ce.DateBased {
+			return time.Parse(calendarDateLayout, s)
+		}
+		n, err := strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			return time.Time{}, err
+		}
+		return time.Unix(n, 0), nil
+	}
+	var err error
+	if ce.Start, err = parse(tagValue(ev.Tags, "start")); err != nil {
+		return nil, fmt.Errorf("invalid start: %w", err)
+	}
+	if s := tagValue(ev.Tags, "end"); s != "" {
+		if ce.End, err = parse(s); err != nil {
+			return nil, fmt.Errorf("invalid end: %w", err)
+		}
+	}
+	return ce, nil
+}
+
+// upcoming reports whether the event has not finished at now.
+func (ce *calendarEvent) upcoming(now time.Time) bool {
+	if ce.DateBased {
+		today, _ := time.Parse(calendarDateLayout, now.Format(calendarDateLayout))
+		last := ce.Start
+		if !ce.End.IsZero() {
+			last = ce.End.AddDate(0, 0, -1)
+		}
+		return !last.Before(today)
+	}
+	if !ce.End.IsZero() {
+		return ce.End.After(now)
+	}
+	return !ce.Start.Before(now)
+}
+
+// when renders the event's time span. Time-based events are shown in the
+// local timezone.
+func (ce *calendarEvent) when() string {
+	if ce.DateBased {
+		s := ce.Start.Format(calendarDateLayout)
+		if !ce.End.IsZero() && ce.End.Sub(ce.Start) > 24*time.Hour {
+			s += " - " + ce.End.AddDate(0, 0, -1).Format(calendarDateLayout)
+		}
+		return s
+	}
+	start := ce.Start.Local()
+	s := start.Format("2006-01-02 15:04 MST")
+	if !ce.End.IsZero() {
+		end := ce.End.Local()
+		if end.Format(calendarDateLayout) == start.Format(calendarDateLayout) {
+			s = start.Format("2006-01-02 15:04") + " - " + end.Format("15:04 MST")
+		} else {
+			s += " - " + end.Format("2006-01-02 15:04 MST")
+		}
+	}
+	return s
+}
+
+// naddr returns the NIP-19 address of the event.
+func (ce *calendarEvent) naddr() string {
+	s, err := nip19.EncodeEntity(ce.Event.PubKey, ce.Event.Kind, tagValue(ce.Event.Tags, "d"), nil)
+	if err != nil {
+		return ce.Event.ID
+	}
+	return s
+}
+
+func (cfg *Config) printCalendarEvent(ce *calendarEvent) {
+	color.Set(color.FgHiGreen)
+	fmt.Print(ce.when())
+	color.Set(color.Reset)
+	fmt.Print(" ")
+	color.Set(color.FgHiRed)
+	fmt.Println(ce.Title)
+	color.Set(color.Reset)
+	if ce.Location != "" {
+		fmt.Println("  @ " + ce.Location)
+	}
+	if ce.TZ != "" {
+		color.Set(color.FgHiBlack)
+		fmt.Println("  tz: " + ce.TZ)
+		color.Set(color.Reset)
+	}
+	color.Set(color.FgHiBlue)
+	fmt.Println("  " + ce.naddr())
+	color.Set(color.Reset)
+}
+
+// decodeNaddr decodes an naddr (with or without "nostr:") into its pointer.
+func decodeNaddr(s string) (*nostr.EntityPointer, error) {
+	prefix, v, err := nip19.Decode(strings.TrimPrefix(s, "nostr:"))
+	if err != nil || prefix != "naddr" {
+		return nil, fmt.Errorf("failed to parse naddr '%s'", s)
+	}
+	ep := v.(nostr.EntityPointer)
+	return &ep, nil
+}
+
+// fetchAddressable returns the newest event at each address.
+func fetchAddressable(ctx context.Context, cfg *Config, eps []*nostr.EntityPointer) ([]*nostr.Event, error) {
+	if len(eps) == 0 {
+		return nil, nil
+	}
+	filters := nostr.Filters{}
+	var relays []string
+	for _, ep := range eps {
+		filters = append(filters, nostr.Filter{
+			Kinds:   []int{ep.Kind},
+			Authors: []string{ep.PublicKey},
+			Tags:    nostr.TagMap{"d": []string{ep.Identifier}},
+		})
+		relays = append(relays, ep.Relays...)
+	}
+	evs, err := cfg.QueryEvents(ctx, filters)
+	if (err != nil || len(evs) == 0) && len(relays) > 0 {
+		evs, err = cfg.queryRelays(ctx, relays, filters)
+	}
+	if err != nil {
+		return nil, err
+	}
+	latest := map[string]*nostr.Event{}
+	for _, ev := range evs {
+		key := fmt.Sprintf("%d:%s:%s", ev.Kind, ev.PubKey, tagValue(ev.Tags, "d"))
+		if cur, ok := latest[key]; !ok || ev.CreatedAt > cur.CreatedAt {
+			latest[key] = ev
+		}
+	}
+	out := make([]*nostr.Event, 0, len(latest))
+	for _, ev := range latest {
+		out = append(out, ev)
+	}
+	return out, nil
+}
+
+func doCalendarCreate(cCtx *cli.Context) error {
+	cfg := cCtx.App.Metadata["config"].(*Config)
+	ctx := context.Background()
+
+	_, pub, err := getSkAndPub(cfg)
+	if err != nil {
+		return err
+	}
+	participants, err := resolveMentions(ctx, cCtx.StringSlice("p"))
+	if err != nil {
+		return err
+	}
+	name := cCtx.String("name")
+	if name == "" {
+		name = randomID(8)
+	}
+	ev, err := buildCalendarEvent(pub, calendarEventOpts{
+		Name:         name,
+		Title:        cCtx.String("title"),
+		Description:  strings.Join(cCtx.Args().Slice(), "\n"),
+		Start:        cCtx.String("start"),
+		End:          cCtx.String("end"),
+		TZ:           cCtx.String("tz"),
+		Location:     cCtx.String("location"),
+		Geohash:      cCtx.String("geohash"),
+		Participants: participants,
+		Hashtags:     cCtx.StringSlice("tag"),
+	}, nostr.Now())
+	if err != nil {
+		return err
+	}
+	if err := cfg.signEvent(ev); err != nil {
+		return err
+	}
+	if cfg.publishEvent(ctx, ev) == 0 {
+		return errors.New("cannot create calendar event")
+	}
+	if s, err := nip19.EncodeEntity(pub, ev.Kind, name, nil); err == nil {
+		fmt.Println(s)
+	}
+	return nil
+}
+
+// fetchCalendarEvents returns the events of the calendar at calendarID
+// (naddr of a kind 31924), or the calendar events of us and our follows when
+// calendarID is empty, sorted by start. Past events are left out unless all.
+func fetchCalendarEvents(ctx context.Context, cfg *Config, calendarID string, n int, all bool) ([]*calendarEvent, error) {
+	var evs []*nostr.Event
+	if calendarID != "" {
+		ep, err := decodeNaddr(calendarID)
+		if err != nil {
+			return nil, err
+		}
+		cals, err := fetchAddressable(ctx, cfg, []*nostr.EntityPointer{ep})
+		if err != nil {
+			return nil, err
+		}
+		if len(cals) == 0 {
+			return nil, fmt.Errorf("calendar not found: %s", calendarID)
+		}
+		var eps []*nostr.EntityPointer
+		for tag := range cals[0].Tags.FindAll("a") {
+			if p, err := nostr.EntityPointerFromTag(tag); err == nil {
+				eps = append(eps, &p)
+			}
+		}
+		if evs, err = fetchAddressable(ctx, cfg, eps); err != nil {
+			return nil, err
+		}
+	} else {
+		_, pub, err := getSkAndPub(cfg)
+		if err != nil {
+			return nil, err
+		}
+		found, err := cfg.QueryEvents(ctx, nostr.Filters{{
+			Kinds:   []int{nostr.KindDateCalendarEvent, nostr.KindTimeCalendarEvent},
+			Authors: append([]string{pub}, cfg.FollowList...),
+			Limit:   n,
+		}})
+		if err != nil {
+			return nil, err
+		}
+		// Drop superseded versions of the same address.
+		latest := map[string]*nostr.Event{}
+		for _, ev := range found {
+			key := fmt.Sprintf("%d:%s:%s", ev.Kind, ev.PubKey, tagValue(ev.Tags, "d"))
+			if cur, ok := latest[key]; !ok || ev.CreatedAt > cur.CreatedAt {
+				latest[key] = ev
+			}
+		}
+		for _, ev := range latest {
+			evs = append(evs, ev)
+		}
+	}
+
+	now := time.Now()
+	var ces []*calendarEvent
+	for _, ev := range evs {
+		ce, err := parseCalendarEvent(ev)
+		if err != nil {
+			continue
+		}
+		if all || ce.upcoming(now) {
+			ces = append(ces, ce)
+		}
+	}
+	sort.Slice(ces, func(i, j int) bool {
+		return ces[i].Start.Before(ces[j].Start)
+	})
+	return ces, nil
+}
+
+func (cfg *Config) printCalendarEvents(ces []*calendarEvent, j bool) {
+	for _, ce := range ces {
+		if j {
+			json.NewEncoder(os.Stdout).Encode(ce.Event)
+			continue
+		}
+		cfg.printCalendarEvent(ce)
+		fmt.Println()
+	}
+}
+
+func doCalendarList(cCtx *cli.Context) error {
+	cfg := cCtx.App.Metadata["config"].(*Config)
+	ces, err := fetchCalendarEvents(context.Background(), cfg, cCtx.String("calendar"), cCtx.Int("n"), cCtx.Bool("all"))
+	if err != nil {
+		return err
+	}
+	cfg.printCalendarEvents(ces, cCtx.Bool("json"))
+	return nil
+}
+
+func doCalendarShow(cCtx *cli.Context) error {
+	cfg := cCtx.App.Metadata["config"].(*Config)
+	ctx := context.Background()
+
+	ep, err := decodeNaddr(cCtx.String("id"))
+	if err != nil {
+		return err
+	}
+	if ep.Kind == nostr.KindCalendar {
+		ces, err := fetchCalendarEvents(ctx, cfg, cCtx.String("id"), cCtx.Int("n"), cCtx.Bool("all"))
+		if err != nil {
+			return err
+		}
+		cfg.printCalendarEvents(ces, cCtx.Bool("json"))
+		return nil
+	}
+	evs, err := fetchAddressable(ctx, cfg, []*nostr.EntityPointer{ep})
+	if err != nil {
+		return err
+	}
+	if len(evs) == 0 {
+		return fmt.Errorf("calendar event not found: %s", cCtx.String("id"))
+	}
+	ce, err := parseCalendarEvent(evs[0])
+	if err != nil {
+		return err
+	}
+
+	rsvps, err := cfg.QueryEvents(ctx, nostr.Filters{{
+		Kinds: []int{nostr.KindCalendarEventRSVP},
+		Tags:  nostr.TagMap{"a": []string{addressValue(ep)}},
+	}})
+	if err != nil {
+		return err
+	}
+	status := tallyRSVPs(rsvps)
+
+	if cCtx.Bool("json") {
+		return json.NewEncoder(os.Stdout).Encode(struct {
+			Event *nostr.Event      `json:"event"`
+			RSVPs map[string]string `json:"rsvps"`
+		}{ce.Event, status})
+	}
+
+	cfg.printCalendarEvent(ce)
+	if ce.Event.Content != "" {
+		fmt.Println()
+		fmt.Println(ce.Event.Content)
+	}
+	if len(ce.Participants) > 0 {
+		fmt.Println()
+		fmt.Println("participants:")
+		for _, p := range ce.Participants {
+			fmt.Println("  " + cfg.displayName(p))
+		}
+	}
+	if len(status) > 0 {
+		counts := map[string][]string{}
+		for p, s := range status {
+			counts[s] = append(counts[s], cfg.displayName(p))
+		}
+		fmt.Println()
+		for _, s := range []string{"accepted", "tentative", "declined"} {
+			if len(counts[s]) == 0 {
+				continue
+			}
+			sort.Strings(counts[s])
+			fmt.Printf("%s (%d): %s\n", s, len(counts[s]), strings.Join(counts[s], ", "))
+		}
+	}
+	return nil
+}
+
+// displayName returns the cached profile name of pubkey, or its npub.
+func (cfg *Config) displayName(pubkey string) string {
+	if profile, err := cfg.GetProfile(pubkey); err == nil && profile.Name != "" {
+		return profile.Name
+	}
+	if npub, err := nip19.EncodePublicKey(pubkey); err == nil {
+		return npub
+	}
+	return pubkey
+}
+
+// tallyRSVPs returns the latest RSVP status per pubkey.
+func tallyRSVPs(evs []*nostr.Event) map[string]string {
+	latest := map[string]*nostr.Event{}
+	for _, ev := range evs {
+		if cur, ok := latest[ev.PubKey]; !ok || ev.CreatedAt > cur.CreatedAt {
+			latest[ev.PubKey] = ev
+		}
+	}
+	status := map[string]string{}
+	for p, ev := range latest {
+		if s := tagValue(ev.Tags, "status"); s != "" {
+			status[p] = s
+		}
+	}
+	return status
+}
+
+// buildRSVPEvent constructs an unsigned kind 31925 RSVP to the calendar event
+// at ep. The d tag is derived from the address, so answering again replaces
+// the previous RSVP.
+func buildRSVPEvent(pubkey string, ep *nostr.EntityPointer, eventID, status, note string, createdAt nostr.Timestamp) (*nostr.Event, error) {
+	switch status {
+	case "accepted", "declined", "tentative":
+	default:
+		return nil, fmt.Errorf("invalid status %q (accepted, declined or tentative)", status)
+	}
+	if ep.Kind != nostr.KindDateCalendarEvent && ep.Kind != nostr.KindTimeCalendarEvent {
+		return nil, fmt.Errorf("kind %d is not a calendar event", ep.Kind)
+	}
+	addr := addressValue(ep)
+	ev := &nostr.Event{
+		PubKey:    pubkey,
+		CreatedAt: createdAt,
+		Kind:      nostr.KindCalendarEventRSVP,
+		Content:   note,
+		Tags: nostr.Tags{
+			{"d", addr},
+			{"a", addr},
+		},
+	}
+	if eventID != "" {
+		ev.Tags = append(ev.Tags, nostr.Tag{"e", eventID})
+	}
+	ev.Tags = append(ev.Tags, nostr.Tag{"status", status})
+	if status != "declined" {
+		fb := "busy"
+		if status == "tentative" {
+			fb = "free"
+		}
+		ev.Tags = append(ev.Tags, nostr.Tag{"fb", fb})
+	}
+	ev.Tags = append(ev.Tags, nostr.Tag{"p", ep.PublicKey})
+	clientTag(ev)
+	return ev, nil
+}
+
+func doCalendarRSVP(cCtx *cli.Context) error {
+	if cCtx.Args().Len() != 1 {
+		return cli.ShowSubcommandHelp(cCtx)
+	}
+	cfg := cCtx.App.Metadata["config"].(*Config)
+	ctx := context.Background()
+
+	_, pub, err := getSkAndPub(cfg)
+	if err != nil {
+		return err
+	}
+	ep, err := decodeNaddr(cCtx.String("id"))
+	if err != nil {
+		return err
+	}
+	var eventID string
+	if evs, err := fetchAddressable(ctx, cfg, []*nostr.EntityPointer{ep}); err == nil && len(evs) > 0 {
+		eventID = evs[0].ID
+	}
+	ev, err := buildRSVPEvent(pub, ep, eventID, cCtx.Args().First(), cCtx.String("note"), nostr.Now())
+	if err != nil {
+		return err
+	}
+	if err := cfg.signEvent(ev); err != nil {
+		return err
+	}
+	if cfg.publishEvent(ctx, ev) == 0 {
+		return errors.New("cannot rsvp")
+	}
+	return nil
+}
+
+// calendarCommand returns the "calendar" parent command with its subcommands (NIP-52).
+func calendarCommand() *cli.Command {
+	return &cli.Command{
+		Name:  "calendar",
+		Usage: "calendar events (NIP-52)",
+		Action: func(cCtx *cli.Context) error {
+			return cli.ShowSubcommandHelp(cCtx)
+		},
+		Subcommands: []*cli.Command{
+			{
+				Name: "create",
+				Flags: []cli.Flag{
+					&cli.StringFlag{Name: "title", Required: true, Usage: "event title"},
+					&cli.StringFlag{Name: "start", Required: true, Usage: "start date (2026-11-01) or time (2026-11-01T19:00)"},
+					&cli.StringFlag{Name: "end", Usage: "end date (exclusive) or time"},
+					&cli.StringFlag{Name: "tz", Usage: "IANA timezone of start/end (e.g. Asia/Tokyo; default: local time)"},
+					&cli.StringFlag{Name: "location", Usage: "location"},
+					&cli.StringFlag{Name: "geohash", Usage: "geohash of the location"},
+					&cli.StringSliceFlag{Name: "p", Usage: "participant (npub/nprofile/hex/NIP-05, repeatable)"},
+					&cli.StringSliceFlag{Name: "tag", Aliases: []string{"t"}, Usage: "hashtag (repeatable)"},
+					&cli.StringFlag{Name: "name", Usage: "identifier (d tag; default: random)"},
+				},
+				Usage:     "create a calendar event (kind 31922 for dates, 31923 for times)",
+				UsageText: "algia calendar create --title [title] --start [start] [--end end] [description]",
+				ArgsUsage: "[description]",
+				Action:    doCalendarCreate,
+			},
+			{
+				Name: "list",
+				Flags: []cli.Flag{
+					&cli.StringFlag{Name: "calendar", Usage: "calendar (naddr of a kind 31924); default: follows"},
+					&cli.BoolFlag{Name: "all", Usage: "include past events"},
+					&cli.IntFlag{Name: "n", Value: 100, Usage: "number of items"},
+					&cli.BoolFlag{Name: "json", Usage: "output JSON"},
+				},
+				Usage:     "list upcoming calendar events",
+				UsageText: "algia calendar list [--calendar naddr]",
+				Action:    doCalendarList,
+			},
+			{
+				Name: "show",
+				Flags: []cli.Flag{
+					&cli.StringFlag{Name: "id", Required: true, Usage: "calendar event or calendar (naddr)"},
+					&cli.BoolFlag{Name: "all", Usage: "include past events (calendars)"},
+					&cli.IntFlag{Name: "n", Value: 100, Usage: "number of items"},
+					&cli.BoolFlag{Name: "json", Usage: "output JSON"},
+				},
+				Usage:     "show a calendar event with its RSVPs, or the events of a calendar",
+				UsageText: "algia calendar show --id [naddr]",
+				Action:    doCalendarShow,
+			},
+			{
+				Name: "rsvp",
+				Flags: []cli.Flag{
+					&cli.StringFlag{Name: "id", Required: true, Usage: "calendar event (naddr)"},
+					&cli.StringFlag{Name: "note", Usage: "note to the organizer"},
+				},
+				Usage:     "answer a calendar event (kind 31925)",
+				UsageText: "algia calendar rsvp --id [naddr] accepted|declined|tentative",
+				ArgsUsage: "accepted|declined|tentative",
+				Action:    doCalendarRSVP,
+			},
+		},
+	}
+}
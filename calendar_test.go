@@ -0,0 +1,163 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+func TestBuildCalendarEvent_DateBased(t *testing.T) {
+	ev, err := buildCalendarEvent(testPub, calendarEventOpts{
+		Name:         "meetup",
+		Title:        "Nostr meetup",
+		Description:  "bring #snacks",
+		Start:        "2026-11-01",
+		End:          "2026-11-03",
+		Location:     "Tokyo",
+		Geohash:      "xn76urx",
+		Participants: []string{testPub},
+	}, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ev.Kind != nostr.KindDateCalendarEvent {
+		t.Errorf("kind=%d", ev.Kind)
+	}
+	for name, want := range map[string]string{
+		"d": "meetup", "title": "Nostr meetup", "start": "2026-11-01", "end": "2026-11-03",
+		"location": "Tokyo", "g": "xn76urx", "t": "snacks",
+	} {
+		if got := tagValue(ev.Tags, name); got != want {
+			t.Errorf("%s=%q want %q", name, got, want)
+		}
+	}
+	if p := findTag(ev.Tags, "p"); p == nil || p[1] != testPub || p[3] != "participant" {
+		t.Errorf("p tag: %v", ev.Tags)
+	}
+	if findTag(ev.Tags, "D") != nil || findTag(ev.Tags, "start_tzid") != nil {
+		t.Errorf("time-only tags on a date event: %v", ev.Tags)
+	}
+
+	ce, err := parseCalendarEvent(ev)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ce.DateBased || ce.Title != "Nostr meetup" || ce.when() != "2026-11-01 - 2026-11-02" {
+		t.Errorf("parsed=%+v when=%q", ce, ce.when())
+	}
+}
+
+func TestBuildCalendarEvent_TimeBased(t *testing.T) {
+	ev, err := buildCalendarEvent(testPub, calendarEventOpts{
+		Name:  "talk",
+		Title: "Talk",
+		Start: "2026-11-01T23:00",
+		End:   "2026-11-02T01:00",
+		TZ:    "UTC",
+	}, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ev.Kind != nostr.KindTimeCalendarEvent {
+		t.Errorf("kind=%d", ev.Kind)
+	}
+	start := time.Date(2026, 11, 1, 23, 0, 0, 0, time.UTC).Unix()
+	if got := tagValue(ev.Tags, "start"); got != "1793574000" || start != 1793574000 {
+		t.Errorf("start=%q want %d", got, start)
+	}
+	if got := tagValue(ev.Tags, "start_tzid"); got != "UTC" {
+		t.Errorf("start_tzid=%q", got)
+	}
+	ds := findAllTags(ev.Tags, "D")
+	if len(ds) != 2 || ds[0][1] != "20758" || ds[1][1] != "20759" {
+		t.Errorf("D tags: %v", ds)
+	}
+
+	ce, err := parseCalendarEvent(ev)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ce.Start.Unix() != start || ce.TZ != "UTC" {
+		t.Errorf("parsed=%+v", ce)
+	}
+}
+
+func TestBuildCalendarEvent_Errors(t *testing.T) {
+	tests := []calendarEventOpts{
+		{Name: "x", Start: "2026-11-01"},
+		{Name: "x", Title: "t", Start: "soon"},
+		{Name: "x", Title: "t", Start: "2026-11-01", End: "2026-11-01T10:00"},
+		{Name: "x", Title: "t", Start: "2026-11-02", End: "2026-11-01"},
+		{Name: "x", Title: "t", Start: "2026-11-01T10:00", TZ: "Nowhere/Special"},
+	}
+	for _, opts := range tests {
+		if _, err := buildCalendarEvent(testPub, opts, 100); err == nil {
+			t.Errorf("expected error for %+v", opts)
+		}
+	}
+}
+
+func TestCalendarEventUpcoming(t *testing.T) {
+	now := time.Date(2026, 11, 2, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		ce   calendarEvent
+		want bool
+	}{
+		{calendarEvent{DateBased: true, Start: time.Date(2026, 11, 2, 0, 0, 0, 0, time.UTC)}, true},
+		{calendarEvent{DateBased: true, Start: time.Date(2026, 11, 1, 0, 0, 0, 0, time.UTC)}, false},
+		{calendarEvent{DateBased: true, Start: time.Date(2026, 11, 1, 0, 0, 0, 0, time.UTC), End: time.Date(2026, 11, 3, 0, 0, 0, 0, time.UTC)}, true},
+		{calendarEvent{Start: now.Add(time.Hour)}, true},
+		{calendarEvent{Start: now.Add(-time.Hour)}, false},
+		{calendarEvent{Start: now.Add(-time.Hour), End: now.Add(time.Hour)}, true},
+	}
+	for i, tt := range tests {
+		if got := tt.ce.upcoming(now); got != tt.want {
+			t.Errorf("%d: upcoming=%v want %v", i, got, tt.want)
+		}
+	}
+}
+
+func TestBuildRSVPEvent(t *testing.T) {
+	ep := &nostr.EntityPointer{PublicKey: testPub, Kind: nostr.KindTimeCalendarEvent, Identifier: "talk"}
+	ev, err := buildRSVPEvent(testPub, ep, testTargetID, "accepted", "", 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := "31923:" + testPub + ":talk"
+	if ev.Kind != nostr.KindCalendarEventRSVP {
+		t.Errorf("kind=%d", ev.Kind)
+	}
+	for name, want := range map[string]string{"d": addr, "a": addr, "e": testTargetID, "status": "accepted", "fb": "busy", "p": testPub} {
+		if got := tagValue(ev.Tags, name); got != want {
+			t.Errorf("%s=%q want %q", name, got, want)
+		}
+	}
+
+	ev, err = buildRSVPEvent(testPub, ep, "", "declined", "sorry", 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if findTag(ev.Tags, "fb") != nil || findTag(ev.Tags, "e") != nil || ev.Content != "sorry" {
+		t.Errorf("declined: %v %q", ev.Tags, ev.Content)
+	}
+
+	if _, err := buildRSVPEvent(testPub, ep, "", "maybe", "", 100); err == nil {
+		t.Error("expected error for invalid status")
+	}
+	if _, err := buildRSVPEvent(testPub, &nostr.EntityPointer{Kind: nostr.KindArticle}, "", "accepted", "", 100); err == nil {
+		t.Error("expected error for a non-calendar address")
+	}
+}
+
+func TestTallyRSVPs(t *testing.T) {
+	evs := []*nostr.Event{
+		{PubKey: "a", CreatedAt: 1, Tags: nostr.Tags{{"status", "tentative"}}},
+		{PubKey: "a", CreatedAt: 2, Tags: nostr.Tags{{"status", "accepted"}}},
+		{PubKey: "b", CreatedAt: 1, Tags: nostr.Tags{{"status", "declined"}}},
+	}
+	got := tallyRSVPs(evs)
+	if got["a"] != "accepted" || got["b"] != "declined" || len(got) != 2 {
+		t.Errorf("got=%v", got)
+	}
+}
@@ -409,7 +409,7 @@ func doChannelPost(cCtx *cli.Context) error {
 		Geohash:        cCtx.String("geohash"),
 		Emojis:         cCtx.StringSlice("emoji"),
 		Tags:           cCtx.StringSlice("tag"),
-	}, cfg.Emojis, createdAt)
+	}, cfg.emojiMap(context.Background()), createdAt)
 	if err != nil {
 		return err
 	}
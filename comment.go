@@ -0,0 +1,387 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/fatih/color"
+	"github.com/urfave/cli/v2"
+
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/nbd-wtf/go-nostr/nip19"
+	"github.com/nbd-wtf/go-nostr/sdk"
+)
+
+// contentRef is something that can be commented on or highlighted: a nostr
+// event, an addressable event, or a NIP-73 external identifier.
+type contentRef struct {
+	Event        *nostr.Event         // the target event, when it could be fetched
+	Address      *nostr.EntityPointer // set for addressable targets
+	External     string               // NIP-73 identifier (URL, isbn:..., podcast:guid:..., #tag)
+	ExternalKind string               // NIP-73 "k" value for External
+	Relay        string               // relay hint
+}
+
+// addressValue returns the "kind:pubkey:d" form of an entity pointer.
+func addressValue(ep *nostr.EntityPointer) string {
+	return fmt.Sprintf("%d:%s:%s", ep.Kind, ep.PublicKey, ep.Identifier)
+}
+
+// authorPubkey returns the pubkey of the target, if it has one.
+func (r *contentRef) authorPubkey() string {
+	if r.Event != nil {
+		return r.Event.PubKey
+	}
+	if r.Address != nil {
+		return r.Address.PublicKey
+	}
+	return ""
+}
+
+var isbnRe = regexp.MustCompile(`^[0-9]{9}[0-9Xx]$|^[0-9]{13}$`)
+
+// parseExternalID turns a URL or NIP-73 identifier into its canonical value
+// and "k" kind.
+func parseExternalID(s string) (string, string, error) {
+	lower := strings.ToLower(s)
+	switch {
+	case strings.HasPrefix(lower, "http://"), strings.HasPrefix(lower, "https://"):
+		u, err := url.Parse(s)
+		if err != nil || u.Host == "" {
+			return "", "", fmt.Errorf("invalid URL '%s'", s)
+		}
+		// NIP-73: web identifiers are normalized URLs without the fragment.
+		u.Fragment = ""
+		u.Scheme = strings.ToLower(u.Scheme)
+		u.Host = strings.ToLower(u.Host)
+		return u.String(), "web", nil
+	case strings.HasPrefix(lower, "isbn:"):
+		isbn := strings.ReplaceAll(s[len("isbn:"):], "-", "")
+		if !isbnRe.MatchString(isbn) {
+			return "", "", fmt.Errorf("invalid ISBN '%s'", s)
+		}
+		return "isbn:" + strings.ToUpper(isbn), "isbn", nil
+	case strings.HasPrefix(lower, "podcast:"):
+		for _, k := range []string{"podcast:item:guid", "podcast:publisher:guid", "podcast:guid"} {
+			if strings.HasPrefix(lower, k+":") && len(s) > len(k)+1 {
+				return k + ":" + s[len(k)+1:], k, nil
+			}
+		}
+		return "", "", fmt.Errorf("invalid podcast identifier '%s'", s)
+	case strings.HasPrefix(s, "#") && len(s) > 1:
+		return "#" + strings.ToLower(s[1:]), "#", nil
+	case strings.HasPrefix(lower, "geo:") && len(s) > 4:
+		return "geo:" + strings.ToLower(s[4:]), "geo", nil
+	}
+	return "", "", fmt.Errorf("unsupported target '%s'", s)
+}
+
+// resolveContentRef resolves naddr/nevent/note/hex ids (fetching the event)
+// or a URL / NIP-73 identifier into a contentRef.
+func resolveContentRef(ctx context.Context, cfg *Config, s string) (*contentRef, error) {
+	s = strings.TrimPrefix(strings.TrimSpace(s), "nostr:")
+
+	if strings.HasPrefix(s, "naddr1") {
+		prefix, v, err := nip19.Decode(s)
+		if err != nil || prefix != "naddr" {
+			return nil, fmt.Errorf("failed to parse naddr '%s'", s)
+		}
+		ep := v.(nostr.EntityPointer)
+		ref := &contentRef{Address: &ep, Relay: firstRelayHint(ep.Relays, firstWriteRelay(cfg))}
+		filters := nostr.Filters{{
+			Kinds:   []int{ep.Kind},
+			Authors: []string{ep.PublicKey},
+			Tags:    nostr.TagMap{"d": []string{ep.Identifier}},
+		}}
+		evs, err := cfg.QueryEvents(ctx, filters)
+		if (err != nil || len(evs) == 0) && len(ep.Relays) > 0 {
+			evs, _ = cfg.queryRelays(ctx, ep.Relays, filters)
+		}
+		if len(evs) > 0 {
+			ref.Event = evs[len(evs)-1]
+		}
+		return ref, nil
+	}
+
+	if evp := sdk.InputToEventPointer(s); evp != nil {
+		filters := nostr.Filters{{IDs: []string{evp.ID}, Limit: 1}}
+		evs, err := cfg.QueryEvents(ctx, filters)
+		if (err != nil || len(evs) == 0) && len(evp.Relays) > 0 {
+			evs, err = cfg.queryRelays(ctx, evp.Relays, filters)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if len(evs) == 0 {
+			return nil, fmt.Errorf("event not found: %s", s)
+		}
+		ref := &contentRef{Event: evs[0], Relay: firstRelayHint(evp.Relays, firstWriteRelay(cfg))}
+		if nostr.IsAddressableKind(ref.Event.Kind) {
+			ref.Address = &nostr.EntityPointer{
+				PublicKey:  ref.Event.PubKey,
+				Kind:       ref.Event.Kind,
+				Identifier: tagValue(ref.Event.Tags, "d"),
+			}
+		}
+		return ref, nil
+	}
+
+	value, kind, err := parseExternalID(s)
+	if err != nil {
+		return nil, err
+	}
+	return &contentRef{External: value, ExternalKind: kind}, nil
+}
+
+// commentScope returns the uppercase root tags and lowercase parent tags
+// (NIP-22) for a comment on ref. Replying to a comment keeps that comment's
+// root scope and makes the comment the parent.
+func commentScope(ref *contentRef) (root, parent nostr.Tags, err error) {
+	if ref.External != "" {
+		root = nostr.Tags{{"I", ref.External}, {"K", ref.ExternalKind}}
+		parent = nostr.Tags{{"i", ref.External}, {"k", ref.ExternalKind}}
+		return root, parent, nil
+	}
+
+	if ev := ref.Event; ev != nil && ev.Kind == nostr.KindComment {
+		for _, tag := range ev.Tags {
+			if len(tag) >= 2 && (tag[0] == "A" || tag[0] == "E" || tag[0] == "I" || tag[0] == "K" || tag[0] == "P") {
+				root = append(root, tag)
+			}
+		}
+		if len(root) == 0 {
+			return nil, nil, errors.New("the comment has no root scope")
+		}
+		parent = nostr.Tags{
+			{"e", ev.ID, ref.Relay, ev.PubKey},
+			{"k", strconv.Itoa(ev.Kind)},
+			{"p", ev.PubKey},
+		}
+		return root, parent, nil
+	}
+
+	var kind int
+	switch {
+	case ref.Address != nil:
+		addr := addressValue(ref.Address)
+		kind = ref.Address.Kind
+		root = nostr.Tags{{"A", addr, ref.Relay}}
+		parent = nostr.Tags{{"a", addr, ref.Relay}}
+		if ref.Event != nil {
+			parent = append(parent, nostr.Tag{"e", ref.Event.ID, ref.Relay})
+		}
+	case ref.Event != nil:
+		// NIP-22: comments must not be used to reply to kind 1 notes.
+		if ref.Event.Kind == nostr.KindTextNote {
+			return nil, nil, errors.New("kind 1 notes take replies, not comments: use 'algia reply'")
+		}
+		kind = ref.Event.Kind
+		root = nostr.Tags{{"E", ref.Event.ID, ref.Relay, ref.Event.PubKey}}
+		parent = nostr.Tags{{"e", ref.Event.ID, ref.Relay, ref.Event.PubKey}}
+	default:
+		return nil, nil, errors.New("nothing to comment on")
+	}
+	k := strconv.Itoa(kind)
+	pub := ref.authorPubkey()
+	root = append(root, nostr.Tag{"K", k}, nostr.Tag{"P", pub, ref.Relay})
+	parent = append(parent, nostr.Tag{"k", k}, nostr.Tag{"p", pub, ref.Relay})
+	return root, parent, nil
+}
+
+// buildCommentEvent constructs an unsigned kind 1111 (NIP-22) comment with
+// the given root and parent scope tags. cfgEmojis is the configured
+// shortcode→icon map for inline :name: emoji expansion.
+func buildCommentEvent(pubkey, content string, root, parent nostr.Tags, cfgEmojis map[string]string, createdAt nostr.Timestamp) (*nostr.Event, error) {
+	if strings.TrimSpace(content) == "" {
+		return nil, errors.New("content is empty")
+	}
+	if len(root) == 0 || len(parent) == 0 {
+		return nil, errors.New("comment scope is empty")
+	}
+	ev := &nostr.Event{
+		PubKey:    pubkey,
+		CreatedAt: createdAt,
+		Kind:      nostr.KindComment,
+		Content:   content,
+		Tags:      nostr.Tags{},
+	}
+	ev.Tags = append(ev.Tags, root...)
+	ev.Tags = append(ev.Tags, parent...)
+	clientTag(ev)
+
+	for _, entry := range extractLinks(ev.Content) {
+		ev.Tags = ev.Tags.AppendUnique(nostr.Tag{"r", entry.text})
+	}
+	for _, entry := range extractEmojis(ev.Content) {
+		name := strings.Trim(entry.text, ":")
+		if icon, ok := cfgEmojis[name]; ok {
+			ev.Tags = ev.Tags.AppendUnique(nostr.Tag{"emoji", name, icon})
+		}
+	}
+	// One ["t", tag] per hashtag (see buildPostEvent).
+	for _, m := range extractTags(ev.Content) {
+		ev.Tags = ev.Tags.AppendUnique(nostr.Tag{"t", m.text})
+	}
+	return ev, nil
+}
+
+func doComment(cCtx *cli.Context) error {
+	stdin := cCtx.Bool("stdin")
+	if !stdin && cCtx.Args().Len() == 0 {
+		return cli.ShowSubcommandHelp(cCtx)
+	}
+	cfg := cCtx.App.Metadata["config"].(*Config)
+	ctx := context.Background()
+
+	_, pub, err := getSkAndPub(cfg)
+	if err != nil {
+		return err
+	}
+
+	var content string
+	if stdin {
+		b, err := io.ReadAll(os.Stdin)
+		if err != nil {
+			return err
+		}
+		content = string(b)
+	} else {
+		content = strings.Join(cCtx.Args().Slice(), "\n")
+	}
+
+	ref, err := resolveContentRef(ctx, cfg, cCtx.String("on"))
+	if err != nil {
+		return err
+	}
+	root, parent, err := commentScope(ref)
+	if err != nil {
+		return err
+	}
+	ev, err := buildCommentEvent(pub, content, root, parent, cfg.emojiMap(ctx), nostr.Now())
+	if err != nil {
+		return err
+	}
+	if s := cCtx.String("sensitive"); s != "" {
+		ev.Tags = ev.Tags.AppendUnique(nostr.Tag{"content-warning", s})
+	}
+	if err := cfg.signEvent(ev); err != nil {
+		return err
+	}
+	if cfg.publishEvent(ctx, ev) == 0 {
+		return errors.New("cannot comment")
+	}
+	if cfg.verbose {
+		if id, err := nip19.EncodeNote(ev.ID); err == nil {
+			fmt.Println(id)
+		}
+	}
+	return nil
+}
+
+// commentTree arranges comments by parent. Top-level comments (whose parent
+// is the root itself) are under the "" key.
+func commentTree(evs []*nostr.Event) map[string][]*nostr.Event {
+	ids := map[string]bool{}
+	for _, ev := range evs {
+		ids[ev.ID] = true
+	}
+	tree := map[string][]*nostr.Event{}
+	for _, ev := range evs {
+		parent := ""
+		if tagValue(ev.Tags, "k") == strconv.Itoa(nostr.KindComment) {
+			if e := tagValue(ev.Tags, "e"); ids[e] {
+				parent = e
+			}
+		}
+		tree[parent] = append(tree[parent], ev)
+	}
+	return tree
+}
+
+func (cfg *Config) printCommentTree(tree map[string][]*nostr.Event, parent string, depth int) {
+	indent := strings.Repeat("  ", depth)
+	for _, ev := range tree[parent] {
+		fmt.Print(indent + ev.CreatedAt.Time().Format("2006-01-02T15:04:05") + " ")
+		if profile, err := cfg.GetProfile(ev.PubKey); err == nil {
+			color.Set(color.FgHiRed)
+			fmt.Print(profile.Name)
+		} else {
+			color.Set(color.FgRed)
+			if pk, err := nip19.EncodePublicKey(ev.PubKey); err == nil {
+				fmt.Print(pk)
+			} else {
+				fmt.Print(ev.PubKey)
+			}
+		}
+		color.Set(color.Reset)
+		fmt.Print(": ")
+		color.Set(color.FgHiBlue)
+		if ni, err := nip19.EncodeNote(ev.ID); err == nil {
+			fmt.Println(ni)
+		} else {
+			fmt.Println(ev.ID)
+		}
+		color.Set(color.Reset)
+		for _, line := range strings.Split(ev.Content, "\n") {
+			fmt.Println(indent + line)
+		}
+		fmt.Println()
+		cfg.printCommentTree(tree, ev.ID, depth+1)
+	}
+}
+
+func doComments(cCtx *cli.Context) error {
+	cfg := cCtx.App.Metadata["config"].(*Config)
+	ctx := context.Background()
+	j := cCtx.Bool("json")
+
+	ref, err := resolveContentRef(ctx, cfg, cCtx.String("on"))
+	if err != nil {
+		return err
+	}
+	root, _, err := commentScope(ref)
+	if err != nil {
+		return err
+	}
+	// The first root tag (A, E or I) scopes the whole thread.
+	key, value := root[0][0], root[0][1]
+	evs, err := cfg.QueryEvents(ctx, nostr.Filters{{
+		Kinds: []int{nostr.KindComment},
+		Tags:  nostr.TagMap{key: []string{value}},
+		Limit: cCtx.Int("n"),
+	}})
+	if err != nil {
+		return err
+	}
+
+	if j {
+		for _, ev := range evs {
+			json.NewEncoder(os.Stdout).Encode(ev)
+		}
+		return nil
+	}
+
+	start := ""
+	if ref.Event != nil && ref.Event.Kind == nostr.KindComment {
+		// Show the sub-thread below the given comment.
+		cfg.PrintEvent(ref.Event, false, false)
+		start = ref.Event.ID
+	} else if ref.Event != nil {
+		cfg.PrintEvent(ref.Event, false, false)
+	} else {
+		color.Set(color.FgHiBlue)
+		fmt.Println(value)
+		color.Set(color.Reset)
+		fmt.Println()
+	}
+	cfg.printCommentTree(commentTree(evs), start, 1)
+	return nil
+}
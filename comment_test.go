@@ -0,0 +1,191 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+func TestParseExternalID(t *testing.T) {
+	tests := []struct {
+		in, value, kind string
+	}{
+		{"https://Example.com/a/b?x=1#frag", "https://example.com/a/b?x=1", "web"},
+		{"http://example.com", "http://example.com", "web"},
+		{"isbn:978-0-7653-8200-3", "isbn:9780765382003", "isbn"},
+		{"isbn:080442957x", "isbn:080442957X", "isbn"},
+		{"podcast:guid:c90e609a-df1e-596a-bd5e-57bcc8aad6cc", "podcast:guid:c90e609a-df1e-596a-bd5e-57bcc8aad6cc", "podcast:guid"},
+		{"podcast:item:guid:d98d189b", "podcast:item:guid:d98d189b", "podcast:item:guid"},
+		{"podcast:publisher:guid:18bcbf10", "podcast:publisher:guid:18bcbf10", "podcast:publisher:guid"},
+		{"#Nostr", "#nostr", "#"},
+		{"geo:xn76urx", "geo:xn76urx", "geo"},
+	}
+	for _, tt := range tests {
+		value, kind, err := parseExternalID(tt.in)
+		if err != nil {
+			t.Errorf("parseExternalID(%q): %v", tt.in, err)
+			continue
+		}
+		if value != tt.value || kind != tt.kind {
+			t.Errorf("parseExternalID(%q): got=(%q, %q) want=(%q, %q)", tt.in, value, kind, tt.value, tt.kind)
+		}
+	}
+	for _, in := range []string{"isbn:123", "podcast:guid:", "ftp://example.com", "hello", "#"} {
+		if _, _, err := parseExternalID(in); err == nil {
+			t.Errorf("parseExternalID(%q): expected error", in)
+		}
+	}
+}
+
+func TestCommentScope_Event(t *testing.T) {
+	ref := &contentRef{
+		Event: &nostr.Event{ID: testTargetID, PubKey: testPub, Kind: 20},
+		Relay: "wss://relay.example",
+	}
+	root, parent, err := commentScope(ref)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e := findTag(root, "E"); e == nil || e[1] != testTargetID || e[2] != "wss://relay.example" || e[3] != testPub {
+		t.Errorf("E tag: %v", root)
+	}
+	if k := findTag(root, "K"); k == nil || k[1] != "20" {
+		t.Errorf("K tag: %v", root)
+	}
+	if p := findTag(root, "P"); p == nil || p[1] != testPub {
+		t.Errorf("P tag: %v", root)
+	}
+	if e := findTag(parent, "e"); e == nil || e[1] != testTargetID {
+		t.Errorf("e tag: %v", parent)
+	}
+	if k := findTag(parent, "k"); k == nil || k[1] != "20" {
+		t.Errorf("k tag: %v", parent)
+	}
+	if p := findTag(parent, "p"); p == nil || p[1] != testPub {
+		t.Errorf("p tag: %v", parent)
+	}
+}
+
+func TestCommentScope_TextNote(t *testing.T) {
+	ref := &contentRef{Event: &nostr.Event{ID: testTargetID, PubKey: testPub, Kind: nostr.KindTextNote}}
+	if _, _, err := commentScope(ref); err == nil || !strings.Contains(err.Error(), "algia reply") {
+		t.Errorf("comment on a kind 1 note: err=%v", err)
+	}
+}
+
+func TestCommentScope_Address(t *testing.T) {
+	ref := &contentRef{
+		Event:   &nostr.Event{ID: testTargetID, PubKey: testPub, Kind: nostr.KindArticle},
+		Address: &nostr.EntityPointer{PublicKey: testPub, Kind: nostr.KindArticle, Identifier: "slug"},
+	}
+	root, parent, err := commentScope(ref)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "30023:" + testPub + ":slug"
+	if a := findTag(root, "A"); a == nil || a[1] != want {
+		t.Errorf("A tag: %v", root)
+	}
+	if findTag(root, "E") != nil {
+		t.Errorf("unexpected E tag for addressable root: %v", root)
+	}
+	if a := findTag(parent, "a"); a == nil || a[1] != want {
+		t.Errorf("a tag: %v", parent)
+	}
+	if e := findTag(parent, "e"); e == nil || e[1] != testTargetID {
+		t.Errorf("e tag: %v", parent)
+	}
+	if k := findTag(root, "K"); k == nil || k[1] != "30023" {
+		t.Errorf("K tag: %v", root)
+	}
+}
+
+func TestCommentScope_External(t *testing.T) {
+	root, parent, err := commentScope(&contentRef{External: "isbn:9780765382003", ExternalKind: "isbn"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if i := findTag(root, "I"); i == nil || i[1] != "isbn:9780765382003" {
+		t.Errorf("I tag: %v", root)
+	}
+	if k := findTag(root, "K"); k == nil || k[1] != "isbn" {
+		t.Errorf("K tag: %v", root)
+	}
+	if i := findTag(parent, "i"); i == nil || i[1] != "isbn:9780765382003" {
+		t.Errorf("i tag: %v", parent)
+	}
+	if findTag(root, "P") != nil {
+		t.Errorf("unexpected P tag: %v", root)
+	}
+}
+
+func TestCommentScope_ReplyToComment(t *testing.T) {
+	const commentID = "2222222222222222222222222222222222222222222222222222222222222222"
+	comment := &nostr.Event{
+		ID:     commentID,
+		PubKey: "cafe000000000000000000000000000000000000000000000000000000000001",
+		Kind:   nostr.KindComment,
+		Tags: nostr.Tags{
+			{"I", "https://example.com"}, {"K", "web"},
+			{"i", "https://example.com"}, {"k", "web"},
+		},
+	}
+	root, parent, err := commentScope(&contentRef{Event: comment})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if i := findTag(root, "I"); i == nil || i[1] != "https://example.com" {
+		t.Errorf("root must be kept: %v", root)
+	}
+	if findTag(root, "i") != nil {
+		t.Errorf("lowercase tags copied into root: %v", root)
+	}
+	if e := findTag(parent, "e"); e == nil || e[1] != commentID {
+		t.Errorf("e tag: %v", parent)
+	}
+	if k := findTag(parent, "k"); k == nil || k[1] != "1111" {
+		t.Errorf("k tag: %v", parent)
+	}
+}
+
+func TestBuildCommentEvent(t *testing.T) {
+	root := nostr.Tags{{"I", "https://example.com"}, {"K", "web"}}
+	parent := nostr.Tags{{"i", "https://example.com"}, {"k", "web"}}
+	ev, err := buildCommentEvent(testPub, "nice :wave: #nostr", root, parent, map[string]string{"wave": "https://example.com/wave.png"}, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ev.Kind != nostr.KindComment || ev.CreatedAt != 100 {
+		t.Errorf("kind=%d created_at=%d", ev.Kind, ev.CreatedAt)
+	}
+	if findTag(ev.Tags, "I") == nil || findTag(ev.Tags, "i") == nil {
+		t.Errorf("scope tags: %v", ev.Tags)
+	}
+	if e := findTag(ev.Tags, "emoji"); e == nil || e[1] != "wave" {
+		t.Errorf("emoji tag: %v", ev.Tags)
+	}
+	if tt := findTag(ev.Tags, "t"); tt == nil || tt[1] != "nostr" {
+		t.Errorf("t tag: %v", ev.Tags)
+	}
+
+	if _, err := buildCommentEvent(testPub, " ", root, parent, nil, 100); err == nil {
+		t.Error("expected error for empty content")
+	}
+	if _, err := buildCommentEvent(testPub, "x", nil, nil, nil, 100); err == nil {
+		t.Error("expected error for empty scope")
+	}
+}
+
+func TestCommentTree(t *testing.T) {
+	top := &nostr.Event{ID: "a", Tags: nostr.Tags{{"E", testTargetID}, {"e", testTargetID}, {"k", "1"}}}
+	reply := &nostr.Event{ID: "b", Tags: nostr.Tags{{"E", testTargetID}, {"e", "a"}, {"k", "1111"}}}
+	orphan := &nostr.Event{ID: "c", Tags: nostr.Tags{{"E", testTargetID}, {"e", "missing"}, {"k", "1111"}}}
+	tree := commentTree([]*nostr.Event{top, reply, orphan})
+	if len(tree[""]) != 2 {
+		t.Errorf("top level=%v", tree[""])
+	}
+	if len(tree["a"]) != 1 || tree["a"][0].ID != "b" {
+		t.Errorf("children of a=%v", tree["a"])
+	}
+}
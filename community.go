@@ -0,0 +1,654 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/fatih/color"
+	"github.com/urfave/cli/v2"
+
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/nbd-wtf/go-nostr/nip19"
+)
+
+// communityRelay is a "relay" tag of a community definition. Marker is
+// "author", "requests", "approvals" or empty.
+type communityRelay struct {
+	URL    string `json:"url"`
+	Marker string `json:"marker,omitempty"`
+}
+
+// communityInfo is the parsed form of a kind 34550 (NIP-72) community
+// definition.
+type communityInfo struct {
+	ID          string           `json:"id"`
+	Author      string           `json:"author"`
+	Name        string           `json:"name"`
+	Description string           `json:"description,omitempty"`
+	Image       string           `json:"image,omitempty"`
+	Rules       string           `json:"rules,omitempty"`
+	Moderators  []string         `json:"moderators"`
+	Relays      []communityRelay `json:"relays,omitempty"`
+	Event       *nostr.Event     `json:"-"`
+}
+
+func parseCommunity(ev *nostr.Event) *communityInfo {
+	ci := &communityInfo{
+		ID:          tagValue(ev.Tags, "d"),
+		Author:      ev.PubKey,
+		Name:        tagValue(ev.Tags, "name"),
+		Description: tagValue(ev.Tags, "description"),
+		Image:       tagValue(ev.Tags, "image"),
+		Rules:       tagValue(ev.Tags, "rules"),
+		Event:       ev,
+	}
+	if ci.Name == "" {
+		ci.Name = ci.ID
+	}
+	for tag := range ev.Tags.FindAll("p") {
+		if len(tag) >= 4 && tag[3] == "moderator" && !slices.Contains(ci.Moderators, tag[1]) {
+			ci.Moderators = append(ci.Moderators, tag[1])
+		}
+	}
+	for tag := range ev.Tags.FindAll("relay") {
+		r := communityRelay{URL: tag[1]}
+		if len(tag) >= 3 {
+			r.Marker = tag[2]
+		}
+		ci.Relays = append(ci.Relays, r)
+	}
+	return ci
+}
+
+func (ci *communityInfo) address() string {
+	return fmt.Sprintf("%d:%s:%s", nostr.KindCommunityDefinition, ci.Author, ci.ID)
+}
+
+func (ci *communityInfo) naddr() string {
+	var relays []string
+	for _, r := range ci.Relays {
+		relays = append(relays, r.URL)
+	}
+	s, err := nip19.EncodeEntity(ci.Author, nostr.KindCommunityDefinition, ci.ID, relays)
+	if err != nil {
+		return ci.address()
+	}
+	return s
+}
+
+// isModerator reports whether pubkey may approve posts. The community's
+// author counts as a moderator.
+func (ci *communityInfo) isModerator(pubkey string) bool {
+	return pubkey == ci.Author || slices.Contains(ci.Moderators, pubkey)
+}
+
+// relayURLs returns the community's relays with any of the given markers; an
+// unmarked relay matches every marker.
+func (ci *communityInfo) relayURLs(markers ...string) []string {
+	var out []string
+	for _, r := range ci.Relays {
+		if r.Marker == "" || slices.Contains(markers, r.Marker) {
+			out = append(out, r.URL)
+		}
+	}
+	return out
+}
+
+// buildCommunityPostEvent constructs an unsigned kind 1111 post to a
+// community, scoped to its definition the NIP-22 way as NIP-72 requires.
+func buildCommunityPostEvent(pubkey string, ci *communityInfo, content string, cfgEmojis map[string]string, createdAt nostr.Timestamp) (*nostr.Event, error) {
+	ref := &contentRef{
+		Address: &nostr.EntityPointer{PublicKey: ci.Author, Kind: nostr.KindCommunityDefinition, Identifier: ci.ID},
+		Relay:   firstRelayHint(ci.relayURLs("requests"), ""),
+	}
+	root, parent, err := commentScope(ref)
+	if err != nil {
+		return nil, err
+	}
+	return buildCommentEvent(pubkey, content, root, parent, cfgEmojis, createdAt)
+}
+
+// communityPostAddress returns the community address a post belongs to: the
+// "A" root of a kind 1111 post, or the "a" tag of a legacy kind 1 post.
+func communityPostAddress(ev *nostr.Event) string {
+	key := "a"
+	if ev.Kind == nostr.KindComment {
+		key = "A"
+	}
+	for tag := range ev.Tags.FindAll(key) {
+		if strings.HasPrefix(tag[1], strconv.Itoa(nostr.KindCommunityDefinition)+":") {
+			return tag[1]
+		}
+	}
+	return ""
+}
+
+// isTopLevelCommunityPost reports whether a kind 1111 post replies to the
+// community itself rather than to another post.
+func isTopLevelCommunityPost(ev *nostr.Event) bool {
+	if ev.Kind != nostr.KindComment {
+		return true
+	}
+	return tagValue(ev.Tags, "k") == strconv.Itoa(nostr.KindCommunityDefinition)
+}
+
+// buildCommunityApprovalEvent constructs an unsigned kind 4550 approval of
+// post, which must be made by one of the community's moderators.
+func buildCommunityApprovalEvent(pubkey string, ci *communityInfo, post *nostr.Event, createdAt nostr.Timestamp) (*nostr.Event, error) {
+	if !ci.isModerator(pubkey) {
+		return nil, fmt.Errorf("you are not a moderator of %s", ci.Name)
+	}
+	if addr := communityPostAddress(post); addr != ci.address() {
+		return nil, errors.New("the post does not belong to this community")
+	}
+	b, err := json.Marshal(post)
+	if err != nil {
+		return nil, err
+	}
+	relay := firstRelayHint(ci.relayURLs("approvals"), "")
+	ev := &nostr.Event{
+		PubKey:    pubkey,
+		CreatedAt: createdAt,
+		Kind:      nostr.KindCommunityPostApproval,
+		Content:   string(b),
+		Tags: nostr.Tags{
+			{"a", ci.address(), relay},
+			{"e", post.ID, relay},
+			{"p", post.PubKey, relay},
+			{"k", strconv.Itoa(post.Kind)},
+		},
+	}
+	clientTag(ev)
+	return ev, nil
+}
+
+// approvedPosts returns the ids of posts approved by the community's
+// moderators. Approvals by anyone else are ignored.
+func approvedPosts(ci *communityInfo, approvals []*nostr.Event) map[string]bool {
+	out := map[string]bool{}
+	for _, ev := range approvals {
+		if !ci.isApproval(ev) {
+			continue
+		}
+		for tag := range ev.Tags.FindAll("e") {
+			out[tag[1]] = true
+		}
+	}
+	return out
+}
+
+// isApproval reports whether ev is a kind 4550 approval for ci by one of its
+// moderators.
+func (ci *communityInfo) isApproval(ev *nostr.Event) bool {
+	if ev.Kind != nostr.KindCommunityPostApproval || !ci.isModerator(ev.PubKey) {
+		return false
+	}
+	a := ev.Tags.Find("a")
+	return a != nil && a[1] == ci.address()
+}
+
+// approvedPostEvents returns the approved posts embedded in the approvals of
+// ci, and the ids of approved posts whose approval did not carry a valid copy.
+func approvedPostEvents(ci *communityInfo, approvals []*nostr.Event) ([]*nostr.Event, []string) {
+	approved := approvedPosts(ci, approvals)
+	found := map[string]*nostr.Event{}
+	for _, ev := range approvals {
+		if !ci.isApproval(ev) {
+			continue
+		}
+		var post nostr.Event
+		if err := json.Unmarshal([]byte(ev.Content), &post); err != nil || !approved[post.ID] || found[post.ID] != nil {
+			continue
+		}
+		if ok, err := post.CheckSignature(); err != nil || !ok {
+			continue
+		}
+		found[post.ID] = &post
+	}
+	posts := make([]*nostr.Event, 0, len(found))
+	for _, post := range found {
+		posts = append(posts, post)
+	}
+	var missing []string
+	for id := range approved {
+		if found[id] == nil {
+			missing = append(missing, id)
+		}
+	}
+	sort.Strings(missing)
+	return posts, missing
+}
+
+func fetchCommunity(ctx context.Context, cfg *Config, id string) (*communityInfo, error) {
+	ep, err := decodeNaddr(id)
+	if err != nil {
+		return nil, err
+	}
+	if ep.Kind != nostr.KindCommunityDefinition {
+		return nil, fmt.Errorf("kind %d is not a community", ep.Kind)
+	}
+	evs, err := fetchAddressable(ctx, cfg, []*nostr.EntityPointer{ep})
+	if err != nil {
+		return nil, err
+	}
+	if len(evs) == 0 {
+		return nil, fmt.Errorf("community not found: %s", id)
+	}
+	return parseCommunity(evs[0]), nil
+}
+
+// communityQuery queries our read relays and, when nothing comes back, the
+// community's own relays.
+func communityQuery(ctx context.Context, cfg *Config, ci *communityInfo, filters nostr.Filters, markers ...string) ([]*nostr.Event, error) {
+	evs, err := cfg.QueryEvents(ctx, filters)
+	if relays := ci.relayURLs(markers...); (err != nil || len(evs) == 0) && len(relays) > 0 {
+		evs, err = cfg.queryRelays(ctx, relays, filters)
+	}
+	return evs, err
+}
+
+// communityPublish sends ev to our write relays and the community's relays
+// with the given markers.
+func communityPublish(ctx context.Context, cfg *Config, ci *communityInfo, ev *nostr.Event, markers ...string) int64 {
+	success := cfg.publishEvent(ctx, ev)
+	if relays := ci.relayURLs(markers...); len(relays) > 0 {
+		for res := range cfg.pool.PublishMany(ctx, relays, *ev) {
+			if res.Error != nil {
+				fmt.Fprintln(os.Stderr, res.RelayURL, res.Error)
+			} else {
+				success++
+			}
+		}
+	}
+	return success
+}
+
+func (cfg *Config) printCommunity(ci *communityInfo) {
+	color.Set(color.FgHiRed)
+	fmt.Print(ci.Name)
+	color.Set(color.Reset)
+	fmt.Println(" by " + cfg.displayName(ci.Author))
+	if ci.Description != "" {
+		fmt.Println(ci.Description)
+	}
+	color.Set(color.FgHiBlue)
+	fmt.Println(ci.naddr())
+	color.Set(color.Reset)
+	fmt.Println()
+}
+
+func doCommunityList(cCtx *cli.Context) error {
+	cfg := cCtx.App.Metadata["config"].(*Config)
+	ctx := context.Background()
+
+	var evs []*nostr.Event
+	if cCtx.Bool("all") {
+		var err error
+		evs, err = cfg.QueryEvents(ctx, nostr.Filters{{
+			Kinds: []int{nostr.KindCommunityDefinition},
+			Limit: cCtx.Int("n"),
+		}})
+		if err != nil {
+			return err
+		}
+	} else {
+		// Joined communities are the "a" entries of our kind 10004 list.
+		list, err := callListShow(&listShowArg{ctx: ctx, cfg: cfg, kind: nostr.KindCommunityList})
+		if err != nil {
+			return err
+		}
+		var eps []*nostr.EntityPointer
+		for tag := range list.Tags.FindAll("a") {
+			if ep, err := nostr.EntityPointerFromTag(tag); err == nil && ep.Kind == nostr.KindCommunityDefinition {
+				eps = append(eps, &ep)
+			}
+		}
+		if evs, err = fetchAddressable(ctx, cfg, eps); err != nil {
+			return err
+		}
+	}
+
+	var cis []*communityInfo
+	seen := map[string]bool{}
+	for _, ev := range evs {
+		ci := parseCommunity(ev)
+		if !seen[ci.address()] {
+			seen[ci.address()] = true
+			cis = append(cis, ci)
+		}
+	}
+	sort.Slice(cis, func(i, j int) bool {
+		return strings.ToLower(cis[i].Name) < strings.ToLower(cis[j].Name)
+	})
+	for _, ci := range cis {
+		if cCtx.Bool("json") {
+			json.NewEncoder(os.Stdout).Encode(ci)
+		} else {
+			cfg.printCommunity(ci)
+		}
+	}
+	return nil
+}
+
+func doCommunityShow(cCtx *cli.Context) error {
+	cfg := cCtx.App.Metadata["config"].(*Config)
+	ci, err := fetchCommunity(context.Background(), cfg, cCtx.String("id"))
+	if err != nil {
+		return err
+	}
+	if cCtx.Bool("json") {
+		return json.NewEncoder(os.Stdout).Encode(ci)
+	}
+	cfg.printCommunity(ci)
+	if ci.Rules != "" {
+		fmt.Println("Rules: " + ci.Rules)
+	}
+	if ci.Image != "" {
+		fmt.Println("Image: " + ci.Image)
+	}
+	var mods []string
+	for _, m := range ci.Moderators {
+		mods = append(mods, cfg.displayName(m))
+	}
+	fmt.Println("Moderators: " + strings.Join(mods, ", "))
+	for _, r := range ci.Relays {
+		if r.Marker != "" {
+			fmt.Printf("Relay: %s (%s)\n", r.URL, r.Marker)
+		} else {
+			fmt.Printf("Relay: %s\n", r.URL)
+		}
+	}
+	return nil
+}
+
+func doCommunityTimeline(cCtx *cli.Context) error {
+	cfg := cCtx.App.Metadata["config"].(*Config)
+	ctx := context.Background()
+	n := cCtx.Int("n")
+	pending := cCtx.Bool("pending")
+
+	ci, err := fetchCommunity(ctx, cfg, cCtx.String("id"))
+	if err != nil {
+		return err
+	}
+	addr := ci.address()
+	mods := append([]string{ci.Author}, ci.Moderators...)
+	approvals, err := communityQuery(ctx, cfg, ci, nostr.Filters{{
+		Kinds:   []int{nostr.KindCommunityPostApproval},
+		Authors: mods,
+		Tags:    nostr.TagMap{"a": []string{addr}},
+	}}, "approvals")
+	if err != nil {
+		return err
+	}
+	approved := approvedPosts(ci, approvals)
+
+	// The timeline is built from the approvals, which carry the posts they
+	// approve, so older approved posts are not crowded out by unapproved
+	// ones. Only --pending reads the latest posts themselves.
+	posts, missing := approvedPostEvents(ci, approvals)
+	if len(missing) > 0 {
+		evs, err := communityQuery(ctx, cfg, ci, nostr.Filters{{IDs: missing}}, "author", "requests")
+		if err != nil {
+			return err
+		}
+		posts = append(posts, evs...)
+	}
+	if pending {
+		evs, err := communityQuery(ctx, cfg, ci, nostr.Filters{
+			{Kinds: []int{nostr.KindComment}, Tags: nostr.TagMap{"A": []string{addr}}, Limit: n},
+			{Kinds: []int{nostr.KindTextNote}, Tags: nostr.TagMap{"a": []string{addr}}, Limit: n},
+		}, "author", "requests")
+		if err != nil {
+			return err
+		}
+		posts = append(posts, evs...)
+	}
+
+	var evs []*nostr.Event
+	seen := map[string]bool{}
+	for _, ev := range posts {
+		if seen[ev.ID] || communityPostAddress(ev) != addr || !isTopLevelCommunityPost(ev) {
+			continue
+		}
+		seen[ev.ID] = true
+		evs = append(evs, ev)
+	}
+	sort.Slice(evs, func(i, k int) bool {
+		return evs[i].CreatedAt < evs[k].CreatedAt
+	})
+	evs = cfg.applyLabels(ctx, evs)
+	if len(evs) > n {
+		evs = evs[len(evs)-n:]
+	}
+
+	j := cCtx.Bool("json")
+	for _, ev := range evs {
+		if pending && !j && !approved[ev.ID] {
+			color.Set(color.FgHiBlack)
+			fmt.Print("(pending) ")
+			color.Set(color.Reset)
+		}
+		cfg.PrintEvent(ev, j, cCtx.Bool("extra"))
+	}
+	return nil
+}
+
+func doCommunityPost(cCtx *cli.Context) error {
+	stdin := cCtx.Bool("stdin")
+	if !stdin && cCtx.Args().Len() == 0 {
+		return cli.ShowSubcommandHelp(cCtx)
+	}
+	cfg := cCtx.App.Metadata["config"].(*Config)
+	ctx := context.Background()
+
+	_, pub, err := getSkAndPub(cfg)
+	if err != nil {
+		return err
+	}
+	ci, err := fetchCommunity(ctx, cfg, cCtx.String("id"))
+	if err != nil {
+		return err
+	}
+
+	var content string
+	if stdin {
+		b, err := io.ReadAll(os.Stdin)
+		if err != nil {
+			return err
+		}
+		content = string(b)
+	} else {
+		content = strings.Join(cCtx.Args().Slice(), "\n")
+	}
+
+	ev, err := buildCommunityPostEvent(pub, ci, content, cfg.emojiMap(ctx), nostr.Now())
+	if err != nil {
+		return err
+	}
+	if err := cfg.signEvent(ev); err != nil {
+		return err
+	}
+	if communityPublish(ctx, cfg, ci, ev, "requests") == 0 {
+		return errors.New("cannot post to community")
+	}
+	if cfg.verbose {
+		if id, err := nip19.EncodeNote(ev.ID); err == nil {
+			fmt.Println(id)
+		}
+	}
+	return nil
+}
+
+func doCommunityApprove(cCtx *cli.Context) error {
+	if cCtx.Args().Len() == 0 {
+		return cli.ShowSubcommandHelp(cCtx)
+	}
+	cfg := cCtx.App.Metadata["config"].(*Config)
+	ctx := context.Background()
+
+	_, pub, err := getSkAndPub(cfg)
+	if err != nil {
+		return err
+	}
+	ci, err := fetchCommunity(ctx, cfg, cCtx.String("id"))
+	if err != nil {
+		return err
+	}
+	if !ci.isModerator(pub) {
+		return fmt.Errorf("you are not a moderator of %s", ci.Name)
+	}
+
+	var ids []string
+	for _, arg := range cCtx.Args().Slice() {
+		id, err := resolveChannelID(arg)
+		if err != nil {
+			return err
+		}
+		ids = append(ids, id)
+	}
+	posts, err := communityQuery(ctx, cfg, ci, nostr.Filters{{IDs: ids}}, "author", "requests")
+	if err != nil {
+		return err
+	}
+	byID := map[string]*nostr.Event{}
+	for _, ev := range posts {
+		byID[ev.ID] = ev
+	}
+
+	for _, id := range ids {
+		post, ok := byID[id]
+		if !ok {
+			return fmt.Errorf("post not found: %s", id)
+		}
+		ev, err := buildCommunityApprovalEvent(pub, ci, post, nostr.Now())
+		if err != nil {
+			return err
+		}
+		if err := cfg.signEvent(ev); err != nil {
+			return err
+		}
+		if communityPublish(ctx, cfg, ci, ev, "approvals") == 0 {
+			return errors.New("cannot approve post")
+		}
+	}
+	return nil
+}
+
+func doCommunityJoin(cCtx *cli.Context) error {
+	if cCtx.Args().Len() == 0 {
+		return cli.ShowSubcommandHelp(cCtx)
+	}
+	for _, arg := range cCtx.Args().Slice() {
+		if ep, err := decodeNaddr(arg); err != nil || ep.Kind != nostr.KindCommunityDefinition {
+			return fmt.Errorf("not a community: %s", arg)
+		}
+	}
+	return callListAdd(&listAddArg{
+		ctx:   context.Background(),
+		cfg:   cCtx.App.Metadata["config"].(*Config),
+		kind:  nostr.KindCommunityList,
+		items: cCtx.Args().Slice(),
+	})
+}
+
+func doCommunityLeave(cCtx *cli.Context) error {
+	if cCtx.Args().Len() == 0 {
+		return cli.ShowSubcommandHelp(cCtx)
+	}
+	return callListRemove(&listRemoveArg{
+		ctx:   context.Background(),
+		cfg:   cCtx.App.Metadata["config"].(*Config),
+		kind:  nostr.KindCommunityList,
+		items: cCtx.Args().Slice(),
+	})
+}
+
+func communityCommand() *cli.Command {
+	return &cli.Command{
+		Name:  "community",
+		Usage: "moderated communities (NIP-72)",
+		Action: func(cCtx *cli.Context) error {
+			return cli.ShowSubcommandHelp(cCtx)
+		},
+		Subcommands: []*cli.Command{
+			{
+				Name: "list",
+				Flags: []cli.Flag{
+					&cli.BoolFlag{Name: "all", Usage: "list communities found on relays instead of joined ones"},
+					&cli.IntFlag{Name: "n", Value: 100, Usage: "number of items"},
+					&cli.BoolFlag{Name: "json", Usage: "output JSON"},
+				},
+				Usage:     "list joined communities (kind 10004)",
+				UsageText: "algia community list",
+				Action:    doCommunityList,
+			},
+			{
+				Name: "show",
+				Flags: []cli.Flag{
+					&cli.StringFlag{Name: "id", Required: true, Usage: "community (naddr)"},
+					&cli.BoolFlag{Name: "json", Usage: "output JSON"},
+				},
+				Usage:     "show a community's details and moderators",
+				UsageText: "algia community show --id [naddr]",
+				Action:    doCommunityShow,
+			},
+			{
+				Name: "timeline",
+				Flags: []cli.Flag{
+					&cli.StringFlag{Name: "id", Required: true, Usage: "community (naddr)"},
+					&cli.BoolFlag{Name: "pending", Usage: "include posts not yet approved"},
+					&cli.IntFlag{Name: "n", Value: 30, Usage: "number of items"},
+					&cli.BoolFlag{Name: "json", Usage: "output JSON"},
+					&cli.BoolFlag{Name: "extra", Usage: "extra JSON"},
+				},
+				Usage:     "show approved posts of a community",
+				UsageText: "algia community timeline --id [naddr]",
+				Action:    doCommunityTimeline,
+			},
+			{
+				Name: "post",
+				Flags: []cli.Flag{
+					&cli.StringFlag{Name: "id", Required: true, Usage: "community (naddr)"},
+					&cli.BoolFlag{Name: "stdin"},
+				},
+				Usage:     "post to a community (kind 1111)",
+				UsageText: "algia community post --id [naddr] [note text]",
+				ArgsUsage: "[note text]",
+				Action:    doCommunityPost,
+			},
+			{
+				Name: "approve",
+				Flags: []cli.Flag{
+					&cli.StringFlag{Name: "id", Required: true, Usage: "community (naddr)"},
+				},
+				Usage:     "approve posts as a moderator (kind 4550)",
+				UsageText: "algia community approve --id [naddr] [note/nevent...]",
+				ArgsUsage: "[note/nevent...]",
+				Action:    doCommunityApprove,
+			},
+			{
+				Name:      "join",
+				Usage:     "add communities to your community list (kind 10004)",
+				UsageText: "algia community join [naddr...]",
+				ArgsUsage: "[naddr...]",
+				Action:    doCommunityJoin,
+			},
+			{
+				Name:      "leave",
+				Usage:     "remove communities from your community list (kind 10004)",
+				UsageText: "algia community leave [naddr...]",
+				ArgsUsage: "[naddr...]",
+				Action:    doCommunityLeave,
+			},
+		},
+	}
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+func testCommunity() *communityInfo {
+	return parseCommunity(&nostr.Event{
+		PubKey: testPub,
+		Kind:   nostr.KindCommunityDefinition,
+		Tags: nostr.Tags{
+			{"d", "gophers"},
+			{"name", "Gophers"},
+			{"description", "Go on nostr"},
+			{"p", "mod1", "", "moderator"},
+			{"p", "someone"},
+			{"relay", "wss://requests.example", "requests"},
+			{"relay", "wss://approvals.example", "approvals"},
+		},
+	})
+}
+
+func TestParseCommunity(t *testing.T) {
+	ci := testCommunity()
+	if ci.ID != "gophers" || ci.Name != "Gophers" || ci.Description != "Go on nostr" {
+		t.Errorf("ci=%+v", ci)
+	}
+	if len(ci.Moderators) != 1 || ci.Moderators[0] != "mod1" {
+		t.Errorf("moderators=%v", ci.Moderators)
+	}
+	if !ci.isModerator(testPub) || !ci.isModerator("mod1") || ci.isModerator("someone") {
+		t.Error("isModerator")
+	}
+	if got := ci.relayURLs("approvals"); len(got) != 1 || got[0] != "wss://approvals.example" {
+		t.Errorf("approval relays=%v", got)
+	}
+}
+
+func TestBuildCommunityPostEvent(t *testing.T) {
+	ci := testCommunity()
+	ev, err := buildCommunityPostEvent(testPub, ci, "hello #go", nil, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ev.Kind != nostr.KindComment {
+		t.Errorf("kind=%d", ev.Kind)
+	}
+	addr := "34550:" + testPub + ":gophers"
+	if a := findTag(ev.Tags, "A"); a == nil || a[1] != addr || a[2] != "wss://requests.example" {
+		t.Errorf("A tag: %v", ev.Tags)
+	}
+	if a := findTag(ev.Tags, "a"); a == nil || a[1] != addr {
+		t.Errorf("a tag: %v", ev.Tags)
+	}
+	for _, k := range []string{"K", "k"} {
+		if tag := findTag(ev.Tags, k); tag == nil || tag[1] != "34550" {
+			t.Errorf("%s tag: %v", k, ev.Tags)
+		}
+	}
+	if communityPostAddress(ev) != addr || !isTopLevelCommunityPost(ev) {
+		t.Errorf("post address=%q", communityPostAddress(ev))
+	}
+
+	legacy := &nostr.Event{Kind: nostr.KindTextNote, Tags: nostr.Tags{{"a", addr}}}
+	if communityPostAddress(legacy) != addr {
+		t.Error("legacy kind 1 post not recognized")
+	}
+}
+
+func TestBuildCommunityApprovalEvent(t *testing.T) {
+	ci := testCommunity()
+	post, err := buildCommunityPostEvent("author1", ci, "hello", nil, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	post.ID = testTargetID
+
+	ev, err := buildCommunityApprovalEvent("mod1", ci, post, 200)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ev.Kind != nostr.KindCommunityPostApproval {
+		t.Errorf("kind=%d", ev.Kind)
+	}
+	for name, want := range map[string]string{"a": ci.address(), "e": testTargetID, "p": "author1", "k": "1111"} {
+		if got := tagValue(ev.Tags, name); got != want {
+			t.Errorf("%s=%q want %q", name, got, want)
+		}
+	}
+	var embedded nostr.Event
+	if err := json.Unmarshal([]byte(ev.Content), &embedded); err != nil || embedded.ID != testTargetID {
+		t.Errorf("content=%q err=%v", ev.Content, err)
+	}
+
+	if _, err := buildCommunityApprovalEvent("someone", ci, post, 200); err == nil {
+		t.Error("expected error for a non-moderator")
+	}
+	other := &nostr.Event{ID: testTargetID, Kind: nostr.KindTextNote, Tags: nostr.Tags{{"a", "34550:x:other"}}}
+	if _, err := buildCommunityApprovalEvent("mod1", ci, other, 200); err == nil {
+		t.Error("expected error for a post of another community")
+	}
+}
+
+func TestApprovedPosts(t *testing.T) {
+	ci := testCommunity()
+	approvals := []*nostr.Event{
+		{PubKey: "mod1", Kind: nostr.KindCommunityPostApproval, Tags: nostr.Tags{{"a", ci.address()}, {"e", "p1"}}},
+		{PubKey: "someone", Kind: nostr.KindCommunityPostApproval, Tags: nostr.Tags{{"a", ci.address()}, {"e", "p2"}}},
+		{PubKey: "mod1", Kind: nostr.KindCommunityPostApproval, Tags: nostr.Tags{{"a", "34550:x:other"}, {"e", "p3"}}},
+	}
+	got := approvedPosts(ci, approvals)
+	if !got["p1"] || got["p2"] || got["p3"] || len(got) != 1 {
+		t.Errorf("approved=%v", got)
+	}
+}
+
+func TestApprovedPostEvents(t *testing.T) {
+	ci := testCommunity()
+	post := func(content string) *nostr.Event {
+		ev := &nostr.Event{Kind: nostr.KindTextNote, CreatedAt: 100, Tags: nostr.Tags{{"a", ci.address()}}, Content: content}
+		if err := ev.Sign(nostr.GeneratePrivateKey()); err != nil {
+			t.Fatal(err)
+		}
+		return ev
+	}
+	approval := func(by string, p *nostr.Event, content string) *nostr.Event {
+		if content == "" {
+			b, _ := json.Marshal(p)
+			content = string(b)
+		}
+		return &nostr.Event{PubKey: by, Kind: nostr.KindCommunityPostApproval, Tags: nostr.Tags{{"a", ci.address()}, {"e", p.ID}}, Content: content}
+	}
+	embedded, bare, forged, stranger := post("embedded"), post("bare"), post("forged"), post("stranger")
+	tampered := *forged
+	tampered.Content = "changed"
+	b, _ := json.Marshal(tampered)
+
+	posts, missing := approvedPostEvents(ci, []*nostr.Event{
+		approval("mod1", embedded, ""),
+		approval(testPub, embedded, ""), // approved twice
+		approval("mod1", bare, "{}"),
+		approval("mod1", forged, string(b)),
+		approval("someone", stranger, ""),
+	})
+	if len(posts) != 1 || posts[0].ID != embedded.ID {
+		t.Errorf("posts=%v", posts)
+	}
+	want := []string{bare.ID, forged.ID}
+	if want[0] > want[1] {
+		want[0], want[1] = want[1], want[0]
+	}
+	if len(missing) != 2 || missing[0] != want[0] || missing[1] != want[1] {
+		t.Errorf("missing=%v want %v", missing, want)
+	}
+}
@@ -2,14 +2,25 @@ package main
 
 import (
 	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
+	"mime"
+	"net/http"
 	"os"
+	"path/filepath"
+	"slices"
 	"sort"
+	"strconv"
 	"strings"
 	"sync/atomic"
+	"time"
 
 	"github.com/urfave/cli/v2"
 
@@ -22,121 +33,394 @@ import (
 	"github.com/nbd-wtf/go-nostr/sdk"
 )
 
-func doDMList(cCtx *cli.Context) error {
-	j := cCtx.Bool("json")
+// dmRecipients resolves -u values (npub, nprofile, hex, NIP-05 or "me") into
+// hex pubkeys in the order given, without duplicates.
+func dmRecipients(us []string, me string) ([]string, error) {
+	var out []string
+	for _, u := range us {
+		var pub string
+		if u == "me" {
+			pub = me
+		} else if pp := sdk.InputToProfile(context.TODO(), u); pp != nil {
+			pub = pp.PublicKey
+		} else {
+			return nil, fmt.Errorf("failed to parse pubkey from '%s'", u)
+		}
+		if !slices.Contains(out, pub) {
+			out = append(out, pub)
+		}
+	}
+	if len(out) == 0 {
+		return nil, errors.New("no DM user given")
+	}
+	return out, nil
+}
 
-	cfg := cCtx.App.Metadata["config"].(*Config)
+// dmRoom returns the participants of a DM other than me, sorted. NIP-17
+// defines a chat room by its sender and p tags, so the same set of people is
+// the same conversation. A note to self is the room [me].
+func dmRoom(ev *nostr.Event, me string) []string {
+	var room []string
+	add := func(p string) {
+		if p != me && !slices.Contains(room, p) {
+			room = append(room, p)
+		}
+	}
+	add(ev.PubKey)
+	for tag := range ev.Tags.FindAll("p") {
+		add(tag[1])
+	}
+	if len(room) == 0 {
+		return []string{me}
+	}
+	sort.Strings(room)
+	return room
+}
 
-	var sk string
-	var pub string
-	var err error
-	if _, s, err := nip19.Decode(cfg.PrivateKey); err == nil {
-		sk = s.(string)
-	} else {
-		return err
+// roomOf returns the room for a list of recipients as dmRoom would see it.
+func roomOf(recipients []string, me string) []string {
+	return dmRoom(&nostr.Event{PubKey: me, Tags: pTags(recipients)}, me)
+}
+
+func pTags(pubkeys []string) nostr.Tags {
+	tags := nostr.Tags{}
+	for _, p := range pubkeys {
+		tags = append(tags, nostr.Tag{"p", p})
 	}
-	if pub, err = nostr.GetPublicKey(sk); err != nil {
-		return err
+	return tags
+}
+
+// dmOpts captures everything buildDMRumor needs.
+type dmOpts struct {
+	Recipients []string
+	Content    string
+	Subject    string
+	Sensitive  string
+	ReplyID    string // id of the kind 14 message being replied to
+	ReplyRelay string
+}
+
+// buildDMRumor constructs the unsigned kind 14 (NIP-17) chat message to
+// recipients. We are left out of the p tags unless writing to ourselves.
+func buildDMRumor(pubkey string, opts dmOpts, createdAt nostr.Timestamp) (*nostr.Event, error) {
+	if strings.TrimSpace(opts.Content) == "" {
+		return nil, errors.New("content is empty")
+	}
+	var to []string
+	for _, p := range opts.Recipients {
+		if p != pubkey {
+			to = append(to, p)
+		}
+	}
+	if len(to) == 0 && slices.Contains(opts.Recipients, pubkey) {
+		to = []string{pubkey}
+	}
+	if len(to) == 0 {
+		return nil, errors.New("no DM user given")
+	}
+	ev := &nostr.Event{
+		PubKey:    pubkey,
+		CreatedAt: createdAt,
+		Kind:      nostr.KindDirectMessage,
+		Content:   opts.Content,
+		Tags:      pTags(to),
+	}
+	clientTag(ev)
+	if opts.ReplyID != "" {
+		ev.Tags = append(ev.Tags, nostr.Tag{"e", opts.ReplyID, opts.ReplyRelay})
+	}
+	if opts.Subject != "" {
+		ev.Tags = append(ev.Tags, nostr.Tag{"subject", opts.Subject})
 	}
+	if opts.Sensitive != "" {
+		ev.Tags = append(ev.Tags, nostr.Tag{"content-warning", opts.Sensitive})
+	}
+	return ev, nil
+}
 
-	var evs []*nostr.Event
+// kindDMFile is the NIP-17 file message rumor.
+const kindDMFile = 15
 
-	// get timeline with combined filters for both kind 4 and 1059
-	filters := nostr.Filters{
-		{
-			Kinds:   []int{nostr.KindEncryptedDirectMessage},
-			Authors: []string{pub},
-			Tags:    nostr.TagMap{"p": []string{pub}},
-			Limit:   9999,
-		},
+// dmFileAlgorithm is the only encryption-algorithm we produce or accept.
+const dmFileAlgorithm = "aes-gcm"
+
+// dmFile describes an encrypted attachment carried by a kind 15 rumor. Key and
+// Nonce are hex, Hash is the sha256 of the uploaded ciphertext and OrigHash
+// the sha256 of the plaintext.
+type dmFile struct {
+	URL      string
+	Type     string
+	Key      string
+	Nonce    string
+	Hash     string
+	OrigHash string
+	Size     int
+}
+
+// encryptDMFile encrypts data with AES-256-GCM under a fresh random key and
+// nonce and describes the result. URL is left for the caller to fill in once
+// the ciphertext is uploaded.
+func encryptDMFile(data []byte, fileType string) ([]byte, *dmFile, error) {
+	key := make([]byte, 32)
+	nonce := make([]byte, 12)
+	if _, err := rand.Read(key); err != nil {
+		return nil, nil, err
 	}
-	// Collect all events, then sort and display top n
-	if eevs, err := cfg.QueryEvents(context.Background(), filters); err != nil {
-		return err
-	} else {
-		for _, ev := range eevs {
-			evs = append(evs, ev)
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, nil, err
+	}
+	ct := gcm.Seal(nil, nonce, data, nil)
+	sum := sha256.Sum256(ct)
+	osum := sha256.Sum256(data)
+	return ct, &dmFile{
+		Type:     fileType,
+		Key:      hex.EncodeToString(key),
+		Nonce:    hex.EncodeToString(nonce),
+		Hash:     hex.EncodeToString(sum[:]),
+		OrigHash: hex.EncodeToString(osum[:]),
+		Size:     len(ct),
+	}, nil
+}
+
+// decryptDMFile verifies and decrypts a downloaded attachment. The x and ox
+// hashes are checked when the sender provided them.
+func decryptDMFile(ct []byte, f *dmFile) ([]byte, error) {
+	if f.Hash != "" {
+		sum := sha256.Sum256(ct)
+		if hex.EncodeToString(sum[:]) != f.Hash {
+			return nil, errors.New("downloaded file does not match its x hash")
 		}
 	}
+	key, err := hex.DecodeString(f.Key)
+	if err != nil {
+		return nil, fmt.Errorf("invalid decryption-key: %w", err)
+	}
+	nonce, err := hex.DecodeString(f.Nonce)
+	if err != nil {
+		return nil, fmt.Errorf("invalid decryption-nonce: %w", err)
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCMWithNonceSize(block, len(nonce))
+	if err != nil {
+		return nil, err
+	}
+	data, err := gcm.Open(nil, nonce, ct, nil)
+	if err != nil {
+		return nil, fmt.Errorf("cannot decrypt file: %w", err)
+	}
+	if f.OrigHash != "" {
+		sum := sha256.Sum256(data)
+		if hex.EncodeToString(sum[:]) != f.OrigHash {
+			return nil, errors.New("decrypted file does not match its ox hash")
+		}
+	}
+	return data, nil
+}
 
-	// get timeline with combined filters for both kind 4 and 1059
-	filters = nostr.Filters{
-		{
-			Kinds:   []int{nostr.KindEncryptedDirectMessage},
-			Authors: []string{pub},
-			Limit:   9999,
-		},
+// buildDMFileRumor constructs the unsigned kind 15 file message carrying f to
+// the recipients in opts. opts.Content is ignored; the content is the URL.
+func buildDMFileRumor(pubkey string, opts dmOpts, f *dmFile, createdAt nostr.Timestamp) (*nostr.Event, error) {
+	if f.URL == "" {
+		return nil, errors.New("file URL is empty")
 	}
-	// Collect all events, then sort and display top n
-	if eevs, err := cfg.QueryEvents(context.Background(), filters); err != nil {
-		return err
-	} else {
-		for _, ev := range eevs {
-			evs = append(evs, ev)
+	opts.Content = f.URL
+	ev, err := buildDMRumor(pubkey, opts, createdAt)
+	if err != nil {
+		return nil, err
+	}
+	ev.Kind = kindDMFile
+	ev.Tags = append(ev.Tags,
+		nostr.Tag{"file-type", f.Type},
+		nostr.Tag{"encryption-algorithm", dmFileAlgorithm},
+		nostr.Tag{"decryption-key", f.Key},
+		nostr.Tag{"decryption-nonce", f.Nonce},
+		nostr.Tag{"x", f.Hash},
+	)
+	if f.OrigHash != "" {
+		ev.Tags = append(ev.Tags, nostr.Tag{"ox", f.OrigHash})
+	}
+	if f.Size > 0 {
+		ev.Tags = append(ev.Tags, nostr.Tag{"size", strconv.Itoa(f.Size)})
+	}
+	return ev, nil
+}
+
+// parseDMFile reads the attachment described by a kind 15 rumor.
+func parseDMFile(ev *nostr.Event) (*dmFile, error) {
+	if ev.Kind != kindDMFile {
+		return nil, fmt.Errorf("event %s is not a file message (kind %d)", ev.ID, ev.Kind)
+	}
+	if alg := tagValue(ev.Tags, "encryption-algorithm"); alg != dmFileAlgorithm {
+		return nil, fmt.Errorf("unsupported encryption-algorithm '%s'", alg)
+	}
+	f := &dmFile{
+		URL:      strings.TrimSpace(ev.Content),
+		Type:     tagValue(ev.Tags, "file-type"),
+		Key:      tagValue(ev.Tags, "decryption-key"),
+		Nonce:    tagValue(ev.Tags, "decryption-nonce"),
+		Hash:     tagValue(ev.Tags, "x"),
+		OrigHash: tagValue(ev.Tags, "ox"),
+	}
+	f.Size, _ = strconv.Atoi(tagValue(ev.Tags, "size"))
+	if f.URL == "" || f.Key == "" || f.Nonce == "" {
+		return nil, errors.New("file message lacks a URL, key or nonce")
+	}
+	return f, nil
+}
+
+// uploadDMFiles encrypts each path and uploads the ciphertext through the
+// configured media servers. Ciphertexts are staged in a temporary directory
+// without their original names or extensions.
+func uploadDMFiles(cfg *Config, paths, servers []string) ([]*dmFile, error) {
+	if len(paths) == 0 {
+		return nil, nil
+	}
+	dir, err := os.MkdirTemp("", "algia-dm")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+
+	var files []*dmFile
+	var staged []string
+	for _, p := range paths {
+		data, err := os.ReadFile(p)
+		if err != nil {
+			return nil, err
+		}
+		fileType := mime.TypeByExtension(filepath.Ext(p))
+		if fileType == "" {
+			fileType = http.DetectContentType(data)
+		}
+		ct, f, err := encryptDMFile(data, fileType)
+		if err != nil {
+			return nil, err
+		}
+		tmp := filepath.Join(dir, f.Hash)
+		if err := os.WriteFile(tmp, ct, 0600); err != nil {
+			return nil, err
 		}
+		files = append(files, f)
+		staged = append(staged, tmp)
 	}
+	bds, err := uploadImages(cfg, staged, servers, false)
+	if err != nil {
+		return nil, err
+	}
+	for i, bd := range bds {
+		files[i].URL = bd.URL
+	}
+	return files, nil
+}
 
-	// Query for kind 1059 (encrypted) events with strict participant check
-	filters = nostr.Filters{
-		{
-			Kinds: []int{1059},
-			Tags:  nostr.TagMap{"p": []string{pub}},
-			Limit: 9999,
-		},
+// fetchDMs returns every DM we can read: kind 4 messages we sent or received
+// and the rumors of kind 1059 gift wraps addressed to us. Wraps that fail to
+// unwrap are dropped.
+func fetchDMs(ctx context.Context, cfg *Config, pub string) ([]*nostr.Event, error) {
+	evs, err := cfg.QueryEvents(ctx, nostr.Filters{
+		{Kinds: []int{nostr.KindEncryptedDirectMessage}, Authors: []string{pub}, Limit: 9999},
+		{Kinds: []int{nostr.KindEncryptedDirectMessage}, Tags: nostr.TagMap{"p": []string{pub}}, Limit: 9999},
+	})
+	if err != nil {
+		return nil, err
 	}
-	if eevs, err := cfg.QueryEvents(context.Background(), filters); err == nil {
-		for _, ev := range eevs {
-			evs = append(evs, ev)
+	wevs, err := cfg.QueryEvents(ctx, nostr.Filters{
+		{Kinds: []int{nostr.KindGiftWrap}, Tags: nostr.TagMap{"p": []string{pub}}, Limit: 9999},
+	})
+	if err == nil {
+		for _, ev := range wevs {
+			if ev.Kind != nostr.KindGiftWrap {
+				evs = append(evs, ev)
+			}
 		}
 	}
 	if cfg.verbose {
 		fmt.Fprintf(os.Stderr, "Total events received: %d\n", len(evs))
 	}
+	return evs, nil
+}
+
+// dmName returns the name shown for a DM participant.
+func (cfg *Config) dmName(pubkey string) string {
+	npub, err := nip19.EncodePublicKey(pubkey)
+	if err != nil {
+		return pubkey
+	}
+	if profile, err := cfg.GetProfile(npub); err == nil {
+		if profile.DisplayName != "" {
+			return profile.DisplayName
+		} else if profile.Name != "" {
+			return profile.Name
+		}
+	}
+	return npub
+}
+
+func doDMList(cCtx *cli.Context) error {
+	j := cCtx.Bool("json")
+
+	cfg := cCtx.App.Metadata["config"].(*Config)
+
+	_, pub, err := getSkAndPub(cfg)
+	if err != nil {
+		return err
+	}
+	evs, err := fetchDMs(context.Background(), cfg, pub)
+	if err != nil {
+		return err
+	}
 
 	type entry struct {
-		Name      string `json:"name"`
-		Pubkey    string `json:"pubkey"`
+		Name      string   `json:"name"`
+		Pubkey    string   `json:"pubkey"`
+		Pubkeys   []string `json:"pubkeys"`
+		Subject   string   `json:"subject,omitempty"`
 		CreatedAt nostr.Timestamp
 	}
 	bestEntries := make(map[string]entry)
+	subjectAt := make(map[string]nostr.Timestamp)
 
 	for _, ev := range evs {
-		var p string
-		if ev.PubKey == pub {
-			tag := ev.Tags.GetFirst([]string{"p"})
-			if tag == nil {
-				continue
-			}
-			p = (*tag)[1]
-		} else {
-			p = ev.PubKey
-		}
-
-		npub, err := nip19.EncodePublicKey(p)
-		if err != nil {
-			continue
-		}
+		room := dmRoom(ev, pub)
+		key := strings.Join(room, ",")
 
-		profile, err := cfg.GetProfile(npub)
-		name := npub
-		if err == nil {
-			if profile.DisplayName != "" {
-				name = profile.DisplayName
-			} else if profile.Name != "" {
-				name = profile.Name
+		current, exists := bestEntries[key]
+		if !exists {
+			var names []string
+			for _, p := range room {
+				npub, err := nip19.EncodePublicKey(p)
+				if err != nil {
+					continue
+				}
+				current.Pubkeys = append(current.Pubkeys, npub)
+				names = append(names, cfg.dmName(p))
 			}
+			if len(current.Pubkeys) == 0 {
+				continue
+			}
+			current.Pubkey = current.Pubkeys[0]
+			current.Name = strings.Join(names, ", ")
 		}
-
-		current, exists := bestEntries[p]
-		newEntry := entry{
-			Name:      name,
-			Pubkey:    npub,
-			CreatedAt: ev.CreatedAt,
+		if ev.CreatedAt > current.CreatedAt {
+			current.CreatedAt = ev.CreatedAt
 		}
-
-		if !exists || ev.CreatedAt > current.CreatedAt {
-			bestEntries[p] = newEntry
+		// The newest subject names the room.
+		if subject := tagValue(ev.Tags, "subject"); subject != "" && ev.CreatedAt >= subjectAt[key] {
+			current.Subject = subject
+			subjectAt[key] = ev.CreatedAt
 		}
+		bestEntries[key] = current
 	}
 
 	users := make([]entry, 0, len(bestEntries))
@@ -157,101 +441,45 @@ func doDMList(cCtx *cli.Context) error {
 
 	for _, user := range users {
 		color.Set(color.FgHiBlue)
-		fmt.Print(user.Pubkey)
+		fmt.Print(strings.Join(user.Pubkeys, ","))
 		color.Set(color.Reset)
 		fmt.Print(": ")
 		color.Set(color.FgHiRed)
-		fmt.Println(user.Name)
+		fmt.Print(user.Name)
 		color.Set(color.Reset)
+		if user.Subject != "" {
+			fmt.Print(" [" + user.Subject + "]")
+		}
+		fmt.Println()
 	}
 	return nil
 }
 
 func doDMTimeline(cCtx *cli.Context) error {
-	u := cCtx.String("u")
 	n := cCtx.Int("n")
 	j := cCtx.Bool("json")
 	extra := cCtx.Bool("extra")
 
 	cfg := cCtx.App.Metadata["config"].(*Config)
 
-	var sk string
-	var pk string
-	var err error
-	if _, s, err := nip19.Decode(cfg.PrivateKey); err == nil {
-		sk = s.(string)
-	} else {
+	_, pk, err := getSkAndPub(cfg)
+	if err != nil {
 		return err
 	}
-	if pk, err = nostr.GetPublicKey(sk); err != nil {
-		return err
-	}
-
-	var pub string
-	if u == "me" {
-		pub = pk
-	} else if pp := sdk.InputToProfile(context.TODO(), u); pp != nil {
-		pub = pp.PublicKey
-	} else {
-		return fmt.Errorf("failed to parse pubkey from '%s'", u)
-	}
-
-	var evs []*nostr.Event
-
-	// get timeline with combined filters for both kind 4 and 1059
-	filters := nostr.Filters{
-		{
-			Kinds:   []int{nostr.KindEncryptedDirectMessage},
-			Authors: []string{pub},
-			Tags:    nostr.TagMap{"p": []string{pk}},
-			Limit:   9999,
-		},
-	}
-	// Collect all events, then sort and display top n
-	if eevs, err := cfg.QueryEvents(context.Background(), filters); err != nil {
+	// The -u values name a room: everyone in the conversation except us.
+	recipients, err := dmRecipients(cCtx.StringSlice("u"), pk)
+	if err != nil {
 		return err
-	} else {
-		for _, ev := range eevs {
-			evs = append(evs, ev)
-		}
 	}
+	room := strings.Join(roomOf(recipients, pk), ",")
 
-	// get timeline with combined filters for both kind 4 and 1059
-	filters = nostr.Filters{
-		{
-			Kinds:   []int{nostr.KindEncryptedDirectMessage},
-			Authors: []string{pk},
-			Tags:    nostr.TagMap{"p": []string{pub}},
-			Limit:   9999,
-		},
-	}
-	// Collect all events, then sort and display top n
-	if eevs, err := cfg.QueryEvents(context.Background(), filters); err != nil {
+	all, err := fetchDMs(context.Background(), cfg, pk)
+	if err != nil {
 		return err
-	} else {
-		for _, ev := range eevs {
-			evs = append(evs, ev)
-		}
-	}
-
-	// Query for kind 1059 (encrypted) events with strict participant check
-	filters = nostr.Filters{
-		{
-			Kinds: []int{1059},
-			Tags:  nostr.TagMap{"p": []string{pk}},
-			Limit: 9999,
-		},
 	}
-	if eevs, err := cfg.QueryEvents(context.Background(), filters); err == nil {
-		for _, ev := range eevs {
-			// Validate participants for kind 1059
-			if ev.Kind != 14 {
-				continue
-			}
-			if !((ev.PubKey == pub && ev.Tags.GetFirst([]string{"p"}).Value() == pk) ||
-				(ev.PubKey == pk && ev.Tags.GetFirst([]string{"p"}).Value() == pub)) {
-				continue
-			}
+	var evs []*nostr.Event
+	for _, ev := range all {
+		if strings.Join(dmRoom(ev, pk), ",") == room {
 			evs = append(evs, ev)
 		}
 	}
@@ -288,66 +516,311 @@ func createGiftWrap(ev nostr.Event, recipientPubkey, senderSk string) (nostr.Eve
 	)
 }
 
-func doDMPost(cCtx *cli.Context) error {
-	u := cCtx.String("u")
-	stdin := cCtx.Bool("stdin")
-	if !stdin && cCtx.Args().Len() == 0 {
-		return cli.ShowSubcommandHelp(cCtx)
+// dmReadRelays returns the relays DMs are read from: the "dm" relays, or the
+// read relays when none is marked.
+func dmReadRelays(cfg *Config) []string {
+	var relays []string
+	for u, r := range cfg.Relays {
+		if r.DM {
+			relays = append(relays, u)
+		}
 	}
-	sensitive := cCtx.String("sensitive")
-	useNip04 := cCtx.Bool("nip04")
+	if len(relays) == 0 {
+		for u, r := range cfg.Relays {
+			if r.Read {
+				relays = append(relays, u)
+			}
+		}
+	}
+	sort.Strings(relays)
+	return relays
+}
+
+// giftWrapBacklog is how far back NIP-59 may randomize a gift wrap's
+// created_at.
+const giftWrapBacklog = 2 * 24 * 60 * 60
 
+func doDMStream(cCtx *cli.Context) error {
+	j := cCtx.Bool("json")
 	cfg := cCtx.App.Metadata["config"].(*Config)
 
-	var sk string
-	if _, s, err := nip19.Decode(cfg.PrivateKey); err == nil {
-		sk = s.(string)
-	} else {
+	sk, pub, err := getSkAndPub(cfg)
+	if err != nil {
 		return err
 	}
-	ev := nostr.Event{}
-	clientTag(&ev)
-
-	if npub, err := nostr.GetPublicKey(sk); err == nil {
-		if _, err := nip19.EncodePublicKey(npub); err != nil {
+	var room string
+	if us := cCtx.StringSlice("u"); len(us) > 0 {
+		recipients, err := dmRecipients(us, pub)
+		if err != nil {
 			return err
 		}
-		ev.PubKey = npub
-	} else {
-		return err
+		room = strings.Join(roomOf(recipients, pub), ",")
 	}
 
-	if stdin {
-		b, err := io.ReadAll(os.Stdin)
+	relays := dmReadRelays(cfg)
+	if len(relays) == 0 {
+		return errors.New("no read relays available")
+	}
+	ctx := context.Background()
+	cfg.preAuth(ctx, relays)
+
+	// Wraps are backdated, so ask for a wider window and judge by the
+	// rumor's own created_at.
+	since := nostr.Now()
+	wrapSince := since - giftWrapBacklog
+	filters := nostr.Filters{
+		{Kinds: []int{nostr.KindGiftWrap}, Tags: nostr.TagMap{"p": []string{pub}}, Since: &wrapSince},
+		{Kinds: []int{nostr.KindEncryptedDirectMessage}, Tags: nostr.TagMap{"p": []string{pub}}, Since: &since},
+		{Kinds: []int{nostr.KindEncryptedDirectMessage}, Authors: []string{pub}, Since: &since},
+	}
+	seen := map[string]bool{}
+	for ie := range cfg.pool.SubMany(ctx, relays, filters) {
+		ev := ie.Event
+		if ev == nil || seen[ev.ID] {
+			continue
+		}
+		seen[ev.ID] = true
+		switch ev.Kind {
+		case nostr.KindEncryptedDirectMessage:
+			if err := cfg.Decode(ev, sk, pub); err != nil {
+				continue
+			}
+		case nostr.KindGiftWrap:
+			rumor, err := unwrapGift(ev, sk)
+			if err != nil {
+				continue
+			}
+			ev = rumor
+		}
+		if ev.CreatedAt < since {
+			continue
+		}
+		if room != "" && strings.Join(dmRoom(ev, pub), ",") != room {
+			continue
+		}
+		cfg.PrintEvent(ev, j, false)
+	}
+	return nil
+}
+
+// Where DMs to a recipient go, in order of preference.
+const (
+	dmRelaySourceDM    = "dm"    // their kind 10050 DM relay list
+	dmRelaySourceInbox = "inbox" // their kind 10002 read relays (NIP-65)
+	dmRelaySourceOwn   = "own"   // our own DM relays
+)
+
+// relayListRelays returns the relays of a kind 10050 list, or the read
+// (inbox) relays of a kind 10002 list. ev may be nil.
+func relayListRelays(ev *nostr.Event) []string {
+	if ev == nil {
+		return nil
+	}
+	var relays []string
+	switch ev.Kind {
+	case nostr.KindDMRelayList:
+		for tag := range ev.Tags.FindAll("relay") {
+			relays = append(relays, tag[1])
+		}
+	case nostr.KindRelayListMetadata:
+		for tag := range ev.Tags.FindAll("r") {
+			if len(tag) < 3 || tag[2] == "" || tag[2] == "read" {
+				relays = append(relays, tag[1])
+			}
+		}
+	}
+	return relays
+}
+
+// pickDMRelays chooses where to deliver a DM: the recipient's DM relays,
+// else their NIP-65 inbox relays, else our own DM relays. It also returns
+// which of these it picked.
+func pickDMRelays(dmList, relayList *nostr.Event, own []string) ([]string, string) {
+	if relays := relayListRelays(dmList); len(relays) > 0 {
+		return relays, dmRelaySourceDM
+	}
+	if relays := relayListRelays(relayList); len(relays) > 0 {
+		return relays, dmRelaySourceInbox
+	}
+	return own, dmRelaySourceOwn
+}
+
+// ownDMRelays returns the relays we keep DMs on: the "dm" relays, or the
+// write relays when none is marked.
+func ownDMRelays(cfg *Config) []string {
+	var relays []string
+	for u, r := range cfg.Relays {
+		if r.DM {
+			relays = append(relays, u)
+		}
+	}
+	if len(relays) == 0 {
+		relays = writeRelays(cfg)
+	}
+	sort.Strings(relays)
+	return relays
+}
+
+// latestByKind returns the newest event of each kind.
+func latestByKind(evs []*nostr.Event) map[int]*nostr.Event {
+	out := map[int]*nostr.Event{}
+	for _, ev := range evs {
+		if cur, ok := out[ev.Kind]; !ok || ev.CreatedAt > cur.CreatedAt {
+			out[ev.Kind] = ev
+		}
+	}
+	return out
+}
+
+// dmRelaysFor returns the relays to deliver a DM for pubkey to, warning when
+// it has to fall back from the recipient's DM relay list.
+func (cfg *Config) dmRelaysFor(ctx context.Context, pubkey string) ([]string, error) {
+	evs, err := cfg.QueryEvents(ctx, nostr.Filters{{
+		Kinds:   []int{nostr.KindDMRelayList, nostr.KindRelayListMetadata},
+		Authors: []string{pubkey},
+	}})
+	if err != nil {
+		return nil, err
+	}
+	latest := latestByKind(evs)
+	relays, source := pickDMRelays(latest[nostr.KindDMRelayList], latest[nostr.KindRelayListMetadata], ownDMRelays(cfg))
+	switch source {
+	case dmRelaySourceInbox:
+		fmt.Fprintf(os.Stderr, "warning: %s does not publish relay list for DM; using their inbox relays\n", cfg.dmName(pubkey))
+	case dmRelaySourceOwn:
+		fmt.Fprintf(os.Stderr, "warning: %s does not publish relay list for DM; using our DM relays\n", cfg.dmName(pubkey))
+	}
+	if len(relays) == 0 {
+		return nil, fmt.Errorf("no relay to send DM to %s", cfg.dmName(pubkey))
+	}
+	return relays, nil
+}
+
+// sendDMRumor gift-wraps rumor for each of its recipients, publishing to
+// their DM relays, and keeps a wrapped copy for ourselves on our DM relays.
+// A recipient that cannot be reached does not stop the others, and our copy
+// is kept either way so a partly delivered message is not lost; the error
+// names the recipients that did not get it.
+func (cfg *Config) sendDMRumor(ctx context.Context, rumor nostr.Event, sk string) error {
+	var recipients []string
+	for tag := range rumor.Tags.FindAll("p") {
+		if tag[1] != rumor.PubKey && !slices.Contains(recipients, tag[1]) {
+			recipients = append(recipients, tag[1])
+		}
+	}
+
+	var failed []string
+	for _, pub := range recipients {
+		if err := cfg.sendGiftWrap(ctx, rumor, pub, sk); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			failed = append(failed, cfg.dmName(pub))
+		}
+	}
+
+	var errs []error
+	if len(failed) > 0 {
+		errs = append(errs, fmt.Errorf("cannot send DM to %s", strings.Join(failed, ", ")))
+	}
+
+	// Publish sender's gift wrap to sender's own relays
+	senderWrap, err := createGiftWrap(rumor, rumor.PubKey, sk)
+	if err != nil {
+		return errors.Join(append(errs, err)...)
+	}
+	var success atomic.Int64
+	cfg.Do(ctx, Relay{Write: true, DM: true}, func(ctx context.Context, relay *nostr.Relay) bool {
+		err := relay.Publish(ctx, senderWrap)
 		if err != nil {
-			return err
+			fmt.Fprintln(os.Stderr, relay.URL, err)
+		} else {
+			success.Add(1)
+		}
+		return true
+	})
+	if success.Load() == 0 {
+		errs = append(errs, errors.New("cannot post sender's copy"))
+	}
+	return errors.Join(errs...)
+}
+
+// sendGiftWrap delivers rumor, gift-wrapped, to the DM relays of pub.
+func (cfg *Config) sendGiftWrap(ctx context.Context, rumor nostr.Event, pub, sk string) error {
+	wrap, err := createGiftWrap(rumor, pub, sk)
+	if err != nil {
+		return err
+	}
+	relays, err := cfg.dmRelaysFor(ctx, pub)
+	if err != nil {
+		return err
+	}
+	var success int
+	for res := range cfg.pool.PublishMany(ctx, relays, wrap) {
+		if res.Error != nil {
+			fmt.Fprintln(os.Stderr, res.RelayURL, res.Error)
+		} else {
+			success++
 		}
-		ev.Content = string(b)
-	} else {
-		ev.Content = strings.Join(cCtx.Args().Slice(), "\n")
 	}
-	if strings.TrimSpace(ev.Content) == "" {
-		return errors.New("content is empty")
+	if success == 0 {
+		return fmt.Errorf("cannot send DM to %s", cfg.dmName(pub))
 	}
+	return nil
+}
 
-	if sensitive != "" {
-		ev.Tags = ev.Tags.AppendUnique(nostr.Tag{"content-warning", sensitive})
+func doDMPost(cCtx *cli.Context) error {
+	stdin := cCtx.Bool("stdin")
+	images := cCtx.StringSlice("image")
+	if !stdin && cCtx.Args().Len() == 0 && len(images) == 0 {
+		return cli.ShowSubcommandHelp(cCtx)
 	}
+	sensitive := cCtx.String("sensitive")
+	useNip04 := cCtx.Bool("nip04")
 
-	if u == "me" {
-		u = ev.PubKey
+	cfg := cCtx.App.Metadata["config"].(*Config)
+
+	sk, me, err := getSkAndPub(cfg)
+	if err != nil {
+		return err
 	}
-	var pub string
-	if pp := sdk.InputToProfile(context.TODO(), u); pp != nil {
-		pub = pp.PublicKey
+
+	var content string
+	if stdin {
+		b, err := io.ReadAll(os.Stdin)
+		if err != nil {
+			return err
+		}
+		content = string(b)
 	} else {
-		return fmt.Errorf("failed to parse pubkey from '%s'", u)
+		content = strings.Join(cCtx.Args().Slice(), "\n")
 	}
 
-	ev.Tags = ev.Tags.AppendUnique(nostr.Tag{"p", pub})
-	ev.CreatedAt = nostr.Now()
-
+	recipients, err := dmRecipients(cCtx.StringSlice("u"), me)
+	if err != nil {
+		return err
+	}
 	if useNip04 {
+		if len(recipients) != 1 {
+			return errors.New("NIP-04 DMs support only one recipient")
+		}
+		if cCtx.String("subject") != "" || cCtx.String("reply") != "" {
+			return errors.New("NIP-04 DMs do not support a subject or replies")
+		}
+		if len(images) > 0 {
+			return errors.New("NIP-04 DMs do not support encrypted files")
+		}
+		pub := recipients[0]
+		ev := nostr.Event{}
+		clientTag(&ev)
+		ev.PubKey = me
+		ev.Content = content
+		if strings.TrimSpace(ev.Content) == "" {
+			return errors.New("content is empty")
+		}
+		if sensitive != "" {
+			ev.Tags = ev.Tags.AppendUnique(nostr.Tag{"content-warning", sensitive})
+		}
+		ev.Tags = ev.Tags.AppendUnique(nostr.Tag{"p", pub})
+		ev.CreatedAt = nostr.Now()
 		ev.Kind = nostr.KindEncryptedDirectMessage
 		ss, err := nip04.ComputeSharedSecret(pub, sk)
 		if err != nil {
@@ -374,65 +847,274 @@ func doDMPost(cCtx *cli.Context) error {
 		if success.Load() == 0 {
 			return errors.New("cannot post")
 		}
-	} else {
-		ev.Kind = nostr.KindDirectMessage
+		return nil
+	}
 
-		// Create gift wrap for receiver
-		receiverWrap, err := createGiftWrap(ev, pub, sk)
+	opts := dmOpts{
+		Recipients: recipients,
+		Content:    content,
+		Subject:    cCtx.String("subject"),
+		Sensitive:  sensitive,
+	}
+	if r := cCtx.String("reply"); r != "" {
+		evp := sdk.InputToEventPointer(r)
+		if evp == nil {
+			return fmt.Errorf("failed to parse event from '%s'", r)
+		}
+		opts.ReplyID = evp.ID
+		opts.ReplyRelay = firstRelayHint(evp.Relays, "")
+	}
+	// Upload before sending anything so a failed upload does not leave the
+	// text half of the message delivered on its own.
+	files, err := uploadDMFiles(cfg, images, cCtx.StringSlice("server"))
+	if err != nil {
+		return err
+	}
+	var rumors []*nostr.Event
+	var parts []string
+	if strings.TrimSpace(content) != "" || len(files) == 0 {
+		rumor, err := buildDMRumor(me, opts, nostr.Now())
 		if err != nil {
 			return err
 		}
-
-		// Create gift wrap for sender (self)
-		senderWrap, err := createGiftWrap(ev, ev.PubKey, sk)
+		rumors = append(rumors, rumor)
+		parts = append(parts, "text")
+	}
+	for i, f := range files {
+		rumor, err := buildDMFileRumor(me, opts, f, nostr.Now())
 		if err != nil {
 			return err
 		}
+		rumors = append(rumors, rumor)
+		parts = append(parts, images[i])
+	}
+	// Every part is sent even when an earlier one fails, so the error says
+	// which of them did not get through.
+	var errs []error
+	for i, rumor := range rumors {
+		if err := cfg.sendDMRumor(context.TODO(), *rumor, sk); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", parts[i], err))
+		}
+	}
+	return errors.Join(errs...)
+}
 
-		// Get receiver's relay list
-		filters := nostr.Filters{
-			{
-				Kinds:   []int{nostr.KindDMRelayList},
-				Authors: []string{pub},
-			},
+func doDMGetFile(cCtx *cli.Context) error {
+	if cCtx.Args().Len() != 1 {
+		return cli.ShowSubcommandHelp(cCtx)
+	}
+	cfg := cCtx.App.Metadata["config"].(*Config)
+
+	_, pub, err := getSkAndPub(cfg)
+	if err != nil {
+		return err
+	}
+	evp := sdk.InputToEventPointer(cCtx.Args().First())
+	if evp == nil {
+		return fmt.Errorf("failed to parse event from '%s'", cCtx.Args().First())
+	}
+	id := evp.ID
+
+	ctx := context.Background()
+	evs, err := fetchDMs(ctx, cfg, pub)
+	if err != nil {
+		return err
+	}
+	var rumor *nostr.Event
+	for _, ev := range evs {
+		if ev.ID == id {
+			rumor = ev
+			break
 		}
-		revs, err := cfg.QueryEvents(context.Background(), filters)
-		if err != nil {
-			return err
+	}
+	if rumor == nil {
+		return fmt.Errorf("DM %s not found", id)
+	}
+	f, err := parseDMFile(rumor)
+	if err != nil {
+		return err
+	}
+	ct, _, err := httpGetBytes(ctx, f.URL)
+	if err != nil {
+		return err
+	}
+	data, err := decryptDMFile(ct, f)
+	if err != nil {
+		return err
+	}
+
+	output := cCtx.String("o")
+	if output == "" {
+		output = id[:16]
+		if exts, _ := mime.ExtensionsByType(f.Type); len(exts) > 0 {
+			output += exts[0]
 		}
+	}
+	if output == "-" {
+		_, err = os.Stdout.Write(data)
+		return err
+	}
+	if err := os.WriteFile(output, data, 0644); err != nil {
+		return err
+	}
+	fmt.Println(output)
+	return nil
+}
 
-		if len(revs) == 0 {
-			return errors.New("user does not publish relay list for DM")
+// buildDMRelayListEvent constructs an unsigned kind 10050 DM relay list.
+func buildDMRelayListEvent(pubkey string, relays []string, createdAt nostr.Timestamp) (*nostr.Event, error) {
+	ev := &nostr.Event{
+		PubKey:    pubkey,
+		CreatedAt: createdAt,
+		Kind:      nostr.KindDMRelayList,
+		Tags:      nostr.Tags{},
+	}
+	for _, r := range relays {
+		if !strings.HasPrefix(r, "wss://") && !strings.HasPrefix(r, "ws://") {
+			return nil, fmt.Errorf("invalid relay URL '%s'", r)
 		}
+		ev.Tags = ev.Tags.AppendUnique(nostr.Tag{"relay", nostr.NormalizeURL(r)})
+	}
+	if len(ev.Tags) == 0 {
+		return nil, errors.New("no relay given")
+	}
+	clientTag(ev)
+	return ev, nil
+}
 
-		relays := []string{}
-		for _, tag := range revs[0].Tags {
-			if len(tag) >= 2 && tag[0] == "relay" {
-				relays = append(relays, tag[1])
+// dmRelayMismatch compares the "dm" relays in config with the ones we
+// advertise and returns those only advertised and those only configured.
+func dmRelayMismatch(configured, advertised []string) (missing, extra []string) {
+	norm := func(urls []string) []string {
+		var out []string
+		for _, u := range urls {
+			if u = nostr.NormalizeURL(u); !slices.Contains(out, u) {
+				out = append(out, u)
 			}
 		}
+		sort.Strings(out)
+		return out
+	}
+	configured, advertised = norm(configured), norm(advertised)
+	for _, u := range advertised {
+		if !slices.Contains(configured, u) {
+			missing = append(missing, u)
+		}
+	}
+	for _, u := range configured {
+		if !slices.Contains(advertised, u) {
+			extra = append(extra, u)
+		}
+	}
+	return missing, extra
+}
 
-		// Publish receiver's gift wrap to receiver's relays
-		ctx := context.TODO()
-		result := <-nostr.NewSimplePool(ctx).PublishMany(ctx, relays, receiverWrap)
-		if result.Error != nil {
-			return result.Error
+// configuredDMRelays returns the relays marked "dm" in config.
+func configuredDMRelays(cfg *Config) []string {
+	var relays []string
+	for u, r := range cfg.Relays {
+		if r.DM {
+			relays = append(relays, u)
 		}
+	}
+	sort.Strings(relays)
+	return relays
+}
 
-		// Publish sender's gift wrap to sender's own relays
-		var success atomic.Int64
-		cfg.Do(context.Background(), Relay{Write: true, DM: true}, func(ctx context.Context, relay *nostr.Relay) bool {
-			err := relay.Publish(ctx, senderWrap)
-			if err != nil {
-				fmt.Fprintln(os.Stderr, relay.URL, err)
-			} else {
-				success.Add(1)
-			}
-			return true
-		})
-		if success.Load() == 0 {
-			return errors.New("cannot post sender's copy")
+// fetchOwnDMRelayList returns our latest kind 10050 event, or nil.
+func fetchOwnDMRelayList(ctx context.Context, cfg *Config) (*nostr.Event, error) {
+	_, pub, err := getSkAndPub(cfg)
+	if err != nil {
+		return nil, err
+	}
+	evs, err := cfg.QueryEvents(ctx, nostr.Filters{{
+		Kinds:   []int{nostr.KindDMRelayList},
+		Authors: []string{pub},
+	}})
+	if err != nil {
+		return nil, err
+	}
+	return latestByKind(evs)[nostr.KindDMRelayList], nil
+}
+
+// checkDMRelays warns when the "dm" relays in config differ from our kind
+// 10050 list, since others send us DMs to the advertised ones. A match is
+// remembered in the config for followListCacheTTL, like FollowList, so the
+// list is only fetched again once it is stale or the "dm" relays change.
+func (cfg *Config) checkDMRelays(ctx context.Context) {
+	if cfg.tempRelay {
+		return
+	}
+	configured := configuredDMRelays(cfg)
+	if c := cfg.DMRelayCheck; c != nil && slices.Equal(c.Items, configured) && time.Since(c.Updated) < followListCacheTTL {
+		return
+	}
+	ev, err := fetchOwnDMRelayList(ctx, cfg)
+	if err != nil {
+		return
+	}
+	missing, extra := dmRelayMismatch(configured, relayListRelays(ev))
+	switch {
+	case ev == nil && len(configured) > 0:
+		fmt.Fprintln(os.Stderr, "warning: no DM relay list (kind 10050) is published; run 'algia dm relays set'")
+	case len(missing) > 0 || len(extra) > 0:
+		fmt.Fprintln(os.Stderr, "warning: \"dm\" relays in config differ from your DM relay list (kind 10050)")
+		for _, u := range missing {
+			fmt.Fprintln(os.Stderr, "  advertised only:", u)
 		}
+		for _, u := range extra {
+			fmt.Fprintln(os.Stderr, "  configured only:", u)
+		}
+	default:
+		cfg.DMRelayCheck = &CachedList{Items: configured, Updated: time.Now()}
+		cfg.listsChanged = true
+	}
+}
+
+func doDMRelaysShow(cCtx *cli.Context) error {
+	cfg := cCtx.App.Metadata["config"].(*Config)
+	ev, err := fetchOwnDMRelayList(context.Background(), cfg)
+	if err != nil {
+		return err
+	}
+	if ev == nil {
+		return errors.New("no DM relay list found")
+	}
+	if cCtx.Bool("json") {
+		return json.NewEncoder(os.Stdout).Encode(ev)
+	}
+	_, extra := dmRelayMismatch(relayListRelays(ev), configuredDMRelays(cfg))
+	for _, u := range relayListRelays(ev) {
+		if slices.Contains(extra, nostr.NormalizeURL(u)) {
+			fmt.Println(u + " (not marked \"dm\" in config)")
+		} else {
+			fmt.Println(u)
+		}
+	}
+	return nil
+}
+
+func doDMRelaysSet(cCtx *cli.Context) error {
+	cfg := cCtx.App.Metadata["config"].(*Config)
+
+	_, pub, err := getSkAndPub(cfg)
+	if err != nil {
+		return err
+	}
+	// Without arguments, advertise the "dm" relays from config.
+	relays := cCtx.Args().Slice()
+	if len(relays) == 0 {
+		relays = configuredDMRelays(cfg)
+	}
+	ev, err := buildDMRelayListEvent(pub, relays, nostr.Now())
+	if err != nil {
+		return err
+	}
+	if err := cfg.signEvent(ev); err != nil {
+		return err
+	}
+	if cfg.publishEvent(context.Background(), ev) == 0 {
+		return errors.New("cannot publish DM relay list")
 	}
 	return nil
 }
@@ -442,6 +1124,14 @@ func dmCommand() *cli.Command {
 	return &cli.Command{
 		Name:  "dm",
 		Usage: "direct messages (NIP-17/NIP-04)",
+		Before: func(cCtx *cli.Context) error {
+			switch cCtx.Args().First() {
+			case "", "help", "h", "relays":
+				return nil
+			}
+			cCtx.App.Metadata["config"].(*Config).checkDMRelays(context.Background())
+			return nil
+		},
 		Subcommands: []*cli.Command{
 			{
 				Name: "list",
@@ -456,27 +1146,98 @@ func dmCommand() *cli.Command {
 				Name: "timeline",
 				Flags: []cli.Flag{
 					&cli.IntFlag{Name: "n", Value: 30, Usage: "number of items"},
-					&cli.StringFlag{Name: "u", Value: "", Usage: "DM user", Required: true},
+					&cli.StringSliceFlag{Name: "u", Usage: "DM user (repeat for a group conversation)", Required: true},
 					&cli.BoolFlag{Name: "json", Usage: "output JSON"},
 					&cli.BoolFlag{Name: "extra", Usage: "extra JSON"},
 				},
 				Usage:     "show DM timeline",
-				UsageText: "algia dm timeline -u <user>",
+				UsageText: "algia dm timeline -u <user> [-u <user>...]",
 				Action:    doDMTimeline,
 			},
+			{
+				Name: "stream",
+				Flags: []cli.Flag{
+					&cli.StringSliceFlag{Name: "u", Usage: "only this conversation (repeat for a group conversation)"},
+					&cli.BoolFlag{Name: "json", Usage: "output JSON"},
+				},
+				Usage:     "stream incoming DMs",
+				UsageText: "algia dm stream [-u <user>...]",
+				Action:    doDMStream,
+			},
 			{
 				Name: "post",
 				Flags: []cli.Flag{
-					&cli.StringFlag{Name: "u", Value: "", Usage: "DM user", Required: true},
+					&cli.StringSliceFlag{Name: "u", Usage: "DM user (repeat for a group conversation)", Required: true},
 					&cli.BoolFlag{Name: "stdin"},
 					&cli.StringFlag{Name: "sensitive"},
+					&cli.StringFlag{Name: "subject", Usage: "conversation subject (NIP-17)"},
+					&cli.StringFlag{Name: "reply", Usage: "reply to the DM with this id (note/nevent/hex)"},
 					&cli.BoolFlag{Name: "nip04"},
+					&cli.StringSliceFlag{Name: "image", Aliases: []string{"i"}, Usage: "file(s) to encrypt, upload and send (repeatable)"},
+					&cli.StringSliceFlag{Name: "server", Aliases: []string{"s"}, Usage: "media server override (default: configured file-servers)"},
 				},
 				Usage:     "post new DM note",
-				UsageText: "algia dm post -u <user> [note text]",
+				UsageText: "algia dm post -u <user> [-u <user>...] [-i file...] [note text]",
 				ArgsUsage: "[note text]",
 				Action:    doDMPost,
 			},
+			{
+				Name: "get-file",
+				Flags: []cli.Flag{
+					&cli.StringFlag{Name: "o", Usage: "output path (\"-\" for stdout; default: derived from the id and file type)"},
+				},
+				Usage:     "download and decrypt a DM file attachment (kind 15)",
+				UsageText: "algia dm get-file [-o path] <id>",
+				ArgsUsage: "<id>",
+				Action:    doDMGetFile,
+			},
+			{
+				Name: "export",
+				Flags: []cli.Flag{
+					&cli.StringSliceFlag{Name: "u", Usage: "only this conversation (repeat for a group conversation)"},
+					&cli.StringFlag{Name: "format", Value: "jsonl", Usage: "jsonl, mbox or markdown"},
+					&cli.StringFlag{Name: "o", Usage: "output directory", Required: true},
+					&cli.BoolFlag{Name: "raw", Usage: "also write the still-encrypted events to raw.jsonl for dm import"},
+				},
+				Usage:     "export decrypted conversations, one file per conversation",
+				UsageText: "algia dm export [-u <user>...] [--format jsonl|mbox|markdown] [--raw] -o <dir>",
+				Action:    doDMExport,
+			},
+			{
+				Name: "import",
+				Flags: []cli.Flag{
+					&cli.StringSliceFlag{Name: "relay", Usage: "relay to publish to (default: your DM relay list)"},
+				},
+				Usage:     "rebroadcast raw DMs written by dm export --raw",
+				UsageText: "algia dm import [--relay <url>...] <raw.jsonl>...",
+				ArgsUsage: "<raw.jsonl>...",
+				Action:    doDMImport,
+			},
+			{
+				Name:  "relays",
+				Usage: "manage your DM relay list (kind 10050)",
+				Action: func(cCtx *cli.Context) error {
+					return cli.ShowSubcommandHelp(cCtx)
+				},
+				Subcommands: []*cli.Command{
+					{
+						Name: "show",
+						Flags: []cli.Flag{
+							&cli.BoolFlag{Name: "json", Usage: "output JSON"},
+						},
+						Usage:     "show your DM relay list",
+						UsageText: "algia dm relays show",
+						Action:    doDMRelaysShow,
+					},
+					{
+						Name:      "set",
+						Usage:     "publish your DM relay list (default: the \"dm\" relays in config)",
+						UsageText: "algia dm relays set [relay...]",
+						ArgsUsage: "[relay...]",
+						Action:    doDMRelaysSet,
+					},
+				},
+			},
 		},
 	}
 }
@@ -0,0 +1,300 @@
+package main
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/nbd-wtf/go-nostr/nip19"
+)
+
+func TestBuildDMRumor(t *testing.T) {
+	ev, err := buildDMRumor("me", dmOpts{Recipients: []string{"bob", "me", "alice"}, Content: "hi all", Subject: "lunch"}, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ev.Kind != nostr.KindDirectMessage {
+		t.Errorf("kind=%d", ev.Kind)
+	}
+	ps := findAllTags(ev.Tags, "p")
+	if len(ps) != 2 || ps[0][1] != "bob" || ps[1][1] != "alice" {
+		t.Errorf("p tags: %v", ps)
+	}
+	if got := tagValue(ev.Tags, "subject"); got != "lunch" {
+		t.Errorf("subject=%q", got)
+	}
+
+	ev, err = buildDMRumor("me", dmOpts{Recipients: []string{"me"}, Content: "note to self", Sensitive: "spoiler"}, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ps := findAllTags(ev.Tags, "p"); len(ps) != 1 || ps[0][1] != "me" {
+		t.Errorf("self p tags: %v", ps)
+	}
+	if got := tagValue(ev.Tags, "content-warning"); got != "spoiler" {
+		t.Errorf("content-warning=%q", got)
+	}
+
+	ev, err = buildDMRumor("me", dmOpts{Recipients: []string{"bob"}, Content: "yes", ReplyID: testTargetID, ReplyRelay: "wss://relay.example"}, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e := findTag(ev.Tags, "e"); e == nil || e[1] != testTargetID || e[2] != "wss://relay.example" {
+		t.Errorf("e tag: %v", ev.Tags)
+	}
+	if findTag(ev.Tags, "subject") != nil {
+		t.Errorf("unexpected subject: %v", ev.Tags)
+	}
+
+	if _, err := buildDMRumor("me", dmOpts{Recipients: []string{"bob"}, Content: " "}, 100); err == nil {
+		t.Error("expected error for empty content")
+	}
+	if _, err := buildDMRumor("me", dmOpts{Content: "hi"}, 100); err == nil {
+		t.Error("expected error for no recipients")
+	}
+}
+
+func TestDMRoom(t *testing.T) {
+	tests := []struct {
+		ev   *nostr.Event
+		want string
+	}{
+		// Sent by us to two people.
+		{&nostr.Event{PubKey: "me", Tags: nostr.Tags{{"p", "bob"}, {"p", "alice"}}}, "alice,bob"},
+		// Received in the same room.
+		{&nostr.Event{PubKey: "bob", Tags: nostr.Tags{{"p", "me"}, {"p", "alice"}}}, "alice,bob"},
+		// One to one.
+		{&nostr.Event{PubKey: "bob", Tags: nostr.Tags{{"p", "me"}}}, "bob"},
+		// Note to self.
+		{&nostr.Event{PubKey: "me", Tags: nostr.Tags{{"p", "me"}}}, "me"},
+	}
+	for i, tt := range tests {
+		got := dmRoom(tt.ev, "me")
+		if s := strings.Join(got, ","); s != tt.want {
+			t.Errorf("%d: room=%q want %q", i, s, tt.want)
+		}
+	}
+	if got := strings.Join(roomOf([]string{"bob", "alice", "me"}, "me"), ","); got != "alice,bob" {
+		t.Errorf("roomOf=%q", got)
+	}
+}
+
+func TestPickDMRelays(t *testing.T) {
+	dmList := &nostr.Event{Kind: nostr.KindDMRelayList, Tags: nostr.Tags{{"relay", "wss://dm.example"}}}
+	relayList := &nostr.Event{Kind: nostr.KindRelayListMetadata, Tags: nostr.Tags{
+		{"r", "wss://both.example"},
+		{"r", "wss://inbox.example", "read"},
+		{"r", "wss://outbox.example", "write"},
+	}}
+	own := []string{"wss://own.example"}
+
+	relays, source := pickDMRelays(dmList, relayList, own)
+	if source != dmRelaySourceDM || len(relays) != 1 || relays[0] != "wss://dm.example" {
+		t.Errorf("dm list: %v %s", relays, source)
+	}
+	relays, source = pickDMRelays(nil, relayList, own)
+	if source != dmRelaySourceInbox || strings.Join(relays, ",") != "wss://both.example,wss://inbox.example" {
+		t.Errorf("inbox: %v %s", relays, source)
+	}
+	relays, source = pickDMRelays(&nostr.Event{Kind: nostr.KindDMRelayList}, nil, own)
+	if source != dmRelaySourceOwn || len(relays) != 1 || relays[0] != "wss://own.example" {
+		t.Errorf("own: %v %s", relays, source)
+	}
+}
+
+func TestBuildDMRelayListEvent(t *testing.T) {
+	ev, err := buildDMRelayListEvent(testPub, []string{"wss://relay.example/", "wss://relay.example", "wss://other.example"}, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ev.Kind != nostr.KindDMRelayList {
+		t.Errorf("kind=%d", ev.Kind)
+	}
+	if got := relayListRelays(ev); len(got) != 2 {
+		t.Errorf("relays=%v", got)
+	}
+	if _, err := buildDMRelayListEvent(testPub, nil, 100); err == nil {
+		t.Error("expected error for no relays")
+	}
+	if _, err := buildDMRelayListEvent(testPub, []string{"https://example.com"}, 100); err == nil {
+		t.Error("expected error for a non-websocket URL")
+	}
+}
+
+func TestDMRelayMismatch(t *testing.T) {
+	missing, extra := dmRelayMismatch(
+		[]string{"wss://a.example/", "wss://b.example"},
+		[]string{"wss://a.example", "wss://c.example"},
+	)
+	if strings.Join(missing, ",") != "wss://c.example" || strings.Join(extra, ",") != "wss://b.example" {
+		t.Errorf("missing=%v extra=%v", missing, extra)
+	}
+	missing, extra = dmRelayMismatch([]string{"wss://a.example"}, []string{"wss://a.example/"})
+	if len(missing) != 0 || len(extra) != 0 {
+		t.Errorf("normalized URLs should match: %v %v", missing, extra)
+	}
+}
+
+func TestCheckDMRelaysCache(t *testing.T) {
+	sk := nostr.GeneratePrivateKey()
+	pub, _ := nostr.GetPublicKey(sk)
+	nsec, _ := nip19.EncodePrivateKey(sk)
+	tr, url := newTestRelay(t, nil)
+	cfg := &Config{
+		PrivateKey: nsec,
+		Relays:     map[string]Relay{url: {Read: true, Write: true, DM: true}},
+		pool:       nostr.NewSimplePool(context.Background()),
+	}
+	list := func(at nostr.Timestamp, relays ...string) {
+		ev, err := buildDMRelayListEvent(pub, relays, at)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := ev.Sign(sk); err != nil {
+			t.Fatal(err)
+		}
+		tr.store(ev)
+	}
+
+	list(nostr.Now()-10, url)
+	cfg.checkDMRelays(context.Background())
+	c := cfg.DMRelayCheck
+	if c == nil || !slices.Equal(c.Items, []string{url}) || !cfg.listsChanged {
+		t.Fatalf("matching list not remembered: %+v", c)
+	}
+
+	// While fresh, the remembered match is not checked again.
+	list(nostr.Now(), "wss://elsewhere.example")
+	cfg.checkDMRelays(context.Background())
+	if cfg.DMRelayCheck != c {
+		t.Error("fresh check was repeated")
+	}
+
+	// A stale match is checked again and not renewed on a mismatch.
+	c.Updated = time.Now().Add(-2 * followListCacheTTL)
+	cfg.checkDMRelays(context.Background())
+	if cfg.DMRelayCheck != c || time.Since(c.Updated) < followListCacheTTL {
+		t.Errorf("stale check: %+v", cfg.DMRelayCheck)
+	}
+}
+
+func TestDMFileRoundTrip(t *testing.T) {
+	plain := []byte("\x89PNG not really a screenshot")
+	ct, f, err := encryptDMFile(plain, "image/png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(ct) == string(plain) || f.Size != len(ct) {
+		t.Errorf("ciphertext size=%d", f.Size)
+	}
+	got, err := decryptDMFile(ct, f)
+	if err != nil || string(got) != string(plain) {
+		t.Fatalf("got %q err=%v", got, err)
+	}
+
+	tampered := append([]byte{}, ct...)
+	tampered[0] ^= 1
+	if _, err := decryptDMFile(tampered, f); err == nil {
+		t.Error("expected x hash mismatch")
+	}
+	noHash := *f
+	noHash.Hash = ""
+	if _, err := decryptDMFile(tampered, &noHash); err == nil {
+		t.Error("expected GCM authentication failure")
+	}
+}
+
+func TestBuildDMFileRumor(t *testing.T) {
+	_, f, err := encryptDMFile([]byte("data"), "image/png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.URL = "https://files.example/" + f.Hash
+	ev, err := buildDMFileRumor("me", dmOpts{Recipients: []string{"bob"}, Content: "ignored", Subject: "pics"}, f, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ev.Kind != kindDMFile || ev.Content != f.URL {
+		t.Errorf("kind=%d content=%q", ev.Kind, ev.Content)
+	}
+	for name, want := range map[string]string{
+		"p":                    "bob",
+		"subject":              "pics",
+		"file-type":            "image/png",
+		"encryption-algorithm": "aes-gcm",
+		"decryption-key":       f.Key,
+		"decryption-nonce":     f.Nonce,
+		"x":                    f.Hash,
+		"ox":                   f.OrigHash,
+	} {
+		if got := tagValue(ev.Tags, name); got != want {
+			t.Errorf("%s=%q want %q", name, got, want)
+		}
+	}
+
+	parsed, err := parseDMFile(ev)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *parsed != *f {
+		t.Errorf("parsed=%+v want %+v", parsed, f)
+	}
+
+	if _, err := buildDMFileRumor("me", dmOpts{Recipients: []string{"bob"}}, &dmFile{}, 100); err == nil {
+		t.Error("expected error for a missing URL")
+	}
+	if _, err := parseDMFile(&nostr.Event{Kind: nostr.KindDirectMessage}); err == nil {
+		t.Error("expected error for a kind 14 message")
+	}
+}
+
+func TestSendDMRumorPartialDelivery(t *testing.T) {
+	sk := nostr.GeneratePrivateKey()
+	me, _ := nostr.GetPublicKey(sk)
+	nsec, _ := nip19.EncodePrivateKey(sk)
+	aliceSk, bobSk := nostr.GeneratePrivateKey(), nostr.GeneratePrivateKey()
+	alice, _ := nostr.GetPublicKey(aliceSk)
+	bob, _ := nostr.GetPublicKey(bobSk)
+
+	tr, url := newTestRelay(t, nil)
+	// Alice only reads DMs on a relay that is down; Bob has no DM relay list,
+	// so his copy goes to our DM relay.
+	dmList := &nostr.Event{Kind: nostr.KindDMRelayList, CreatedAt: nostr.Now(), Tags: nostr.Tags{{"relay", "ws://127.0.0.1:1"}}}
+	if err := dmList.Sign(aliceSk); err != nil {
+		t.Fatal(err)
+	}
+	tr.store(dmList)
+
+	cfg := &Config{
+		PrivateKey: nsec,
+		Relays:     map[string]Relay{url: {Read: true, Write: true, DM: true}},
+		pool:       nostr.NewSimplePool(context.Background()),
+		profiles: map[string]Profile{
+			alice: {Name: "alice", FetchedAt: time.Now()},
+			bob:   {Name: "bob", FetchedAt: time.Now()},
+		},
+	}
+	rumor, err := buildDMRumor(me, dmOpts{Recipients: []string{alice, bob}, Content: "hi"}, nostr.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = cfg.sendDMRumor(context.Background(), *rumor, sk)
+	if err == nil || !strings.Contains(err.Error(), "cannot send DM to alice") || strings.Contains(err.Error(), "bob") {
+		t.Errorf("err=%v", err)
+	}
+
+	var wrappedFor []string
+	tr.mu.Lock()
+	for _, ev := range tr.events {
+		if ev.Kind == nostr.KindGiftWrap {
+			wrappedFor = append(wrappedFor, tagValue(ev.Tags, "p"))
+		}
+	}
+	tr.mu.Unlock()
+	if len(wrappedFor) != 2 || !slices.Contains(wrappedFor, bob) || !slices.Contains(wrappedFor, me) {
+		t.Errorf("gift wraps for %v, want bob and our own copy", wrappedFor)
+	}
+}
@@ -0,0 +1,405 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/urfave/cli/v2"
+
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/nbd-wtf/go-nostr/nip19"
+)
+
+// dmExportPage is the page size used when walking a relay's DM history.
+const dmExportPage = 500
+
+// pageEvents walks a history backwards with until, calling fetch until a
+// page yields nothing new. until is inclusive so events sharing the boundary
+// second are not lost; ids already seen are skipped.
+func pageEvents(fetch func(until *nostr.Timestamp) ([]*nostr.Event, error)) ([]*nostr.Event, error) {
+	seen := make(map[string]bool)
+	var all []*nostr.Event
+	var until *nostr.Timestamp
+	for {
+		evs, err := fetch(until)
+		if err != nil {
+			return nil, err
+		}
+		var oldest nostr.Timestamp
+		added := 0
+		for _, ev := range evs {
+			if seen[ev.ID] {
+				continue
+			}
+			seen[ev.ID] = true
+			all = append(all, ev)
+			added++
+			if oldest == 0 || ev.CreatedAt < oldest {
+				oldest = ev.CreatedAt
+			}
+		}
+		if added == 0 {
+			return all, nil
+		}
+		until = &oldest
+	}
+}
+
+// dmArchiveEntry pairs a DM as stored on relays (a kind 4 event or a kind
+// 1059 wrap) with the message it carries.
+type dmArchiveEntry struct {
+	Raw *nostr.Event
+	Msg *nostr.Event
+}
+
+// fetchDMArchive fetches every kind 4 and kind 1059 event we can read. Unlike
+// fetchDMs it pages through each relay separately, since one relay's oldest
+// event says nothing about how far back another one's page reached.
+func fetchDMArchive(ctx context.Context, cfg *Config, sk, pub string) ([]dmArchiveEntry, error) {
+	filters := []nostr.Filter{
+		{Kinds: []int{nostr.KindEncryptedDirectMessage}, Authors: []string{pub}},
+		{Kinds: []int{nostr.KindEncryptedDirectMessage}, Tags: nostr.TagMap{"p": []string{pub}}},
+		{Kinds: []int{nostr.KindGiftWrap}, Tags: nostr.TagMap{"p": []string{pub}}},
+	}
+	relays := dmReadRelays(cfg)
+	if len(relays) == 0 {
+		return nil, errors.New("no read relays available")
+	}
+
+	raw := make(map[string]*nostr.Event)
+	for _, relay := range relays {
+		for _, filter := range filters {
+			evs, err := pageEvents(func(until *nostr.Timestamp) ([]*nostr.Event, error) {
+				f := filter
+				f.Until = until
+				f.Limit = dmExportPage
+				return cfg.queryRelays(ctx, []string{relay}, nostr.Filters{f})
+			})
+			if err != nil {
+				fmt.Fprintln(os.Stderr, relay, err)
+				continue
+			}
+			for _, ev := range evs {
+				raw[ev.ID] = ev
+			}
+		}
+		if cfg.verbose {
+			fmt.Fprintf(os.Stderr, "%s: %d events so far\n", relay, len(raw))
+		}
+	}
+
+	var entries []dmArchiveEntry
+	for _, ev := range raw {
+		var msg *nostr.Event
+		switch ev.Kind {
+		case nostr.KindEncryptedDirectMessage:
+			dec := *ev
+			if err := cfg.Decode(&dec, sk, pub); err != nil {
+				continue
+			}
+			msg = &dec
+		case nostr.KindGiftWrap:
+			rumor, err := unwrapGift(ev, sk)
+			if err != nil {
+				if cfg.verbose {
+					fmt.Fprintf(os.Stderr, "GiftUnwrap failed for event %s: %v\n", ev.ID, err)
+				}
+				continue
+			}
+			if rumor.Kind != nostr.KindDirectMessage && rumor.Kind != kindDMFile {
+				continue
+			}
+			msg = rumor
+		}
+		entries = append(entries, dmArchiveEntry{Raw: ev, Msg: msg})
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Msg.CreatedAt < entries[j].Msg.CreatedAt
+	})
+	return entries, nil
+}
+
+// dmRoomFile names the export file of a room. Group rooms get a stable hash
+// since a list of npubs soon exceeds file name limits.
+func dmRoomFile(room []string) string {
+	if len(room) == 1 {
+		if npub, err := nip19.EncodePublicKey(room[0]); err == nil {
+			return npub
+		}
+	}
+	sum := sha256.Sum256([]byte(strings.Join(room, ",")))
+	return "group-" + hex.EncodeToString(sum[:8])
+}
+
+// dmExportMessage is one line of a jsonl export.
+type dmExportMessage struct {
+	ID           string     `json:"id"`
+	CreatedAt    time.Time  `json:"created_at"`
+	PubKey       string     `json:"pubkey"`
+	Sender       string     `json:"sender"`
+	Participants []string   `json:"participants"`
+	Kind         int        `json:"kind"`
+	Subject      string     `json:"subject,omitempty"`
+	Content      string     `json:"content"`
+	Tags         nostr.Tags `json:"tags,omitempty"`
+}
+
+// dmExportBody is the text shown for a message; file messages name their
+// type alongside the URL of the encrypted upload.
+func dmExportBody(ev *nostr.Event) string {
+	if ev.Kind == kindDMFile {
+		return fmt.Sprintf("[file %s] %s", tagValue(ev.Tags, "file-type"), ev.Content)
+	}
+	return ev.Content
+}
+
+// writeDMExport writes the messages of one room in format. name maps a
+// pubkey to the name shown for it.
+func writeDMExport(w io.Writer, format string, room []string, msgs []*nostr.Event, name func(string) string) error {
+	var names []string
+	for _, p := range room {
+		names = append(names, name(p))
+	}
+
+	switch format {
+	case "jsonl":
+		enc := json.NewEncoder(w)
+		for _, ev := range msgs {
+			if err := enc.Encode(dmExportMessage{
+				ID:           ev.ID,
+				CreatedAt:    ev.CreatedAt.Time(),
+				PubKey:       ev.PubKey,
+				Sender:       name(ev.PubKey),
+				Participants: room,
+				Kind:         ev.Kind,
+				Subject:      tagValue(ev.Tags, "subject"),
+				Content:      ev.Content,
+				Tags:         ev.Tags,
+			}); err != nil {
+				return err
+			}
+		}
+	case "mbox":
+		subject := "Direct message"
+		for _, ev := range msgs {
+			if s := tagValue(ev.Tags, "subject"); s != "" {
+				subject = s
+			}
+			npub, _ := nip19.EncodePublicKey(ev.PubKey)
+			t := ev.CreatedAt.Time()
+			fmt.Fprintf(w, "From %s %s\n", npub, t.UTC().Format(time.ANSIC))
+			fmt.Fprintf(w, "From: %s <%s>\n", name(ev.PubKey), npub)
+			fmt.Fprintf(w, "Date: %s\n", t.Format(time.RFC1123Z))
+			fmt.Fprintf(w, "Subject: %s\n", subject)
+			fmt.Fprintf(w, "Message-ID: <%s@nostr>\n", ev.ID)
+			fmt.Fprintf(w, "Content-Type: text/plain; charset=utf-8\n\n")
+			for _, line := range strings.Split(dmExportBody(ev), "\n") {
+				// mboxrd quoting: a body line starting with (>*)From gains a ">".
+				if strings.HasPrefix(strings.TrimLeft(line, ">"), "From ") {
+					line = ">" + line
+				}
+				fmt.Fprintln(w, line)
+			}
+			fmt.Fprintln(w)
+		}
+	case "markdown":
+		fmt.Fprintf(w, "# Direct messages with %s\n", strings.Join(names, ", "))
+		for _, ev := range msgs {
+			if s := tagValue(ev.Tags, "subject"); s != "" {
+				fmt.Fprintf(w, "\n## %s\n", s)
+			}
+			fmt.Fprintf(w, "\n**%s** %s\n\n%s\n", name(ev.PubKey), ev.CreatedAt.Time().Format("2006-01-02 15:04:05 -0700"), dmExportBody(ev))
+		}
+	default:
+		return fmt.Errorf("unknown format '%s'", format)
+	}
+	return nil
+}
+
+var dmExportExt = map[string]string{"jsonl": ".jsonl", "mbox": ".mbox", "markdown": ".md"}
+
+func doDMExport(cCtx *cli.Context) error {
+	format := cCtx.String("format")
+	ext, ok := dmExportExt[format]
+	if !ok {
+		return fmt.Errorf("unknown format '%s'", format)
+	}
+	dir := cCtx.String("o")
+
+	cfg := cCtx.App.Metadata["config"].(*Config)
+
+	sk, pub, err := getSkAndPub(cfg)
+	if err != nil {
+		return err
+	}
+	want := ""
+	if us := cCtx.StringSlice("u"); len(us) > 0 {
+		recipients, err := dmRecipients(us, pub)
+		if err != nil {
+			return err
+		}
+		want = strings.Join(roomOf(recipients, pub), ",")
+	}
+
+	entries, err := fetchDMArchive(context.Background(), cfg, sk, pub)
+	if err != nil {
+		return err
+	}
+
+	rooms := make(map[string][]*nostr.Event)
+	var raw []*nostr.Event
+	for _, e := range entries {
+		key := strings.Join(dmRoom(e.Msg, pub), ",")
+		if want != "" && key != want {
+			continue
+		}
+		rooms[key] = append(rooms[key], e.Msg)
+		raw = append(raw, e.Raw)
+	}
+	if len(raw) == 0 {
+		return errors.New("no DMs found")
+	}
+
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+	names := make(map[string]string)
+	name := func(p string) string {
+		if n, ok := names[p]; ok {
+			return n
+		}
+		names[p] = cfg.dmName(p)
+		return names[p]
+	}
+	for key, msgs := range rooms {
+		room := strings.Split(key, ",")
+		f, err := os.OpenFile(filepath.Join(dir, dmRoomFile(room)+ext), os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+		if err != nil {
+			return err
+		}
+		w := bufio.NewWriter(f)
+		err = writeDMExport(w, format, room, msgs, name)
+		if err == nil {
+			err = w.Flush()
+		}
+		f.Close()
+		if err != nil {
+			return err
+		}
+	}
+
+	if cCtx.Bool("raw") {
+		f, err := os.OpenFile(filepath.Join(dir, "raw.jsonl"), os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+		if err != nil {
+			return err
+		}
+		w := bufio.NewWriter(f)
+		enc := json.NewEncoder(w)
+		for _, ev := range raw {
+			if err = enc.Encode(ev); err != nil {
+				break
+			}
+		}
+		if err == nil {
+			err = w.Flush()
+		}
+		f.Close()
+		if err != nil {
+			return err
+		}
+	}
+	fmt.Printf("%d messages in %d conversations written to %s\n", len(raw), len(rooms), dir)
+	return nil
+}
+
+// readRawDMs reads events written by dm export --raw, keeping the kind 4
+// messages and 1059 wraps whose signatures check out.
+func readRawDMs(r io.Reader) ([]*nostr.Event, error) {
+	var evs []*nostr.Event
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		if strings.TrimSpace(scanner.Text()) == "" {
+			continue
+		}
+		var ev nostr.Event
+		if err := json.Unmarshal(scanner.Bytes(), &ev); err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		if ev.Kind != nostr.KindEncryptedDirectMessage && ev.Kind != nostr.KindGiftWrap {
+			return nil, fmt.Errorf("line %d: kind %d is not a raw DM", line, ev.Kind)
+		}
+		if ok, err := ev.CheckSignature(); !ok {
+			return nil, fmt.Errorf("line %d: invalid signature: %v", line, err)
+		}
+		evs = append(evs, &ev)
+	}
+	return evs, scanner.Err()
+}
+
+func doDMImport(cCtx *cli.Context) error {
+	if cCtx.Args().Len() == 0 {
+		return cli.ShowSubcommandHelp(cCtx)
+	}
+	cfg := cCtx.App.Metadata["config"].(*Config)
+
+	var evs []*nostr.Event
+	for _, path := range cCtx.Args().Slice() {
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		got, err := readRawDMs(f)
+		f.Close()
+		if err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+		evs = append(evs, got...)
+	}
+
+	ctx := context.Background()
+	relays := cCtx.StringSlice("relay")
+	if len(relays) == 0 {
+		if ev, err := fetchOwnDMRelayList(ctx, cfg); err == nil && ev != nil {
+			relays = relayListRelays(ev)
+		}
+	}
+	if len(relays) == 0 {
+		relays = ownDMRelays(cfg)
+	}
+	if len(relays) == 0 {
+		return errors.New("no DM relays to import to")
+	}
+
+	var imported int
+	for _, ev := range evs {
+		var success int
+		for res := range cfg.pool.PublishMany(ctx, relays, *ev) {
+			if res.Error != nil {
+				if cfg.verbose {
+					fmt.Fprintln(os.Stderr, res.RelayURL, ev.ID, res.Error)
+				}
+			} else {
+				success++
+			}
+		}
+		if success > 0 {
+			imported++
+		} else {
+			fmt.Fprintf(os.Stderr, "%s: not accepted by any relay\n", ev.ID)
+		}
+	}
+	fmt.Printf("%d of %d events published to %s\n", imported, len(evs), strings.Join(relays, ", "))
+	return nil
+}
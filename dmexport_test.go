@@ -0,0 +1,126 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+func TestPageEvents(t *testing.T) {
+	// A relay holding seven events, two sharing the second at a page boundary,
+	// that returns at most three per query.
+	var history []*nostr.Event
+	for i, ts := range []nostr.Timestamp{70, 60, 50, 40, 40, 20, 10} {
+		history = append(history, &nostr.Event{ID: string(rune('a' + i)), CreatedAt: ts})
+	}
+	calls := 0
+	got, err := pageEvents(func(until *nostr.Timestamp) ([]*nostr.Event, error) {
+		calls++
+		var page []*nostr.Event
+		for _, ev := range history {
+			if until != nil && ev.CreatedAt > *until {
+				continue
+			}
+			if len(page) == 3 {
+				break
+			}
+			page = append(page, ev)
+		}
+		return page, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != len(history) {
+		t.Errorf("got %d events in %d calls, want %d", len(got), calls, len(history))
+	}
+}
+
+func TestDMRoomFile(t *testing.T) {
+	if got := dmRoomFile([]string{testPub}); !strings.HasPrefix(got, "npub1") {
+		t.Errorf("single room file=%q", got)
+	}
+	a := dmRoomFile([]string{"alice", "bob"})
+	if !strings.HasPrefix(a, "group-") || a != dmRoomFile([]string{"alice", "bob"}) || a == dmRoomFile([]string{"alice", "carol"}) {
+		t.Errorf("group room file=%q", a)
+	}
+}
+
+func TestWriteDMExport(t *testing.T) {
+	msgs := []*nostr.Event{
+		{ID: "m1", PubKey: "bob", CreatedAt: 100, Kind: nostr.KindDirectMessage, Content: "hi\nFrom here on", Tags: nostr.Tags{{"p", "me"}, {"subject", "plans"}}},
+		{ID: "m2", PubKey: "me", CreatedAt: 200, Kind: kindDMFile, Content: "https://files.example/x", Tags: nostr.Tags{{"p", "bob"}, {"file-type", "image/png"}}},
+	}
+	name := func(p string) string { return strings.ToUpper(p) }
+
+	var buf bytes.Buffer
+	if err := writeDMExport(&buf, "jsonl", []string{"bob"}, msgs, name); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("jsonl lines=%d", len(lines))
+	}
+	var m dmExportMessage
+	if err := json.Unmarshal([]byte(lines[0]), &m); err != nil {
+		t.Fatal(err)
+	}
+	if m.Sender != "BOB" || m.Subject != "plans" || m.CreatedAt.Unix() != 100 {
+		t.Errorf("jsonl message=%+v", m)
+	}
+
+	buf.Reset()
+	if err := writeDMExport(&buf, "mbox", []string{"bob"}, msgs, name); err != nil {
+		t.Fatal(err)
+	}
+	mbox := buf.String()
+	if strings.Count(mbox, "\nFrom: ") != 2 || !strings.Contains(mbox, "\n>From here on\n") || !strings.Contains(mbox, "Subject: plans") {
+		t.Errorf("mbox=%s", mbox)
+	}
+	if !strings.Contains(mbox, "[file image/png] https://files.example/x") {
+		t.Errorf("mbox file message=%s", mbox)
+	}
+
+	buf.Reset()
+	if err := writeDMExport(&buf, "markdown", []string{"bob"}, msgs, name); err != nil {
+		t.Fatal(err)
+	}
+	if md := buf.String(); !strings.HasPrefix(md, "# Direct messages with BOB\n") || !strings.Contains(md, "**ME**") {
+		t.Errorf("markdown=%s", md)
+	}
+
+	if err := writeDMExport(&buf, "csv", nil, msgs, name); err == nil {
+		t.Error("expected error for an unknown format")
+	}
+}
+
+func TestReadRawDMs(t *testing.T) {
+	sk := nostr.GeneratePrivateKey()
+	pub, _ := nostr.GetPublicKey(sk)
+	wrap := nostr.Event{PubKey: pub, Kind: nostr.KindGiftWrap, CreatedAt: 100, Tags: nostr.Tags{{"p", pub}}, Content: "x"}
+	if err := wrap.Sign(sk); err != nil {
+		t.Fatal(err)
+	}
+	b, _ := json.Marshal(wrap)
+
+	evs, err := readRawDMs(strings.NewReader(string(b) + "\n\n"))
+	if err != nil || len(evs) != 1 || evs[0].ID != wrap.ID {
+		t.Fatalf("evs=%v err=%v", evs, err)
+	}
+
+	tampered := wrap
+	tampered.Content = "y"
+	b, _ = json.Marshal(tampered)
+	if _, err := readRawDMs(bytes.NewReader(b)); err == nil {
+		t.Error("expected error for a bad signature")
+	}
+	note := nostr.Event{PubKey: pub, Kind: nostr.KindTextNote, CreatedAt: 100, Content: "x"}
+	note.Sign(sk)
+	b, _ = json.Marshal(note)
+	if _, err := readRawDMs(bytes.NewReader(b)); err == nil {
+		t.Error("expected error for a non-DM kind")
+	}
+}
@@ -0,0 +1,635 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"sort"
+	"strings"
+
+	"github.com/fatih/color"
+	"github.com/urfave/cli/v2"
+
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/nbd-wtf/go-nostr/nip19"
+	"github.com/nbd-wtf/go-nostr/nip44"
+	"github.com/nbd-wtf/go-nostr/sdk"
+)
+
+// kindDraft is the NIP-37 draft wrap kind.
+const kindDraft = 31234
+
+// encryptToSelf NIP-44 encrypts plaintext to our own pubkey (drafts, private
+// list items).
+func encryptToSelf(sk, pub, plaintext string) (string, error) {
+	ck, err := nip44.GenerateConversationKey(pub, sk)
+	if err != nil {
+		return "", err
+	}
+	return nip44.Encrypt(plaintext, ck)
+}
+
+// decryptFromSelf reverses encryptToSelf.
+func decryptFromSelf(sk, pub, ciphertext string) (string, error) {
+	ck, err := nip44.GenerateConversationKey(pub, sk)
+	if err != nil {
+		return "", err
+	}
+	return nip44.Decrypt(ciphertext, ck)
+}
+
+// draft is a decrypted NIP-37 draft. Inner is the unsigned event template:
+// its content is the text as typed and its tags carry the builder parameters
+// (h/e for groups and channels, d/title/summary for articles, p for mentions,
+// content-warning, g, emoji, imeta and any --tag values).
+type draft struct {
+	Name      string
+	UpdatedAt nostr.Timestamp
+	Inner     nostr.Event
+}
+
+// draftPassthroughSkip lists inner tags consumed by the builders rather than
+// copied onto the published event as-is.
+var draftPassthroughSkip = map[string]bool{
+	"e": true, "h": true, "p": true, "d": true, "title": true, "summary": true,
+	"content-warning": true, "g": true, "emoji": true,
+}
+
+// buildDraftEvent constructs an unsigned kind 31234 event wrapping inner,
+// NIP-44 encrypted to ourselves. An empty inner content produces a blanked
+// draft, which is how NIP-37 marks a draft as deleted.
+func buildDraftEvent(sk, pub, name string, inner *nostr.Event, createdAt nostr.Timestamp) (*nostr.Event, error) {
+	if name == "" {
+		return nil, errors.New("draft name is empty")
+	}
+	ev := &nostr.Event{
+		PubKey:    pub,
+		CreatedAt: createdAt,
+		Kind:      kindDraft,
+		Tags:      nostr.Tags{nostr.Tag{"d", name}},
+	}
+	if inner == nil {
+		return ev, nil
+	}
+	ev.Tags = append(ev.Tags, nostr.Tag{"k", fmt.Sprint(inner.Kind)})
+	b, err := json.Marshal(inner)
+	if err != nil {
+		return nil, err
+	}
+	ev.Content, err = encryptToSelf(sk, pub, string(b))
+	if err != nil {
+		return nil, err
+	}
+	return ev, nil
+}
+
+// parseDraftEvent decrypts a kind 31234 event. It returns nil for a blanked
+// (deleted) draft.
+func parseDraftEvent(sk, pub string, ev *nostr.Event) (*draft, error) {
+	if ev.Content == "" {
+		return nil, nil
+	}
+	plain, err := decryptFromSelf(sk, pub, ev.Content)
+	if err != nil {
+		return nil, err
+	}
+	d := &draft{UpdatedAt: ev.CreatedAt}
+	if err := json.Unmarshal([]byte(plain), &d.Inner); err != nil {
+		return nil, err
+	}
+	if tag := ev.Tags.GetFirst([]string{"d", ""}); tag != nil {
+		d.Name = (*tag)[1]
+	}
+	return d, nil
+}
+
+// buildFromDraft turns a draft into the unsigned event it stands for, going
+// through the same builder as the matching post command.
+func buildFromDraft(cfg *Config, pub string, d *draft, createdAt nostr.Timestamp) (*nostr.Event, error) {
+	inner := d.Inner
+	cfgEmojis := cfg.emojiMap(context.Background())
+	var mentions, emojis []string
+	var sensitive, geohash, channelID, replyID, groupID string
+	for _, tag := range inner.Tags {
+		if len(tag) < 2 {
+			continue
+		}
+		switch tag[0] {
+		case "p":
+			mentions = append(mentions, tag[1])
+		case "emoji":
+			if len(tag) >= 3 {
+				emojis = append(emojis, tag[1]+"="+tag[2])
+			}
+		case "content-warning":
+			sensitive = tag[1]
+		case "g":
+			geohash = tag[1]
+		case "h":
+			groupID = tag[1]
+		case "e":
+			if len(tag) >= 4 && tag[3] == "root" {
+				channelID = tag[1]
+			} else {
+				replyID = tag[1]
+			}
+		}
+	}
+
+	var ev *nostr.Event
+	var err error
+	switch inner.Kind {
+	case nostr.KindTextNote, nostr.KindArticle:
+		arg := &postArg{
+			cfg:       cfg,
+			content:   inner.Content,
+			sensitive: sensitive,
+			geohash:   geohash,
+			emoji:     emojis,
+			emojis:    cfgEmojis,
+		}
+		if inner.Kind == nostr.KindArticle {
+			arg.articleName = tagValue(inner.Tags, "d")
+			arg.articleTitle = tagValue(inner.Tags, "title")
+			arg.articleSummary = tagValue(inner.Tags, "summary")
+		}
+		ev, err = buildPostEvent(arg, pub, mentions, createdAt)
+	case nostr.KindChannelMessage:
+		ev, err = buildChannelPostEvent(pub, channelPostOpts{
+			Content:        inner.Content,
+			ChannelID:      channelID,
+			ReplyID:        replyID,
+			RelayHint:      firstWriteRelay(cfg),
+			MentionPubkeys: mentions,
+			Sensitive:      sensitive,
+			Geohash:        geohash,
+			Emojis:         emojis,
+		}, cfgEmojis, createdAt)
+	case nostr.KindSimpleGroupChatMessage:
+		ev, err = buildGroupPostEvent(pub, groupID, inner.Content, replyID, cfgEmojis, createdAt)
+	default:
+		return nil, fmt.Errorf("cannot publish a draft of kind %d", inner.Kind)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	for _, tag := range inner.Tags {
+		if len(tag) == 0 || draftPassthroughSkip[tag[0]] {
+			continue
+		}
+		if tag[0] == "client" {
+			tags := make(nostr.Tags, 0, len(ev.Tags))
+			for _, existing := range ev.Tags {
+				if len(existing) > 0 && existing[0] == "client" {
+					continue
+				}
+				tags = append(tags, existing)
+			}
+			ev.Tags = append(tags, tag)
+		} else {
+			ev.Tags = ev.Tags.AppendUnique(tag)
+		}
+	}
+	return ev, nil
+}
+
+// fetchDrafts returns our drafts, newest version per name, blanked ones
+// dropped, most recently updated first.
+func fetchDrafts(ctx context.Context, cfg *Config) ([]*draft, error) {
+	sk, pub, err := getSkAndPub(cfg)
+	if err != nil {
+		return nil, err
+	}
+	evs, err := cfg.QueryEvents(ctx, nostr.Filters{{
+		Kinds:   []int{kindDraft},
+		Authors: []string{pub},
+	}})
+	if err != nil {
+		return nil, err
+	}
+	latest := map[string]*nostr.Event{}
+	for _, ev := range evs {
+		name := tagValue(ev.Tags, "d")
+		if cur, ok := latest[name]; !ok || ev.CreatedAt > cur.CreatedAt {
+			latest[name] = ev
+		}
+	}
+	drafts := []*draft{}
+	for _, ev := range latest {
+		d, err := parseDraftEvent(sk, pub, ev)
+		if err != nil {
+			if cfg.verbose {
+				fmt.Fprintln(os.Stderr, "cannot decrypt draft", tagValue(ev.Tags, "d"), err)
+			}
+			continue
+		}
+		if d != nil {
+			drafts = append(drafts, d)
+		}
+	}
+	sort.Slice(drafts, func(i, j int) bool {
+		return drafts[i].UpdatedAt > drafts[j].UpdatedAt
+	})
+	return drafts, nil
+}
+
+func findDraft(ctx context.Context, cfg *Config, name string) (*draft, error) {
+	drafts, err := fetchDrafts(ctx, cfg)
+	if err != nil {
+		return nil, err
+	}
+	for _, d := range drafts {
+		if d.Name == name {
+			return d, nil
+		}
+	}
+	return nil, fmt.Errorf("no draft named %q", name)
+}
+
+// saveDraft wraps inner as a draft and publishes it to the write relays.
+func saveDraft(ctx context.Context, cfg *Config, name string, inner *nostr.Event) error {
+	sk, pub, err := getSkAndPub(cfg)
+	if err != nil {
+		return err
+	}
+	ev, err := buildDraftEvent(sk, pub, name, inner, nostr.Now())
+	if err != nil {
+		return err
+	}
+	// Drafts are only readable by our own key, so they are signed with it
+	// directly rather than under a NIP-26 delegation.
+	if err := ev.Sign(sk); err != nil {
+		return err
+	}
+	if cfg.publishEvent(ctx, ev) == 0 {
+		return errors.New("cannot save draft")
+	}
+	return nil
+}
+
+// randomID returns n random bytes as hex, for draft names and poll option ids.
+func randomID(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// editText opens $EDITOR (vi by default) on text and returns the result.
+func editText(text string) (string, error) {
+	f, err := os.CreateTemp("", "algia-draft-*.txt")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.WriteString(text); err != nil {
+		f.Close()
+		return "", err
+	}
+	f.Close()
+
+	editor := os.Getenv("EDITOR")
+	if editor == "" {
+		editor = "vi"
+	}
+	cmd := exec.Command("sh", "-c", editor+` "$0"`, f.Name())
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return "", err
+	}
+	b, err := os.ReadFile(f.Name())
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(string(b), "\n"), nil
+}
+
+func readDraftContent(cCtx *cli.Context) (string, error) {
+	if cCtx.Bool("stdin") {
+		b, err := io.ReadAll(os.Stdin)
+		if err != nil {
+			return "", err
+		}
+		return string(b), nil
+	}
+	return strings.Join(cCtx.Args().Slice(), "\n"), nil
+}
+
+func doDraftSave(cCtx *cli.Context) error {
+	images := cCtx.StringSlice("image")
+	if !cCtx.Bool("stdin") && cCtx.Args().Len() == 0 && len(images) == 0 {
+		return cli.ShowSubcommandHelp(cCtx)
+	}
+	cfg := cCtx.App.Metadata["config"].(*Config)
+	ctx := context.Background()
+
+	content, err := readDraftContent(cCtx)
+	if err != nil {
+		return err
+	}
+	bds, err := uploadImages(cfg, images, cCtx.StringSlice("server"), cCtx.String("group") != "")
+	if err != nil {
+		return err
+	}
+	content = appendImageURLs(content, bds)
+
+	_, pub, err := getSkAndPub(cfg)
+	if err != nil {
+		return err
+	}
+	inner := &nostr.Event{PubKey: pub, Kind: nostr.KindTextNote, Content: content, Tags: nostr.Tags{}}
+
+	var replyID string
+	if r := cCtx.String("reply"); r != "" {
+		evp := sdk.InputToEventPointer(r)
+		if evp == nil {
+			return fmt.Errorf("failed to parse reply event id from '%s'", r)
+		}
+		replyID = evp.ID
+	}
+
+	switch {
+	case cCtx.String("channel") != "":
+		channelID, err := resolveChannelID(cCtx.String("channel"))
+		if err != nil {
+			return err
+		}
+		inner.Kind = nostr.KindChannelMessage
+		inner.Tags = append(inner.Tags, nostr.Tag{"e", channelID, "", "root"})
+	case cCtx.String("group") != "":
+		groupID, err := resolveGroupRef(cCtx, cCtx.String("group"))
+		if err != nil {
+			return err
+		}
+		inner.Kind = nostr.KindSimpleGroupChatMessage
+		inner.Tags = append(inner.Tags, nostr.Tag{"h", groupID})
+	case cCtx.String("article-name") != "":
+		if cCtx.String("article-title") == "" {
+			return errors.New("article-title is required when article-name is set")
+		}
+		inner.Kind = nostr.KindArticle
+		inner.Tags = append(inner.Tags,
+			nostr.Tag{"d", cCtx.String("article-name")},
+			nostr.Tag{"title", cCtx.String("article-title")},
+			nostr.Tag{"summary", cCtx.String("article-summary")})
+	}
+	if replyID != "" {
+		if inner.Kind == nostr.KindTextNote || inner.Kind == nostr.KindArticle {
+			return errors.New("--reply needs --channel or --group (use reply for notes)")
+		}
+		inner.Tags = append(inner.Tags, nostr.Tag{"e", replyID, "", "reply"})
+	}
+
+	mentions, err := resolveMentions(ctx, cCtx.StringSlice("u"))
+	if err != nil {
+		return err
+	}
+	for _, p := range mentions {
+		inner.Tags = append(inner.Tags, nostr.Tag{"p", p})
+	}
+	for _, u := range cCtx.StringSlice("emoji") {
+		name, icon, found := strings.Cut(u, "=")
+		if !found {
+			return usageError
+		}
+		inner.Tags = append(inner.Tags, nostr.Tag{"emoji", name, icon})
+	}
+	if s := cCtx.String("sensitive"); s != "" {
+		inner.Tags = append(inner.Tags, nostr.Tag{"content-warning", s})
+	}
+	if g := cCtx.String("geohash"); g != "" {
+		inner.Tags = append(inner.Tags, nostr.Tag{"g", g})
+	}
+	for _, t := range cCtx.StringSlice("tag") {
+		name, value, found := strings.Cut(t, "=")
+		tag := nostr.Tag{name}
+		if found {
+			tag = append(tag, strings.Split(value, ";")...)
+		}
+		inner.Tags = append(inner.Tags, tag)
+	}
+	addImetaTags(inner, bds)
+
+	name := cCtx.String("name")
+	if name == "" {
+		name = randomID(4)
+	}
+	if err := saveDraft(ctx, cfg, name, inner); err != nil {
+		return err
+	}
+	fmt.Println(name)
+	return nil
+}
+
+func doDraftList(cCtx *cli.Context) error {
+	cfg := cCtx.App.Metadata["config"].(*Config)
+	drafts, err := fetchDrafts(context.Background(), cfg)
+	if err != nil {
+		return err
+	}
+
+	if cCtx.Bool("json") {
+		for _, d := range drafts {
+			json.NewEncoder(os.Stdout).Encode(struct {
+				Name      string          `json:"name"`
+				UpdatedAt nostr.Timestamp `json:"updated_at"`
+				Event     nostr.Event     `json:"event"`
+			}{d.Name, d.UpdatedAt, d.Inner})
+		}
+		return nil
+	}
+
+	for _, d := range drafts {
+		color.Set(color.FgHiBlue)
+		fmt.Print(d.Name)
+		color.Set(color.Reset)
+		fmt.Printf(" %s kind:%d", d.UpdatedAt.Time().Format("2006-01-02T15:04:05"), d.Inner.Kind)
+		if id := tagValue(d.Inner.Tags, "h"); id != "" {
+			fmt.Print(" group:" + id)
+		}
+		fmt.Println()
+		fmt.Println(d.Inner.Content)
+		fmt.Println()
+	}
+	return nil
+}
+
+func doDraftEdit(cCtx *cli.Context) error {
+	if cCtx.Args().Len() == 0 {
+		return cli.ShowSubcommandHelp(cCtx)
+	}
+	name := cCtx.Args().First()
+	cfg := cCtx.App.Metadata["config"].(*Config)
+	ctx := context.Background()
+
+	d, err := findDraft(ctx, cfg, name)
+	if err != nil {
+		return err
+	}
+
+	var content string
+	switch {
+	case cCtx.Bool("stdin"):
+		b, err := io.ReadAll(os.Stdin)
+		if err != nil {
+			return err
+		}
+		content = string(b)
+	case cCtx.Args().Len() > 1:
+		content = strings.Join(cCtx.Args().Tail(), "\n")
+	default:
+		content, err = editText(d.Inner.Content)
+		if err != nil {
+			return err
+		}
+	}
+	if strings.TrimSpace(content) == "" {
+		return errors.New("content is empty (use draft rm to delete)")
+	}
+	if content == d.Inner.Content {
+		return nil
+	}
+	d.Inner.Content = content
+	return saveDraft(ctx, cfg, name, &d.Inner)
+}
+
+func doDraftPublish(cCtx *cli.Context) error {
+	if cCtx.Args().Len() == 0 {
+		return cli.ShowSubcommandHelp(cCtx)
+	}
+	name := cCtx.Args().First()
+	cfg := cCtx.App.Metadata["config"].(*Config)
+	ctx := context.Background()
+
+	_, pub, err := getSkAndPub(cfg)
+	if err != nil {
+		return err
+	}
+	d, err := findDraft(ctx, cfg, name)
+	if err != nil {
+		return err
+	}
+	ev, err := buildFromDraft(cfg, pub, d, nostr.Now())
+	if err != nil {
+		return err
+	}
+
+	if ev.Kind == nostr.KindSimpleGroupChatMessage {
+		// NIP-29 messages go to the group relay with auth, as in group post.
+		if err := cfg.publishGroupEvent(ev, "cannot publish draft"); err != nil {
+			return err
+		}
+	} else {
+		if err := cfg.signEvent(ev); err != nil {
+			return err
+		}
+		if cfg.publishEvent(ctx, ev) == 0 {
+			return errors.New("cannot publish draft")
+		}
+		if cfg.verbose {
+			if id, err := nip19.EncodeNote(ev.ID); err == nil {
+				fmt.Println(id)
+			}
+		}
+	}
+
+	if err := saveDraft(ctx, cfg, name, nil); err != nil {
+		fmt.Fprintln(os.Stderr, "published, but the draft could not be removed:", err)
+	}
+	return nil
+}
+
+func doDraftRemove(cCtx *cli.Context) error {
+	if cCtx.Args().Len() == 0 {
+		return cli.ShowSubcommandHelp(cCtx)
+	}
+	cfg := cCtx.App.Metadata["config"].(*Config)
+	ctx := context.Background()
+	for _, name := range cCtx.Args().Slice() {
+		if _, err := findDraft(ctx, cfg, name); err != nil {
+			return err
+		}
+		if err := saveDraft(ctx, cfg, name, nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// draftCommand returns the "draft" parent command with its subcommands (NIP-37).
+func draftCommand() *cli.Command {
+	return &cli.Command{
+		Name:  "draft",
+		Usage: "drafts synced through relays (NIP-37)",
+		Action: func(cCtx *cli.Context) error {
+			return cli.ShowSubcommandHelp(cCtx)
+		},
+		Subcommands: []*cli.Command{
+			{
+				Name: "save",
+				Flags: []cli.Flag{
+					&cli.StringFlag{Name: "name", Usage: "draft name (d tag; default: random, overwrites an existing draft of that name)"},
+					&cli.StringFlag{Name: "channel", Usage: "draft a channel message (note/nevent/hex of the kind 40 event)"},
+					&cli.StringFlag{Name: "group", Usage: "draft a group message (group id or #name)"},
+					&cli.StringFlag{Name: "reply", Usage: "reply target message id (with --channel or --group)"},
+					&cli.StringSliceFlag{Name: "u", Usage: "users to mention"},
+					&cli.BoolFlag{Name: "stdin"},
+					&cli.StringFlag{Name: "sensitive"},
+					&cli.StringSliceFlag{Name: "emoji"},
+					&cli.StringFlag{Name: "geohash"},
+					&cli.StringSliceFlag{Name: "tag", Aliases: []string{"t"}, Usage: "tag (key=value1;value2)"},
+					&cli.StringSliceFlag{Name: "image", Aliases: []string{"i"}, Usage: "image file(s) to upload and attach (repeatable)"},
+					&cli.StringSliceFlag{Name: "server", Aliases: []string{"s"}, Usage: "media server override (default: configured file-servers)"},
+					&cli.StringFlag{Name: "article-name"},
+					&cli.StringFlag{Name: "article-title"},
+					&cli.StringFlag{Name: "article-summary"},
+				},
+				Usage:     "save a draft (kind 1, or 30023/42/9 with --article-name/--channel/--group)",
+				UsageText: "algia draft save [--name name] [note text]",
+				ArgsUsage: "[note text]",
+				Action:    doDraftSave,
+			},
+			{
+				Name: "list",
+				Flags: []cli.Flag{
+					&cli.BoolFlag{Name: "json", Usage: "output JSON"},
+				},
+				Usage:     "list drafts",
+				UsageText: "algia draft list",
+				Action:    doDraftList,
+			},
+			{
+				Name: "edit",
+				Flags: []cli.Flag{
+					&cli.BoolFlag{Name: "stdin", Usage: "read the new text from stdin"},
+				},
+				Usage:     "edit a draft in $EDITOR (or replace its text with the given text)",
+				UsageText: "algia draft edit <name> [new text]",
+				ArgsUsage: "<name> [new text]",
+				Action:    doDraftEdit,
+			},
+			{
+				Name:      "publish",
+				Usage:     "publish a draft and delete it",
+				UsageText: "algia draft publish <name>",
+				ArgsUsage: "<name>",
+				Action:    doDraftPublish,
+			},
+			{
+				Name:      "rm",
+				Usage:     "delete draft(s)",
+				UsageText: "algia draft rm <name> [name...]",
+				ArgsUsage: "<name> [name...]",
+				Action:    doDraftRemove,
+			},
+		},
+	}
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+func TestDraftEventRoundTrip(t *testing.T) {
+	sk := nostr.GeneratePrivateKey()
+	pub, _ := nostr.GetPublicKey(sk)
+
+	inner := &nostr.Event{
+		PubKey:  pub,
+		Kind:    nostr.KindTextNote,
+		Content: "work in progress",
+		Tags:    nostr.Tags{{"content-warning", "spoiler"}},
+	}
+	ev, err := buildDraftEvent(sk, pub, "d1", inner, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ev.Kind != kindDraft {
+		t.Errorf("kind=%d want %d", ev.Kind, kindDraft)
+	}
+	if d := findTag(ev.Tags, "d"); d == nil || d[1] != "d1" {
+		t.Errorf("d tag: %v", ev.Tags)
+	}
+	if k := findTag(ev.Tags, "k"); k == nil || k[1] != "1" {
+		t.Errorf("k tag: %v", ev.Tags)
+	}
+	if ev.Content == "" || ev.Content == inner.Content {
+		t.Errorf("content not encrypted: %q", ev.Content)
+	}
+
+	d, err := parseDraftEvent(sk, pub, ev)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d.Name != "d1" || d.UpdatedAt != 100 || d.Inner.Content != "work in progress" {
+		t.Errorf("draft=%+v", d)
+	}
+	if cw := findTag(d.Inner.Tags, "content-warning"); cw == nil || cw[1] != "spoiler" {
+		t.Errorf("inner tags: %v", d.Inner.Tags)
+	}
+}
+
+func TestDraftEventBlanked(t *testing.T) {
+	sk := nostr.GeneratePrivateKey()
+	pub, _ := nostr.GetPublicKey(sk)
+
+	ev, err := buildDraftEvent(sk, pub, "d1", nil, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ev.Content != "" {
+		t.Errorf("content=%q want blank", ev.Content)
+	}
+	d, err := parseDraftEvent(sk, pub, ev)
+	if err != nil || d != nil {
+		t.Errorf("blanked draft: d=%v err=%v", d, err)
+	}
+
+	if _, err := buildDraftEvent(sk, pub, "", nil, 100); err == nil {
+		t.Error("expected error for empty name")
+	}
+}
+
+func TestBuildFromDraft(t *testing.T) {
+	cfg := &Config{Relays: map[string]Relay{"wss://relay.example": {Write: true}}}
+
+	tests := []struct {
+		name  string
+		inner nostr.Event
+		kind  int
+		check func(t *testing.T, ev *nostr.Event)
+	}{
+		{
+			name: "note",
+			inner: nostr.Event{Kind: nostr.KindTextNote, Content: "hello #nostr", Tags: nostr.Tags{
+				{"p", testPub}, {"content-warning", "cw"}, {"foo", "bar"},
+			}},
+			kind: nostr.KindTextNote,
+			check: func(t *testing.T, ev *nostr.Event) {
+				if p := findTag(ev.Tags, "p"); p == nil || p[1] != testPub {
+					t.Errorf("p tag: %v", ev.Tags)
+				}
+				if tt := findTag(ev.Tags, "t"); tt == nil || tt[1] != "nostr" {
+					t.Errorf("t tag: %v", ev.Tags)
+				}
+				if f := findTag(ev.Tags, "foo"); f == nil || f[1] != "bar" {
+					t.Errorf("passthrough tag: %v", ev.Tags)
+				}
+				if len(findAllTags(ev.Tags, "content-warning")) != 1 {
+					t.Errorf("content-warning: %v", ev.Tags)
+				}
+			},
+		},
+		{
+			name: "article",
+			inner: nostr.Event{Kind: nostr.KindArticle, Content: "# body", Tags: nostr.Tags{
+				{"d", "slug"}, {"title", "Title"}, {"summary", "sum"},
+			}},
+			kind: nostr.KindArticle,
+			check: func(t *testing.T, ev *nostr.Event) {
+				if d := findTag(ev.Tags, "d"); d == nil || d[1] != "slug" {
+					t.Errorf("d tag: %v", ev.Tags)
+				}
+				if ti := findTag(ev.Tags, "title"); ti == nil || ti[1] != "Title" {
+					t.Errorf("title tag: %v", ev.Tags)
+				}
+			},
+		},
+		{
+			name: "channel",
+			inner: nostr.Event{Kind: nostr.KindChannelMessage, Content: "hi", Tags: nostr.Tags{
+				{"e", testTargetID, "", "root"},
+			}},
+			kind: nostr.KindChannelMessage,
+			check: func(t *testing.T, ev *nostr.Event) {
+				e := findTag(ev.Tags, "e")
+				if e == nil || e[1] != testTargetID || e[2] != "wss://relay.example" || e[3] != "root" {
+					t.Errorf("e tag: %v", ev.Tags)
+				}
+			},
+		},
+		{
+			name: "group",
+			inner: nostr.Event{Kind: nostr.KindSimpleGroupChatMessage, Content: "hi", Tags: nostr.Tags{
+				{"h", "group1"}, {"e", testTargetID, "", "reply"},
+			}},
+			kind: nostr.KindSimpleGroupChatMessage,
+			check: func(t *testing.T, ev *nostr.Event) {
+				if h := findTag(ev.Tags, "h"); h == nil || h[1] != "group1" {
+					t.Errorf("h tag: %v", ev.Tags)
+				}
+				if e := findTag(ev.Tags, "e"); e == nil || e[1] != testTargetID || e[3] != "reply" {
+					t.Errorf("e tag: %v", ev.Tags)
+				}
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ev, err := buildFromDraft(cfg, testPub, &draft{Name: "x", Inner: tt.inner}, 200)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if ev.Kind != tt.kind || ev.CreatedAt != 200 || ev.PubKey != testPub {
+				t.Errorf("kind=%d created_at=%d pubkey=%s", ev.Kind, ev.CreatedAt, ev.PubKey)
+			}
+			tt.check(t, ev)
+		})
+	}
+
+	if _, err := buildFromDraft(cfg, testPub, &draft{Inner: nostr.Event{Kind: 7, Content: "+"}}, 200); err == nil {
+		t.Error("expected error for unsupported kind")
+	}
+}
@@ -0,0 +1,290 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/urfave/cli/v2"
+)
+
+const (
+	kindEmojiList = 10030
+	kindEmojiSet  = 30030
+)
+
+// emojiShortcodeRe matches what NIP-30 allows in a shortcode.
+var emojiShortcodeRe = regexp.MustCompile(`^[a-zA-Z0-9_]+$`)
+
+// emojiListSource is where the emojis synced from our kind 10030 list and the
+// kind 30030 sets it references are cached, as "shortcode=url" items. Editing
+// the list with "list add"/"list remove" drops the cache like for --list.
+var emojiListSource = &listSource{Kind: kindEmojiList}
+
+// emojiItems collects "shortcode=url" pairs from the emoji tags of the given
+// events in order; the first definition of a shortcode wins.
+func emojiItems(evs ...*nostr.Event) []string {
+	seen := map[string]bool{}
+	var items []string
+	for _, ev := range evs {
+		if ev == nil {
+			continue
+		}
+		for _, tag := range ev.Tags {
+			if len(tag) < 3 || tag[0] != "emoji" || tag[2] == "" || seen[tag[1]] {
+				continue
+			}
+			seen[tag[1]] = true
+			items = append(items, tag[1]+"="+tag[2])
+		}
+	}
+	return items
+}
+
+// emojiSetPointers returns the kind 30030 sets referenced by "a" tags.
+func emojiSetPointers(tags nostr.Tags) []*nostr.EntityPointer {
+	var eps []*nostr.EntityPointer
+	for _, tag := range tags {
+		if len(tag) < 2 || tag[0] != "a" {
+			continue
+		}
+		ep, err := nostr.EntityPointerFromTag(tag)
+		if err != nil || ep.Kind != kindEmojiSet {
+			continue
+		}
+		eps = append(eps, &ep)
+	}
+	return eps
+}
+
+// mergeEmojis combines synced "shortcode=url" items with the configured map;
+// the config wins so a hand-written entry can override a list.
+func mergeEmojis(items []string, configured map[string]string) map[string]string {
+	m := map[string]string{}
+	for _, item := range items {
+		if name, icon, ok := strings.Cut(item, "="); ok {
+			m[name] = icon
+		}
+	}
+	for name, icon := range configured {
+		m[name] = icon
+	}
+	return m
+}
+
+// syncEmojis fetches our emoji list and the sets it references and caches
+// their emojis. Having no emoji list is not an error.
+func (cfg *Config) syncEmojis(ctx context.Context) ([]string, error) {
+	_, pub, err := getSkAndPub(cfg)
+	if err != nil {
+		return nil, err
+	}
+	evs, err := cfg.QueryEvents(ctx, nostr.Filters{{Kinds: []int{kindEmojiList}, Authors: []string{pub}, Limit: 1}})
+	if err != nil {
+		return nil, err
+	}
+	var list *nostr.Event
+	var sets []*nostr.Event
+	if len(evs) > 0 {
+		list = evs[len(evs)-1]
+		private, err := listPrivateTags(cfg, list)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+		}
+		all := append(append(nostr.Tags{}, list.Tags...), private...)
+		list = &nostr.Event{Tags: all}
+		sets, err = fetchAddressable(ctx, cfg, emojiSetPointers(all))
+		if err != nil {
+			return nil, err
+		}
+	}
+	items := emojiItems(append([]*nostr.Event{list}, sets...)...)
+	if cfg.Lists == nil {
+		cfg.Lists = make(map[string]CachedList)
+	}
+	cfg.Lists[emojiListSource.key()] = CachedList{Items: items, Updated: time.Now()}
+	cfg.listsChanged = true
+	return items, nil
+}
+
+// emojiMap returns the shortcode→icon map used to expand :shortcode: in
+// posts: the synced NIP-30 emojis, refreshed on the FollowList schedule,
+// merged with cfg.Emojis. Without a private key only cfg.Emojis is used.
+func (cfg *Config) emojiMap(ctx context.Context) map[string]string {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	cached, ok := cfg.Lists[emojiListSource.key()]
+	items := cached.Items
+	if !ok || time.Since(cached.Updated) >= followListCacheTTL {
+		if cfg.PrivateKey == "" {
+			return mergeEmojis(items, cfg.Emojis)
+		}
+		synced, err := cfg.syncEmojis(ctx)
+		if err != nil {
+			if cfg.verbose {
+				fmt.Fprintln(os.Stderr, "cannot sync emojis:", err)
+			}
+		} else {
+			items = synced
+		}
+	}
+	return mergeEmojis(items, cfg.Emojis)
+}
+
+// reactionEmoji resolves a ":shortcode:" reaction without --emoji to the
+// content and icon buildLikeEvent expects, using the emoji map.
+func reactionEmoji(content, emoji string, emojis map[string]string) (string, string) {
+	if emoji != "" || len(content) < 3 || !strings.HasPrefix(content, ":") || !strings.HasSuffix(content, ":") {
+		return content, emoji
+	}
+	name := strings.Trim(content, ":")
+	if icon, ok := emojis[name]; ok {
+		return name, icon
+	}
+	return content, emoji
+}
+
+type emojiEntry struct {
+	Shortcode string `json:"shortcode"`
+	URL       string `json:"url"`
+}
+
+func sortedEmojis(m map[string]string, match func(name string) bool) []emojiEntry {
+	var entries []emojiEntry
+	for name, icon := range m {
+		if match == nil || match(name) {
+			entries = append(entries, emojiEntry{Shortcode: name, URL: icon})
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Shortcode < entries[j].Shortcode
+	})
+	return entries
+}
+
+func printEmojis(entries []emojiEntry, j bool) error {
+	if j {
+		return json.NewEncoder(os.Stdout).Encode(entries)
+	}
+	for _, e := range entries {
+		fmt.Printf(":%s: %s\n", e.Shortcode, e.URL)
+	}
+	return nil
+}
+
+func doEmojiList(cCtx *cli.Context) error {
+	cfg := cCtx.App.Metadata["config"].(*Config)
+	if cCtx.Bool("refresh") {
+		if _, err := cfg.syncEmojis(context.Background()); err != nil {
+			return err
+		}
+	}
+	return printEmojis(sortedEmojis(cfg.emojiMap(context.Background()), nil), cCtx.Bool("json"))
+}
+
+func doEmojiSearch(cCtx *cli.Context) error {
+	if cCtx.Args().Len() != 1 {
+		return cli.ShowSubcommandHelp(cCtx)
+	}
+	cfg := cCtx.App.Metadata["config"].(*Config)
+	term := strings.ToLower(strings.Trim(cCtx.Args().First(), ":"))
+	entries := sortedEmojis(cfg.emojiMap(context.Background()), func(name string) bool {
+		return strings.Contains(strings.ToLower(name), term)
+	})
+	return printEmojis(entries, cCtx.Bool("json"))
+}
+
+func doEmojiAdd(cCtx *cli.Context) error {
+	if cCtx.Args().Len() != 2 {
+		return cli.ShowSubcommandHelp(cCtx)
+	}
+	name := strings.Trim(cCtx.Args().Get(0), ":")
+	icon := cCtx.Args().Get(1)
+	if !emojiShortcodeRe.MatchString(name) {
+		return fmt.Errorf("invalid shortcode %q: use letters, digits and underscores", name)
+	}
+	if !strings.HasPrefix(icon, "https://") && !strings.HasPrefix(icon, "http://") {
+		return errors.New("the emoji must be an image URL")
+	}
+
+	cfg := cCtx.App.Metadata["config"].(*Config)
+	ctx := context.Background()
+	_, pub, err := getSkAndPub(cfg)
+	if err != nil {
+		return err
+	}
+
+	set := cCtx.String("set")
+	if set == "" {
+		return callListAdd(&listAddArg{ctx: ctx, cfg: cfg, kind: kindEmojiList, items: []string{name + "=" + icon}})
+	}
+
+	if err := callListAdd(&listAddArg{ctx: ctx, cfg: cfg, kind: kindEmojiSet, name: set, items: []string{name + "=" + icon}}); err != nil {
+		return err
+	}
+	// Reference the set from our emoji list unless it already is; either way
+	// the synced emojis have to be fetched again.
+	addr := fmt.Sprintf("%d:%s:%s", kindEmojiSet, pub, set)
+	evs, err := cfg.QueryEvents(ctx, nostr.Filters{{Kinds: []int{kindEmojiList}, Authors: []string{pub}, Limit: 1}})
+	if err != nil {
+		return err
+	}
+	if len(evs) > 0 {
+		list := evs[len(evs)-1]
+		private, _ := listPrivateTags(cfg, list)
+		for _, tags := range []nostr.Tags{list.Tags, private} {
+			if tags.FindWithValue("a", addr) != nil {
+				cfg.forgetListSource(kindEmojiList, "")
+				return nil
+			}
+		}
+	}
+	return callListAdd(&listAddArg{ctx: ctx, cfg: cfg, kind: kindEmojiList, items: []string{addr}})
+}
+
+func emojiCommand() *cli.Command {
+	return &cli.Command{
+		Name:  "emoji",
+		Usage: "custom emojis (list/search/add)",
+		Subcommands: []*cli.Command{
+			{
+				Name: "list",
+				Flags: []cli.Flag{
+					&cli.BoolFlag{Name: "refresh", Usage: "sync the emoji list now"},
+					&cli.BoolFlag{Name: "json", Usage: "output JSON"},
+				},
+				Usage:     "show the emojis available for :shortcode:",
+				UsageText: "algia emoji list [--refresh] [--json]",
+				Action:    doEmojiList,
+			},
+			{
+				Name: "search",
+				Flags: []cli.Flag{
+					&cli.BoolFlag{Name: "json", Usage: "output JSON"},
+				},
+				Usage:     "find emojis whose shortcode contains a word",
+				UsageText: "algia emoji search [--json] <word>",
+				ArgsUsage: "<word>",
+				Action:    doEmojiSearch,
+			},
+			{
+				Name: "add",
+				Flags: []cli.Flag{
+					&cli.StringFlag{Name: "set", Usage: "add to the emoji set with this name instead of the emoji list"},
+				},
+				Usage:     "add an emoji to your emoji list or an emoji set",
+				UsageText: "algia emoji add [--set <name>] <shortcode> <url>",
+				ArgsUsage: "<shortcode> <url>",
+				Action:    doEmojiAdd,
+			},
+		},
+	}
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+func TestEmojiItems(t *testing.T) {
+	pub, _ := nostr.GetPublicKey(nostr.GeneratePrivateKey())
+	list := &nostr.Event{Tags: nostr.Tags{
+		{"emoji", "wave", "https://e.example/w.png"},
+		{"a", "30030:" + pub + ":cats"},
+		{"emoji", "broken"},
+	}}
+	set := &nostr.Event{Tags: nostr.Tags{
+		{"d", "cats"},
+		{"emoji", "wave", "https://cats.example/w.png"},
+		{"emoji", "neko", "https://cats.example/n.png"},
+	}}
+	got := emojiItems(list, nil, set)
+	if strings.Join(got, ",") != "wave=https://e.example/w.png,neko=https://cats.example/n.png" {
+		t.Errorf("items=%v", got)
+	}
+
+	eps := emojiSetPointers(list.Tags)
+	if len(eps) != 1 || eps[0].PublicKey != pub || eps[0].Identifier != "cats" {
+		t.Errorf("pointers=%v", eps)
+	}
+	if eps := emojiSetPointers(nostr.Tags{{"a", "30000:" + pub + ":friends"}}); len(eps) != 0 {
+		t.Errorf("non-emoji set referenced: %v", eps)
+	}
+}
+
+func TestMergeEmojis(t *testing.T) {
+	m := mergeEmojis([]string{"wave=https://list.example/w.png", "neko=https://list.example/n.png"},
+		map[string]string{"wave": "https://config.example/w.png"})
+	if m["wave"] != "https://config.example/w.png" || m["neko"] != "https://list.example/n.png" || len(m) != 2 {
+		t.Errorf("merged=%v", m)
+	}
+}
+
+func TestEmojiMapCached(t *testing.T) {
+	cfg := &Config{
+		Emojis: map[string]string{"heart": "https://e.example/h.png"},
+		Lists: map[string]CachedList{
+			"10030:": {Items: []string{"wave=https://e.example/w.png"}, Updated: time.Now()},
+		},
+	}
+	m := cfg.emojiMap(nil)
+	if m["heart"] == "" || m["wave"] == "" || cfg.listsChanged {
+		t.Errorf("map=%v changed=%v", m, cfg.listsChanged)
+	}
+}
+
+func TestReactionEmoji(t *testing.T) {
+	emojis := map[string]string{"wave": "https://e.example/w.png"}
+	tests := []struct {
+		content, emoji         string
+		wantContent, wantEmoji string
+	}{
+		{":wave:", "", "wave", "https://e.example/w.png"},
+		{":nope:", "", ":nope:", ""},
+		{"+", "", "+", ""},
+		{":wave:", "https://other.example/w.png", ":wave:", "https://other.example/w.png"},
+		{"::", "", "::", ""},
+	}
+	for _, tt := range tests {
+		content, emoji := reactionEmoji(tt.content, tt.emoji, emojis)
+		if content != tt.wantContent || emoji != tt.wantEmoji {
+			t.Errorf("%q %q: got %q %q", tt.content, tt.emoji, content, emoji)
+		}
+	}
+}
+
+func TestItemToTagEmoji(t *testing.T) {
+	tag := itemToTag(":wave:=https://e.example/w.png", kindEmojiSet)
+	if len(tag) != 3 || tag[0] != "emoji" || tag[1] != "wave" || tag[2] != "https://e.example/w.png" {
+		t.Errorf("tag=%v", tag)
+	}
+	addr := "30030:" + testPub + ":cats"
+	if tag := itemToTag(addr, kindEmojiList); len(tag) != 2 || tag[0] != "a" || tag[1] != addr {
+		t.Errorf("set reference=%v", tag)
+	}
+	if tag := itemToTag(addr, kindEmojiSet); tag != nil {
+		t.Errorf("set referenced from a set: %v", tag)
+	}
+}
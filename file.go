@@ -999,9 +999,11 @@ func blossomHas(ctx context.Context, server, hash string) bool {
 }
 
 // tagValue returns the value of the first tag with the given key, or "".
+// The key must match exactly: GetFirst matches by prefix, so "t" would find
+// a NIP-52 "title" tag, and it stops at a key with no value.
 func tagValue(tags nostr.Tags, key string) string {
-	if t := tags.GetFirst([]string{key}); t != nil {
-		return t.Value()
+	if t := tags.Find(key); t != nil {
+		return t[1]
 	}
 	return ""
 }
@@ -20,6 +20,21 @@ func TestRelayHTTPBase(t *testing.T) {
 	}
 }
 
+func TestTagValue(t *testing.T) {
+	tags := nostr.Tags{{"title", "Nostr meetup"}, {"e"}, {"t", "nostr"}, {"e", "abc"}}
+	cases := map[string]string{
+		"t":     "nostr",
+		"title": "Nostr meetup",
+		"e":     "abc",
+		"d":     "",
+	}
+	for key, want := range cases {
+		if got := tagValue(tags, key); got != want {
+			t.Errorf("tagValue(%q)=%q want %q", key, got, want)
+		}
+	}
+}
+
 func TestAppendImageURLs(t *testing.T) {
 	bds := []*blossom.BlobDescriptor{
 		{URL: "https://s/a.png"},
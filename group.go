@@ -224,8 +224,9 @@ func doGroupStream(cCtx *cli.Context) error {
 
 // buildGroupPostEvent constructs an unsigned kind 9 message for a NIP-29 group.
 // The group id goes into the "h" tag; links and hashtags in the content are
-// auto-attached like the other post builders.
-func buildGroupPostEvent(pubkey, groupID, content, replyID string, createdAt nostr.Timestamp) (*nostr.Event, error) {
+// auto-attached like the other post builders. cfgEmojis is the shortcode→icon
+// map for inline :name: emoji expansion.
+func buildGroupPostEvent(pubkey, groupID, content, replyID string, cfgEmojis map[string]string, createdAt nostr.Timestamp) (*nostr.Event, error) {
 	if strings.TrimSpace(content) == "" {
 		return nil, errors.New("content is empty")
 	}
@@ -255,6 +256,12 @@ func buildGroupPostEvent(pubkey, groupID, content, replyID string, createdAt nos
 	for _, m := range extractTags(ev.Content) {
 		ev.Tags = ev.Tags.AppendUnique(nostr.Tag{"t", m.text})
 	}
+	for _, entry := range extractEmojis(ev.Content) {
+		name := strings.Trim(entry.text, ":")
+		if icon, ok := cfgEmojis[name]; ok {
+			ev.Tags = ev.Tags.AppendUnique(nostr.Tag{"emoji", name, icon})
+		}
+	}
 	return ev, nil
 }
 
@@ -304,7 +311,7 @@ func doGroupPost(cCtx *cli.Context) error {
 		replyID = evp.ID
 	}
 
-	ev, err := buildGroupPostEvent(pub, id, content, replyID, nostr.Now())
+	ev, err := buildGroupPostEvent(pub, id, content, replyID, cfg.emojiMap(context.Background()), nostr.Now())
 	if err != nil {
 		return err
 	}
@@ -459,7 +466,8 @@ func doGroupReact(cCtx *cli.Context) error {
 		return fmt.Errorf("failed to parse event id from '%s'", target)
 	}
 
-	ev, err := buildGroupReactEvent(pub, id, evp.ID, cCtx.String("content"), cCtx.String("emoji"), nostr.Now())
+	content, emoji := reactionEmoji(cCtx.String("content"), cCtx.String("emoji"), cfg.emojiMap(context.Background()))
+	ev, err := buildGroupReactEvent(pub, id, evp.ID, content, emoji, nostr.Now())
 	if err != nil {
 		return err
 	}
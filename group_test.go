@@ -41,7 +41,7 @@ func TestParseGroupMetadata(t *testing.T) {
 
 func TestBuildGroupPostEvent(t *testing.T) {
 	const pub = "0000000000000000000000000000000000000000000000000000000000000001"
-	ev, err := buildGroupPostEvent(pub, "grp-42", "hello #nostr https://example.com", "", 12345)
+	ev, err := buildGroupPostEvent(pub, "grp-42", "hello #nostr https://example.com", "", nil, 12345)
 	if err != nil {
 		t.Fatalf("err=%v", err)
 	}
@@ -58,6 +58,14 @@ func TestBuildGroupPostEvent(t *testing.T) {
 	if tg := findTag(ev.Tags, "t"); tg == nil || len(tg) < 2 || tg[1] != "nostr" {
 		t.Errorf("t tag=%v want hashtag nostr", tg)
 	}
+
+	ev, err = buildGroupPostEvent(pub, "grp-42", "hi :wave: :nope:", "", map[string]string{"wave": "https://e.example/w.png"}, 12345)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e := findAllTags(ev.Tags, "emoji"); len(e) != 1 || e[0][1] != "wave" {
+		t.Errorf("emoji tags=%v", e)
+	}
 }
 
 func TestBuildGroupDeleteEvent(t *testing.T) {
@@ -181,7 +189,7 @@ func TestResolveGroupRef_Passthrough(t *testing.T) {
 
 func TestBuildGroupPostEvent_Reply(t *testing.T) {
 	const pub = "0000000000000000000000000000000000000000000000000000000000000001"
-	ev, err := buildGroupPostEvent(pub, "grp-42", "ｶﾞｯ", "targetid", 12345)
+	ev, err := buildGroupPostEvent(pub, "grp-42", "ｶﾞｯ", "targetid", nil, 12345)
 	if err != nil {
 		t.Fatalf("err=%v", err)
 	}
@@ -200,10 +208,10 @@ func TestBuildGroupPostEvent_Reply(t *testing.T) {
 
 func TestBuildGroupPostEvent_Errors(t *testing.T) {
 	const pub = "0000000000000000000000000000000000000000000000000000000000000001"
-	if _, err := buildGroupPostEvent(pub, "grp", "   ", "", 1); err == nil {
+	if _, err := buildGroupPostEvent(pub, "grp", "   ", "", nil, 1); err == nil {
 		t.Errorf("empty content: want error")
 	}
-	if _, err := buildGroupPostEvent(pub, "", "hi", "", 1); err == nil {
+	if _, err := buildGroupPostEvent(pub, "", "hi", "", nil, 1); err == nil {
 		t.Errorf("empty group id: want error")
 	}
 }
@@ -0,0 +1,262 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/fatih/color"
+	"github.com/urfave/cli/v2"
+
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/nbd-wtf/go-nostr/nip19"
+	"github.com/nbd-wtf/go-nostr/sdk"
+)
+
+// highlightOpts captures everything buildHighlightEvent needs.
+type highlightOpts struct {
+	Text    string
+	Context string // surrounding text, when the highlight is a part of it
+	Comment string // turns the highlight into a quote highlight
+}
+
+// buildHighlightEvent constructs an unsigned kind 9802 (NIP-84) highlight of
+// ref. Nostr sources get an "a" or "e" tag, URLs an "r" tag marked "source",
+// and the source's author a "p" tag with the "author" role.
+func buildHighlightEvent(pubkey string, ref *contentRef, opts highlightOpts, createdAt nostr.Timestamp) (*nostr.Event, error) {
+	if strings.TrimSpace(opts.Text) == "" {
+		return nil, errors.New("highlighted text is empty")
+	}
+	ev := &nostr.Event{
+		PubKey:    pubkey,
+		CreatedAt: createdAt,
+		Kind:      nostr.KindHighlights,
+		Content:   opts.Text,
+		Tags:      nostr.Tags{},
+	}
+	clientTag(ev)
+
+	switch {
+	case ref.Address != nil:
+		ev.Tags = append(ev.Tags, nostr.Tag{"a", addressValue(ref.Address), ref.Relay})
+	case ref.Event != nil:
+		ev.Tags = append(ev.Tags, nostr.Tag{"e", ref.Event.ID, ref.Relay})
+	case ref.ExternalKind == "web":
+		ev.Tags = append(ev.Tags, nostr.Tag{"r", ref.External, "source"})
+	default:
+		return nil, fmt.Errorf("cannot highlight '%s' (use naddr, nevent or a URL)", ref.External)
+	}
+	if p := ref.authorPubkey(); p != "" {
+		ev.Tags = append(ev.Tags, nostr.Tag{"p", p, ref.Relay, "author"})
+	}
+
+	if opts.Context != "" {
+		ev.Tags = append(ev.Tags, nostr.Tag{"context", opts.Context})
+	}
+	if opts.Comment != "" {
+		ev.Tags = append(ev.Tags, nostr.Tag{"comment", opts.Comment})
+		for _, entry := range extractLinks(opts.Comment) {
+			ev.Tags = ev.Tags.AppendUnique(nostr.Tag{"r", entry.text, "mention"})
+		}
+		for _, m := range extractTags(opts.Comment) {
+			ev.Tags = ev.Tags.AppendUnique(nostr.Tag{"t", m.text})
+		}
+	}
+	return ev, nil
+}
+
+// highlightSource returns a printable reference to what ev highlights.
+func highlightSource(ev *nostr.Event) string {
+	for _, tag := range ev.Tags {
+		if len(tag) < 2 {
+			continue
+		}
+		switch tag[0] {
+		case "a":
+			ep, err := nostr.EntityPointerFromTag(tag)
+			if err != nil {
+				return tag[1]
+			}
+			if s, err := nip19.EncodeEntity(ep.PublicKey, ep.Kind, ep.Identifier, ep.Relays); err == nil {
+				return s
+			}
+			return tag[1]
+		case "e":
+			var relays []string
+			if len(tag) >= 3 && tag[2] != "" {
+				relays = []string{tag[2]}
+			}
+			if s, err := nip19.EncodeEvent(tag[1], relays, ""); err == nil {
+				return s
+			}
+			return tag[1]
+		}
+	}
+	for _, tag := range ev.Tags {
+		if len(tag) >= 2 && tag[0] == "r" && (len(tag) < 3 || tag[2] != "mention") {
+			return tag[1]
+		}
+	}
+	return ""
+}
+
+// printHighlight prints a highlight as a quote with its context, comment and
+// source.
+func (cfg *Config) printHighlight(ev *nostr.Event) {
+	fmt.Print(ev.CreatedAt.Time().Format("2006-01-02T15:04:05") + " ")
+	if profile, err := cfg.GetProfile(ev.PubKey); err == nil {
+		color.Set(color.FgHiRed)
+		fmt.Print(profile.Name)
+	} else {
+		color.Set(color.FgRed)
+		if pk, err := nip19.EncodePublicKey(ev.PubKey); err == nil {
+			fmt.Print(pk)
+		} else {
+			fmt.Print(ev.PubKey)
+		}
+	}
+	color.Set(color.Reset)
+	fmt.Print(": ")
+	color.Set(color.FgHiBlue)
+	if ni, err := nip19.EncodeNote(ev.ID); err == nil {
+		fmt.Println(ni)
+	} else {
+		fmt.Println(ev.ID)
+	}
+	color.Set(color.Reset)
+
+	if c := tagValue(ev.Tags, "comment"); c != "" {
+		fmt.Println(c)
+	}
+	if c := tagValue(ev.Tags, "context"); c != "" {
+		color.Set(color.FgHiBlack)
+		for _, line := range strings.Split(c, "\n") {
+			fmt.Println("  " + line)
+		}
+		color.Set(color.Reset)
+	}
+	color.Set(color.FgHiYellow)
+	for _, line := range strings.Split(ev.Content, "\n") {
+		fmt.Println("> " + line)
+	}
+	color.Set(color.Reset)
+	if src := highlightSource(ev); src != "" {
+		color.Set(color.FgHiBlack)
+		fmt.Println("-- " + src)
+		color.Set(color.Reset)
+	}
+	fmt.Println()
+}
+
+func doHighlight(cCtx *cli.Context) error {
+	stdin := cCtx.Bool("stdin")
+	if !stdin && cCtx.Args().Len() == 0 {
+		return cli.ShowSubcommandHelp(cCtx)
+	}
+	cfg := cCtx.App.Metadata["config"].(*Config)
+	ctx := context.Background()
+
+	_, pub, err := getSkAndPub(cfg)
+	if err != nil {
+		return err
+	}
+
+	var text string
+	if stdin {
+		b, err := io.ReadAll(os.Stdin)
+		if err != nil {
+			return err
+		}
+		text = strings.TrimRight(string(b), "\n")
+	} else {
+		text = strings.Join(cCtx.Args().Slice(), "\n")
+	}
+
+	ref, err := resolveContentRef(ctx, cfg, cCtx.String("from"))
+	if err != nil {
+		return err
+	}
+	ev, err := buildHighlightEvent(pub, ref, highlightOpts{
+		Text:    text,
+		Context: cCtx.String("context"),
+		Comment: cCtx.String("comment"),
+	}, nostr.Now())
+	if err != nil {
+		return err
+	}
+	if err := cfg.signEvent(ev); err != nil {
+		return err
+	}
+	if cfg.publishEvent(ctx, ev) == 0 {
+		return errors.New("cannot highlight")
+	}
+	if cfg.verbose {
+		if id, err := nip19.EncodeNote(ev.ID); err == nil {
+			fmt.Println(id)
+		}
+	}
+	return nil
+}
+
+func doHighlights(cCtx *cli.Context) error {
+	cfg := cCtx.App.Metadata["config"].(*Config)
+	ctx := context.Background()
+	j := cCtx.Bool("json")
+
+	filter := nostr.Filter{
+		Kinds: []int{nostr.KindHighlights},
+		Limit: cCtx.Int("n"),
+	}
+
+	for _, u := range cCtx.StringSlice("u") {
+		if u == "me" {
+			_, pub, err := getSkAndPub(cfg)
+			if err != nil {
+				return err
+			}
+			filter.Authors = append(filter.Authors, pub)
+			continue
+		}
+		pp := sdk.InputToProfile(ctx, u)
+		if pp == nil {
+			return fmt.Errorf("failed to parse pubkey from '%s'", u)
+		}
+		filter.Authors = append(filter.Authors, pp.PublicKey)
+	}
+
+	if on := cCtx.String("on"); on != "" {
+		ref, err := resolveContentRef(ctx, cfg, on)
+		if err != nil {
+			return err
+		}
+		switch {
+		case ref.Address != nil:
+			filter.Tags = nostr.TagMap{"a": []string{addressValue(ref.Address)}}
+		case ref.Event != nil:
+			filter.Tags = nostr.TagMap{"e": []string{ref.Event.ID}}
+		case ref.ExternalKind == "web":
+			filter.Tags = nostr.TagMap{"r": []string{ref.External}}
+		default:
+			return fmt.Errorf("cannot list highlights of '%s'", on)
+		}
+	} else if len(filter.Authors) == 0 {
+		filter.Authors = cfg.FollowList
+	}
+
+	evs, err := cfg.QueryEvents(ctx, nostr.Filters{filter})
+	if err != nil {
+		return err
+	}
+	for _, ev := range evs {
+		if j {
+			json.NewEncoder(os.Stdout).Encode(ev)
+		} else {
+			cfg.printHighlight(ev)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+func TestBuildHighlightEvent_Article(t *testing.T) {
+	// highlightSource needs a valid pubkey to encode the naddr.
+	author, _ := nostr.GetPublicKey(nostr.GeneratePrivateKey())
+	ref := &contentRef{
+		Address: &nostr.EntityPointer{PublicKey: author, Kind: nostr.KindArticle, Identifier: "slug"},
+		Relay:   "wss://relay.example",
+	}
+	ev, err := buildHighlightEvent(testPub, ref, highlightOpts{Text: "quoted", Context: "before quoted after"}, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ev.Kind != nostr.KindHighlights || ev.Content != "quoted" {
+		t.Errorf("kind=%d content=%q", ev.Kind, ev.Content)
+	}
+	if a := findTag(ev.Tags, "a"); a == nil || a[1] != "30023:"+author+":slug" || a[2] != "wss://relay.example" {
+		t.Errorf("a tag: %v", ev.Tags)
+	}
+	if p := findTag(ev.Tags, "p"); p == nil || p[1] != author || p[3] != "author" {
+		t.Errorf("p tag: %v", ev.Tags)
+	}
+	if c := findTag(ev.Tags, "context"); c == nil || c[1] != "before quoted after" {
+		t.Errorf("context tag: %v", ev.Tags)
+	}
+	if findTag(ev.Tags, "comment") != nil {
+		t.Errorf("unexpected comment tag: %v", ev.Tags)
+	}
+	if !strings.HasPrefix(highlightSource(ev), "naddr1") {
+		t.Errorf("source=%q", highlightSource(ev))
+	}
+}
+
+func TestBuildHighlightEvent_Note(t *testing.T) {
+	ref := &contentRef{Event: &nostr.Event{ID: testTargetID, PubKey: testPub, Kind: 1}}
+	ev, err := buildHighlightEvent(testPub, ref, highlightOpts{Text: "quoted"}, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e := findTag(ev.Tags, "e"); e == nil || e[1] != testTargetID {
+		t.Errorf("e tag: %v", ev.Tags)
+	}
+	if findTag(ev.Tags, "a") != nil {
+		t.Errorf("unexpected a tag: %v", ev.Tags)
+	}
+	if !strings.HasPrefix(highlightSource(ev), "nevent1") {
+		t.Errorf("source=%q", highlightSource(ev))
+	}
+}
+
+func TestBuildHighlightEvent_URLWithComment(t *testing.T) {
+	ref := &contentRef{External: "https://example.com/post", ExternalKind: "web"}
+	ev, err := buildHighlightEvent(testPub, ref, highlightOpts{Text: "quoted", Comment: "see https://other.example #quote"}, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rs := findAllTags(ev.Tags, "r")
+	if len(rs) != 2 {
+		t.Fatalf("r tags: %v", ev.Tags)
+	}
+	if rs[0][1] != "https://example.com/post" || rs[0][2] != "source" {
+		t.Errorf("source r tag: %v", rs[0])
+	}
+	if rs[1][1] != "https://other.example" || rs[1][2] != "mention" {
+		t.Errorf("mention r tag: %v", rs[1])
+	}
+	if c := findTag(ev.Tags, "comment"); c == nil || c[1] != "see https://other.example #quote" {
+		t.Errorf("comment tag: %v", ev.Tags)
+	}
+	if findTag(ev.Tags, "p") != nil {
+		t.Errorf("unexpected p tag: %v", ev.Tags)
+	}
+	if got := highlightSource(ev); got != "https://example.com/post" {
+		t.Errorf("source=%q", got)
+	}
+}
+
+func TestBuildHighlightEvent_Errors(t *testing.T) {
+	if _, err := buildHighlightEvent(testPub, &contentRef{External: "https://example.com", ExternalKind: "web"}, highlightOpts{Text: " "}, 100); err == nil {
+		t.Error("expected error for empty text")
+	}
+	if _, err := buildHighlightEvent(testPub, &contentRef{External: "isbn:9780765382003", ExternalKind: "isbn"}, highlightOpts{Text: "x"}, 100); err == nil {
+		t.Error("expected error for a non-web external source")
+	}
+}
@@ -0,0 +1,518 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/urfave/cli/v2"
+)
+
+// eventHistory is the local, append-only history of our own replaceable and
+// addressable events (profile, contacts, relay list, NIP-51 lists, ...), one
+// signed event per line. Every version algia fetches or signs is kept so a
+// list clobbered by another client can be put back with "history restore".
+type eventHistory struct {
+	path string
+	mu   sync.Mutex
+	pub  string          // our pubkey, resolved on first use
+	ids  map[string]bool // events already in the file, loaded on first use
+}
+
+func historyFileName(profile string) string {
+	if profile == "" {
+		return "history.jsonl"
+	}
+	return "history-" + profile + ".jsonl"
+}
+
+// isHistoryKind reports whether events of kind replace their predecessors.
+func isHistoryKind(kind int) bool {
+	return nostr.IsReplaceableKind(kind) || nostr.IsAddressableKind(kind)
+}
+
+// historyAddress identifies the slot a version belongs to, as in an "a" tag.
+func historyAddress(ev *nostr.Event) string {
+	d := ""
+	if nostr.IsAddressableKind(ev.Kind) {
+		d = tagValue(ev.Tags, "d")
+	}
+	return fmt.Sprintf("%d:%s:%s", ev.Kind, ev.PubKey, d)
+}
+
+// load reads every version in the history file. A missing file is empty.
+func (h *eventHistory) load() ([]*nostr.Event, error) {
+	f, err := os.Open(h.path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer f.Close()
+
+	var evs []*nostr.Event
+	sc := bufio.NewScanner(f)
+	sc.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	for sc.Scan() {
+		line := sc.Bytes()
+		if len(line) == 0 {
+			continue
+		}
+		var ev nostr.Event
+		if err := json.Unmarshal(line, &ev); err != nil {
+			return nil, fmt.Errorf("%s: %w", h.path, err)
+		}
+		evs = append(evs, &ev)
+	}
+	return evs, sc.Err()
+}
+
+// add appends the signed history-kind events of pub that are not in the file
+// yet. It is written out right away: QueryEvents decrypts some contents in
+// place afterwards.
+func (h *eventHistory) add(pub string, evs ...*nostr.Event) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.ids == nil {
+		old, err := h.load()
+		if err != nil {
+			return err
+		}
+		h.ids = map[string]bool{}
+		for _, ev := range old {
+			h.ids[ev.ID] = true
+		}
+	}
+
+	var buf []byte
+	for _, ev := range evs {
+		if ev == nil || ev.PubKey != pub || ev.Sig == "" || !isHistoryKind(ev.Kind) || h.ids[ev.ID] {
+			continue
+		}
+		b, err := json.Marshal(ev)
+		if err != nil {
+			return err
+		}
+		buf = append(append(buf, b...), '\n')
+		h.ids[ev.ID] = true
+	}
+	if len(buf) == 0 {
+		return nil
+	}
+	f, err := os.OpenFile(h.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(buf); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// recordHistory keeps our own replaceable and addressable events among evs in
+// the local history. Failing to write it never fails the command.
+func (cfg *Config) recordHistory(evs ...*nostr.Event) {
+	h := cfg.history
+	if h == nil || cfg.tempRelay {
+		return
+	}
+	if h.pub == "" {
+		_, pub, err := getSkAndPub(cfg)
+		if err != nil {
+			return
+		}
+		h.pub = pub
+	}
+	if err := h.add(h.pub, evs...); err != nil && cfg.verbose {
+		fmt.Fprintln(os.Stderr, "cannot record history:", err)
+	}
+}
+
+// historyVersions returns the versions of kind (and d for addressable kinds,
+// any d when empty) by pub, grouped by address and newest first within each.
+func historyVersions(evs []*nostr.Event, pub string, kind int, d string) map[string][]*nostr.Event {
+	out := map[string][]*nostr.Event{}
+	seen := map[string]bool{}
+	for _, ev := range evs {
+		if ev.PubKey != pub || ev.Kind != kind || seen[ev.ID] {
+			continue
+		}
+		if d != "" && tagValue(ev.Tags, "d") != d {
+			continue
+		}
+		seen[ev.ID] = true
+		addr := historyAddress(ev)
+		out[addr] = append(out[addr], ev)
+	}
+	for _, versions := range out {
+		sort.SliceStable(versions, func(i, j int) bool {
+			return versions[i].CreatedAt > versions[j].CreatedAt
+		})
+	}
+	return out
+}
+
+// findHistoryVersion looks a version up by its id or an unambiguous prefix.
+func findHistoryVersion(evs []*nostr.Event, pub, prefix string) (*nostr.Event, error) {
+	prefix = strings.ToLower(prefix)
+	if len(prefix) < 4 {
+		return nil, errors.New("give at least 4 characters of the version")
+	}
+	var found *nostr.Event
+	for _, ev := range evs {
+		if ev.PubKey != pub || !strings.HasPrefix(ev.ID, prefix) {
+			continue
+		}
+		if found != nil && found.ID != ev.ID {
+			return nil, fmt.Errorf("version %s is ambiguous", prefix)
+		}
+		found = ev
+	}
+	if found == nil {
+		return nil, fmt.Errorf("version %s not found in history", prefix)
+	}
+	return found, nil
+}
+
+// historyLines renders what a diff compares: profile fields for kind 0, and
+// tags (without the client tag) for everything else, followed by the
+// private items or, when those cannot be read, the raw content.
+func historyLines(ev *nostr.Event, private nostr.Tags, privateErr error) []string {
+	var lines []string
+	if ev.Kind == nostr.KindProfileMetadata {
+		var fields map[string]any
+		if err := json.Unmarshal([]byte(ev.Content), &fields); err != nil {
+			return []string{"content: " + ev.Content}
+		}
+		for name, value := range fields {
+			b, _ := json.Marshal(value)
+			lines = append(lines, name+": "+string(b))
+		}
+		sort.Strings(lines)
+		return lines
+	}
+	for _, tag := range ev.Tags {
+		if len(tag) > 0 && tag[0] != "client" {
+			lines = append(lines, strings.Join(tag, " "))
+		}
+	}
+	for _, tag := range private {
+		lines = append(lines, strings.Join(tag, " ")+" (private)")
+	}
+	if ev.Content != "" && (privateErr != nil || ev.Kind == nostr.KindFollowList) {
+		lines = append(lines, "content: "+ev.Content)
+	}
+	return lines
+}
+
+// diffLines lists what is in older but not newer as "- " lines and what is in
+// newer but not older as "+ " lines, counting duplicates.
+func diffLines(older, newer []string) []string {
+	count := map[string]int{}
+	for _, l := range older {
+		count[l]++
+	}
+	var added []string
+	for _, l := range newer {
+		if count[l] > 0 {
+			count[l]--
+		} else {
+			added = append(added, "+ "+l)
+		}
+	}
+	var removed []string
+	for _, l := range older {
+		if count[l] > 0 {
+			count[l]--
+			removed = append(removed, "- "+l)
+		}
+	}
+	return append(removed, added...)
+}
+
+// eventHistoryLines is historyLines with private items decrypted when we can.
+func eventHistoryLines(sk, pub string, ev *nostr.Event) []string {
+	var private nostr.Tags
+	var err error
+	if ev.Content != "" && ev.Kind != nostr.KindProfileMetadata && ev.Kind != nostr.KindFollowList {
+		private, err = decryptPrivateTags(sk, pub, ev.Content)
+	}
+	return historyLines(ev, private, err)
+}
+
+// parseHistoryKind reads the <kind> argument, a number or a list name such as
+// "mute" or "follow-set" as printed by "list list".
+func parseHistoryKind(s string) (int, error) {
+	if kind, err := strconv.Atoi(s); err == nil {
+		if !isHistoryKind(kind) {
+			return 0, fmt.Errorf("kind %d is not replaceable", kind)
+		}
+		return kind, nil
+	}
+	switch s {
+	case "profile", "metadata":
+		return nostr.KindProfileMetadata, nil
+	case "contacts", "follows":
+		return nostr.KindFollowList, nil
+	case "relays":
+		return nostr.KindRelayListMetadata, nil
+	}
+	for _, r := range [][2]int{{10000, 20000}, {30000, 40000}} {
+		for kind := r[0]; kind < r[1]; kind++ {
+			if kindLabel(kind) == s {
+				return kind, nil
+			}
+		}
+	}
+	return 0, fmt.Errorf("unknown kind %q", s)
+}
+
+// pullHistory asks relays for every version they still hold. Most relays
+// keep only the latest one, archival relays can be given with --relay.
+func pullHistory(ctx context.Context, cfg *Config, pub string, kind int, d string, relays []string) error {
+	filter := nostr.Filter{Kinds: []int{kind}, Authors: []string{pub}}
+	if d != "" {
+		filter.Tags = nostr.TagMap{"d": []string{d}}
+	}
+	evs, err := cfg.queryRelays(ctx, relays, nostr.Filters{filter})
+	if err != nil {
+		return err
+	}
+	for _, ev := range evs {
+		if ok, _ := ev.CheckSignature(); ok {
+			cfg.recordHistory(ev)
+		}
+	}
+	return nil
+}
+
+func doHistory(cCtx *cli.Context) error {
+	cfg := cCtx.App.Metadata["config"].(*Config)
+	if cfg.history == nil {
+		return errors.New("history is not available")
+	}
+	sk, pub, err := getSkAndPub(cfg)
+	if err != nil {
+		return err
+	}
+
+	if cCtx.Args().Len() == 0 {
+		evs, err := cfg.history.load()
+		if err != nil {
+			return err
+		}
+		counts := map[string]int{}
+		var addrs []string
+		for _, ev := range evs {
+			if ev.PubKey != pub {
+				continue
+			}
+			addr := historyAddress(ev)
+			if counts[addr] == 0 {
+				addrs = append(addrs, addr)
+			}
+			counts[addr]++
+		}
+		sort.Strings(addrs)
+		for _, addr := range addrs {
+			parts := strings.SplitN(addr, ":", 3)
+			kind, _ := strconv.Atoi(parts[0])
+			label := kindLabel(kind)
+			if parts[2] != "" {
+				label += " " + parts[2]
+			}
+			fmt.Printf("%s\t%s\t%d versions\n", parts[0], label, counts[addr])
+		}
+		return nil
+	}
+	if cCtx.Args().Len() > 2 {
+		return cli.ShowSubcommandHelp(cCtx)
+	}
+
+	kind, err := parseHistoryKind(cCtx.Args().Get(0))
+	if err != nil {
+		return err
+	}
+	d := cCtx.Args().Get(1)
+	if d != "" && !nostr.IsAddressableKind(kind) {
+		return fmt.Errorf("kind %d has no name", kind)
+	}
+
+	if cCtx.Bool("fetch") || len(cCtx.StringSlice("relay")) > 0 {
+		if err := pullHistory(context.Background(), cfg, pub, kind, d, cCtx.StringSlice("relay")); err != nil {
+			return err
+		}
+	}
+
+	evs, err := cfg.history.load()
+	if err != nil {
+		return err
+	}
+	groups := historyVersions(evs, pub, kind, d)
+	if len(groups) == 0 {
+		return fmt.Errorf("no history for kind %d; try --fetch", kind)
+	}
+	var addrs []string
+	for addr := range groups {
+		addrs = append(addrs, addr)
+	}
+	sort.Strings(addrs)
+
+	n := cCtx.Int("n")
+	for i, addr := range addrs {
+		if len(addrs) > 1 {
+			if i > 0 {
+				fmt.Println()
+			}
+			fmt.Println("#", addr)
+		}
+		versions := groups[addr]
+		if n > 0 && len(versions) > n {
+			versions = versions[:n+1]
+		}
+		for j, ev := range versions {
+			if n > 0 && j == n {
+				break
+			}
+			lines := eventHistoryLines(sk, pub, ev)
+			fmt.Printf("%s %s (%d entries)\n", ev.ID[:12], ev.CreatedAt.Time().Format("2006-01-02 15:04:05"), len(lines))
+			if j+1 < len(versions) {
+				for _, l := range diffLines(eventHistoryLines(sk, pub, versions[j+1]), lines) {
+					fmt.Println("    " + l)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// restoredEvent copies a version for republishing: same kind, tags and
+// content, a fresh timestamp, and no stale client or delegation tag.
+func restoredEvent(old *nostr.Event, now nostr.Timestamp) *nostr.Event {
+	ev := &nostr.Event{
+		PubKey:    old.PubKey,
+		CreatedAt: now,
+		Kind:      old.Kind,
+		Content:   old.Content,
+		Tags:      nostr.Tags{},
+	}
+	for _, tag := range old.Tags {
+		if len(tag) > 0 && (tag[0] == "client" || tag[0] == "delegation") {
+			continue
+		}
+		ev.Tags = append(ev.Tags, slices.Clone(tag))
+	}
+	clientTag(ev)
+	return ev
+}
+
+func doHistoryRestore(cCtx *cli.Context) error {
+	if cCtx.Args().Len() != 1 {
+		return cli.ShowSubcommandHelp(cCtx)
+	}
+	cfg := cCtx.App.Metadata["config"].(*Config)
+	if cfg.history == nil {
+		return errors.New("history is not available")
+	}
+	sk, pub, err := getSkAndPub(cfg)
+	if err != nil {
+		return err
+	}
+	evs, err := cfg.history.load()
+	if err != nil {
+		return err
+	}
+	version, err := findHistoryVersion(evs, pub, cCtx.Args().First())
+	if err != nil {
+		return err
+	}
+
+	// Compare with what relays serve now, which may be newer than history.
+	ctx := context.Background()
+	filter := nostr.Filter{Kinds: []int{version.Kind}, Authors: []string{pub}, Limit: 1}
+	if nostr.IsAddressableKind(version.Kind) {
+		filter.Tags = nostr.TagMap{"d": []string{tagValue(version.Tags, "d")}}
+	}
+	if _, err := cfg.QueryEvents(ctx, nostr.Filters{filter}); err != nil {
+		return err
+	}
+	if evs, err = cfg.history.load(); err != nil {
+		return err
+	}
+	var current *nostr.Event
+	for _, ev := range evs {
+		if historyAddress(ev) == historyAddress(version) && (current == nil || ev.CreatedAt > current.CreatedAt) {
+			current = ev
+		}
+	}
+	if current != nil && current.ID == version.ID {
+		return fmt.Errorf("version %s is already the current one", version.ID[:12])
+	}
+
+	fmt.Printf("restore %s %s\n", version.ID[:12], version.CreatedAt.Time().Format("2006-01-02 15:04:05"))
+	var older []string
+	if current != nil {
+		older = eventHistoryLines(sk, pub, current)
+	}
+	for _, l := range diffLines(older, eventHistoryLines(sk, pub, version)) {
+		fmt.Println("    " + l)
+	}
+	if !cCtx.Bool("yes") && !confirm("Republish this version?") {
+		return nil
+	}
+
+	ev := restoredEvent(version, nostr.Now())
+	if err := cfg.signEvent(ev); err != nil {
+		return err
+	}
+	if cfg.publishEvent(ctx, ev) == 0 {
+		return errors.New("cannot restore version")
+	}
+	switch {
+	case ev.Kind == nostr.KindFollowList:
+		cfg.Updated = time.Time{}
+		cfg.listsChanged = true
+	case ev.Kind >= 10000:
+		cfg.forgetListSource(ev.Kind, tagValue(ev.Tags, "d"))
+	}
+	return nil
+}
+
+func historyCommand() *cli.Command {
+	return &cli.Command{
+		Name:  "history",
+		Usage: "versions of your profile, contacts and lists (restore)",
+		Flags: []cli.Flag{
+			&cli.BoolFlag{Name: "fetch", Usage: "ask relays for older versions too"},
+			&cli.StringSliceFlag{Name: "relay", Usage: "also ask this relay for older versions"},
+			&cli.IntFlag{Name: "n", Value: 10, Usage: "versions to show per list (0 for all)"},
+		},
+		UsageText: "algia history [--fetch] [--relay <url>] [-n <count>] [<kind> [<name>]]",
+		ArgsUsage: "[<kind> [<name>]]",
+		Action:    doHistory,
+		Subcommands: []*cli.Command{
+			{
+				Name: "restore",
+				Flags: []cli.Flag{
+					&cli.BoolFlag{Name: "yes", Aliases: []string{"y"}, Usage: "republish without asking"},
+				},
+				Usage:     "republish an old version with a fresh timestamp",
+				UsageText: "algia history restore [--yes] <version>",
+				ArgsUsage: "<version>",
+				Action:    doHistoryRestore,
+			},
+		},
+	}
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+func signedListEvent(t *testing.T, sk string, kind int, createdAt nostr.Timestamp, tags nostr.Tags) *nostr.Event {
+	t.Helper()
+	ev := &nostr.Event{Kind: kind, CreatedAt: createdAt, Tags: tags}
+	if err := ev.Sign(sk); err != nil {
+		t.Fatal(err)
+	}
+	return ev
+}
+
+func TestEventHistoryAdd(t *testing.T) {
+	sk := nostr.GeneratePrivateKey()
+	pub, _ := nostr.GetPublicKey(sk)
+	other := nostr.GeneratePrivateKey()
+
+	h := &eventHistory{path: filepath.Join(t.TempDir(), historyFileName("test"))}
+	v1 := signedListEvent(t, sk, 10000, 100, nostr.Tags{{"p", "alice"}})
+	v2 := signedListEvent(t, sk, 10000, 200, nostr.Tags{{"p", "alice"}, {"p", "bob"}})
+	note := signedListEvent(t, sk, nostr.KindTextNote, 150, nil)
+	theirs := signedListEvent(t, other, 10000, 300, nil)
+	unsigned := &nostr.Event{PubKey: pub, Kind: 10000, CreatedAt: 400}
+
+	if err := h.add(pub, v1, note, theirs, unsigned); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.add(pub, v1, v2); err != nil {
+		t.Fatal(err)
+	}
+	// A fresh reader sees what was written and does not duplicate it.
+	h = &eventHistory{path: h.path}
+	if err := h.add(pub, v2); err != nil {
+		t.Fatal(err)
+	}
+	evs, err := h.load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(evs) != 2 || evs[0].ID != v1.ID || evs[1].ID != v2.ID {
+		t.Fatalf("history=%v", evs)
+	}
+	if ok, _ := evs[1].CheckSignature(); !ok {
+		t.Error("stored event no longer verifies")
+	}
+
+	empty, err := (&eventHistory{path: filepath.Join(t.TempDir(), "missing.jsonl")}).load()
+	if err != nil || len(empty) != 0 {
+		t.Errorf("missing file: %v %v", empty, err)
+	}
+}
+
+func TestHistoryVersions(t *testing.T) {
+	evs := []*nostr.Event{
+		{ID: "a1", PubKey: "me", Kind: 30000, CreatedAt: 100, Tags: nostr.Tags{{"d", "friends"}}},
+		{ID: "a2", PubKey: "me", Kind: 30000, CreatedAt: 300, Tags: nostr.Tags{{"d", "friends"}}},
+		{ID: "b1", PubKey: "me", Kind: 30000, CreatedAt: 200, Tags: nostr.Tags{{"d", "work"}}},
+		{ID: "c1", PubKey: "me", Kind: 10000, CreatedAt: 200},
+		{ID: "x1", PubKey: "them", Kind: 30000, CreatedAt: 200, Tags: nostr.Tags{{"d", "friends"}}},
+	}
+	groups := historyVersions(evs, "me", 30000, "")
+	if len(groups) != 2 {
+		t.Fatalf("groups=%v", groups)
+	}
+	friends := groups["30000:me:friends"]
+	if len(friends) != 2 || friends[0].ID != "a2" || friends[1].ID != "a1" {
+		t.Errorf("friends=%v", friends)
+	}
+	if groups := historyVersions(evs, "me", 30000, "work"); len(groups) != 1 {
+		t.Errorf("work=%v", groups)
+	}
+	if addr := historyAddress(evs[3]); addr != "10000:me:" {
+		t.Errorf("address=%q", addr)
+	}
+
+	if ev, err := findHistoryVersion(evs, "me", "a2"); err == nil {
+		t.Errorf("short prefix accepted: %v", ev)
+	}
+	evs[0].ID, evs[1].ID = "abcd01", "abcd02"
+	if _, err := findHistoryVersion(evs, "me", "abcd"); err == nil {
+		t.Error("expected an ambiguous version")
+	}
+	if ev, err := findHistoryVersion(evs, "me", "ABCD02"); err != nil || ev.CreatedAt != 300 {
+		t.Errorf("ev=%v err=%v", ev, err)
+	}
+}
+
+func TestDiffLines(t *testing.T) {
+	got := diffLines([]string{"p alice", "p bob", "t go", "t go"}, []string{"p alice", "t go", "p carol"})
+	if strings.Join(got, "|") != "- p bob|- t go|+ p carol" {
+		t.Errorf("diff=%q", got)
+	}
+
+	old := &nostr.Event{Kind: 0, Content: `{"name":"me","about":"old"}`}
+	cur := &nostr.Event{Kind: 0, Content: `{"name":"me","about":"new"}`}
+	got = diffLines(historyLines(old, nil, nil), historyLines(cur, nil, nil))
+	if strings.Join(got, "|") != `- about: "old"|+ about: "new"` {
+		t.Errorf("profile diff=%q", got)
+	}
+
+	list := &nostr.Event{Kind: 10000, Tags: nostr.Tags{{"p", "alice"}, {"client", "algia"}}, Content: "secret"}
+	lines := historyLines(list, nostr.Tags{{"word", "spoiler"}}, nil)
+	if strings.Join(lines, "|") != "p alice|word spoiler (private)" {
+		t.Errorf("lines=%q", lines)
+	}
+}
+
+func TestParseHistoryKind(t *testing.T) {
+	for in, want := range map[string]int{"3": 3, "profile": 0, "mute": 10000, "follow-set": 30000, "emoji": 10030} {
+		if got, err := parseHistoryKind(in); err != nil || got != want {
+			t.Errorf("%s: got %d err=%v", in, got, err)
+		}
+	}
+	for _, in := range []string{"1", "nope"} {
+		if _, err := parseHistoryKind(in); err == nil {
+			t.Errorf("%s: expected error", in)
+		}
+	}
+}
+
+func TestRestoredEvent(t *testing.T) {
+	old := &nostr.Event{
+		ID: "old", PubKey: "me", Kind: 30000, CreatedAt: 100, Content: "enc",
+		Tags: nostr.Tags{{"d", "friends"}, {"client", "other"}, {"delegation", "x", "y", "z"}, {"p", "alice"}},
+	}
+	ev := restoredEvent(old, 500)
+	if ev.CreatedAt != 500 || ev.Kind != 30000 || ev.Content != "enc" || ev.ID != "" {
+		t.Errorf("ev=%+v", ev)
+	}
+	if findTag(ev.Tags, "delegation") != nil || tagValue(ev.Tags, "d") != "friends" || tagValue(ev.Tags, "p") != "alice" {
+		t.Errorf("tags=%v", ev.Tags)
+	}
+	if c := findAllTags(ev.Tags, "client"); len(c) > 1 || (len(c) == 1 && c[0][1] == "other") {
+		t.Errorf("client tags=%v", c)
+	}
+	ev.Tags.Find("p")[1] = "changed"
+	if old.Tags[3][1] != "alice" {
+		t.Error("restored tags share storage with the old version")
+	}
+}
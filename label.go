@@ -0,0 +1,420 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/fatih/color"
+	"github.com/urfave/cli/v2"
+
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/nbd-wtf/go-nostr/nip19"
+	"github.com/nbd-wtf/go-nostr/sdk"
+)
+
+// labelUGC is the NIP-32 namespace implied when a label has none.
+const labelUGC = "ugc"
+
+// LabelConfig is the "labels" config section. Labels published by Authors
+// (npub or hex; "me" for yourself) are shown on timeline notes, and notes
+// carrying a label in Hide ("value" or "namespace:value") are left out.
+type LabelConfig struct {
+	Authors    []string `json:"authors"`
+	Namespaces []string `json:"namespaces,omitempty"`
+	Hide       []string `json:"hide,omitempty"`
+}
+
+// labelValue is a single NIP-32 label.
+type labelValue struct {
+	NS    string `json:"namespace"`
+	Value string `json:"value"`
+}
+
+func (l labelValue) String() string {
+	if l.NS == "" || l.NS == labelUGC {
+		return l.Value
+	}
+	return l.NS + ":" + l.Value
+}
+
+// matches reports whether l is selected by s, which is either a bare value or
+// "namespace:value".
+func (l labelValue) matches(s string) bool {
+	return s == l.Value || s == l.NS+":"+l.Value
+}
+
+// labelTargetTag turns a label target into the tag that points at it:
+// nevent/note/hex ids become "e", npub/nprofile "p", naddr "a", #hashtags "t"
+// and URLs "r".
+func labelTargetTag(s string) (nostr.Tag, error) {
+	s = strings.TrimPrefix(strings.TrimSpace(s), "nostr:")
+	if strings.HasPrefix(s, "#") {
+		if len(s) == 1 {
+			return nil, errors.New("empty hashtag")
+		}
+		return nostr.Tag{"t", strings.ToLower(s[1:])}, nil
+	}
+	if strings.HasPrefix(s, "http://") || strings.HasPrefix(s, "https://") {
+		v, _, err := parseExternalID(s)
+		if err != nil {
+			return nil, err
+		}
+		return nostr.Tag{"r", v}, nil
+	}
+	if prefix, v, err := nip19.Decode(s); err == nil {
+		switch prefix {
+		case "npub":
+			return nostr.Tag{"p", v.(string)}, nil
+		case "nprofile":
+			return nostr.Tag{"p", v.(nostr.ProfilePointer).PublicKey}, nil
+		case "naddr":
+			ep := v.(nostr.EntityPointer)
+			return nostr.Tag{"a", addressValue(&ep)}, nil
+		}
+	}
+	if evp := sdk.InputToEventPointer(s); evp != nil {
+		return nostr.Tag{"e", evp.ID}, nil
+	}
+	return nil, fmt.Errorf("unsupported label target '%s'", s)
+}
+
+// appendLabelTags appends the NIP-32 "L" and "l" tags for labels in ns.
+func appendLabelTags(tags nostr.Tags, ns string, labels []string) nostr.Tags {
+	if len(labels) == 0 {
+		return tags
+	}
+	if ns == "" {
+		ns = labelUGC
+	}
+	tags = tags.AppendUnique(nostr.Tag{"L", ns})
+	for _, l := range labels {
+		tags = tags.AppendUnique(nostr.Tag{"l", l, ns})
+	}
+	return tags
+}
+
+// buildLabelEvent constructs an unsigned kind 1985 label event attaching
+// labels in ns to targets.
+func buildLabelEvent(pubkey, ns string, labels []string, targets nostr.Tags, content string, createdAt nostr.Timestamp) (*nostr.Event, error) {
+	if len(labels) == 0 {
+		return nil, errors.New("no label given")
+	}
+	if len(targets) == 0 {
+		return nil, errors.New("no target given")
+	}
+	ev := &nostr.Event{
+		PubKey:    pubkey,
+		CreatedAt: createdAt,
+		Kind:      nostr.KindLabel,
+		Content:   content,
+		Tags:      nostr.Tags{},
+	}
+	clientTag(ev)
+	ev.Tags = appendLabelTags(ev.Tags, ns, labels)
+	for _, t := range targets {
+		ev.Tags = ev.Tags.AppendUnique(t)
+	}
+	return ev, nil
+}
+
+// eventLabels returns the labels an event carries, optionally restricted to
+// namespaces. An "l" tag without a mark belongs to the ugc namespace.
+func eventLabels(ev *nostr.Event, namespaces []string) []labelValue {
+	var out []labelValue
+	for tag := range ev.Tags.FindAll("l") {
+		ns := labelUGC
+		if len(tag) >= 3 && tag[2] != "" {
+			ns = tag[2]
+		}
+		if len(namespaces) > 0 && !slices.Contains(namespaces, ns) {
+			continue
+		}
+		out = append(out, labelValue{NS: ns, Value: tag[1]})
+	}
+	return out
+}
+
+// indexLabels maps kind 1985 label events to the event ids and pubkeys they
+// target. A "p" tag only counts as a target when the label event does not
+// also point at events, where it merely names their author.
+func indexLabels(labelEvs []*nostr.Event, namespaces []string) (byEvent, byPubkey map[string][]labelValue) {
+	byEvent = map[string][]labelValue{}
+	byPubkey = map[string][]labelValue{}
+	for _, lev := range labelEvs {
+		labels := eventLabels(lev, namespaces)
+		if len(labels) == 0 {
+			continue
+		}
+		hasEvent := false
+		for tag := range lev.Tags.FindAll("e") {
+			hasEvent = true
+			byEvent[tag[1]] = appendLabels(byEvent[tag[1]], labels)
+		}
+		if hasEvent {
+			continue
+		}
+		for tag := range lev.Tags.FindAll("p") {
+			byPubkey[tag[1]] = appendLabels(byPubkey[tag[1]], labels)
+		}
+	}
+	return byEvent, byPubkey
+}
+
+func appendLabels(dst []labelValue, labels []labelValue) []labelValue {
+	for _, l := range labels {
+		if !slices.Contains(dst, l) {
+			dst = append(dst, l)
+		}
+	}
+	return dst
+}
+
+// hiddenByLabels reports whether any of labels is selected by hide.
+func hiddenByLabels(labels []labelValue, hide []string) bool {
+	for _, l := range labels {
+		for _, h := range hide {
+			if l.matches(h) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// labelAuthors returns the trusted label authors from the config as hex
+// pubkeys. They are resolved on the first call and kept for the run.
+func (cfg *Config) labelAuthors(ctx context.Context) []string {
+	if cfg.Labels == nil {
+		return nil
+	}
+	cfg.labelOnce.Do(func() {
+		for _, a := range cfg.Labels.Authors {
+			if a == "me" {
+				if _, pub, err := getSkAndPub(cfg); err == nil {
+					cfg.labelPubkeys = append(cfg.labelPubkeys, pub)
+				}
+				continue
+			}
+			if pp := sdk.InputToProfile(ctx, a); pp != nil {
+				cfg.labelPubkeys = append(cfg.labelPubkeys, pp.PublicKey)
+			} else {
+				fmt.Fprintf(os.Stderr, "labels: ignoring author '%s'\n", a)
+			}
+		}
+	})
+	return cfg.labelPubkeys
+}
+
+// applyLabels looks up labels by trusted authors for evs, remembers them for
+// printing and returns evs without the notes that should be hidden. Labels
+// the authors put on their own notes always count. Events looked up before
+// in this run are not queried again, so a timeline that applies labels
+// before it is cut to size costs PrintEvents nothing. Streamed events are
+// printed one by one with PrintEvent and are not looked up.
+func (cfg *Config) applyLabels(ctx context.Context, evs []*nostr.Event) []*nostr.Event {
+	if cfg.Labels == nil || len(evs) == 0 {
+		return evs
+	}
+	namespaces := cfg.Labels.Namespaces
+	if cfg.labels == nil {
+		cfg.labels = map[string][]labelValue{}
+	}
+	var fresh []*nostr.Event
+	for _, ev := range evs {
+		if _, ok := cfg.labels[ev.ID]; !ok {
+			fresh = append(fresh, ev)
+		}
+	}
+
+	var byEvent, byPubkey map[string][]labelValue
+	if authors := cfg.labelAuthors(ctx); len(authors) > 0 && len(fresh) > 0 {
+		ids := make([]string, 0, len(fresh))
+		pubkeys := []string{}
+		for _, ev := range fresh {
+			ids = append(ids, ev.ID)
+			if !slices.Contains(pubkeys, ev.PubKey) {
+				pubkeys = append(pubkeys, ev.PubKey)
+			}
+		}
+		filters := nostr.Filters{
+			{Kinds: []int{nostr.KindLabel}, Authors: authors, Tags: nostr.TagMap{"e": ids}},
+			{Kinds: []int{nostr.KindLabel}, Authors: authors, Tags: nostr.TagMap{"p": pubkeys}},
+		}
+		if len(namespaces) > 0 {
+			for i := range filters {
+				filters[i].Tags["L"] = namespaces
+			}
+		}
+		labelEvs, err := cfg.QueryEvents(ctx, filters)
+		if err != nil && cfg.verbose {
+			fmt.Fprintln(os.Stderr, err)
+		}
+		byEvent, byPubkey = indexLabels(labelEvs, namespaces)
+	}
+
+	for _, ev := range fresh {
+		labels := appendLabels(nil, eventLabels(ev, namespaces))
+		labels = appendLabels(labels, byEvent[ev.ID])
+		cfg.labels[ev.ID] = appendLabels(labels, byPubkey[ev.PubKey])
+	}
+	out := evs[:0:0]
+	for _, ev := range evs {
+		if !hiddenByLabels(cfg.labels[ev.ID], cfg.Labels.Hide) {
+			out = append(out, ev)
+		}
+	}
+	return out
+}
+
+// printLabels prints the remembered labels of ev, e.g. "[nsfw] ".
+func (cfg *Config) printLabels(ev *nostr.Event) {
+	labels := cfg.labels[ev.ID]
+	if len(labels) == 0 {
+		return
+	}
+	color.Set(color.FgHiMagenta)
+	for _, l := range labels {
+		fmt.Print("[" + l.String() + "] ")
+	}
+	color.Set(color.Reset)
+}
+
+// labelTarget returns a printable form of what a label event points at.
+func labelTarget(ev *nostr.Event) string {
+	var targets []string
+	for _, tag := range ev.Tags {
+		if len(tag) < 2 {
+			continue
+		}
+		switch tag[0] {
+		case "e":
+			if s, err := nip19.EncodeNote(tag[1]); err == nil {
+				targets = append(targets, s)
+			} else {
+				targets = append(targets, tag[1])
+			}
+		case "p":
+			if s, err := nip19.EncodePublicKey(tag[1]); err == nil {
+				targets = append(targets, s)
+			} else {
+				targets = append(targets, tag[1])
+			}
+		case "t":
+			targets = append(targets, "#"+tag[1])
+		case "a", "r":
+			targets = append(targets, tag[1])
+		}
+	}
+	return strings.Join(targets, " ")
+}
+
+func doLabel(cCtx *cli.Context) error {
+	cfg := cCtx.App.Metadata["config"].(*Config)
+	ctx := context.Background()
+
+	_, pub, err := getSkAndPub(cfg)
+	if err != nil {
+		return err
+	}
+
+	var targets nostr.Tags
+	for _, t := range cCtx.StringSlice("target") {
+		tag, err := labelTargetTag(t)
+		if err != nil {
+			return err
+		}
+		targets = append(targets, tag)
+	}
+
+	var content string
+	if cCtx.Bool("stdin") {
+		b, err := io.ReadAll(os.Stdin)
+		if err != nil {
+			return err
+		}
+		content = strings.TrimRight(string(b), "\n")
+	} else {
+		content = strings.Join(cCtx.Args().Slice(), " ")
+	}
+
+	ev, err := buildLabelEvent(pub, cCtx.String("ns"), cCtx.StringSlice("label"), targets, content, nostr.Now())
+	if err != nil {
+		return err
+	}
+	if err := cfg.signEvent(ev); err != nil {
+		return err
+	}
+	if cfg.publishEvent(ctx, ev) == 0 {
+		return errors.New("cannot label")
+	}
+	if cfg.verbose {
+		if id, err := nip19.EncodeNote(ev.ID); err == nil {
+			fmt.Println(id)
+		}
+	}
+	return nil
+}
+
+func doLabels(cCtx *cli.Context) error {
+	cfg := cCtx.App.Metadata["config"].(*Config)
+	ctx := context.Background()
+	j := cCtx.Bool("json")
+
+	ns := cCtx.String("ns")
+	filter := nostr.Filter{
+		Kinds: []int{nostr.KindLabel},
+		Tags:  nostr.TagMap{"L": []string{ns}},
+		Limit: cCtx.Int("n"),
+	}
+	if l := cCtx.StringSlice("label"); len(l) > 0 {
+		filter.Tags["l"] = l
+	}
+	for _, t := range cCtx.StringSlice("target") {
+		tag, err := labelTargetTag(t)
+		if err != nil {
+			return err
+		}
+		filter.Tags[tag[0]] = append(filter.Tags[tag[0]], tag[1])
+	}
+	for _, u := range cCtx.StringSlice("u") {
+		if u == "me" {
+			_, pub, err := getSkAndPub(cfg)
+			if err != nil {
+				return err
+			}
+			filter.Authors = append(filter.Authors, pub)
+			continue
+		}
+		pp := sdk.InputToProfile(ctx, u)
+		if pp == nil {
+			return fmt.Errorf("failed to parse pubkey from '%s'", u)
+		}
+		filter.Authors = append(filter.Authors, pp.PublicKey)
+	}
+
+	evs, err := cfg.QueryEvents(ctx, nostr.Filters{filter})
+	if err != nil {
+		return err
+	}
+	for _, ev := range evs {
+		if j {
+			json.NewEncoder(os.Stdout).Encode(ev)
+			continue
+		}
+		var names []string
+		for _, l := range eventLabels(ev, []string{ns}) {
+			names = append(names, "["+l.String()+"]")
+		}
+		fmt.Printf("%s %s: %s %s\n", ev.CreatedAt.Time().Format("2006-01-02T15:04:05"), cfg.displayName(ev.PubKey), strings.Join(names, " "), labelTarget(ev))
+		if ev.Content != "" {
+			fmt.Println("  " + ev.Content)
+		}
+	}
+	return nil
+}
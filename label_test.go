@@ -0,0 +1,173 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/nbd-wtf/go-nostr/nip19"
+)
+
+func TestLabelTargetTag(t *testing.T) {
+	npub, _ := nip19.EncodePublicKey(testPub)
+	note, _ := nip19.EncodeNote(testTargetID)
+	author, _ := nostr.GetPublicKey(nostr.GeneratePrivateKey())
+	naddr, _ := nip19.EncodeEntity(author, nostr.KindArticle, "slug", nil)
+
+	tests := []struct {
+		in   string
+		want nostr.Tag
+	}{
+		{"#Nostr", nostr.Tag{"t", "nostr"}},
+		{"https://Example.com/a#frag", nostr.Tag{"r", "https://example.com/a"}},
+		{npub, nostr.Tag{"p", testPub}},
+		{"nostr:" + note, nostr.Tag{"e", testTargetID}},
+		{testTargetID, nostr.Tag{"e", testTargetID}},
+		{naddr, nostr.Tag{"a", "30023:" + author + ":slug"}},
+	}
+	for _, tt := range tests {
+		got, err := labelTargetTag(tt.in)
+		if err != nil {
+			t.Errorf("%s: %v", tt.in, err)
+			continue
+		}
+		if len(got) != len(tt.want) || got[0] != tt.want[0] || got[1] != tt.want[1] {
+			t.Errorf("%s: got %v want %v", tt.in, got, tt.want)
+		}
+	}
+	for _, in := range []string{"#", "nothing"} {
+		if _, err := labelTargetTag(in); err == nil {
+			t.Errorf("expected error for %q", in)
+		}
+	}
+}
+
+func TestBuildLabelEvent(t *testing.T) {
+	ev, err := buildLabelEvent(testPub, "com.example.moderation", []string{"nsfw", "nsfw", "spoiler"}, nostr.Tags{{"e", testTargetID}}, "explicit", 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ev.Kind != nostr.KindLabel || ev.Content != "explicit" {
+		t.Errorf("kind=%d content=%q", ev.Kind, ev.Content)
+	}
+	if got := tagValue(ev.Tags, "L"); got != "com.example.moderation" {
+		t.Errorf("L=%q", got)
+	}
+	ls := findAllTags(ev.Tags, "l")
+	if len(ls) != 2 || ls[0][1] != "nsfw" || ls[0][2] != "com.example.moderation" {
+		t.Errorf("l tags: %v", ls)
+	}
+	if got := tagValue(ev.Tags, "e"); got != testTargetID {
+		t.Errorf("e=%q", got)
+	}
+
+	ev, err = buildLabelEvent(testPub, "", []string{"good"}, nostr.Tags{{"t", "nostr"}}, "", 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := tagValue(ev.Tags, "L"); got != labelUGC {
+		t.Errorf("default namespace=%q", got)
+	}
+
+	if _, err := buildLabelEvent(testPub, "ns", nil, nostr.Tags{{"t", "x"}}, "", 100); err == nil {
+		t.Error("expected error for no labels")
+	}
+	if _, err := buildLabelEvent(testPub, "ns", []string{"x"}, nil, "", 100); err == nil {
+		t.Error("expected error for no targets")
+	}
+}
+
+func TestIndexLabels(t *testing.T) {
+	evs := []*nostr.Event{
+		// Labels a note; the p tag only names its author.
+		{Tags: nostr.Tags{{"L", "mod"}, {"l", "nsfw", "mod"}, {"e", "note1"}, {"p", "alice"}}},
+		// Labels a pubkey.
+		{Tags: nostr.Tags{{"L", "mod"}, {"l", "bot", "mod"}, {"p", "bob"}}},
+		// Another namespace.
+		{Tags: nostr.Tags{{"L", "other"}, {"l", "x", "other"}, {"e", "note1"}}},
+	}
+	byEvent, byPubkey := indexLabels(evs, []string{"mod"})
+	if got := byEvent["note1"]; len(got) != 1 || got[0].String() != "mod:nsfw" {
+		t.Errorf("note1=%v", got)
+	}
+	if _, ok := byPubkey["alice"]; ok {
+		t.Errorf("author of a labeled note got labeled: %v", byPubkey)
+	}
+	if got := byPubkey["bob"]; len(got) != 1 || got[0].Value != "bot" {
+		t.Errorf("bob=%v", got)
+	}
+
+	byEvent, _ = indexLabels(evs, nil)
+	if got := byEvent["note1"]; len(got) != 2 {
+		t.Errorf("all namespaces: %v", got)
+	}
+}
+
+func TestHiddenByLabels(t *testing.T) {
+	labels := []labelValue{{NS: "mod", Value: "nsfw"}}
+	for hide, want := range map[string]bool{"nsfw": true, "mod:nsfw": true, "other:nsfw": false, "spam": false} {
+		if got := hiddenByLabels(labels, []string{hide}); got != want {
+			t.Errorf("hide %q: got %v want %v", hide, got, want)
+		}
+	}
+	if (labelValue{NS: labelUGC, Value: "nsfw"}).String() != "nsfw" {
+		t.Error("ugc labels should print without namespace")
+	}
+}
+
+func TestCallTimelineHidesBeforeTruncating(t *testing.T) {
+	sk := nostr.GeneratePrivateKey()
+	nsec, _ := nip19.EncodePrivateKey(sk)
+	authorSk := nostr.GeneratePrivateKey()
+	author, _ := nostr.GetPublicKey(authorSk)
+	tr, url := newTestRelay(t, nil)
+	cfg := &Config{
+		PrivateKey: nsec,
+		Relays:     map[string]Relay{url: {Read: true, Write: true}},
+		Labels:     &LabelConfig{Authors: []string{"me"}, Hide: []string{"spam"}},
+		pool:       nostr.NewSimplePool(context.Background()),
+	}
+
+	now := nostr.Now()
+	var notes []*nostr.Event
+	for i, content := range []string{"old", "mid", "new"} {
+		ev := &nostr.Event{Kind: nostr.KindTextNote, CreatedAt: now - nostr.Timestamp(10-i), Content: content}
+		if err := ev.Sign(authorSk); err != nil {
+			t.Fatal(err)
+		}
+		notes = append(notes, ev)
+		tr.store(ev)
+	}
+	label := &nostr.Event{
+		Kind:      nostr.KindLabel,
+		CreatedAt: now,
+		Tags:      nostr.Tags{{"L", labelUGC}, {"l", "spam", labelUGC}, {"e", notes[2].ID}},
+	}
+	if err := label.Sign(sk); err != nil {
+		t.Fatal(err)
+	}
+	tr.store(label)
+
+	evs, err := callTimeline(&timelineArg{ctx: context.Background(), cfg: cfg, u: author, n: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(evs) != 2 || evs[0].Content != "old" || evs[1].Content != "mid" {
+		t.Errorf("timeline=%v", evs)
+	}
+
+	// Printing the timeline reuses the labels looked up above: with the
+	// relay gone, a new query would find nothing and unhide the note.
+	tr.mu.Lock()
+	tr.events = nil
+	tr.mu.Unlock()
+	if got := cfg.applyLabels(context.Background(), notes); len(got) != 2 {
+		t.Errorf("second apply=%v", got)
+	}
+
+	// Authors are resolved once per run.
+	cfg.Labels.Authors = nil
+	if got := cfg.labelAuthors(context.Background()); len(got) != 1 {
+		t.Errorf("labelAuthors=%v", got)
+	}
+}
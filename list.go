@@ -6,12 +6,15 @@ import (
 	"errors"
 	"fmt"
 	"os"
+	"slices"
 	"strings"
 	"sync/atomic"
+	"time"
 
 	"github.com/urfave/cli/v2"
 
 	"github.com/nbd-wtf/go-nostr"
+	"github.com/nbd-wtf/go-nostr/nip04"
 	"github.com/nbd-wtf/go-nostr/nip19"
 	"github.com/nbd-wtf/go-nostr/sdk"
 )
@@ -110,6 +113,66 @@ func getSkAndPub(cfg *Config) (string, string, error) {
 	return sk, pub, nil
 }
 
+// decryptPrivateTags reads the private items of a NIP-51 list: a JSON tag
+// array in the content, encrypted to ourselves with NIP-44 or, by older
+// clients, NIP-04.
+func decryptPrivateTags(sk, pub, content string) (nostr.Tags, error) {
+	plain := strings.TrimSpace(content)
+	if plain == "" {
+		return nil, nil
+	}
+	var err error
+	if strings.Contains(plain, "?iv=") {
+		var ss []byte
+		if ss, err = nip04.ComputeSharedSecret(pub, sk); err == nil {
+			plain, err = nip04.Decrypt(plain, ss)
+		}
+	} else {
+		plain, err = decryptFromSelf(sk, pub, plain)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("cannot decrypt private items: %w", err)
+	}
+	var tags nostr.Tags
+	if err := json.Unmarshal([]byte(plain), &tags); err != nil {
+		return nil, fmt.Errorf("invalid private items: %w", err)
+	}
+	return tags, nil
+}
+
+// encryptPrivateTags is the inverse of decryptPrivateTags, always using
+// NIP-44. No private items means empty content.
+func encryptPrivateTags(sk, pub string, tags nostr.Tags) (string, error) {
+	if len(tags) == 0 {
+		return "", nil
+	}
+	b, err := json.Marshal(tags)
+	if err != nil {
+		return "", err
+	}
+	return encryptToSelf(sk, pub, string(b))
+}
+
+// listPrivateTags decrypts the private items of one of our lists.
+func listPrivateTags(cfg *Config, ev *nostr.Event) (nostr.Tags, error) {
+	sk, pub, err := getSkAndPub(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return decryptPrivateTags(sk, pub, ev.Content)
+}
+
+// appendTagOnce appends tag unless one with the same name and value is
+// already there. Unlike Tags.AppendUnique it never matches by prefix.
+func appendTagOnce(tags nostr.Tags, tag nostr.Tag) nostr.Tags {
+	for _, t := range tags {
+		if len(t) >= 2 && t[0] == tag[0] && t[1] == tag[1] {
+			return tags
+		}
+	}
+	return append(tags, tag)
+}
+
 // resolveKindAndName resolves the kind and name from --kind flag and positional args.
 // For standard lists (kind 10000-19999), name is not used.
 // For sets (kind >= 30000), the first positional arg is the name.
@@ -320,87 +383,69 @@ func callListShow(arg *listShowArg) (*nostr.Event, error) {
 	return evs[len(evs)-1], nil
 }
 
-func printListTags(cfg *Config, ev *nostr.Event) {
-	for _, tag := range ev.Tags {
-		if len(tag) < 2 {
-			continue
+// listTagLine renders one list item for display. known is false for tags
+// that are neither items nor list metadata; line is empty for metadata.
+func listTagLine(cfg *Config, tag nostr.Tag) (line string, known bool) {
+	if len(tag) < 2 {
+		return "", true
+	}
+	switch tag[0] {
+	case "p":
+		npub, _ := nip19.EncodePublicKey(tag[1])
+		profile, err := cfg.GetProfile(tag[1])
+		if err == nil && profile.Name != "" {
+			return fmt.Sprintf("%s (%s)", npub, profile.Name), true
 		}
-		switch tag[0] {
-		case "p":
-			npub, _ := nip19.EncodePublicKey(tag[1])
-			profile, err := cfg.GetProfile(tag[1])
-			if err == nil && profile.Name != "" {
-				fmt.Printf("%s (%s)\n", npub, profile.Name)
-			} else {
-				fmt.Println(npub)
-			}
-		case "e":
-			note, _ := nip19.EncodeNote(tag[1])
-			fmt.Println(note)
-		case "a":
-			fmt.Println(tag[1])
-		case "relay":
-			fmt.Println(tag[1])
-		case "t":
-			fmt.Printf("#%s\n", tag[1])
-		case "word":
-			fmt.Println(tag[1])
-		case "emoji":
-			if len(tag) >= 3 {
-				fmt.Printf(":%s: %s\n", tag[1], tag[2])
-			}
-		case "group":
-			if len(tag) >= 3 {
-				fmt.Printf("%s (%s)\n", tag[1], tag[2])
-			} else {
-				fmt.Println(tag[1])
-			}
-		case "d", "title", "image", "description", "client":
-			// skip metadata tags
-		default:
-			fmt.Printf("[%s] %s\n", tag[0], tag[1])
+		return npub, true
+	case "e":
+		note, _ := nip19.EncodeNote(tag[1])
+		return note, true
+	case "a", "relay", "word", "r":
+		return tag[1], true
+	case "t":
+		return "#" + tag[1], true
+	case "emoji":
+		if len(tag) >= 3 {
+			return fmt.Sprintf(":%s: %s", tag[1], tag[2]), true
+		}
+		return "", true
+	case "group":
+		if len(tag) >= 3 {
+			return fmt.Sprintf("%s (%s)", tag[1], tag[2]), true
+		}
+		return tag[1], true
+	case "d", "title", "image", "description", "client":
+		// skip metadata tags
+		return "", true
+	}
+	return fmt.Sprintf("[%s] %s", tag[0], tag[1]), false
+}
+
+// printListTags prints the public items followed by the private ones, which
+// are marked "(private)".
+func printListTags(cfg *Config, public, private nostr.Tags) {
+	for _, tag := range public {
+		if line, _ := listTagLine(cfg, tag); line != "" {
+			fmt.Println(line)
+		}
+	}
+	for _, tag := range private {
+		if line, _ := listTagLine(cfg, tag); line != "" {
+			fmt.Println(line + " (private)")
 		}
 	}
 }
 
-func formatListTags(cfg *Config, ev *nostr.Event) string {
+func formatListTags(cfg *Config, public, private nostr.Tags) string {
 	var sb strings.Builder
-	for _, tag := range ev.Tags {
-		if len(tag) < 2 {
-			continue
+	for _, tag := range public {
+		if line, known := listTagLine(cfg, tag); known && line != "" {
+			fmt.Fprintln(&sb, line)
 		}
-		switch tag[0] {
-		case "p":
-			npub, _ := nip19.EncodePublicKey(tag[1])
-			profile, err := cfg.GetProfile(tag[1])
-			if err == nil && profile.Name != "" {
-				fmt.Fprintf(&sb, "%s (%s)\n", npub, profile.Name)
-			} else {
-				fmt.Fprintln(&sb, npub)
-			}
-		case "e":
-			note, _ := nip19.EncodeNote(tag[1])
-			fmt.Fprintln(&sb, note)
-		case "a":
-			fmt.Fprintln(&sb, tag[1])
-		case "relay":
-			fmt.Fprintln(&sb, tag[1])
-		case "t":
-			fmt.Fprintf(&sb, "#%s\n", tag[1])
-		case "word":
-			fmt.Fprintln(&sb, tag[1])
-		case "emoji":
-			if len(tag) >= 3 {
-				fmt.Fprintf(&sb, ":%s: %s\n", tag[1], tag[2])
-			}
-		case "group":
-			if len(tag) >= 3 {
-				fmt.Fprintf(&sb, "%s (%s)\n", tag[1], tag[2])
-			} else {
-				fmt.Fprintln(&sb, tag[1])
-			}
-		case "d", "title", "image", "description", "client":
-			// skip metadata tags
+	}
+	for _, tag := range private {
+		if line, known := listTagLine(cfg, tag); known && line != "" {
+			fmt.Fprintln(&sb, line+" (private)")
 		}
 	}
 	return sb.String()
@@ -429,27 +474,76 @@ func doListShow(cCtx *cli.Context) error {
 		return err
 	}
 
+	private, err := listPrivateTags(cfg, ev)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+	}
+
 	if j {
+		// Like DMs, the content is shown decrypted: the private tag array.
+		if private != nil {
+			b, _ := json.Marshal(private)
+			ev.Content = string(b)
+		}
 		b, _ := json.Marshal(ev)
 		fmt.Println(string(b))
 		return nil
 	}
 
-	printListTags(cfg, ev)
+	printListTags(cfg, ev.Tags, private)
 	return nil
 }
 
-// listAddArg is the argument for callListAdd.
+// editListHalf adds (or with remove, drops) tags in either the public tags
+// of ev or, with private, its encrypted content. The other half is left as it
+// was.
+func editListHalf(sk, pub string, ev *nostr.Event, tags []nostr.Tag, private, remove bool) error {
+	edit := func(items nostr.Tags) nostr.Tags {
+		if !remove {
+			for _, tag := range tags {
+				items = appendTagOnce(items, tag)
+			}
+			return items
+		}
+		drop := make(map[string]bool)
+		for _, tag := range tags {
+			drop[tag[0]+":"+tag[1]] = true
+		}
+		kept := nostr.Tags{}
+		for _, tag := range items {
+			if len(tag) >= 2 && drop[tag[0]+":"+tag[1]] {
+				continue
+			}
+			kept = append(kept, tag)
+		}
+		return kept
+	}
+
+	if !private {
+		ev.Tags = edit(ev.Tags)
+		return nil
+	}
+	items, err := decryptPrivateTags(sk, pub, ev.Content)
+	if err != nil {
+		return err
+	}
+	ev.Content, err = encryptPrivateTags(sk, pub, edit(items))
+	return err
+}
+
+// listAddArg is the argument for callListAdd. With private the items go to
+// the encrypted content and the public tags are left alone, and vice versa.
 type listAddArg struct {
-	ctx   context.Context
-	cfg   *Config
-	kind  int
-	name  string
-	items []string
+	ctx     context.Context
+	cfg     *Config
+	kind    int
+	name    string
+	items   []string
+	private bool
 }
 
 func callListAdd(arg *listAddArg) error {
-	_, pub, err := getSkAndPub(arg.cfg)
+	sk, pub, err := getSkAndPub(arg.cfg)
 	if err != nil {
 		return err
 	}
@@ -491,12 +585,16 @@ func callListAdd(arg *listAddArg) error {
 	}
 
 	// Add new items
+	var tags []nostr.Tag
 	for _, item := range arg.items {
 		tag := itemToTag(item, arg.kind)
 		if tag == nil {
 			return fmt.Errorf("cannot determine tag type for %q", item)
 		}
-		ev.Tags = ev.Tags.AppendUnique(tag)
+		tags = append(tags, tag)
+	}
+	if err := editListHalf(sk, pub, &ev, tags, arg.private, false); err != nil {
+		return err
 	}
 
 	if err := arg.cfg.signEvent(&ev); err != nil {
@@ -515,6 +613,7 @@ func callListAdd(arg *listAddArg) error {
 	if success.Load() == 0 {
 		return errors.New("cannot publish list")
 	}
+	arg.cfg.forgetListSource(arg.kind, arg.name)
 	return nil
 }
 
@@ -528,25 +627,28 @@ func doListAdd(cCtx *cli.Context) error {
 	cfg := cCtx.App.Metadata["config"].(*Config)
 
 	return callListAdd(&listAddArg{
-		ctx:   context.Background(),
-		cfg:   cfg,
-		kind:  kind,
-		name:  name,
-		items: rest,
+		ctx:     context.Background(),
+		cfg:     cfg,
+		kind:    kind,
+		name:    name,
+		items:   rest,
+		private: cCtx.Bool("private"),
 	})
 }
 
-// listRemoveArg is the argument for callListRemove.
+// listRemoveArg is the argument for callListRemove. Like listAddArg, private
+// selects which half of the list is edited.
 type listRemoveArg struct {
-	ctx   context.Context
-	cfg   *Config
-	kind  int
-	name  string
-	items []string
+	ctx     context.Context
+	cfg     *Config
+	kind    int
+	name    string
+	items   []string
+	private bool
 }
 
 func callListRemove(arg *listRemoveArg) error {
-	_, pub, err := getSkAndPub(arg.cfg)
+	sk, pub, err := getSkAndPub(arg.cfg)
 	if err != nil {
 		return err
 	}
@@ -575,14 +677,13 @@ func callListRemove(arg *listRemoveArg) error {
 
 	existing := evs[len(evs)-1]
 
-	// Build set of values to remove
-	removeSet := make(map[string]struct{})
+	var tags []nostr.Tag
 	for _, item := range arg.items {
 		tag := itemToTag(item, arg.kind)
 		if tag == nil {
 			return fmt.Errorf("cannot determine tag type for %q", item)
 		}
-		removeSet[tag[0]+":"+tag[1]] = struct{}{}
+		tags = append(tags, tag)
 	}
 
 	ev := nostr.Event{}
@@ -594,14 +695,14 @@ func callListRemove(arg *listRemoveArg) error {
 	clientTag(&ev)
 
 	for _, tag := range existing.Tags {
-		if len(tag) >= 2 && tag[0] != "client" {
-			key := tag[0] + ":" + tag[1]
-			if _, ok := removeSet[key]; ok {
-				continue
-			}
+		if len(tag) > 0 && tag[0] == "client" {
+			continue
 		}
 		ev.Tags = append(ev.Tags, tag)
 	}
+	if err := editListHalf(sk, pub, &ev, tags, arg.private, true); err != nil {
+		return err
+	}
 
 	if err := arg.cfg.signEvent(&ev); err != nil {
 		return err
@@ -619,6 +720,7 @@ func callListRemove(arg *listRemoveArg) error {
 	if success.Load() == 0 {
 		return errors.New("cannot publish list")
 	}
+	arg.cfg.forgetListSource(arg.kind, arg.name)
 	return nil
 }
 
@@ -632,11 +734,12 @@ func doListRemove(cCtx *cli.Context) error {
 	cfg := cCtx.App.Metadata["config"].(*Config)
 
 	return callListRemove(&listRemoveArg{
-		ctx:   context.Background(),
-		cfg:   cfg,
-		kind:  kind,
-		name:  name,
-		items: rest,
+		ctx:     context.Background(),
+		cfg:     cfg,
+		kind:    kind,
+		name:    name,
+		items:   rest,
+		private: cCtx.Bool("private"),
 	})
 }
 
@@ -735,6 +838,16 @@ func itemToTag(item string, kind int) nostr.Tag {
 	if strings.HasPrefix(item, "#") {
 		return nostr.Tag{"t", strings.TrimPrefix(item, "#")}
 	}
+	// shortcode=url → emoji tag, 30030:<pubkey>:<name> → emoji set reference
+	if kind == kindEmojiList || kind == kindEmojiSet {
+		if name, icon, ok := strings.Cut(item, "="); ok && name != "" && icon != "" {
+			return nostr.Tag{"emoji", strings.Trim(name, ":"), icon}
+		}
+		if kind == kindEmojiList && strings.HasPrefix(item, "30030:") {
+			return nostr.Tag{"a", item}
+		}
+		return nil
+	}
 	// Infer from kind
 	switch kind {
 	case 10000: // mute list
@@ -797,21 +910,53 @@ func listCommand() *cli.Command {
 				Action:    doListShow,
 			},
 			{
-				Name:      "add",
-				Flags:     []cli.Flag{kindFlag()},
+				Name: "add",
+				Flags: []cli.Flag{
+					kindFlag(),
+					&cli.BoolFlag{Name: "private", Usage: "add to the encrypted (private) items"},
+				},
 				Usage:     "add items to a list",
-				UsageText: "algia list add [--kind <kind>] [<name>] <item> [item...]",
+				UsageText: "algia list add [--kind <kind>] [--private] [<name>] <item> [item...]",
 				ArgsUsage: "[<name>] <item> [item...]",
 				Action:    doListAdd,
 			},
 			{
-				Name:      "remove",
-				Flags:     []cli.Flag{kindFlag()},
+				Name: "remove",
+				Flags: []cli.Flag{
+					kindFlag(),
+					&cli.BoolFlag{Name: "private", Usage: "remove from the encrypted (private) items"},
+				},
 				Usage:     "remove items from a list",
-				UsageText: "algia list remove [--kind <kind>] [<name>] <item> [item...]",
+				UsageText: "algia list remove [--kind <kind>] [--private] [<name>] <item> [item...]",
 				ArgsUsage: "[<name>] <item> [item...]",
 				Action:    doListRemove,
 			},
+			{
+				Name: "export",
+				Flags: []cli.Flag{
+					kindFlag(),
+					&cli.StringFlag{Name: "format", Value: "json", Usage: "json, csv or opml"},
+					&cli.StringFlag{Name: "o", Usage: "output file (default: stdout)"},
+				},
+				Usage:     "export a list, private items included",
+				UsageText: "algia list export [--format json|csv|opml] [-o file] [<kind>] [<name>]",
+				ArgsUsage: "[<kind>] [<name>]",
+				Action:    doListExport,
+			},
+			{
+				Name: "import",
+				Flags: []cli.Flag{
+					kindFlag(),
+					&cli.StringFlag{Name: "name", Usage: "set name (default: from the file)"},
+					&cli.StringFlag{Name: "format", Usage: "json, csv or opml (default: from the file extension)"},
+					&cli.BoolFlag{Name: "dry-run", Usage: "only show what would be added"},
+					&cli.BoolFlag{Name: "yes", Aliases: []string{"y"}, Usage: "publish without asking"},
+				},
+				Usage:     "add the items of an exported list that are not in the list yet",
+				UsageText: "algia list import [--kind <kind>] [--name <name>] [--dry-run] <file>",
+				ArgsUsage: "<file>",
+				Action:    doListImport,
+			},
 			{
 				Name:      "delete",
 				Flags:     []cli.Flag{kindFlag()},
@@ -823,3 +968,101 @@ func listCommand() *cli.Command {
 		},
 	}
 }
+
+// listSource names a NIP-51 list used to feed tl/stream --list: a follow set
+// ("name" or "follow-set:name"), the interest list ("interest") or an
+// interest set ("interest:name"), or a relay set ("relay-set:name").
+type listSource struct {
+	Kind int
+	Name string
+}
+
+func parseListSource(s string) (*listSource, error) {
+	prefix, name, found := strings.Cut(s, ":")
+	if !found {
+		if s == "interest" {
+			return &listSource{Kind: 10015}, nil
+		}
+		prefix, name = "follow-set", s
+	}
+	if name == "" {
+		return nil, fmt.Errorf("list name missing in '%s'", s)
+	}
+	switch prefix {
+	case "follow-set":
+		return &listSource{Kind: 30000, Name: name}, nil
+	case "interest":
+		return &listSource{Kind: 30015, Name: name}, nil
+	case "relay-set":
+		return &listSource{Kind: 30002, Name: name}, nil
+	}
+	return nil, fmt.Errorf("unknown list '%s' (use <name>, interest, interest:<name> or relay-set:<name>)", s)
+}
+
+func (ls *listSource) key() string {
+	return fmt.Sprintf("%d:%s", ls.Kind, ls.Name)
+}
+
+// itemTag is the tag whose values the source contributes.
+func (ls *listSource) itemTag() string {
+	switch ls.Kind {
+	case 10015, 30015:
+		return "t"
+	case 30002:
+		return "relay"
+	}
+	return "p"
+}
+
+// listSourceItems collects the values of tag name from both halves of a
+// list, public first, without duplicates.
+func listSourceItems(name string, public, private nostr.Tags) []string {
+	var items []string
+	for _, tags := range []nostr.Tags{public, private} {
+		for _, tag := range tags {
+			if len(tag) >= 2 && tag[0] == name && !slices.Contains(items, tag[1]) {
+				items = append(items, tag[1])
+			}
+		}
+	}
+	return items
+}
+
+// CachedList is a list resolved for --list, kept in config and refreshed on
+// the same schedule as FollowList.
+type CachedList struct {
+	Items   []string  `json:"items"`
+	Updated time.Time `json:"updated"`
+}
+
+// resolveListSource returns the items of ls, from the config cache while it
+// is fresh. Private items count too.
+func (cfg *Config) resolveListSource(ctx context.Context, ls *listSource) ([]string, error) {
+	if cached, ok := cfg.Lists[ls.key()]; ok && time.Since(cached.Updated) < followListCacheTTL {
+		return cached.Items, nil
+	}
+	ev, err := callListShow(&listShowArg{ctx: ctx, cfg: cfg, kind: ls.Kind, name: ls.Name})
+	if err != nil {
+		return nil, err
+	}
+	private, err := listPrivateTags(cfg, ev)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+	}
+	items := listSourceItems(ls.itemTag(), ev.Tags, private)
+	if cfg.Lists == nil {
+		cfg.Lists = make(map[string]CachedList)
+	}
+	cfg.Lists[ls.key()] = CachedList{Items: items, Updated: time.Now()}
+	cfg.listsChanged = true
+	return items, nil
+}
+
+// forgetListSource drops a cached --list source after we edit the list.
+func (cfg *Config) forgetListSource(kind int, name string) {
+	key := (&listSource{Kind: kind, Name: name}).key()
+	if _, ok := cfg.Lists[key]; ok {
+		delete(cfg.Lists, key)
+		cfg.listsChanged = true
+	}
+}
@@ -1,6 +1,14 @@
 package main
 
-import "testing"
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/nbd-wtf/go-nostr/nip04"
+	"github.com/nbd-wtf/go-nostr/nip19"
+)
 
 func TestKindLabel(t *testing.T) {
 	tests := []struct {
@@ -40,3 +48,186 @@ func TestIsStandardList(t *testing.T) {
 		}
 	}
 }
+
+func TestDecryptPrivateTags(t *testing.T) {
+	sk := nostr.GeneratePrivateKey()
+	pub, _ := nostr.GetPublicKey(sk)
+	const plain = `[["p","alice"],["t","go"]]`
+
+	nip44Content, err := encryptToSelf(sk, pub, plain)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ss, _ := nip04.ComputeSharedSecret(pub, sk)
+	nip04Content, err := nip04.Encrypt(plain, ss)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, content := range map[string]string{"nip44": nip44Content, "nip04": nip04Content} {
+		tags, err := decryptPrivateTags(sk, pub, content)
+		if err != nil || len(tags) != 2 || tags[0][1] != "alice" {
+			t.Errorf("%s: tags=%v err=%v", name, tags, err)
+		}
+	}
+	if tags, err := decryptPrivateTags(sk, pub, ""); err != nil || tags != nil {
+		t.Errorf("empty: tags=%v err=%v", tags, err)
+	}
+	if _, err := decryptPrivateTags(sk, pub, "garbage"); err == nil {
+		t.Error("expected error for undecryptable content")
+	}
+}
+
+func TestEditListHalf(t *testing.T) {
+	sk := nostr.GeneratePrivateKey()
+	pub, _ := nostr.GetPublicKey(sk)
+	content, _ := encryptPrivateTags(sk, pub, nostr.Tags{{"p", "secret"}})
+	ev := &nostr.Event{Tags: nostr.Tags{{"d", "friends"}, {"p", "alice"}}, Content: content}
+
+	// A public edit leaves the private half untouched.
+	if err := editListHalf(sk, pub, ev, []nostr.Tag{{"p", "bob"}, {"p", "alice"}}, false, false); err != nil {
+		t.Fatal(err)
+	}
+	if len(findAllTags(ev.Tags, "p")) != 2 || ev.Content != content {
+		t.Errorf("tags=%v content changed=%v", ev.Tags, ev.Content != content)
+	}
+
+	// A private edit leaves the public tags untouched.
+	if err := editListHalf(sk, pub, ev, []nostr.Tag{{"p", "carol"}}, true, false); err != nil {
+		t.Fatal(err)
+	}
+	private, err := decryptPrivateTags(sk, pub, ev.Content)
+	if err != nil || len(private) != 2 || len(findAllTags(ev.Tags, "p")) != 2 {
+		t.Errorf("private=%v tags=%v err=%v", private, ev.Tags, err)
+	}
+
+	if err := editListHalf(sk, pub, ev, []nostr.Tag{{"p", "secret"}, {"p", "alice"}}, true, true); err != nil {
+		t.Fatal(err)
+	}
+	private, _ = decryptPrivateTags(sk, pub, ev.Content)
+	if len(private) != 1 || private[0][1] != "carol" || tagValue(ev.Tags, "p") != "alice" {
+		t.Errorf("after private remove: private=%v tags=%v", private, ev.Tags)
+	}
+
+	if err := editListHalf(sk, pub, ev, []nostr.Tag{{"p", "alice"}}, false, true); err != nil {
+		t.Fatal(err)
+	}
+	if ps := findAllTags(ev.Tags, "p"); len(ps) != 1 || ps[0][1] != "bob" || tagValue(ev.Tags, "d") != "friends" {
+		t.Errorf("after public remove: %v", ev.Tags)
+	}
+}
+
+func TestAppendTagOnce(t *testing.T) {
+	tags := nostr.Tags{{"t", "golang"}}
+	tags = appendTagOnce(tags, nostr.Tag{"t", "go"})
+	if len(tags) != 2 {
+		t.Errorf("prefix treated as duplicate: %v", tags)
+	}
+	if tags = appendTagOnce(tags, nostr.Tag{"t", "go"}); len(tags) != 2 {
+		t.Errorf("duplicate appended: %v", tags)
+	}
+}
+
+func TestParseListSource(t *testing.T) {
+	tests := []struct {
+		in   string
+		kind int
+		name string
+		tag  string
+	}{
+		{"friends", 30000, "friends", "p"},
+		{"follow-set:friends", 30000, "friends", "p"},
+		{"interest", 10015, "", "t"},
+		{"interest:go", 30015, "go", "t"},
+		{"relay-set:jp", 30002, "jp", "relay"},
+	}
+	for _, tt := range tests {
+		ls, err := parseListSource(tt.in)
+		if err != nil {
+			t.Errorf("%s: %v", tt.in, err)
+			continue
+		}
+		if ls.Kind != tt.kind || ls.Name != tt.name || ls.itemTag() != tt.tag {
+			t.Errorf("%s: got %+v tag %s", tt.in, ls, ls.itemTag())
+		}
+	}
+	for _, in := range []string{"relay-set:", "mute:x"} {
+		if _, err := parseListSource(in); err == nil {
+			t.Errorf("expected error for %q", in)
+		}
+	}
+}
+
+func TestListSourceItems(t *testing.T) {
+	got := listSourceItems("p",
+		nostr.Tags{{"d", "friends"}, {"p", "alice"}, {"t", "go"}, {"p", "bob"}},
+		nostr.Tags{{"p", "carol"}, {"p", "alice"}},
+	)
+	if strings.Join(got, ",") != "alice,bob,carol" {
+		t.Errorf("items=%v", got)
+	}
+}
+
+func TestForgetListSource(t *testing.T) {
+	cfg := &Config{Lists: map[string]CachedList{"30000:friends": {Items: []string{"alice"}}}}
+	cfg.forgetListSource(30000, "other")
+	if cfg.listsChanged {
+		t.Error("unrelated list marked changed")
+	}
+	cfg.forgetListSource(30000, "friends")
+	if _, ok := cfg.Lists["30000:friends"]; ok || !cfg.listsChanged {
+		t.Errorf("lists=%v changed=%v", cfg.Lists, cfg.listsChanged)
+	}
+}
+
+func TestQueryEventsKeepsPrivateList(t *testing.T) {
+	sk := nostr.GeneratePrivateKey()
+	pub, _ := nostr.GetPublicKey(sk)
+	nsec, _ := nip19.EncodePrivateKey(sk)
+	tr, url := newTestRelay(t, nil)
+	cfg := &Config{
+		PrivateKey: nsec,
+		Relays:     map[string]Relay{url: {Read: true, Bookmark: true}},
+		pool:       nostr.NewSimplePool(context.Background()),
+	}
+
+	private := nostr.Tags{{"e", strings.Repeat("1", 64)}}
+	nip44Content, err := encryptPrivateTags(sk, pub, private)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ss, _ := nip04.ComputeSharedSecret(pub, sk)
+	nip04Content, _ := nip04.Encrypt(`[["e","`+strings.Repeat("2", 64)+`"]]`, ss)
+	for _, c := range []struct{ d, content string }{{"nip44", nip44Content}, {"nip04", nip04Content}} {
+		ev := &nostr.Event{
+			Kind:      nostr.KindCategorizedBookmarksList,
+			CreatedAt: nostr.Now(),
+			Tags:      nostr.Tags{{"d", c.d}, {"e", strings.Repeat("3", 64)}},
+			Content:   c.content,
+		}
+		if err := ev.Sign(sk); err != nil {
+			t.Fatal(err)
+		}
+		tr.store(ev)
+	}
+
+	evs, err := cfg.QueryEvents(context.Background(), nostr.Filters{{Kinds: []int{nostr.KindCategorizedBookmarksList}, Authors: []string{pub}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(evs) != 2 {
+		t.Fatalf("got %d lists, want both", len(evs))
+	}
+	for _, ev := range evs {
+		tags, err := listPrivateTags(cfg, ev)
+		if err != nil {
+			t.Fatalf("%s: %v", tagValue(ev.Tags, "d"), err)
+		}
+		want := strings.Repeat("1", 64)
+		if tagValue(ev.Tags, "d") == "nip04" {
+			want = strings.Repeat("2", 64)
+		}
+		if len(tags) != 1 || tags[0][1] != want {
+			t.Errorf("%s: private=%v", tagValue(ev.Tags, "d"), tags)
+		}
+	}
+}
@@ -0,0 +1,480 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/urfave/cli/v2"
+
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/nbd-wtf/go-nostr/nip19"
+)
+
+// listDocItem is one list item in an exported list.
+type listDocItem struct {
+	Tag     nostr.Tag `json:"tag"`
+	Private bool      `json:"private,omitempty"`
+}
+
+// listDoc is the format-independent form of an exported list.
+type listDoc struct {
+	Kind  int           `json:"kind"`
+	Name  string        `json:"name,omitempty"`
+	Title string        `json:"title,omitempty"`
+	Items []listDocItem `json:"items"`
+}
+
+// listMetaTags are list tags that describe the list rather than its items.
+var listMetaTags = map[string]bool{"d": true, "title": true, "image": true, "description": true, "client": true}
+
+// newListDoc collects the items of ev, public ones first.
+func newListDoc(ev *nostr.Event, private nostr.Tags) *listDoc {
+	doc := &listDoc{
+		Kind:  ev.Kind,
+		Name:  tagValue(ev.Tags, "d"),
+		Title: tagValue(ev.Tags, "title"),
+		Items: []listDocItem{},
+	}
+	add := func(tags nostr.Tags, isPrivate bool) {
+		for _, tag := range tags {
+			if len(tag) >= 2 && !listMetaTags[tag[0]] {
+				doc.Items = append(doc.Items, listDocItem{Tag: tag, Private: isPrivate})
+			}
+		}
+	}
+	add(ev.Tags, false)
+	add(private, true)
+	return doc
+}
+
+// listItemText renders an item for people: npubs, notes and #hashtags.
+func listItemText(tag nostr.Tag) string {
+	switch tag[0] {
+	case "p":
+		if npub, err := nip19.EncodePublicKey(tag[1]); err == nil {
+			return npub
+		}
+	case "e":
+		if note, err := nip19.EncodeNote(tag[1]); err == nil {
+			return note
+		}
+	case "t":
+		return "#" + tag[1]
+	}
+	return tag[1]
+}
+
+// opmlOutline is an OPML outline. The list is the top outline and its items
+// are the children; tag, value and extra carry the tag as is.
+type opmlOutline struct {
+	Text     string        `xml:"text,attr"`
+	Kind     int           `xml:"kind,attr,omitempty"`
+	Name     string        `xml:"name,attr,omitempty"`
+	Tag      string        `xml:"tag,attr,omitempty"`
+	Value    string        `xml:"value,attr,omitempty"`
+	Extra    string        `xml:"extra,attr,omitempty"`
+	Private  bool          `xml:"private,attr,omitempty"`
+	Outlines []opmlOutline `xml:"outline"`
+}
+
+type opmlDoc struct {
+	XMLName xml.Name      `xml:"opml"`
+	Version string        `xml:"version,attr"`
+	Title   string        `xml:"head>title"`
+	Body    []opmlOutline `xml:"body>outline"`
+}
+
+var listCSVHeader = []string{"kind", "name", "tag", "value", "extra", "private"}
+
+// writeListDoc writes doc as json, csv or opml. Extra tag elements (relay
+// hints, markers) are joined with ";" in csv and opml.
+func writeListDoc(w io.Writer, format string, doc *listDoc) error {
+	switch format {
+	case "json":
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(doc)
+	case "csv":
+		cw := csv.NewWriter(w)
+		cw.Write(listCSVHeader)
+		for _, item := range doc.Items {
+			cw.Write([]string{
+				strconv.Itoa(doc.Kind),
+				doc.Name,
+				item.Tag[0],
+				item.Tag[1],
+				strings.Join(item.Tag[2:], ";"),
+				strconv.FormatBool(item.Private),
+			})
+		}
+		cw.Flush()
+		return cw.Error()
+	case "opml":
+		title := kindLabel(doc.Kind)
+		if doc.Name != "" {
+			title += " " + doc.Name
+		}
+		list := opmlOutline{Text: title, Kind: doc.Kind, Name: doc.Name}
+		if doc.Title != "" {
+			list.Text = doc.Title
+		}
+		for _, item := range doc.Items {
+			list.Outlines = append(list.Outlines, opmlOutline{
+				Text:    listItemText(item.Tag),
+				Tag:     item.Tag[0],
+				Value:   item.Tag[1],
+				Extra:   strings.Join(item.Tag[2:], ";"),
+				Private: item.Private,
+			})
+		}
+		b, err := xml.MarshalIndent(opmlDoc{Version: "2.0", Title: title, Body: []opmlOutline{list}}, "", "  ")
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintf(w, "%s%s\n", xml.Header, b)
+		return err
+	}
+	return fmt.Errorf("unknown format '%s'", format)
+}
+
+// listTagFrom builds a tag from its name, value and ";"-joined extras.
+func listTagFrom(name, value, extra string) nostr.Tag {
+	tag := nostr.Tag{name, value}
+	if extra != "" {
+		tag = append(tag, strings.Split(extra, ";")...)
+	}
+	return tag
+}
+
+// readListDoc parses a list written by writeListDoc, or written by hand: the
+// tag name may be left empty and the value given as an npub, nprofile,
+// NIP-05 address, note, URL or #hashtag, to be resolved by resolveListItem.
+// CSV needs only a value column.
+func readListDoc(r io.Reader, format string) (*listDoc, error) {
+	doc := &listDoc{}
+	switch format {
+	case "json":
+		if err := json.NewDecoder(r).Decode(doc); err != nil {
+			return nil, err
+		}
+	case "csv":
+		rows, err := csv.NewReader(r).ReadAll()
+		if err != nil {
+			return nil, err
+		}
+		if len(rows) == 0 {
+			return nil, errors.New("empty CSV")
+		}
+		col := make(map[string]int)
+		for i, h := range rows[0] {
+			col[strings.ToLower(strings.TrimSpace(h))] = i
+		}
+		if _, ok := col["value"]; !ok {
+			return nil, errors.New("CSV has no value column")
+		}
+		get := func(row []string, name string) string {
+			if i, ok := col[name]; ok && i < len(row) {
+				return strings.TrimSpace(row[i])
+			}
+			return ""
+		}
+		for _, row := range rows[1:] {
+			if doc.Kind == 0 {
+				doc.Kind, _ = strconv.Atoi(get(row, "kind"))
+			}
+			if doc.Name == "" {
+				doc.Name = get(row, "name")
+			}
+			private, _ := strconv.ParseBool(get(row, "private"))
+			doc.Items = append(doc.Items, listDocItem{
+				Tag:     listTagFrom(get(row, "tag"), get(row, "value"), get(row, "extra")),
+				Private: private,
+			})
+		}
+	case "opml":
+		var od opmlDoc
+		if err := xml.NewDecoder(r).Decode(&od); err != nil {
+			return nil, err
+		}
+		var walk func(outlines []opmlOutline)
+		walk = func(outlines []opmlOutline) {
+			for _, o := range outlines {
+				if len(o.Outlines) > 0 {
+					if doc.Kind == 0 {
+						doc.Kind, doc.Name = o.Kind, o.Name
+					}
+					walk(o.Outlines)
+					continue
+				}
+				value := o.Value
+				if value == "" {
+					value = o.Text
+				}
+				doc.Items = append(doc.Items, listDocItem{Tag: listTagFrom(o.Tag, value, o.Extra), Private: o.Private})
+			}
+		}
+		walk(od.Body)
+	default:
+		return nil, fmt.Errorf("unknown format '%s'", format)
+	}
+	return doc, nil
+}
+
+// resolveListItem turns an imported item into a canonical tag. Items without
+// a tag name, and p/e items whose value is not hex, go through itemToTag so
+// NIP-05 addresses, nprofiles and nevents resolve like they do for list add.
+func resolveListItem(tag nostr.Tag, kind int) (nostr.Tag, error) {
+	if len(tag) < 2 || strings.TrimSpace(tag[1]) == "" {
+		return nil, errors.New("item without a value")
+	}
+	name, value := tag[0], strings.TrimSpace(tag[1])
+	if name != "" && (name != "p" && name != "e" || nostr.IsValid32ByteHex(value)) {
+		return tag, nil
+	}
+	resolved := itemToTag(value, kind)
+	if resolved == nil || (name != "" && resolved[0] != name) {
+		return nil, fmt.Errorf("cannot resolve %q", value)
+	}
+	return resolved, nil
+}
+
+// listImportFormat picks the format from --format or the file extension.
+func listImportFormat(format, path string) (string, error) {
+	if format != "" {
+		return format, nil
+	}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		return "json", nil
+	case ".csv":
+		return "csv", nil
+	case ".opml", ".xml":
+		return "opml", nil
+	}
+	return "", fmt.Errorf("cannot tell the format of %s; use --format", path)
+}
+
+// listAdditions returns the items of doc not already in the list, split into
+// public and private, keeping the first of any duplicates within doc.
+func listAdditions(items []listDocItem, public, private nostr.Tags) (addPublic, addPrivate nostr.Tags) {
+	have := make(map[string]bool)
+	for _, tags := range []nostr.Tags{public, private} {
+		for _, tag := range tags {
+			if len(tag) >= 2 {
+				have[tag[0]+":"+tag[1]] = true
+			}
+		}
+	}
+	for _, item := range items {
+		key := item.Tag[0] + ":" + item.Tag[1]
+		if have[key] {
+			continue
+		}
+		have[key] = true
+		if item.Private {
+			addPrivate = append(addPrivate, item.Tag)
+		} else {
+			addPublic = append(addPublic, item.Tag)
+		}
+	}
+	return addPublic, addPrivate
+}
+
+// exportKindAndName reads "[<kind>] [<name>]" like resolveKindAndName, but
+// also takes the kind as the first argument.
+func exportKindAndName(cCtx *cli.Context) (int, string) {
+	args := cCtx.Args().Slice()
+	kind := cCtx.Int("kind")
+	if len(args) > 0 {
+		if k, err := strconv.Atoi(args[0]); err == nil {
+			kind, args = k, args[1:]
+		}
+	}
+	if kind == 0 {
+		kind = 30000
+	}
+	if isStandardList(kind) || len(args) == 0 {
+		return kind, ""
+	}
+	return kind, args[0]
+}
+
+func doListExport(cCtx *cli.Context) error {
+	kind, name := exportKindAndName(cCtx)
+	format := cCtx.String("format")
+
+	cfg := cCtx.App.Metadata["config"].(*Config)
+
+	ev, err := callListShow(&listShowArg{
+		ctx:  context.Background(),
+		cfg:  cfg,
+		kind: kind,
+		name: name,
+	})
+	if err != nil {
+		return err
+	}
+	private, err := listPrivateTags(cfg, ev)
+	if err != nil {
+		return err
+	}
+	doc := newListDoc(ev, private)
+
+	if output := cCtx.String("o"); output != "" && output != "-" {
+		f, err := os.OpenFile(output, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+		if err != nil {
+			return err
+		}
+		w := bufio.NewWriter(f)
+		err = writeListDoc(w, format, doc)
+		if err == nil {
+			err = w.Flush()
+		}
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		return err
+	}
+	return writeListDoc(os.Stdout, format, doc)
+}
+
+func doListImport(cCtx *cli.Context) error {
+	if cCtx.Args().Len() != 1 {
+		return cli.ShowSubcommandHelp(cCtx)
+	}
+	path := cCtx.Args().First()
+	format, err := listImportFormat(cCtx.String("format"), path)
+	if err != nil {
+		return err
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	doc, err := readListDoc(f, format)
+	f.Close()
+	if err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	if k := cCtx.Int("kind"); k != 0 {
+		doc.Kind = k
+	}
+	if n := cCtx.String("name"); n != "" {
+		doc.Name = n
+	}
+	if doc.Kind == 0 {
+		return errors.New("the file names no kind; use --kind")
+	}
+	if isStandardList(doc.Kind) {
+		doc.Name = ""
+	} else if doc.Name == "" {
+		return errors.New("the file names no set; use --name")
+	}
+
+	for i, item := range doc.Items {
+		tag, err := resolveListItem(item.Tag, doc.Kind)
+		if err != nil {
+			return fmt.Errorf("item %d: %w", i+1, err)
+		}
+		doc.Items[i].Tag = tag
+	}
+
+	cfg := cCtx.App.Metadata["config"].(*Config)
+	ctx := context.Background()
+
+	sk, pub, err := getSkAndPub(cfg)
+	if err != nil {
+		return err
+	}
+	// A missing list is created.
+	filter := nostr.Filter{Kinds: []int{doc.Kind}, Authors: []string{pub}, Limit: 1}
+	if !isStandardList(doc.Kind) {
+		filter.Tags = nostr.TagMap{"d": []string{doc.Name}}
+	}
+	evs, err := cfg.QueryEvents(ctx, nostr.Filters{filter})
+	if err != nil {
+		return err
+	}
+	var existing *nostr.Event
+	if len(evs) > 0 {
+		existing = evs[len(evs)-1]
+	}
+
+	ev := nostr.Event{PubKey: pub, Kind: doc.Kind, CreatedAt: nostr.Now(), Tags: nostr.Tags{}}
+	clientTag(&ev)
+	var public, private nostr.Tags
+	if existing != nil {
+		for _, tag := range existing.Tags {
+			if len(tag) > 0 && tag[0] != "client" {
+				ev.Tags = append(ev.Tags, tag)
+			}
+		}
+		ev.Content = existing.Content
+		public = existing.Tags
+		if private, err = decryptPrivateTags(sk, pub, existing.Content); err != nil {
+			return err
+		}
+	} else if !isStandardList(doc.Kind) {
+		ev.Tags = append(ev.Tags, nostr.Tag{"d", doc.Name})
+		if doc.Title != "" {
+			ev.Tags = append(ev.Tags, nostr.Tag{"title", doc.Title})
+		}
+	}
+
+	addPublic, addPrivate := listAdditions(doc.Items, public, private)
+	target := kindLabel(doc.Kind)
+	if doc.Name != "" {
+		target += " " + doc.Name
+	}
+	fmt.Printf("%s: %d of %d items are new\n", target, len(addPublic)+len(addPrivate), len(doc.Items))
+	for _, tag := range addPublic {
+		fmt.Println("+ " + listItemText(tag))
+	}
+	for _, tag := range addPrivate {
+		fmt.Println("+ " + listItemText(tag) + " (private)")
+	}
+	if len(addPublic)+len(addPrivate) == 0 || cCtx.Bool("dry-run") {
+		return nil
+	}
+	if !cCtx.Bool("yes") && !confirm("Publish?") {
+		return errors.New("canceled")
+	}
+
+	if err := editListHalf(sk, pub, &ev, addPublic, false, false); err != nil {
+		return err
+	}
+	if len(addPrivate) > 0 {
+		if err := editListHalf(sk, pub, &ev, addPrivate, true, false); err != nil {
+			return err
+		}
+	}
+	if err := cfg.signEvent(&ev); err != nil {
+		return err
+	}
+	if cfg.publishEvent(ctx, &ev) == 0 {
+		return errors.New("cannot publish list")
+	}
+	cfg.forgetListSource(doc.Kind, doc.Name)
+	return nil
+}
+
+// confirm asks a yes/no question on the terminal; anything but y/yes is no.
+func confirm(prompt string) bool {
+	fmt.Fprintf(os.Stderr, "%s [y/N] ", prompt)
+	answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+	switch strings.ToLower(strings.TrimSpace(answer)) {
+	case "y", "yes":
+		return true
+	}
+	return false
+}
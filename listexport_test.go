@@ -0,0 +1,126 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/nbd-wtf/go-nostr/nip19"
+)
+
+func testListDoc() *listDoc {
+	ev := &nostr.Event{
+		Kind: 30000,
+		Tags: nostr.Tags{{"d", "friends"}, {"title", "Friends"}, {"p", testPub, "wss://relay.example"}, {"t", "go"}, {"client", "algia"}},
+	}
+	return newListDoc(ev, nostr.Tags{{"p", testTargetID}})
+}
+
+func TestNewListDoc(t *testing.T) {
+	doc := testListDoc()
+	if doc.Kind != 30000 || doc.Name != "friends" || doc.Title != "Friends" {
+		t.Errorf("doc=%+v", doc)
+	}
+	if len(doc.Items) != 3 || doc.Items[0].Private || !doc.Items[2].Private {
+		t.Errorf("items=%+v", doc.Items)
+	}
+}
+
+func TestListDocRoundTrip(t *testing.T) {
+	for _, format := range []string{"json", "csv", "opml"} {
+		var buf bytes.Buffer
+		if err := writeListDoc(&buf, format, testListDoc()); err != nil {
+			t.Fatalf("%s: %v", format, err)
+		}
+		doc, err := readListDoc(&buf, format)
+		if err != nil {
+			t.Fatalf("%s: %v", format, err)
+		}
+		if doc.Kind != 30000 || doc.Name != "friends" || len(doc.Items) != 3 {
+			t.Errorf("%s: doc=%+v", format, doc)
+			continue
+		}
+		p := doc.Items[0].Tag
+		if len(p) != 3 || p[1] != testPub || p[2] != "wss://relay.example" {
+			t.Errorf("%s: p tag=%v", format, p)
+		}
+		if !doc.Items[2].Private || doc.Items[1].Private {
+			t.Errorf("%s: private flags=%+v", format, doc.Items)
+		}
+	}
+	if err := writeListDoc(&bytes.Buffer{}, "yaml", testListDoc()); err == nil {
+		t.Error("expected error for an unknown format")
+	}
+}
+
+func TestReadListDocByHand(t *testing.T) {
+	doc, err := readListDoc(strings.NewReader("value,private\nnpub1xyz,true\n#go,\n"), "csv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(doc.Items) != 2 || doc.Items[0].Tag[0] != "" || doc.Items[0].Tag[1] != "npub1xyz" || !doc.Items[0].Private {
+		t.Errorf("items=%+v", doc.Items)
+	}
+	if _, err := readListDoc(strings.NewReader("name\nx\n"), "csv"); err == nil {
+		t.Error("expected error for CSV without a value column")
+	}
+}
+
+func TestResolveListItem(t *testing.T) {
+	npub, _ := nip19.EncodePublicKey(testPub)
+	tests := []struct {
+		in   nostr.Tag
+		want nostr.Tag
+	}{
+		{nostr.Tag{"p", testPub}, nostr.Tag{"p", testPub}},
+		{nostr.Tag{"p", npub}, nostr.Tag{"p", testPub}},
+		{nostr.Tag{"", npub}, nostr.Tag{"p", testPub}},
+		{nostr.Tag{"", "#go"}, nostr.Tag{"t", "go"}},
+		{nostr.Tag{"relay", "wss://relay.example"}, nostr.Tag{"relay", "wss://relay.example"}},
+	}
+	for _, tt := range tests {
+		got, err := resolveListItem(tt.in, 30000)
+		if err != nil {
+			t.Errorf("%v: %v", tt.in, err)
+			continue
+		}
+		if got[0] != tt.want[0] || got[1] != tt.want[1] {
+			t.Errorf("%v: got %v want %v", tt.in, got, tt.want)
+		}
+	}
+	if _, err := resolveListItem(nostr.Tag{"e", "#go"}, 30000); err == nil {
+		t.Error("expected error for a mismatched tag")
+	}
+	if _, err := resolveListItem(nostr.Tag{"p", ""}, 30000); err == nil {
+		t.Error("expected error for an empty value")
+	}
+}
+
+func TestListAdditions(t *testing.T) {
+	items := []listDocItem{
+		{Tag: nostr.Tag{"p", "alice"}},
+		{Tag: nostr.Tag{"p", "bob"}, Private: true},
+		{Tag: nostr.Tag{"p", "carol"}},
+		{Tag: nostr.Tag{"p", "carol"}, Private: true},
+		{Tag: nostr.Tag{"t", "go"}},
+	}
+	addPublic, addPrivate := listAdditions(items, nostr.Tags{{"p", "alice"}}, nostr.Tags{{"p", "bob"}})
+	if len(addPublic) != 2 || addPublic[0][1] != "carol" || addPublic[1][1] != "go" || len(addPrivate) != 0 {
+		t.Errorf("public=%v private=%v", addPublic, addPrivate)
+	}
+}
+
+func TestListImportFormat(t *testing.T) {
+	for path, want := range map[string]string{"a.json": "json", "b.CSV": "csv", "c.opml": "opml", "d.xml": "opml"} {
+		if got, err := listImportFormat("", path); err != nil || got != want {
+			t.Errorf("%s: got %q err=%v", path, got, err)
+		}
+	}
+	if _, err := listImportFormat("", "list.txt"); err == nil {
+		t.Error("expected error for an unknown extension")
+	}
+	if got, _ := listImportFormat("csv", "list.txt"); got != "csv" {
+		t.Errorf("--format ignored: %q", got)
+	}
+}
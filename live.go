@@ -0,0 +1,378 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/fatih/color"
+	"github.com/urfave/cli/v2"
+
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/nbd-wtf/go-nostr/nip19"
+)
+
+// liveParticipant is a "p" tag of a live activity.
+type liveParticipant struct {
+	Pubkey string
+	Role   string
+}
+
+// liveActivity is the parsed form of a NIP-53 kind 30311 event.
+type liveActivity struct {
+	Event        *nostr.Event
+	Title        string
+	Summary      string
+	Status       string // planned, live or ended
+	Streaming    string
+	Starts       nostr.Timestamp
+	Current      int
+	Participants []liveParticipant
+	Relays       []string
+}
+
+// parseLiveActivity reads the tags of a kind 30311 event.
+func parseLiveActivity(ev *nostr.Event) *liveActivity {
+	la := &liveActivity{
+		Event:     ev,
+		Title:     tagValue(ev.Tags, "title"),
+		Summary:   tagValue(ev.Tags, "summary"),
+		Status:    tagValue(ev.Tags, "status"),
+		Streaming: tagValue(ev.Tags, "streaming"),
+	}
+	if n, err := strconv.ParseInt(tagValue(ev.Tags, "starts"), 10, 64); err == nil {
+		la.Starts = nostr.Timestamp(n)
+	}
+	la.Current, _ = strconv.Atoi(tagValue(ev.Tags, "current_participants"))
+	for p := range ev.Tags.FindAll("p") {
+		lp := liveParticipant{Pubkey: p[1]}
+		if len(p) >= 4 {
+			lp.Role = p[3]
+		}
+		la.Participants = append(la.Participants, lp)
+	}
+	if r := ev.Tags.Find("relays"); r != nil {
+		la.Relays = append(la.Relays, r[1:]...)
+	}
+	return la
+}
+
+// address returns the "30311:pubkey:d" coordinate of the activity.
+func (la *liveActivity) address() string {
+	return fmt.Sprintf("%d:%s:%s", la.Event.Kind, la.Event.PubKey, tagValue(la.Event.Tags, "d"))
+}
+
+// liveChatOpts captures everything buildLiveChatEvent needs.
+type liveChatOpts struct {
+	Content   string
+	Address   string // "30311:pubkey:d" of the activity
+	RelayHint string
+	ReplyID   string
+}
+
+// buildLiveChatEvent constructs an unsigned kind 1311 live chat message.
+// cfgEmojis is the configured shortcode→icon map for inline :name: emoji
+// expansion.
+func buildLiveChatEvent(pubkey string, opts liveChatOpts, cfgEmojis map[string]string, createdAt nostr.Timestamp) (*nostr.Event, error) {
+	if strings.TrimSpace(opts.Content) == "" {
+		return nil, errors.New("content is empty")
+	}
+	if opts.Address == "" {
+		return nil, errors.New("activity address is empty")
+	}
+	ev := &nostr.Event{
+		PubKey:    pubkey,
+		CreatedAt: createdAt,
+		Kind:      nostr.KindLiveChatMessage,
+		Content:   opts.Content,
+		Tags:      nostr.Tags{{"a", opts.Address, opts.RelayHint, "root"}},
+	}
+	clientTag(ev)
+	if opts.ReplyID != "" {
+		ev.Tags = append(ev.Tags, nostr.Tag{"e", opts.ReplyID, opts.RelayHint, "reply"})
+	}
+	for _, entry := range extractLinks(ev.Content) {
+		ev.Tags = ev.Tags.AppendUnique(nostr.Tag{"r", entry.text})
+	}
+	for _, entry := range extractEmojis(ev.Content) {
+		name := strings.Trim(entry.text, ":")
+		if icon, ok := cfgEmojis[name]; ok {
+			ev.Tags = ev.Tags.AppendUnique(nostr.Tag{"emoji", name, icon})
+		}
+	}
+	// One ["t", tag] per hashtag (see buildPostEvent).
+	for _, m := range extractTags(ev.Content) {
+		ev.Tags = ev.Tags.AppendUnique(nostr.Tag{"t", m.text})
+	}
+	return ev, nil
+}
+
+// fetchLiveActivity loads the activity at an naddr.
+func fetchLiveActivity(ctx context.Context, cfg *Config, id string) (*liveActivity, *nostr.EntityPointer, error) {
+	ep, err := decodeNaddr(id)
+	if err != nil {
+		return nil, nil, err
+	}
+	if ep.Kind != nostr.KindLiveEvent {
+		return nil, nil, fmt.Errorf("kind %d is not a live activity", ep.Kind)
+	}
+	evs, err := fetchAddressable(ctx, cfg, []*nostr.EntityPointer{ep})
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(evs) == 0 {
+		return nil, nil, fmt.Errorf("live activity not found: %s", id)
+	}
+	return parseLiveActivity(evs[0]), ep, nil
+}
+
+// liveRelays returns our read relays plus the relays the activity and its
+// naddr point at, deduplicated.
+func liveRelays(cfg *Config, la *liveActivity, ep *nostr.EntityPointer) []string {
+	seen := map[string]bool{}
+	var relays []string
+	add := func(urls ...string) {
+		for _, u := range urls {
+			u = nostr.NormalizeURL(u)
+			if u != "" && !seen[u] {
+				seen[u] = true
+				relays = append(relays, u)
+			}
+		}
+	}
+	for u, r := range cfg.Relays {
+		if r.Read {
+			add(u)
+		}
+	}
+	add(la.Relays...)
+	add(ep.Relays...)
+	return relays
+}
+
+func doLiveList(cCtx *cli.Context) error {
+	cfg := cCtx.App.Metadata["config"].(*Config)
+	ctx := context.Background()
+	all := cCtx.Bool("all")
+
+	_, pub, err := getSkAndPub(cfg)
+	if err != nil {
+		return err
+	}
+	follows := append([]string{pub}, cfg.FollowList...)
+	// Activities are often published by a streaming service on behalf of the
+	// host, so look for follows both as authors and as participants.
+	evs, err := cfg.QueryEvents(ctx, nostr.Filters{
+		{Kinds: []int{nostr.KindLiveEvent}, Authors: follows, Limit: cCtx.Int("n")},
+		{Kinds: []int{nostr.KindLiveEvent}, Tags: nostr.TagMap{"p": follows}, Limit: cCtx.Int("n")},
+	})
+	if err != nil {
+		return err
+	}
+
+	latest := map[string]*liveActivity{}
+	for _, ev := range evs {
+		la := parseLiveActivity(ev)
+		if cur, ok := latest[la.address()]; !ok || ev.CreatedAt > cur.Event.CreatedAt {
+			latest[la.address()] = la
+		}
+	}
+	var las []*liveActivity
+	for _, la := range latest {
+		if all || la.Status == "live" {
+			las = append(las, la)
+		}
+	}
+	sort.Slice(las, func(i, j int) bool {
+		return las[i].Starts > las[j].Starts
+	})
+
+	if cCtx.Bool("json") {
+		for _, la := range las {
+			json.NewEncoder(os.Stdout).Encode(la.Event)
+		}
+		return nil
+	}
+	for _, la := range las {
+		color.Set(color.FgHiGreen)
+		fmt.Print("[" + la.Status + "]")
+		color.Set(color.Reset)
+		fmt.Print(" ")
+		color.Set(color.FgHiRed)
+		fmt.Print(la.Title)
+		color.Set(color.Reset)
+		if la.Starts > 0 {
+			fmt.Print(" since " + la.Starts.Time().Format("2006-01-02T15:04:05"))
+		}
+		if la.Current > 0 {
+			fmt.Printf(" (%d watching)", la.Current)
+		}
+		fmt.Println()
+		if la.Summary != "" {
+			fmt.Println(la.Summary)
+		}
+		if len(la.Participants) > 0 {
+			var names []string
+			for _, p := range la.Participants {
+				name := cfg.displayName(p.Pubkey)
+				if p.Role != "" {
+					name += " (" + p.Role + ")"
+				}
+				names = append(names, name)
+			}
+			fmt.Println("with " + strings.Join(names, ", "))
+		}
+		if la.Streaming != "" {
+			fmt.Println(la.Streaming)
+		}
+		color.Set(color.FgHiBlue)
+		if s, err := nip19.EncodeEntity(la.Event.PubKey, la.Event.Kind, tagValue(la.Event.Tags, "d"), la.Relays); err == nil {
+			fmt.Println(s)
+		}
+		color.Set(color.Reset)
+		fmt.Println()
+	}
+	return nil
+}
+
+func doLiveChat(cCtx *cli.Context) error {
+	cfg := cCtx.App.Metadata["config"].(*Config)
+
+	la, ep, err := fetchLiveActivity(context.Background(), cfg, cCtx.String("id"))
+	if err != nil {
+		return err
+	}
+	relays := liveRelays(cfg, la, ep)
+	if len(relays) == 0 {
+		return errors.New("no read relays available")
+	}
+
+	cfg.StreamLive(relays, nostr.Filter{
+		Kinds: []int{nostr.KindLiveChatMessage},
+		Tags:  nostr.TagMap{"a": []string{la.address()}},
+	}, cCtx.Bool("json"))
+	return nil
+}
+
+func doLivePost(cCtx *cli.Context) error {
+	stdin := cCtx.Bool("stdin")
+	if !stdin && cCtx.Args().Len() == 0 {
+		return cli.ShowSubcommandHelp(cCtx)
+	}
+	cfg := cCtx.App.Metadata["config"].(*Config)
+	ctx := context.Background()
+
+	_, pub, err := getSkAndPub(cfg)
+	if err != nil {
+		return err
+	}
+	la, ep, err := fetchLiveActivity(ctx, cfg, cCtx.String("id"))
+	if err != nil {
+		return err
+	}
+
+	var content string
+	if stdin {
+		b, err := io.ReadAll(os.Stdin)
+		if err != nil {
+			return err
+		}
+		content = string(b)
+	} else {
+		content = strings.Join(cCtx.Args().Slice(), "\n")
+	}
+
+	var replyID string
+	if r := cCtx.String("reply"); r != "" {
+		replyID, err = resolveChannelID(r)
+		if err != nil {
+			return err
+		}
+	}
+
+	ev, err := buildLiveChatEvent(pub, liveChatOpts{
+		Content:   content,
+		Address:   la.address(),
+		RelayHint: firstRelayHint(la.Relays, firstRelayHint(ep.Relays, firstWriteRelay(cfg))),
+		ReplyID:   replyID,
+	}, cfg.emojiMap(ctx), nostr.Now())
+	if err != nil {
+		return err
+	}
+	if err := cfg.signEvent(ev); err != nil {
+		return err
+	}
+
+	// Send to our write relays and to the relays the activity reads chat from.
+	success := cfg.publishEvent(ctx, ev)
+	if len(la.Relays) > 0 {
+		for res := range cfg.pool.PublishMany(ctx, la.Relays, *ev) {
+			if res.Error != nil {
+				fmt.Fprintln(os.Stderr, res.RelayURL, res.Error)
+			} else {
+				success++
+			}
+		}
+	}
+	if success == 0 {
+		return errors.New("cannot post to live chat")
+	}
+	if cfg.verbose {
+		if id, err := nip19.EncodeNote(ev.ID); err == nil {
+			fmt.Println(id)
+		}
+	}
+	return nil
+}
+
+// liveCommand returns the "live" parent command with its subcommands (NIP-53).
+func liveCommand() *cli.Command {
+	return &cli.Command{
+		Name:  "live",
+		Usage: "live activities (NIP-53)",
+		Action: func(cCtx *cli.Context) error {
+			return cli.ShowSubcommandHelp(cCtx)
+		},
+		Subcommands: []*cli.Command{
+			{
+				Name: "list",
+				Flags: []cli.Flag{
+					&cli.BoolFlag{Name: "all", Usage: "include planned and ended activities"},
+					&cli.IntFlag{Name: "n", Value: 100, Usage: "number of items"},
+					&cli.BoolFlag{Name: "json", Usage: "output JSON"},
+				},
+				Usage:     "list live activities from follows (kind 30311)",
+				UsageText: "algia live list",
+				Action:    doLiveList,
+			},
+			{
+				Name: "chat",
+				Flags: []cli.Flag{
+					&cli.StringFlag{Name: "id", Required: true, Usage: "live activity (naddr)"},
+					&cli.BoolFlag{Name: "json", Usage: "output JSON"},
+				},
+				Usage:     "stream live chat messages (kind 1311)",
+				UsageText: "algia live chat --id [naddr]",
+				Action:    doLiveChat,
+			},
+			{
+				Name: "post",
+				Flags: []cli.Flag{
+					&cli.StringFlag{Name: "id", Required: true, Usage: "live activity (naddr)"},
+					&cli.StringFlag{Name: "reply", Usage: "reply target message id (note/nevent/hex)"},
+					&cli.BoolFlag{Name: "stdin"},
+				},
+				Usage:     "send a live chat message (kind 1311)",
+				UsageText: "algia live post --id [naddr] [message]",
+				ArgsUsage: "[message]",
+				Action:    doLivePost,
+			},
+		},
+	}
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+func TestParseLiveActivity(t *testing.T) {
+	ev := &nostr.Event{
+		PubKey: testPub,
+		Kind:   nostr.KindLiveEvent,
+		Tags: nostr.Tags{
+			{"d", "stream1"},
+			{"title", "Coding"},
+			{"status", "live"},
+			{"starts", "1700000000"},
+			{"current_participants", "42"},
+			{"p", testTargetID, "", "Host"},
+			{"p", testPub},
+			{"relays", "wss://a.example", "wss://b.example"},
+		},
+	}
+	la := parseLiveActivity(ev)
+	if la.Title != "Coding" || la.Status != "live" || la.Starts != 1700000000 || la.Current != 42 {
+		t.Errorf("parsed=%+v", la)
+	}
+	if len(la.Participants) != 2 || la.Participants[0].Role != "Host" || la.Participants[1].Role != "" {
+		t.Errorf("participants=%v", la.Participants)
+	}
+	if len(la.Relays) != 2 || la.Relays[1] != "wss://b.example" {
+		t.Errorf("relays=%v", la.Relays)
+	}
+	if got := la.address(); got != "30311:"+testPub+":stream1" {
+		t.Errorf("address=%q", got)
+	}
+}
+
+func TestBuildLiveChatEvent(t *testing.T) {
+	addr := "30311:" + testPub + ":stream1"
+	ev, err := buildLiveChatEvent(testPub, liveChatOpts{
+		Content:   "hi :wave:",
+		Address:   addr,
+		RelayHint: "wss://a.example",
+		ReplyID:   testTargetID,
+	}, map[string]string{"wave": "https://example.com/wave.png"}, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ev.Kind != nostr.KindLiveChatMessage || ev.CreatedAt != 100 {
+		t.Errorf("kind=%d created_at=%d", ev.Kind, ev.CreatedAt)
+	}
+	if a := findTag(ev.Tags, "a"); a == nil || a[1] != addr || a[2] != "wss://a.example" || a[3] != "root" {
+		t.Errorf("a tag: %v", ev.Tags)
+	}
+	if e := findTag(ev.Tags, "e"); e == nil || e[1] != testTargetID || e[3] != "reply" {
+		t.Errorf("e tag: %v", ev.Tags)
+	}
+	if e := findTag(ev.Tags, "emoji"); e == nil || e[1] != "wave" {
+		t.Errorf("emoji tag: %v", ev.Tags)
+	}
+
+	if _, err := buildLiveChatEvent(testPub, liveChatOpts{Content: " ", Address: addr}, nil, 100); err == nil {
+		t.Error("expected error for empty content")
+	}
+	if _, err := buildLiveChatEvent(testPub, liveChatOpts{Content: "x"}, nil, 100); err == nil {
+		t.Error("expected error for empty address")
+	}
+}
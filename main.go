@@ -53,17 +53,30 @@ type Relay struct {
 
 // Config is
 type Config struct {
-	Relays         map[string]Relay  `json:"relays"`
-	FollowList     []string          `json:"followList"`
-	PrivateKey     string            `json:"privatekey"`
-	Updated        time.Time         `json:"updated"`
-	Emojis         map[string]string `json:"emojis"`
-	NwcURI         string            `json:"nwc-uri"`
-	FileServers    []fileServer      `json:"file-servers"`
-	Delegation     *Delegation       `json:"delegation,omitempty"`
+	Relays         map[string]Relay      `json:"relays"`
+	FollowList     []string              `json:"followList"`
+	PrivateKey     string                `json:"privatekey"`
+	Updated        time.Time             `json:"updated"`
+	Emojis         map[string]string     `json:"emojis"`
+	NwcURI         string                `json:"nwc-uri"`
+	Wallets        map[string]string     `json:"wallets,omitempty"`
+	ZapBudget      *ZapBudget            `json:"zap-budget,omitempty"`
+	AgentBudget    *ZapBudget            `json:"agent-budget,omitempty"`
+	FileServers    []fileServer          `json:"file-servers"`
+	Delegation     *Delegation           `json:"delegation,omitempty"`
+	Labels         *LabelConfig          `json:"labels,omitempty"`
+	Lists          map[string]CachedList `json:"lists,omitempty"`
+	DMRelayCheck   *CachedList           `json:"dm-relay-check,omitempty"`
 	profiles       map[string]Profile
+	history        *eventHistory
+	ledger         *zapLedger
+	labels         map[string][]labelValue // labels applied to events this run
+	labelPubkeys   []string                // Labels.Authors resolved once per run
+	pollResults    map[string]*pollResult  // vote counts of polls printed this run
+	labelOnce      sync.Once
 	pool           *nostr.SimplePool
 	profileChanged bool
+	listsChanged   bool
 	verbose        bool
 	tempRelay      bool
 	sk             string
@@ -83,6 +96,7 @@ type Profile struct {
 	Nip05       string    `json:"nip05"`
 	Picture     string    `json:"picture"`
 	Lud16       string    `json:"lud16"`
+	Lud06       string    `json:"lud06,omitempty"`
 	DisplayName string    `json:"display_name"`
 	About       string    `json:"about"`
 	Name        string    `json:"name"`
@@ -131,6 +145,7 @@ func loadConfig(profile string) (*Config, error) {
 		profilesFp = filepath.Join(dir, "profiles-"+profile+".json")
 	}
 	os.MkdirAll(filepath.Dir(fp), 0700)
+	historyFp := filepath.Join(dir, historyFileName(profile))
 
 	b, err := os.ReadFile(fp)
 	if err != nil {
@@ -167,6 +182,8 @@ func loadConfig(profile string) (*Config, error) {
 	if cfg.FollowList == nil {
 		cfg.FollowList = []string{}
 	}
+	cfg.history = &eventHistory{path: historyFp}
+	cfg.ledger = &zapLedger{path: filepath.Join(dir, ledgerFileName(profile))}
 	// Initialize pool with read relays
 	cfg.pool = nostr.NewSimplePool(context.Background(),
 		nostr.WithAuthHandler(func(ctx context.Context, authEvent nostr.RelayEvent) error {
@@ -260,6 +277,7 @@ func (cfg *Config) CheckUpdate(profile string) (map[string]Profile, error) {
 				Authors: []string{pub},
 				Limit:   1,
 			}); ev != nil {
+				cfg.recordHistory(ev.Event)
 				rm := map[string]Relay{}
 				for _, r := range ev.Tags.GetAll([]string{"r"}) {
 					if len(r) == 2 {
@@ -308,6 +326,7 @@ func (cfg *Config) CheckUpdate(profile string) (map[string]Profile, error) {
 			Authors: []string{pub},
 			Limit:   1,
 		}); ev != nil {
+			cfg.recordHistory(ev.Event)
 			follows := []string{}
 			for _, tag := range ev.Tags {
 				if len(tag) >= 2 && tag[0] == "p" {
@@ -441,6 +460,8 @@ func (cfg *Config) GetProfile(npub string) (*Profile, error) {
 		return nil, fmt.Errorf("profile not found for %s", npub)
 	}
 
+	cfg.recordHistory(ev.Event)
+
 	var profile Profile
 	if err := json.Unmarshal([]byte(ev.Content), &profile); err != nil {
 		return nil, fmt.Errorf("failed to parse profile: %w", err)
@@ -593,8 +614,29 @@ func (cfg *Config) Decode(ev *nostr.Event, sk string, pub string) error {
 	return nil
 }
 
+// unwrapGift opens a NIP-59 gift wrap addressed to us and returns its rumor.
+// The rumor keeps its own id, which is what NIP-17 replies refer to; the
+// id is filled in when the sender left it out.
+func unwrapGift(wrap *nostr.Event, sk string) (*nostr.Event, error) {
+	rumor, err := nip59.GiftUnwrap(*wrap, func(otherpubkey, ciphertext string) (string, error) {
+		conversationKey, err := nip44.GenerateConversationKey(otherpubkey, sk)
+		if err != nil {
+			return "", err
+		}
+		return nip44.Decrypt(ciphertext, conversationKey)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if rumor.ID == "" || !rumor.CheckID() {
+		rumor.ID = rumor.GetID()
+	}
+	return &rumor, nil
+}
+
 // PrintEvents is
 func (cfg *Config) PrintEvents(evs []*nostr.Event, followsMap map[string]Profile, j, extra bool) {
+	evs = cfg.applyLabels(context.Background(), evs)
 	if j {
 		if extra {
 			var events []Event
@@ -618,6 +660,7 @@ func (cfg *Config) PrintEvents(evs []*nostr.Event, followsMap map[string]Profile
 		return
 	}
 
+	cfg.loadPollResults(context.Background(), evs)
 	for _, ev := range evs {
 		pubkey, delegated := delegationDisplayPubKey(ev)
 		profile, err := cfg.GetProfile(pubkey)
@@ -647,7 +690,11 @@ func (cfg *Config) PrintEvents(evs []*nostr.Event, followsMap map[string]Profile
 			fmt.Println(ev.ID)
 		}
 		color.Set(color.Reset)
+		cfg.printLabels(ev)
 		fmt.Println(ev.Content)
+		if ev.Kind == kindPoll {
+			printPoll(ev, cfg.pollResults[ev.ID])
+		}
 		fmt.Println()
 	}
 }
@@ -699,7 +746,12 @@ func (cfg *Config) PrintEvent(ev *nostr.Event, j, extra bool) {
 		fmt.Println(ev.ID)
 	}
 	color.Set(color.Reset)
+	cfg.printLabels(ev)
 	fmt.Println(ev.Content)
+	if ev.Kind == kindPoll {
+		cfg.loadPollResults(context.Background(), []*nostr.Event{ev})
+		printPoll(ev, cfg.pollResults[ev.ID])
+	}
 	fmt.Println()
 }
 
@@ -784,29 +836,26 @@ func (cfg *Config) QueryEvents(ctx context.Context, filters nostr.Filters) ([]*n
 		return nil, err
 	}
 
+	received := make(map[string]struct{})
 	for relayEvent := range cfg.pool.SubManyEose(ctx, relays, filters) {
 		if relayEvent.Event == nil {
 			continue
 		}
 		ev := relayEvent.Event
 
-		if _, ok := seen[ev.ID]; !ok {
-			if ev.Kind == nostr.KindEncryptedDirectMessage || ev.Kind == nostr.KindCategorizedBookmarksList {
+		if _, ok := received[ev.ID]; !ok {
+			received[ev.ID] = struct{}{}
+			// Before the content is decrypted in place below.
+			cfg.recordHistory(ev)
+			// List contents (kind 30001 included) are left as they are:
+			// they may be NIP-04 or NIP-44, see decryptPrivateTags.
+			if ev.Kind == nostr.KindEncryptedDirectMessage {
 				if err := cfg.Decode(ev, sk, pub); err != nil {
 					continue
 				}
 			} else if ev.Kind == nostr.KindGiftWrap {
-				eev, err := nip59.GiftUnwrap(*ev, func(otherpubkey, ciphertext string) (string, error) {
-					conversationKey, err := nip44.GenerateConversationKey(otherpubkey, sk)
-					if err != nil {
-						return "", err
-					}
-					return nip44.Decrypt(ciphertext, conversationKey)
-				})
-				if err == nil {
-					id := ev.ID
-					ev = &eev
-					ev.ID = id
+				if rumor, err := unwrapGift(ev, sk); err == nil {
+					ev = rumor
 				} else if cfg.verbose {
 					fmt.Fprintf(os.Stderr, "GiftUnwrap failed for event %s: %v\n", ev.ID, err)
 				}
@@ -827,6 +876,35 @@ func (cfg *Config) QueryEvents(ctx context.Context, filters nostr.Filters) ([]*n
 	return evs, nil
 }
 
+// queryRelays is QueryEvents against an explicit relay list (e.g. relays
+// named by an event's tags) instead of the configured ones. Events are
+// returned as-is, sorted by timestamp.
+func (cfg *Config) queryRelays(ctx context.Context, relays []string, filters nostr.Filters) ([]*nostr.Event, error) {
+	if len(relays) == 0 {
+		return cfg.QueryEvents(ctx, filters)
+	}
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	cfg.preAuth(ctx, relays)
+
+	seen := make(map[string]*nostr.Event)
+	for relayEvent := range cfg.pool.SubManyEose(ctx, relays, filters) {
+		if relayEvent.Event != nil {
+			seen[relayEvent.Event.ID] = relayEvent.Event
+		}
+	}
+	evs := make([]*nostr.Event, 0, len(seen))
+	for _, ev := range seen {
+		evs = append(evs, ev)
+	}
+	sort.Slice(evs, func(i, j int) bool {
+		return evs[i].CreatedAt.Time().Before(evs[j].CreatedAt.Time())
+	})
+	cfg.recordHistory(evs...)
+	return evs, nil
+}
+
 // authChallengeWait is how long to wait after connecting for a relay to send
 // its NIP-42 AUTH challenge before we sign and reply. The challenge arrives
 // unsolicited right after the connection opens; replying before it lands sends
@@ -985,22 +1063,16 @@ func (cfg *Config) StreamEvents(filters nostr.Filters, closeOnEOSE bool, callbac
 			continue
 		}
 
-		if ev.Kind == nostr.KindEncryptedDirectMessage || ev.Kind == nostr.KindCategorizedBookmarksList {
+		if ev.Kind == nostr.KindEncryptedDirectMessage {
 			if err := cfg.Decode(ev, sk, pub); err != nil {
 				continue
 			}
 		} else if ev.Kind == nostr.KindGiftWrap {
-			eev, err := nip59.GiftUnwrap(*ev, func(otherpubkey, ciphertext string) (string, error) {
-				conversationKey, err := nip44.GenerateConversationKey(otherpubkey, sk)
-				if err != nil {
-					return "", err
-				}
-				return nip44.Decrypt(ciphertext, conversationKey)
-			})
+			rumor, err := unwrapGift(ev, sk)
 			if err != nil {
 				continue
 			}
-			ev = &eev
+			ev = rumor
 		}
 		if callback(ev) == false {
 			return nil
@@ -1144,6 +1216,7 @@ func doReport(cCtx *cli.Context) error {
 	if targetEventID != "" {
 		report.Tags = append(report.Tags, nostr.Tag{"e", targetEventID})
 	}
+	report.Tags = appendLabelTags(report.Tags, cCtx.String("ns"), cCtx.StringSlice("label"))
 
 	// Sign
 	if err := cfg.signEvent(report); err != nil {
@@ -1213,6 +1286,7 @@ func main() {
 					&cli.BoolFlag{Name: "extra", Usage: "extra JSON"},
 					&cli.BoolFlag{Name: "article", Usage: "show articles"},
 					&cli.BoolFlag{Name: "global", Usage: "show global timeline"},
+					&cli.StringFlag{Name: "list", Usage: "read from a NIP-51 list: <follow-set>, interest, interest:<name> or relay-set:<name>"},
 				},
 				Action: doTimeline,
 			},
@@ -1227,6 +1301,7 @@ func main() {
 					&cli.StringFlag{Name: "reply"},
 					&cli.StringSliceFlag{Name: "tag"},
 					&cli.BoolFlag{Name: "global", Usage: "show global stream"},
+					&cli.StringFlag{Name: "list", Usage: "read from a NIP-51 list: <follow-set>, interest, interest:<name> or relay-set:<name>"},
 				},
 				Action: doStream,
 			},
@@ -1246,6 +1321,7 @@ func main() {
 					&cli.StringFlag{Name: "article-title"},
 					&cli.StringFlag{Name: "article-summary"},
 					&cli.Int64Flag{Name: "created-at", Usage: "override created_at (unix timestamp)"},
+					&cli.StringFlag{Name: "at", Usage: "schedule for later (e.g. 2026-11-01T09:00, local time); published by schedule run"},
 				},
 				Usage:     "post new note",
 				UsageText: "algia post [note text]",
@@ -1270,6 +1346,58 @@ func main() {
 				ArgsUsage: "[note text]",
 				Action:    doReply,
 			},
+			{
+				Name: "comment",
+				Flags: []cli.Flag{
+					&cli.StringFlag{Name: "on", Required: true, Usage: "target (naddr/nevent/note/URL/isbn:.../podcast:guid:.../#tag)"},
+					&cli.BoolFlag{Name: "stdin"},
+					&cli.StringFlag{Name: "sensitive"},
+				},
+				Usage:     "comment on an event or external content (NIP-22 kind 1111)",
+				UsageText: "algia comment --on [target] [comment text]",
+				HelpName:  "comment",
+				ArgsUsage: "[comment text]",
+				Action:    doComment,
+			},
+			{
+				Name: "comments",
+				Flags: []cli.Flag{
+					&cli.StringFlag{Name: "on", Required: true, Usage: "target (naddr/nevent/note/URL/isbn:.../podcast:guid:.../#tag)"},
+					&cli.IntFlag{Name: "n", Value: 300, Usage: "number of items"},
+					&cli.BoolFlag{Name: "json", Usage: "output JSON"},
+				},
+				Usage:     "show the comment tree of a target (NIP-22)",
+				UsageText: "algia comments --on [target]",
+				HelpName:  "comments",
+				Action:    doComments,
+			},
+			{
+				Name: "highlight",
+				Flags: []cli.Flag{
+					&cli.StringFlag{Name: "from", Required: true, Usage: "source (naddr/nevent/note/URL)"},
+					&cli.StringFlag{Name: "context", Usage: "surrounding text"},
+					&cli.StringFlag{Name: "comment", Usage: "your comment on the quote"},
+					&cli.BoolFlag{Name: "stdin"},
+				},
+				Usage:     "highlight a quote from an article, note or web page (NIP-84 kind 9802)",
+				UsageText: "algia highlight --from [source] [quoted text]",
+				HelpName:  "highlight",
+				ArgsUsage: "[quoted text]",
+				Action:    doHighlight,
+			},
+			{
+				Name: "highlights",
+				Flags: []cli.Flag{
+					&cli.StringSliceFlag{Name: "u", Usage: "users (npub/nprofile/hex/NIP-05, or me); default: follows"},
+					&cli.StringFlag{Name: "on", Usage: "only highlights of this source (naddr/nevent/note/URL)"},
+					&cli.IntFlag{Name: "n", Value: 30, Usage: "number of items"},
+					&cli.BoolFlag{Name: "json", Usage: "output JSON"},
+				},
+				Usage:     "show highlights (NIP-84)",
+				UsageText: "algia highlights [-u user] [--on source]",
+				HelpName:  "highlights",
+				Action:    doHighlights,
+			},
 			{
 				Name:    "repost",
 				Aliases: []string{"b"},
@@ -1408,11 +1536,36 @@ func main() {
 				Flags: []cli.Flag{
 					&cli.Uint64Flag{Name: "amount", Usage: "amount for zap", Value: 1},
 					&cli.StringFlag{Name: "comment", Usage: "comment for zap", Value: ""},
+					&cli.BoolFlag{Name: "wait", Usage: "wait for the zap receipt and check it"},
+					&cli.StringFlag{Name: "wallet", Usage: "name of the wallet in wallets (default: nwc-uri)"},
+					&cli.BoolFlag{Name: "yes", Aliases: []string{"y"}, Usage: "do not ask before zaps above confirm-above"},
 				},
 				Usage:     "zap something",
-				UsageText: "algia zap [note|npub|nevent]",
+				UsageText: "algia zap [--amount <sats>] [--comment <text>] [--wait] [--wallet <name>] [--yes] [note|npub|nevent]",
 				HelpName:  "zap",
 				Action:    doZap,
+				Subcommands: []*cli.Command{
+					{
+						Name: "list",
+						Flags: []cli.Flag{
+							&cli.BoolFlag{Name: "sent", Usage: "zaps you sent"},
+							&cli.BoolFlag{Name: "received", Usage: "zaps you received (default)"},
+							&cli.StringFlag{Name: "note", Usage: "zaps on this note"},
+							&cli.StringFlag{Name: "period", Value: "month", Usage: "totals per day, week, month or year"},
+							&cli.IntFlag{Name: "n", Value: 100, Usage: "number of receipts"},
+							&cli.BoolFlag{Name: "json", Usage: "output JSON"},
+						},
+						Usage:     "show zap receipts, checked per NIP-57, with totals",
+						UsageText: "algia zap list [--sent|--received] [--note <id>] [--period day|week|month|year] [-n <count>]",
+						Action:    doZapList,
+					},
+					{
+						Name:      "budget",
+						Usage:     "show the zap budgets and what was spent",
+						UsageText: "algia zap budget",
+						Action:    doZapBudget,
+					},
+				},
 			},
 			{
 				Name: "event",
@@ -1445,21 +1598,60 @@ func main() {
 				HelpName:  "cat",
 				Action:    doCat,
 			},
+			scheduleCommand(),
+			draftCommand(),
+			pollCommand(),
+			calendarCommand(),
+			liveCommand(),
+			badgeCommand(),
 			listCommand(),
+			emojiCommand(),
+			historyCommand(),
+			walletCommand(),
 			channelCommand(),
 			groupCommand(),
+			communityCommand(),
 			{
 				Name:    "report",
 				Aliases: []string{"rep"},
 				Flags: []cli.Flag{
 					&cli.StringFlag{Name: "type", Required: true, Usage: "report type (e.g., spam, malware, scam, fake-profile)"},
 					&cli.StringFlag{Name: "id", Required: true, Usage: "event id or npub to report"},
+					&cli.StringSliceFlag{Name: "label", Usage: "NIP-32 label to attach (repeatable)"},
+					&cli.StringFlag{Name: "ns", Value: labelUGC, Usage: "NIP-32 label namespace"},
 				},
 				Usage:     "report an event or profile",
 				UsageText: "algia report --type [type] --id [id]",
 				HelpName:  "report",
 				Action:    doReport,
 			},
+			{
+				Name: "label",
+				Flags: []cli.Flag{
+					&cli.StringFlag{Name: "ns", Value: labelUGC, Usage: "label namespace (e.g. com.example.ontology, ISO-639-1)"},
+					&cli.StringSliceFlag{Name: "label", Aliases: []string{"l"}, Required: true, Usage: "label value (repeatable)"},
+					&cli.StringSliceFlag{Name: "target", Required: true, Usage: "nevent/note, npub, naddr, #hashtag or URL to label (repeatable)"},
+					&cli.BoolFlag{Name: "stdin", Usage: "read the explanation from stdin"},
+				},
+				Usage:     "label events, pubkeys or topics (NIP-32)",
+				UsageText: "algia label --ns [namespace] --label [value] --target [target] [explanation]",
+				ArgsUsage: "[explanation]",
+				Action:    doLabel,
+			},
+			{
+				Name: "labels",
+				Flags: []cli.Flag{
+					&cli.StringFlag{Name: "ns", Required: true, Usage: "label namespace"},
+					&cli.StringSliceFlag{Name: "label", Aliases: []string{"l"}, Usage: "only these label values"},
+					&cli.StringSliceFlag{Name: "target", Usage: "only labels on this target (repeatable)"},
+					&cli.StringSliceFlag{Name: "u", Usage: "only labels by this user (npub or \"me\"; repeatable)"},
+					&cli.IntFlag{Name: "n", Value: 100, Usage: "number of items"},
+					&cli.BoolFlag{Name: "json", Usage: "output JSON"},
+				},
+				Usage:     "show labels (NIP-32)",
+				UsageText: "algia labels --ns [namespace] [--target [target]]",
+				Action:    doLabels,
+			},
 			fileCommand(),
 			delegationCommand(),
 		},
@@ -1517,6 +1709,11 @@ func main() {
 			}
 			if cfg, ok := cCtx.App.Metadata["config"].(*Config); ok {
 				if profile, ok := cCtx.App.Metadata["profile"].(string); ok {
+					if cfg.listsChanged {
+						if err := cfg.saveConfig(profile); err != nil {
+							fmt.Fprintln(os.Stderr, err)
+						}
+					}
 					if err := cfg.saveProfiles(profile); err != nil {
 						fmt.Fprintln(os.Stderr, err)
 					}
@@ -4,6 +4,7 @@ import (
 	"strings"
 	"testing"
 
+	"github.com/nbd-wtf/go-nostr"
 	"github.com/nbd-wtf/go-nostr/nip19"
 )
 
@@ -74,3 +75,36 @@ func TestMissingProfilePubkeys(t *testing.T) {
 		}
 	}
 }
+
+func TestUnwrapGiftKeepsRumorID(t *testing.T) {
+	senderSk := nostr.GeneratePrivateKey()
+	sender, _ := nostr.GetPublicKey(senderSk)
+	recipientSk := nostr.GeneratePrivateKey()
+	recipient, _ := nostr.GetPublicKey(recipientSk)
+
+	rumor := nostr.Event{
+		PubKey:    sender,
+		CreatedAt: 100,
+		Kind:      nostr.KindDirectMessage,
+		Content:   "hi",
+		Tags:      nostr.Tags{{"p", recipient}},
+	}
+	wrap, err := createGiftWrap(rumor, recipient, senderSk)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := unwrapGift(&wrap, recipientSk)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.ID != rumor.GetID() || got.ID == wrap.ID {
+		t.Errorf("id=%s want rumor id %s", got.ID, rumor.GetID())
+	}
+	if got.Content != "hi" || got.PubKey != sender {
+		t.Errorf("rumor=%+v", got)
+	}
+
+	if _, err := unwrapGift(&wrap, nostr.GeneratePrivateKey()); err == nil {
+		t.Error("expected error unwrapping with the wrong key")
+	}
+}
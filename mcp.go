@@ -2,6 +2,7 @@ package main
 
 import (
 	"context"
+	"strings"
 
 	"github.com/urfave/cli/v2"
 
@@ -63,19 +64,30 @@ func doMcp(cCtx *cli.Context) error {
 	})
 
 	s.AddTool(mcp.NewTool("zap_nostr_note",
-		mcp.WithDescription("Send a Lightning zap (sats) to a note"),
+		mcp.WithDescription("Send a Lightning zap (sats) to a note. Zaps are limited by the agent budget in the config."),
 		mcp.WithString("id", mcp.Description("The event ID (hex string) of the note to zap"), mcp.Required()),
 		mcp.WithNumber("amount_sats", mcp.Description("Amount in satoshis"), mcp.Required()),
 	), func(ctx context.Context, r mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		cfg := cCtx.App.Metadata["config"].(*Config)
+		var out strings.Builder
 		err := callZap(&zapArg{
 			ctx:    ctx,
-			cfg:    cCtx.App.Metadata["config"].(*Config),
+			cfg:    cfg,
 			id:     required[string](r, "id"),
 			amount: required[uint64](r, "amount_sats"),
+			wallet: cfg.agentBudget().Wallet,
+			agent:  true,
+			out:    &out,
 		})
 		if err != nil {
+			if out.Len() > 0 {
+				return mcp.NewToolResultError(out.String() + err.Error()), nil
+			}
 			return mcp.NewToolResultError(err.Error()), nil
 		}
+		if out.Len() > 0 {
+			return mcp.NewToolResultText(out.String()), nil
+		}
 		return mcp.NewToolResultText("OK"), nil
 	})
 
@@ -97,13 +109,15 @@ func doMcp(cCtx *cli.Context) error {
 		mcp.WithDescription("Fetch the latest Nostr timeline events (notes). Returns a list of events with IDs, content, and authors. Use this to get note IDs for liking or zapping. Example: Get 10 recent events from a user's timeline."),
 		mcp.WithNumber("number", mcp.Description("Number of events to fetch (default 10)"), mcp.DefaultNumber(10)),
 		mcp.WithString("user", mcp.Description("Optional: Pubkey or npub of the user whose timeline to fetch"), mcp.DefaultString("")),
+		mcp.WithString("list", mcp.Description("Optional: read from a NIP-51 list instead of the follow list: a follow set name, \"interest\", \"interest:<name>\" or \"relay-set:<name>\""), mcp.DefaultString("")),
 		mcp.WithOutputSchema[[]*nostr.Event](),
 	), mcp.NewStructuredToolHandler(func(ctx context.Context, r mcp.CallToolRequest, arg any) ([]*nostr.Event, error) {
 		events, err := callTimeline(&timelineArg{
-			ctx: ctx,
-			cfg: cCtx.App.Metadata["config"].(*Config),
-			n:   r.GetInt("number", 10),
-			u:   r.GetString("user", ""),
+			ctx:  ctx,
+			cfg:  cCtx.App.Metadata["config"].(*Config),
+			n:    r.GetInt("number", 10),
+			u:    r.GetString("user", ""),
+			list: r.GetString("list", ""),
 		})
 		if err != nil {
 			return nil, err
@@ -207,8 +221,8 @@ func doMcp(cCtx *cli.Context) error {
 	}))
 
 	s.AddTool(mcp.NewTool("get_nostr_bookmarks",
-		mcp.WithDescription("Get the current user's bookmarked Nostr notes (NIP-51 categorized bookmarks list with d=bookmark). Returns the bookmarked text notes themselves, not the bookmark list event."),
-		mcp.WithNumber("number", mcp.Description("Max number of bookmark list events to look up (default 30)"), mcp.DefaultNumber(30)),
+		mcp.WithDescription("Get the current user's bookmarked Nostr notes and articles (NIP-51 kind 10003 bookmark list, plus the legacy kind 30001 d=bookmark list, private items included). Returns the bookmarked events themselves, not the bookmark list event."),
+		mcp.WithNumber("number", mcp.Description("Max number of bookmarked events to return (default 30)"), mcp.DefaultNumber(30)),
 		mcp.WithOutputSchema[[]*nostr.Event](),
 	), mcp.NewStructuredToolHandler(func(ctx context.Context, r mcp.CallToolRequest, arg any) ([]*nostr.Event, error) {
 		return callBookmarks(&bookmarksArg{
@@ -251,14 +265,16 @@ func doMcp(cCtx *cli.Context) error {
 		mcp.WithDescription("View the Nostr timeline in human-readable format with numbered posts. Each post includes the author name and content, with event IDs for reference."),
 		mcp.WithNumber("number", mcp.Description("Number of events to fetch (default 10)"), mcp.DefaultNumber(10)),
 		mcp.WithString("user", mcp.Description("Optional: Pubkey or npub of the user whose timeline to fetch"), mcp.DefaultString("")),
+		mcp.WithString("list", mcp.Description("Optional: read from a NIP-51 list instead of the follow list: a follow set name, \"interest\", \"interest:<name>\" or \"relay-set:<name>\""), mcp.DefaultString("")),
 	), func(ctx context.Context, r mcp.CallToolRequest) (*mcp.CallToolResult, error) {
 		n := r.GetInt("number", 10)
 		u := r.GetString("user", "")
 		events, err := callTimeline(&timelineArg{
-			ctx: ctx,
-			cfg: cCtx.App.Metadata["config"].(*Config),
-			n:   n,
-			u:   u,
+			ctx:  ctx,
+			cfg:  cCtx.App.Metadata["config"].(*Config),
+			n:    n,
+			u:    u,
+			list: r.GetString("list", ""),
 		})
 		if err != nil {
 			return mcp.NewToolResultError(err.Error()), nil
@@ -271,12 +287,22 @@ func doMcp(cCtx *cli.Context) error {
 		mcp.WithDescription("Report a Nostr note for violation. Creates a kind 1984 report event with the specified report type and target note/event ID."),
 		mcp.WithString("id", mcp.Description("The event ID (hex string) or nevent of the note to report"), mcp.Required()),
 		mcp.WithString("type", mcp.Description("Type of violation (e.g., spam, malware, scam, fake-profile, other)"), mcp.Required()),
+		mcp.WithString("labels", mcp.Description("Optional: comma separated NIP-32 labels to attach (e.g. nsfw,violence)")),
+		mcp.WithString("namespace", mcp.Description("Optional: NIP-32 namespace for labels (default: ugc)")),
 	), func(ctx context.Context, r mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		var labels []string
+		for _, l := range strings.Split(r.GetString("labels", ""), ",") {
+			if l = strings.TrimSpace(l); l != "" {
+				labels = append(labels, l)
+			}
+		}
 		err := callReport(&reportArg{
 			ctx:        ctx,
 			cfg:        cCtx.App.Metadata["config"].(*Config),
 			id:         required[string](r, "id"),
 			reportType: required[string](r, "type"),
+			labelNS:    r.GetString("namespace", labelUGC),
+			labels:     labels,
 		})
 		if err != nil {
 			return mcp.NewToolResultError(err.Error()), nil
@@ -319,7 +345,7 @@ func doMcp(cCtx *cli.Context) error {
 	}))
 
 	s.AddTool(mcp.NewTool("show_nostr_list",
-		mcp.WithDescription("Show items in a NIP-51 list or set. For standard lists (kind 10000-19999), only kind is needed. For sets (kind >= 30000), name is also required."),
+		mcp.WithDescription("Show items in a NIP-51 list or set. For standard lists (kind 10000-19999), only kind is needed. For sets (kind >= 30000), name is also required. Private (encrypted) items are decrypted and marked \"(private)\"."),
 		mcp.WithNumber("kind", mcp.Description("The list kind number (e.g. 10002 for relay list, 30000 for follow set)"), mcp.Required()),
 		mcp.WithString("name", mcp.Description("The set name (required for sets with kind >= 30000)")),
 	), func(ctx context.Context, r mcp.CallToolRequest) (*mcp.CallToolResult, error) {
@@ -335,7 +361,11 @@ func doMcp(cCtx *cli.Context) error {
 		if err != nil {
 			return mcp.NewToolResultError(err.Error()), nil
 		}
-		text := formatListTags(cfg, ev)
+		private, err := listPrivateTags(cfg, ev)
+		if err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+		text := formatListTags(cfg, ev.Tags, private)
 		return mcp.NewToolResultText(text), nil
 	})
 
@@ -344,16 +374,19 @@ func doMcp(cCtx *cli.Context) error {
 		mcp.WithNumber("kind", mcp.Description("The list kind number"), mcp.Required()),
 		mcp.WithString("name", mcp.Description("The set name (required for sets with kind >= 30000)")),
 		mcp.WithString("item", mcp.Description("The item to add (npub, note, relay URL, hashtag, etc.)"), mcp.Required()),
+		mcp.WithBoolean("private", mcp.Description("Edit the private (encrypted) items instead of the public tags")),
 	), func(ctx context.Context, r mcp.CallToolRequest) (*mcp.CallToolResult, error) {
 		kind := int(required[float64](r, "kind"))
 		name, _ := optional[string](r, "name")
 		item := required[string](r, "item")
+		private, _ := optional[bool](r, "private")
 		err := callListAdd(&listAddArg{
-			ctx:   ctx,
-			cfg:   cCtx.App.Metadata["config"].(*Config),
-			kind:  kind,
-			name:  name,
-			items: []string{item},
+			ctx:     ctx,
+			cfg:     cCtx.App.Metadata["config"].(*Config),
+			kind:    kind,
+			name:    name,
+			items:   []string{item},
+			private: private,
 		})
 		if err != nil {
 			return mcp.NewToolResultError(err.Error()), nil
@@ -366,16 +399,19 @@ func doMcp(cCtx *cli.Context) error {
 		mcp.WithNumber("kind", mcp.Description("The list kind number"), mcp.Required()),
 		mcp.WithString("name", mcp.Description("The set name (required for sets with kind >= 30000)")),
 		mcp.WithString("item", mcp.Description("The item to remove (npub, note, relay URL, hashtag, etc.)"), mcp.Required()),
+		mcp.WithBoolean("private", mcp.Description("Edit the private (encrypted) items instead of the public tags")),
 	), func(ctx context.Context, r mcp.CallToolRequest) (*mcp.CallToolResult, error) {
 		kind := int(required[float64](r, "kind"))
 		name, _ := optional[string](r, "name")
 		item := required[string](r, "item")
+		private, _ := optional[bool](r, "private")
 		err := callListRemove(&listRemoveArg{
-			ctx:   ctx,
-			cfg:   cCtx.App.Metadata["config"].(*Config),
-			kind:  kind,
-			name:  name,
-			items: []string{item},
+			ctx:     ctx,
+			cfg:     cCtx.App.Metadata["config"].(*Config),
+			kind:    kind,
+			name:    name,
+			items:   []string{item},
+			private: private,
 		})
 		if err != nil {
 			return mcp.NewToolResultError(err.Error()), nil
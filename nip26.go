@@ -146,7 +146,11 @@ func (cfg *Config) signEvent(ev *nostr.Event) error {
 		}
 		ev.Tags = append(ev.Tags, nostr.Tag{"delegation", d.Delegator, d.Conditions, d.Token})
 	}
-	return ev.Sign(sk)
+	if err := ev.Sign(sk); err != nil {
+		return err
+	}
+	cfg.recordHistory(ev)
+	return nil
 }
 
 // delegationDisplayPubKey returns the delegator's public key when ev carries
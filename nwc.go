@@ -0,0 +1,691 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/nbd-wtf/go-nostr/nip04"
+	"github.com/nbd-wtf/go-nostr/nip44"
+	"github.com/urfave/cli/v2"
+)
+
+const (
+	// nwcTimeout bounds a single wallet request, including payments.
+	nwcTimeout = 60 * time.Second
+	// nwcInfoTimeout bounds fetching the wallet's kind 13194 info event.
+	nwcInfoTimeout = 5 * time.Second
+
+	nwcEncryptionNIP44 = "nip44_v2"
+	nwcEncryptionNIP04 = "nip04"
+)
+
+// errNWCTimeout is returned when the wallet did not answer a request in
+// time. The request may still be carried out, a payment included.
+var errNWCTimeout = errors.New("no answer from the wallet")
+
+// nwcClient is a NIP-47 Nostr Wallet Connect client for one wallet service.
+type nwcClient struct {
+	walletPubkey string
+	relays       []string
+	secret       string
+	pubkey       string
+	timeout      time.Duration
+
+	relay      *nostr.Relay
+	methods    []string // from the info event
+	encryption string   // nwcEncryptionNIP44 or nwcEncryptionNIP04
+}
+
+// nwcError is the error object of a NIP-47 response.
+type nwcError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *nwcError) Error() string {
+	if e.Message == "" {
+		return e.Code
+	}
+	return e.Code + ": " + e.Message
+}
+
+type nwcRequest struct {
+	Method string `json:"method"`
+	Params any    `json:"params"`
+}
+
+type nwcResponse struct {
+	ResultType string          `json:"result_type"`
+	Error      *nwcError       `json:"error"`
+	Result     json.RawMessage `json:"result"`
+}
+
+// nwcInfo is the result of get_info.
+type nwcInfo struct {
+	Alias       string   `json:"alias"`
+	Color       string   `json:"color"`
+	Pubkey      string   `json:"pubkey"`
+	Network     string   `json:"network"`
+	BlockHeight int64    `json:"block_height"`
+	BlockHash   string   `json:"block_hash"`
+	Methods     []string `json:"methods"`
+}
+
+// nwcTransaction is an invoice or payment as returned by make_invoice,
+// lookup_invoice and list_transactions. Amounts are in msat.
+type nwcTransaction struct {
+	Type            string `json:"type"`
+	State           string `json:"state,omitempty"`
+	Invoice         string `json:"invoice,omitempty"`
+	Description     string `json:"description,omitempty"`
+	DescriptionHash string `json:"description_hash,omitempty"`
+	Preimage        string `json:"preimage,omitempty"`
+	PaymentHash     string `json:"payment_hash"`
+	Amount          int64  `json:"amount"`
+	FeesPaid        int64  `json:"fees_paid"`
+	CreatedAt       int64  `json:"created_at"`
+	ExpiresAt       int64  `json:"expires_at,omitempty"`
+	SettledAt       int64  `json:"settled_at,omitempty"`
+}
+
+// nwcPayment is the result of pay_invoice and pay_keysend.
+type nwcPayment struct {
+	Preimage string `json:"preimage"`
+	FeesPaid int64  `json:"fees_paid,omitempty"`
+}
+
+// parseNWCURI reads a nostr+walletconnect:// URI.
+func parseNWCURI(s string) (*nwcClient, error) {
+	u, err := url.Parse(s)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "nostr+walletconnect" && u.Scheme != "nostrwalletconnect" {
+		return nil, fmt.Errorf("not a wallet connect URI: %q", u.Scheme)
+	}
+	c := &nwcClient{
+		walletPubkey: u.Host,
+		relays:       u.Query()["relay"],
+		secret:       u.Query().Get("secret"),
+		timeout:      nwcTimeout,
+	}
+	if c.walletPubkey == "" {
+		c.walletPubkey = u.Opaque
+	}
+	if !nostr.IsValidPublicKey(c.walletPubkey) {
+		return nil, errors.New("wallet connect URI has an invalid wallet pubkey")
+	}
+	if len(c.relays) == 0 {
+		return nil, errors.New("wallet connect URI has no relay")
+	}
+	if c.pubkey, err = nostr.GetPublicKey(c.secret); err != nil || !nostr.IsValid32ByteHex(c.secret) {
+		return nil, errors.New("wallet connect URI has an invalid secret")
+	}
+	return c, nil
+}
+
+// walletURI returns the connection URI of the wallet called name, or of the
+// default wallet (nwc-uri) when name is empty. The default may be unset.
+func (cfg *Config) walletURI(name string) (string, error) {
+	if name == "" {
+		return cfg.NwcURI, nil
+	}
+	uri, ok := cfg.Wallets[name]
+	if !ok {
+		names := slices.Sorted(maps.Keys(cfg.Wallets))
+		if len(names) == 0 {
+			return "", fmt.Errorf("no wallet named %q: add it to wallets in the config", name)
+		}
+		return "", fmt.Errorf("no wallet named %q (have %s)", name, strings.Join(names, ", "))
+	}
+	return uri, nil
+}
+
+// wallet returns a client for the wallet called name, or the default wallet.
+func (cfg *Config) wallet(name string) (*nwcClient, error) {
+	uri, err := cfg.walletURI(name)
+	if err != nil {
+		return nil, err
+	}
+	if uri == "" {
+		return nil, errors.New("no wallet: set nwc-uri in the config")
+	}
+	return parseNWCURI(uri)
+}
+
+// connect opens the first relay that answers and reads the wallet's info
+// event to pick the encryption. Wallets that do not advertise NIP-44 get
+// NIP-04, as NIP-47 requires.
+func (c *nwcClient) connect(ctx context.Context) error {
+	if c.relay != nil {
+		return nil
+	}
+	var errs []error
+	for _, u := range c.relays {
+		relay, err := nostr.RelayConnect(ctx, u)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		c.relay = relay
+		break
+	}
+	if c.relay == nil {
+		return fmt.Errorf("cannot connect to the wallet relay: %w", errors.Join(errs...))
+	}
+
+	c.encryption = nwcEncryptionNIP04
+	ictx, cancel := context.WithTimeout(ctx, nwcInfoTimeout)
+	defer cancel()
+	evs, err := c.relay.QuerySync(ictx, nostr.Filter{
+		Kinds:   []int{nostr.KindNWCWalletInfo},
+		Authors: []string{c.walletPubkey},
+		Limit:   1,
+	})
+	if err == nil && len(evs) > 0 {
+		c.methods = strings.Fields(evs[0].Content)
+		if slices.Contains(strings.Fields(tagValue(evs[0].Tags, "encryption")), nwcEncryptionNIP44) {
+			c.encryption = nwcEncryptionNIP44
+		}
+	}
+	return nil
+}
+
+func (c *nwcClient) close() {
+	if c.relay != nil {
+		c.relay.Close()
+		c.relay = nil
+	}
+}
+
+// supports reports whether the info event lists method. Without an info
+// event every method is tried.
+func (c *nwcClient) supports(method string) bool {
+	return len(c.methods) == 0 || slices.Contains(c.methods, method)
+}
+
+func (c *nwcClient) encrypt(plain string) (string, error) {
+	if c.encryption == nwcEncryptionNIP44 {
+		key, err := nip44.GenerateConversationKey(c.walletPubkey, c.secret)
+		if err != nil {
+			return "", err
+		}
+		return nip44.Encrypt(plain, key)
+	}
+	ss, err := nip04.ComputeSharedSecret(c.walletPubkey, c.secret)
+	if err != nil {
+		return "", err
+	}
+	return nip04.Encrypt(plain, ss)
+}
+
+func (c *nwcClient) decrypt(content string) (string, error) {
+	if strings.Contains(content, "?iv=") {
+		ss, err := nip04.ComputeSharedSecret(c.walletPubkey, c.secret)
+		if err != nil {
+			return "", err
+		}
+		return nip04.Decrypt(content, ss)
+	}
+	key, err := nip44.GenerateConversationKey(c.walletPubkey, c.secret)
+	if err != nil {
+		return "", err
+	}
+	return nip44.Decrypt(content, key)
+}
+
+// call sends one request and decodes the result into result. It gives up
+// after c.timeout instead of waiting for a wallet that never answers.
+func (c *nwcClient) call(ctx context.Context, method string, params any, result any) error {
+	if err := c.connect(ctx); err != nil {
+		return err
+	}
+	if !c.supports(method) {
+		return fmt.Errorf("the wallet does not support %s", method)
+	}
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+
+	if params == nil {
+		params = struct{}{}
+	}
+	b, err := json.Marshal(nwcRequest{Method: method, Params: params})
+	if err != nil {
+		return err
+	}
+	content, err := c.encrypt(string(b))
+	if err != nil {
+		return err
+	}
+	ev := nostr.Event{
+		PubKey:    c.pubkey,
+		CreatedAt: nostr.Now(),
+		Kind:      nostr.KindNWCWalletRequest,
+		Tags:      nostr.Tags{{"p", c.walletPubkey}},
+		Content:   content,
+	}
+	if c.encryption == nwcEncryptionNIP44 {
+		ev.Tags = append(ev.Tags, nostr.Tag{"encryption", nwcEncryptionNIP44})
+	}
+	if err := ev.Sign(c.secret); err != nil {
+		return err
+	}
+
+	sub, err := c.relay.Subscribe(ctx, nostr.Filters{{
+		Kinds:   []int{nostr.KindNWCWalletResponse},
+		Authors: []string{c.walletPubkey},
+		Tags:    nostr.TagMap{"e": []string{ev.ID}},
+	}})
+	if err != nil {
+		return err
+	}
+	defer sub.Unsub()
+	if err := c.relay.Publish(ctx, ev); err != nil {
+		return err
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("%s: %w within %s", method, errNWCTimeout, c.timeout)
+		case res, ok := <-sub.Events:
+			if !ok {
+				return fmt.Errorf("%s: wallet relay closed the subscription", method)
+			}
+			plain, err := c.decrypt(res.Content)
+			if err != nil {
+				return fmt.Errorf("%s: %w", method, err)
+			}
+			var resp nwcResponse
+			if err := json.Unmarshal([]byte(plain), &resp); err != nil {
+				return fmt.Errorf("%s: %w", method, err)
+			}
+			if resp.Error != nil {
+				return resp.Error
+			}
+			if result == nil || len(resp.Result) == 0 {
+				return nil
+			}
+			return json.Unmarshal(resp.Result, result)
+		}
+	}
+}
+
+func (c *nwcClient) getInfo(ctx context.Context) (*nwcInfo, error) {
+	var info nwcInfo
+	return &info, c.call(ctx, "get_info", nil, &info)
+}
+
+// getBalance returns the balance in msat.
+func (c *nwcClient) getBalance(ctx context.Context) (int64, error) {
+	var res struct {
+		Balance int64 `json:"balance"`
+	}
+	err := c.call(ctx, "get_balance", nil, &res)
+	return res.Balance, err
+}
+
+func (c *nwcClient) makeInvoice(ctx context.Context, msat int64, description string, expiry int64) (*nwcTransaction, error) {
+	params := map[string]any{"amount": msat}
+	if description != "" {
+		params["description"] = description
+	}
+	if expiry > 0 {
+		params["expiry"] = expiry
+	}
+	var tx nwcTransaction
+	return &tx, c.call(ctx, "make_invoice", params, &tx)
+}
+
+// lookupInvoice finds an invoice by payment hash or by the invoice itself.
+func (c *nwcClient) lookupInvoice(ctx context.Context, hashOrInvoice string) (*nwcTransaction, error) {
+	params := map[string]any{"payment_hash": hashOrInvoice}
+	if strings.HasPrefix(strings.ToLower(hashOrInvoice), "ln") {
+		params = map[string]any{"invoice": hashOrInvoice}
+	}
+	var tx nwcTransaction
+	return &tx, c.call(ctx, "lookup_invoice", params, &tx)
+}
+
+type nwcListParams struct {
+	From   int64  `json:"from,omitempty"`
+	Until  int64  `json:"until,omitempty"`
+	Limit  int    `json:"limit,omitempty"`
+	Offset int    `json:"offset,omitempty"`
+	Unpaid bool   `json:"unpaid,omitempty"`
+	Type   string `json:"type,omitempty"`
+}
+
+func (c *nwcClient) listTransactions(ctx context.Context, params nwcListParams) ([]nwcTransaction, error) {
+	var res struct {
+		Transactions []nwcTransaction `json:"transactions"`
+	}
+	err := c.call(ctx, "list_transactions", params, &res)
+	return res.Transactions, err
+}
+
+// payInvoice pays a BOLT11 invoice; msat is only sent for invoices without
+// an amount.
+func (c *nwcClient) payInvoice(ctx context.Context, invoice string, msat int64) (*nwcPayment, error) {
+	params := map[string]any{"invoice": invoice}
+	if msat > 0 {
+		params["amount"] = msat
+	}
+	var res nwcPayment
+	return &res, c.call(ctx, "pay_invoice", params, &res)
+}
+
+func (c *nwcClient) payKeysend(ctx context.Context, nodePubkey string, msat int64) (*nwcPayment, error) {
+	var res nwcPayment
+	return &res, c.call(ctx, "pay_keysend", map[string]any{"amount": msat, "pubkey": nodePubkey}, &res)
+}
+
+// pay pays invoice with the wallet called name, or the default wallet, and
+// writes the result to out.
+func pay(cfg *Config, name, invoice string, out io.Writer) error {
+	w, err := cfg.wallet(name)
+	if err != nil {
+		return err
+	}
+	defer w.close()
+	res, err := w.payInvoice(context.Background(), invoice, 0)
+	if err != nil {
+		return err
+	}
+	return json.NewEncoder(out).Encode(res)
+}
+
+func walletFromContext(cCtx *cli.Context) (*nwcClient, error) {
+	cfg := cCtx.App.Metadata["config"].(*Config)
+	return cfg.wallet(cCtx.String("wallet"))
+}
+
+func printWalletJSON(v any) error {
+	return json.NewEncoder(os.Stdout).Encode(v)
+}
+
+func doWalletInfo(cCtx *cli.Context) error {
+	w, err := walletFromContext(cCtx)
+	if err != nil {
+		return err
+	}
+	defer w.close()
+	info, err := w.getInfo(context.Background())
+	if err != nil {
+		return err
+	}
+	if cCtx.Bool("json") {
+		return printWalletJSON(info)
+	}
+	fmt.Printf("alias: %s\nnetwork: %s\nnode: %s\nencryption: %s\nmethods: %s\n",
+		info.Alias, info.Network, info.Pubkey, w.encryption, strings.Join(info.Methods, " "))
+	return nil
+}
+
+func doWalletBalance(cCtx *cli.Context) error {
+	w, err := walletFromContext(cCtx)
+	if err != nil {
+		return err
+	}
+	defer w.close()
+	msat, err := w.getBalance(context.Background())
+	if err != nil {
+		return err
+	}
+	if cCtx.Bool("json") {
+		return printWalletJSON(map[string]int64{"balance": msat})
+	}
+	fmt.Printf("%d sats\n", msat/1000)
+	return nil
+}
+
+func doWalletInvoice(cCtx *cli.Context) error {
+	w, err := walletFromContext(cCtx)
+	if err != nil {
+		return err
+	}
+	defer w.close()
+	ctx := context.Background()
+
+	var tx *nwcTransaction
+	if lookup := cCtx.String("lookup"); lookup != "" {
+		tx, err = w.lookupInvoice(ctx, lookup)
+	} else {
+		if cCtx.Args().Len() != 1 {
+			return cli.ShowSubcommandHelp(cCtx)
+		}
+		var sats int64
+		if _, err := fmt.Sscan(cCtx.Args().First(), &sats); err != nil || sats <= 0 {
+			return fmt.Errorf("invalid amount %q", cCtx.Args().First())
+		}
+		tx, err = w.makeInvoice(ctx, sats*1000, cCtx.String("description"), int64(cCtx.Duration("expiry").Seconds()))
+	}
+	if err != nil {
+		return err
+	}
+	if cCtx.Bool("json") {
+		return printWalletJSON(tx)
+	}
+	printWalletTransaction(tx)
+	if tx.Invoice != "" {
+		fmt.Println(tx.Invoice)
+	}
+	return nil
+}
+
+func doWalletPay(cCtx *cli.Context) error {
+	if cCtx.Args().Len() != 1 {
+		return cli.ShowSubcommandHelp(cCtx)
+	}
+	w, err := walletFromContext(cCtx)
+	if err != nil {
+		return err
+	}
+	defer w.close()
+	cfg := cCtx.App.Metadata["config"].(*Config)
+	res, err := cfg.walletPay(context.Background(), w, walletPayArg{
+		wallet:  cCtx.String("wallet"),
+		target:  cCtx.Args().First(),
+		sats:    int64(cCtx.Uint64("amount")),
+		keysend: cCtx.Bool("keysend"),
+		yes:     cCtx.Bool("yes"),
+	})
+	if err != nil {
+		return err
+	}
+	if cCtx.Bool("json") {
+		return printWalletJSON(res)
+	}
+	fmt.Printf("paid: preimage %s, fees %d msat\n", res.Preimage, res.FeesPaid)
+	return nil
+}
+
+// walletPayArg is the argument for walletPay.
+type walletPayArg struct {
+	wallet  string // named wallet, empty for nwc-uri
+	target  string // invoice, or node pubkey with keysend
+	sats    int64  // --amount, for keysend and invoices without one
+	keysend bool
+	yes     bool // do not ask before paying above confirm-above
+}
+
+// walletPay pays arg.target with w. Payments from a wallet share the zap
+// budget and ledger with zaps; one the wallet did not answer in time counts
+// as pending.
+func (cfg *Config) walletPay(ctx context.Context, w *nwcClient, arg walletPayArg) (*nwcPayment, error) {
+	msat := arg.sats * 1000
+	sats := arg.sats
+	if arg.keysend {
+		if msat == 0 {
+			return nil, errors.New("--amount is required for keysend")
+		}
+	} else {
+		inv, err := decodeBolt11(arg.target)
+		if err != nil {
+			return nil, err
+		}
+		if inv.MSat == 0 && msat == 0 {
+			return nil, errors.New("the invoice has no amount; use --amount")
+		}
+		if inv.MSat != 0 {
+			msat = 0
+			sats = (inv.MSat + 999) / 1000
+		}
+	}
+
+	var res *nwcPayment
+	err := cfg.spendZap(ledgerEntry{Sats: sats, Wallet: arg.wallet}, arg.yes, func(e *ledgerEntry) error {
+		var err error
+		if arg.keysend {
+			res, err = w.payKeysend(ctx, arg.target, msat)
+		} else {
+			res, err = w.payInvoice(ctx, arg.target, msat)
+		}
+		switch {
+		case err == nil:
+			e.Sats = sats
+		case errors.Is(err, errNWCTimeout):
+			// Keysend has no invoice to look up later, so it stays counted.
+			e.Sats = sats
+			if !arg.keysend {
+				e.Pending = []pendingPayment{{Invoice: arg.target, Sats: sats}}
+			}
+			return fmt.Errorf("payment pending: %w", err)
+		}
+		return err
+	})
+	return res, err
+}
+
+func printWalletTransaction(tx *nwcTransaction) {
+	at := tx.SettledAt
+	if at == 0 {
+		at = tx.CreatedAt
+	}
+	sign := "+"
+	if tx.Type == "outgoing" {
+		sign = "-"
+	}
+	state := tx.State
+	if state == "" {
+		state = "pending"
+		if tx.SettledAt != 0 {
+			state = "settled"
+		}
+	}
+	line := fmt.Sprintf("%s %s%d sats %s", time.Unix(at, 0).Format("2006-01-02 15:04"), sign, tx.Amount/1000, state)
+	if tx.FeesPaid > 0 {
+		line += fmt.Sprintf(" (fees %d msat)", tx.FeesPaid)
+	}
+	if tx.Description != "" {
+		line += "  " + tx.Description
+	}
+	fmt.Println(line)
+}
+
+func doWalletHistory(cCtx *cli.Context) error {
+	w, err := walletFromContext(cCtx)
+	if err != nil {
+		return err
+	}
+	defer w.close()
+	params := nwcListParams{
+		Limit:  cCtx.Int("n"),
+		Unpaid: cCtx.Bool("unpaid"),
+		Type:   cCtx.String("type"),
+	}
+	if d := cCtx.Duration("since"); d > 0 {
+		params.From = time.Now().Add(-d).Unix()
+	}
+	txs, err := w.listTransactions(context.Background(), params)
+	if err != nil {
+		return err
+	}
+	if cCtx.Bool("json") {
+		if txs == nil {
+			txs = []nwcTransaction{}
+		}
+		return printWalletJSON(txs)
+	}
+	for i := range txs {
+		printWalletTransaction(&txs[i])
+	}
+	return nil
+}
+
+func walletCommand() *cli.Command {
+	jsonFlag := &cli.BoolFlag{Name: "json", Usage: "output JSON"}
+	walletFlag := &cli.StringFlag{Name: "wallet", Usage: "name of the wallet in wallets (default: nwc-uri)"}
+	return &cli.Command{
+		Name:  "wallet",
+		Usage: "Nostr Wallet Connect wallet (info/balance/invoice/pay/history)",
+		Subcommands: []*cli.Command{
+			{
+				Name:      "info",
+				Flags:     []cli.Flag{walletFlag, jsonFlag},
+				Usage:     "show the wallet's node and supported methods",
+				UsageText: "algia wallet info [--wallet <name>] [--json]",
+				Action:    doWalletInfo,
+			},
+			{
+				Name:      "balance",
+				Flags:     []cli.Flag{walletFlag, jsonFlag},
+				Usage:     "show the wallet balance",
+				UsageText: "algia wallet balance [--wallet <name>] [--json]",
+				Action:    doWalletBalance,
+			},
+			{
+				Name: "invoice",
+				Flags: []cli.Flag{
+					&cli.StringFlag{Name: "description", Usage: "invoice description"},
+					&cli.DurationFlag{Name: "expiry", Usage: "how long the invoice is valid"},
+					&cli.StringFlag{Name: "lookup", Usage: "show the invoice with this payment hash or bolt11 instead"},
+					walletFlag,
+					jsonFlag,
+				},
+				Usage:     "create an invoice, or look one up",
+				UsageText: "algia wallet invoice [--description <text>] [--expiry <duration>] <sats>\n   algia wallet invoice --lookup <payment hash|invoice>",
+				ArgsUsage: "<sats>",
+				Action:    doWalletInvoice,
+			},
+			{
+				Name: "pay",
+				Flags: []cli.Flag{
+					&cli.Uint64Flag{Name: "amount", Usage: "sats, for invoices without an amount and keysend"},
+					&cli.BoolFlag{Name: "keysend", Usage: "pay a node pubkey by keysend instead of an invoice"},
+					&cli.BoolFlag{Name: "yes", Aliases: []string{"y"}, Usage: "do not ask before payments above confirm-above"},
+					walletFlag,
+					jsonFlag,
+				},
+				Usage:     "pay an invoice or send a keysend payment",
+				UsageText: "algia wallet pay [--amount <sats>] <invoice>\n   algia wallet pay --keysend --amount <sats> <node pubkey>",
+				ArgsUsage: "<invoice>",
+				Action:    doWalletPay,
+			},
+			{
+				Name: "history",
+				Flags: []cli.Flag{
+					&cli.IntFlag{Name: "n", Value: 20, Usage: "number of transactions"},
+					&cli.DurationFlag{Name: "since", Usage: "only transactions newer than this"},
+					&cli.StringFlag{Name: "type", Usage: "incoming or outgoing"},
+					&cli.BoolFlag{Name: "unpaid", Usage: "include unpaid invoices"},
+					walletFlag,
+					jsonFlag,
+				},
+				Usage:     "list wallet transactions",
+				UsageText: "algia wallet history [-n <count>] [--since <duration>] [--type incoming|outgoing] [--unpaid]",
+				Action:    doWalletHistory,
+			},
+		},
+	}
+}
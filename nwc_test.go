@@ -0,0 +1,337 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/coder/websocket"
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/nbd-wtf/go-nostr/nip04"
+	"github.com/nbd-wtf/go-nostr/nip44"
+)
+
+// testRelay is a minimal in-memory relay. onEvent can answer a published
+// event with more events, which are stored and sent to matching subscribers.
+type testRelay struct {
+	mu      sync.Mutex
+	events  []*nostr.Event
+	subs    map[*websocket.Conn]map[string]nostr.Filters
+	onEvent func(*nostr.Event) []*nostr.Event
+}
+
+func newTestRelay(t *testing.T, onEvent func(*nostr.Event) []*nostr.Event) (*testRelay, string) {
+	t.Helper()
+	tr := &testRelay{subs: map[*websocket.Conn]map[string]nostr.Filters{}, onEvent: onEvent}
+	srv := httptest.NewServer(http.HandlerFunc(tr.serve))
+	t.Cleanup(srv.Close)
+	return tr, "ws" + strings.TrimPrefix(srv.URL, "http")
+}
+
+func (tr *testRelay) send(conn *websocket.Conn, env nostr.Envelope) {
+	b, _ := env.MarshalJSON()
+	conn.Write(context.Background(), websocket.MessageText, b)
+}
+
+func (tr *testRelay) store(evs ...*nostr.Event) {
+	tr.mu.Lock()
+	defer tr.mu.Unlock()
+	for _, ev := range evs {
+		tr.events = append(tr.events, ev)
+		for conn, subs := range tr.subs {
+			for id, filters := range subs {
+				if filters.Match(ev) {
+					tr.send(conn, &nostr.EventEnvelope{SubscriptionID: &id, Event: *ev})
+				}
+			}
+		}
+	}
+}
+
+func (tr *testRelay) serve(w http.ResponseWriter, r *http.Request) {
+	conn, err := websocket.Accept(w, r, nil)
+	if err != nil {
+		return
+	}
+	defer conn.CloseNow()
+	defer func() {
+		tr.mu.Lock()
+		delete(tr.subs, conn)
+		tr.mu.Unlock()
+	}()
+	for {
+		_, b, err := conn.Read(context.Background())
+		if err != nil {
+			return
+		}
+		switch env := nostr.ParseMessage(string(b)).(type) {
+		case *nostr.EventEnvelope:
+			ev := env.Event
+			tr.send(conn, &nostr.OKEnvelope{EventID: ev.ID, OK: true})
+			tr.store(&ev)
+			if tr.onEvent != nil {
+				tr.store(tr.onEvent(&ev)...)
+			}
+		case *nostr.ReqEnvelope:
+			tr.mu.Lock()
+			if tr.subs[conn] == nil {
+				tr.subs[conn] = map[string]nostr.Filters{}
+			}
+			tr.subs[conn][env.SubscriptionID] = env.Filters
+			for _, ev := range tr.events {
+				if env.Filters.Match(ev) {
+					tr.send(conn, &nostr.EventEnvelope{SubscriptionID: &env.SubscriptionID, Event: *ev})
+				}
+			}
+			eose := nostr.EOSEEnvelope(env.SubscriptionID)
+			tr.send(conn, &eose)
+			tr.mu.Unlock()
+		case *nostr.CloseEnvelope:
+			tr.mu.Lock()
+			delete(tr.subs[conn], string(*env))
+			tr.mu.Unlock()
+		}
+	}
+}
+
+// testWallet is a stand-in NIP-47 wallet service.
+type testWallet struct {
+	sk, pub  string
+	uri      string
+	balance  int64
+	silent   bool // never answer
+	requests []string
+	mu       sync.Mutex
+}
+
+func (tw *testWallet) infoEvent(t *testing.T, methods string, encryption string) *nostr.Event {
+	t.Helper()
+	ev := &nostr.Event{Kind: nostr.KindNWCWalletInfo, CreatedAt: nostr.Now(), Content: methods}
+	if encryption != "" {
+		ev.Tags = nostr.Tags{{"encryption", encryption}}
+	}
+	if err := ev.Sign(tw.sk); err != nil {
+		t.Fatal(err)
+	}
+	return ev
+}
+
+func (tw *testWallet) handle(ev *nostr.Event) []*nostr.Event {
+	if ev.Kind != nostr.KindNWCWalletRequest || tw.silent {
+		return nil
+	}
+	nip44Req := tagValue(ev.Tags, "encryption") == nwcEncryptionNIP44
+	var plain string
+	var err error
+	if nip44Req {
+		key, _ := nip44.GenerateConversationKey(ev.PubKey, tw.sk)
+		plain, err = nip44.Decrypt(ev.Content, key)
+	} else {
+		ss, _ := nip04.ComputeSharedSecret(ev.PubKey, tw.sk)
+		plain, err = nip04.Decrypt(ev.Content, ss)
+	}
+	if err != nil {
+		return nil
+	}
+	var req struct {
+		Method string         `json:"method"`
+		Params map[string]any `json:"params"`
+	}
+	json.Unmarshal([]byte(plain), &req)
+	tw.mu.Lock()
+	tw.requests = append(tw.requests, req.Method)
+	tw.mu.Unlock()
+
+	resp := map[string]any{"result_type": req.Method}
+	switch req.Method {
+	case "get_info":
+		resp["result"] = nwcInfo{Alias: "stand-in", Network: "regtest", Methods: []string{"get_info", "get_balance"}}
+	case "get_balance":
+		resp["result"] = map[string]any{"balance": tw.balance}
+	case "make_invoice":
+		resp["result"] = nwcTransaction{Type: "incoming", Invoice: "lnbcrt1...", PaymentHash: "hash1", Amount: int64(req.Params["amount"].(float64)), Description: req.Params["description"].(string), CreatedAt: 100}
+	case "lookup_invoice":
+		if invoice, _ := req.Params["invoice"].(string); invoice == "lnfailed" || invoice == "lnsettled" {
+			resp["result"] = nwcTransaction{Type: "outgoing", Invoice: invoice, State: strings.TrimPrefix(invoice, "ln")}
+		} else if req.Params["payment_hash"] != "hash1" {
+			resp["error"] = nwcError{Code: "NOT_FOUND", Message: "no such invoice"}
+		} else {
+			resp["result"] = nwcTransaction{Type: "incoming", PaymentHash: "hash1", Amount: 21000, SettledAt: 200}
+		}
+	case "list_transactions":
+		resp["result"] = map[string]any{"transactions": []nwcTransaction{
+			{Type: "incoming", PaymentHash: "hash1", Amount: 21000, SettledAt: 200},
+			{Type: "outgoing", PaymentHash: "hash2", Amount: 5000, FeesPaid: 10, SettledAt: 300},
+		}}
+	case "pay_invoice", "pay_keysend":
+		amount, _ := req.Params["amount"].(float64)
+		if int64(amount) > tw.balance {
+			resp["error"] = nwcError{Code: "INSUFFICIENT_BALANCE", Message: "not enough sats"}
+		} else {
+			resp["result"] = nwcPayment{Preimage: "preimage", FeesPaid: 1}
+		}
+	default:
+		resp["error"] = nwcError{Code: "NOT_IMPLEMENTED"}
+	}
+	b, _ := json.Marshal(resp)
+
+	var content string
+	if nip44Req {
+		key, _ := nip44.GenerateConversationKey(ev.PubKey, tw.sk)
+		content, _ = nip44.Encrypt(string(b), key)
+	} else {
+		ss, _ := nip04.ComputeSharedSecret(ev.PubKey, tw.sk)
+		content, _ = nip04.Encrypt(string(b), ss)
+	}
+	res := &nostr.Event{
+		Kind:      nostr.KindNWCWalletResponse,
+		CreatedAt: nostr.Now(),
+		Tags:      nostr.Tags{{"p", ev.PubKey}, {"e", ev.ID}},
+		Content:   content,
+	}
+	res.Sign(tw.sk)
+	return []*nostr.Event{res}
+}
+
+func newTestWallet(t *testing.T, methods, encryption string) (*testWallet, *nwcClient) {
+	t.Helper()
+	tw := &testWallet{sk: nostr.GeneratePrivateKey(), balance: 100_000}
+	tw.pub, _ = nostr.GetPublicKey(tw.sk)
+	tr, url := newTestRelay(t, tw.handle)
+	tr.store(tw.infoEvent(t, methods, encryption))
+
+	tw.uri = "nostr+walletconnect://" + tw.pub + "?relay=" + url + "&secret=" + nostr.GeneratePrivateKey()
+	c, err := parseNWCURI(tw.uri)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.timeout = 5 * time.Second
+	t.Cleanup(c.close)
+	return tw, c
+}
+
+func TestParseNWCURI(t *testing.T) {
+	wallet, _ := nostr.GetPublicKey(nostr.GeneratePrivateKey())
+	secret := nostr.GeneratePrivateKey()
+	c, err := parseNWCURI("nostr+walletconnect://" + wallet + "?relay=wss%3A%2F%2Fa.example&relay=wss://b.example&secret=" + secret + "&lud16=me@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.walletPubkey != wallet || len(c.relays) != 2 || c.relays[0] != "wss://a.example" || c.secret != secret || c.timeout != nwcTimeout {
+		t.Errorf("c=%+v", c)
+	}
+	for _, uri := range []string{
+		"https://" + wallet + "?relay=wss://a.example&secret=" + secret,
+		"nostr+walletconnect://" + wallet + "?secret=" + secret,
+		"nostr+walletconnect://" + wallet + "?relay=wss://a.example&secret=nope",
+		"nostr+walletconnect://nope?relay=wss://a.example&secret=" + secret,
+	} {
+		if _, err := parseNWCURI(uri); err == nil {
+			t.Errorf("%s: expected error", uri)
+		}
+	}
+}
+
+func TestNWCClientNIP44(t *testing.T) {
+	methods := "get_info get_balance make_invoice lookup_invoice list_transactions pay_invoice pay_keysend"
+	tw, c := newTestWallet(t, methods, "nip44_v2 nip04")
+	ctx := context.Background()
+
+	info, err := c.getInfo(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Alias != "stand-in" || c.encryption != nwcEncryptionNIP44 {
+		t.Errorf("info=%+v encryption=%s", info, c.encryption)
+	}
+	if msat, err := c.getBalance(ctx); err != nil || msat != 100_000 {
+		t.Errorf("balance=%d err=%v", msat, err)
+	}
+	tx, err := c.makeInvoice(ctx, 21000, "coffee", 0)
+	if err != nil || tx.Amount != 21000 || tx.Description != "coffee" || tx.Invoice == "" {
+		t.Errorf("invoice=%+v err=%v", tx, err)
+	}
+	if tx, err := c.lookupInvoice(ctx, "hash1"); err != nil || tx.SettledAt != 200 {
+		t.Errorf("lookup=%+v err=%v", tx, err)
+	}
+	var nerr *nwcError
+	if _, err := c.lookupInvoice(ctx, "hash9"); !errors.As(err, &nerr) || nerr.Code != "NOT_FOUND" {
+		t.Errorf("lookup of a missing invoice: %v", err)
+	}
+	txs, err := c.listTransactions(ctx, nwcListParams{Limit: 10})
+	if err != nil || len(txs) != 2 || txs[1].Type != "outgoing" {
+		t.Errorf("transactions=%+v err=%v", txs, err)
+	}
+	if res, err := c.payInvoice(ctx, "lnbcrt1...", 0); err != nil || res.Preimage != "preimage" {
+		t.Errorf("pay=%+v err=%v", res, err)
+	}
+	if _, err := c.payKeysend(ctx, tw.pub, 200_000); !errors.As(err, &nerr) || nerr.Code != "INSUFFICIENT_BALANCE" {
+		t.Errorf("keysend over balance: %v", err)
+	}
+	if len(tw.requests) != 8 {
+		t.Errorf("requests=%v", tw.requests)
+	}
+}
+
+func TestNWCClientNIP04(t *testing.T) {
+	tw, c := newTestWallet(t, "get_balance pay_invoice", "")
+	msat, err := c.getBalance(context.Background())
+	if err != nil || msat != tw.balance {
+		t.Fatalf("balance=%d err=%v", msat, err)
+	}
+	if c.encryption != nwcEncryptionNIP04 {
+		t.Errorf("encryption=%s", c.encryption)
+	}
+	// Methods missing from the info event are not sent at all.
+	if _, err := c.getInfo(context.Background()); err == nil || !strings.Contains(err.Error(), "does not support") {
+		t.Errorf("get_info: %v", err)
+	}
+	if len(tw.requests) != 1 {
+		t.Errorf("requests=%v", tw.requests)
+	}
+}
+
+func TestNWCClientTimeout(t *testing.T) {
+	tw, c := newTestWallet(t, "get_balance", "nip44_v2")
+	tw.silent = true
+	c.timeout = 300 * time.Millisecond
+	start := time.Now()
+	_, err := c.getBalance(context.Background())
+	if !errors.Is(err, errNWCTimeout) {
+		t.Errorf("err=%v", err)
+	}
+	if time.Since(start) > 5*time.Second {
+		t.Errorf("took %s", time.Since(start))
+	}
+}
+
+func TestWalletPayBudget(t *testing.T) {
+	_, c := newTestWallet(t, "pay_keysend", "")
+	cfg := &Config{
+		ledger:    &zapLedger{path: filepath.Join(t.TempDir(), ledgerFileName(""))},
+		ZapBudget: &ZapBudget{MaxPerDay: 30},
+	}
+	node, _ := nostr.GetPublicKey(nostr.GeneratePrivateKey())
+	arg := walletPayArg{wallet: "tips", target: node, sats: 21, keysend: true}
+
+	if _, err := cfg.walletPay(context.Background(), c, arg); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := cfg.walletPay(context.Background(), c, arg); err == nil || !strings.Contains(err.Error(), "per day") {
+		t.Errorf("over the day: %v", err)
+	}
+	entries, err := cfg.ledger.load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].Sats != 21 || entries[0].Wallet != "tips" {
+		t.Errorf("ledger=%+v", entries)
+	}
+}
@@ -0,0 +1,557 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/fatih/color"
+	"github.com/urfave/cli/v2"
+
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/nbd-wtf/go-nostr/nip19"
+	"github.com/nbd-wtf/go-nostr/sdk"
+)
+
+// NIP-88 kinds.
+const (
+	kindPoll         = 1068
+	kindPollResponse = 1018
+)
+
+const (
+	pollSingleChoice   = "singlechoice"
+	pollMultipleChoice = "multiplechoice"
+)
+
+type pollOption struct {
+	ID    string `json:"id"`
+	Label string `json:"label"`
+}
+
+// pollInfo is the parsed form of a kind 1068 poll.
+type pollInfo struct {
+	ID       string
+	Question string
+	Options  []pollOption
+	Type     string
+	EndsAt   nostr.Timestamp // 0: open-ended
+	Relays   []string        // where responses are expected, if restricted
+}
+
+// pollResult is the tally of a poll's responses.
+type pollResult struct {
+	Counts map[string]int `json:"counts"`
+	Voters int            `json:"voters"`
+}
+
+// parsePoll reads the option, polltype, endsAt and relay tags of a poll.
+func parsePoll(ev *nostr.Event) pollInfo {
+	p := pollInfo{ID: ev.ID, Question: ev.Content, Type: pollSingleChoice}
+	for _, tag := range ev.Tags {
+		if len(tag) < 2 {
+			continue
+		}
+		switch tag[0] {
+		case "option":
+			label := ""
+			if len(tag) >= 3 {
+				label = tag[2]
+			}
+			p.Options = append(p.Options, pollOption{ID: tag[1], Label: label})
+		case "polltype":
+			if tag[1] == pollMultipleChoice {
+				p.Type = pollMultipleChoice
+			}
+		case "endsAt":
+			if n, err := strconv.ParseInt(tag[1], 10, 64); err == nil {
+				p.EndsAt = nostr.Timestamp(n)
+			}
+		case "relay":
+			p.Relays = append(p.Relays, tag[1])
+		}
+	}
+	return p
+}
+
+// buildPollEvent constructs an unsigned kind 1068 poll. Option ids are
+// generated; relays, when given, restrict where responses are read from.
+func buildPollEvent(pubkey, question string, options []string, pollType string, endsAt nostr.Timestamp, relays []string, createdAt nostr.Timestamp) (*nostr.Event, error) {
+	if strings.TrimSpace(question) == "" {
+		return nil, errors.New("question is empty")
+	}
+	if len(options) < 2 {
+		return nil, errors.New("a poll needs at least two options")
+	}
+	switch pollType {
+	case "":
+		pollType = pollSingleChoice
+	case pollSingleChoice, pollMultipleChoice:
+	default:
+		return nil, fmt.Errorf("invalid poll type %q (singlechoice or multiplechoice)", pollType)
+	}
+	ev := &nostr.Event{
+		PubKey:    pubkey,
+		CreatedAt: createdAt,
+		Kind:      kindPoll,
+		Content:   question,
+		Tags:      nostr.Tags{},
+	}
+	clientTag(ev)
+	for _, o := range options {
+		ev.Tags = append(ev.Tags, nostr.Tag{"option", randomID(4), o})
+	}
+	ev.Tags = append(ev.Tags, nostr.Tag{"polltype", pollType})
+	if endsAt > 0 {
+		ev.Tags = append(ev.Tags, nostr.Tag{"endsAt", strconv.FormatInt(int64(endsAt), 10)})
+	}
+	for _, r := range relays {
+		ev.Tags = ev.Tags.AppendUnique(nostr.Tag{"relay", nostr.NormalizeURL(r)})
+	}
+	return ev, nil
+}
+
+// resolvePollOption maps an option id, label (case-insensitive) or 1-based
+// index to the option id.
+func resolvePollOption(p pollInfo, s string) (string, error) {
+	for _, o := range p.Options {
+		if o.ID == s {
+			return o.ID, nil
+		}
+	}
+	for _, o := range p.Options {
+		if strings.EqualFold(o.Label, s) {
+			return o.ID, nil
+		}
+	}
+	if n, err := strconv.Atoi(s); err == nil && n >= 1 && n <= len(p.Options) {
+		return p.Options[n-1].ID, nil
+	}
+	return "", fmt.Errorf("no option %q in this poll", s)
+}
+
+// buildPollResponseEvent constructs an unsigned kind 1018 vote.
+func buildPollResponseEvent(pubkey string, p pollInfo, optionIDs []string, createdAt nostr.Timestamp) (*nostr.Event, error) {
+	if len(optionIDs) == 0 {
+		return nil, errors.New("no option chosen")
+	}
+	if p.Type == pollSingleChoice && len(optionIDs) > 1 {
+		return nil, errors.New("this poll accepts a single choice")
+	}
+	ev := &nostr.Event{
+		PubKey:    pubkey,
+		CreatedAt: createdAt,
+		Kind:      kindPollResponse,
+		Tags:      nostr.Tags{nostr.Tag{"e", p.ID}},
+	}
+	for _, id := range optionIDs {
+		ev.Tags = ev.Tags.AppendUnique(nostr.Tag{"response", id})
+	}
+	return ev, nil
+}
+
+// tallyPoll counts responses: one vote per pubkey (the latest response wins),
+// responses after endsAt are ignored, unknown option ids are dropped and a
+// singlechoice poll only counts the first response tag.
+func tallyPoll(p pollInfo, responses []*nostr.Event) pollResult {
+	latest := map[string]*nostr.Event{}
+	for _, ev := range responses {
+		if ev.Kind != kindPollResponse || tagValue(ev.Tags, "e") != p.ID {
+			continue
+		}
+		if p.EndsAt > 0 && ev.CreatedAt > p.EndsAt {
+			continue
+		}
+		if cur, ok := latest[ev.PubKey]; !ok || ev.CreatedAt > cur.CreatedAt {
+			latest[ev.PubKey] = ev
+		}
+	}
+
+	valid := map[string]bool{}
+	res := pollResult{Counts: map[string]int{}}
+	for _, o := range p.Options {
+		valid[o.ID] = true
+		res.Counts[o.ID] = 0
+	}
+	for _, ev := range latest {
+		chosen := map[string]bool{}
+		for _, tag := range ev.Tags {
+			if len(tag) < 2 || tag[0] != "response" || !valid[tag[1]] || chosen[tag[1]] {
+				continue
+			}
+			chosen[tag[1]] = true
+			if p.Type == pollSingleChoice {
+				break
+			}
+		}
+		for id := range chosen {
+			res.Counts[id]++
+		}
+		if len(chosen) > 0 {
+			res.Voters++
+		}
+	}
+	return res
+}
+
+// fetchPoll loads the poll behind a nevent/note/hex id, falling back to the
+// relay hints of a nevent.
+func fetchPoll(ctx context.Context, cfg *Config, id string) (*nostr.Event, error) {
+	evp := sdk.InputToEventPointer(id)
+	if evp == nil {
+		return nil, fmt.Errorf("failed to parse event from '%s'", id)
+	}
+	filters := nostr.Filters{{IDs: []string{evp.ID}, Kinds: []int{kindPoll}, Limit: 1}}
+	evs, err := cfg.QueryEvents(ctx, filters)
+	if (err != nil || len(evs) == 0) && len(evp.Relays) > 0 {
+		evs, err = cfg.queryRelays(ctx, evp.Relays, filters)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(evs) == 0 {
+		return nil, fmt.Errorf("poll not found: %s", id)
+	}
+	return evs[0], nil
+}
+
+// fetchPollResults reads the responses to p from its relays (or ours when
+// the poll does not restrict them) and tallies them.
+func (cfg *Config) fetchPollResults(ctx context.Context, p pollInfo) (pollResult, error) {
+	results, err := cfg.fetchPollsResults(ctx, []pollInfo{p})
+	if err != nil {
+		return pollResult{}, err
+	}
+	return results[p.ID], nil
+}
+
+// fetchPollsResults tallies the responses to polls with a single query. A
+// poll that names relays only counts the responses those relays returned;
+// the others count what our read relays returned.
+func (cfg *Config) fetchPollsResults(ctx context.Context, polls []pollInfo) (map[string]pollResult, error) {
+	var ours []string
+	for k, v := range cfg.Relays {
+		if v.Read {
+			ours = append(ours, nostr.NormalizeURL(k))
+		}
+	}
+	var ids, relays []string
+	for _, p := range polls {
+		ids = append(ids, p.ID)
+		pollRelays := ours
+		if len(p.Relays) > 0 {
+			pollRelays = p.Relays
+		}
+		for _, u := range pollRelays {
+			if u = nostr.NormalizeURL(u); !slices.Contains(relays, u) {
+				relays = append(relays, u)
+			}
+		}
+	}
+	if len(relays) == 0 {
+		return nil, errors.New("no read relays available")
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	cfg.preAuth(ctx, relays)
+
+	filters := nostr.Filters{{Kinds: []int{kindPollResponse}, Tags: nostr.TagMap{"e": ids}}}
+	responses := map[string]*nostr.Event{}
+	seenOn := map[string][]string{}
+	for re := range cfg.pool.SubManyEose(ctx, relays, filters) {
+		if re.Event == nil {
+			continue
+		}
+		responses[re.Event.ID] = re.Event
+		if re.Relay != nil {
+			seenOn[re.Event.ID] = append(seenOn[re.Event.ID], nostr.NormalizeURL(re.Relay.URL))
+		}
+	}
+
+	results := map[string]pollResult{}
+	for _, p := range polls {
+		pollRelays := ours
+		if len(p.Relays) > 0 {
+			pollRelays = nil
+			for _, u := range p.Relays {
+				pollRelays = append(pollRelays, nostr.NormalizeURL(u))
+			}
+		}
+		var evs []*nostr.Event
+		for id, ev := range responses {
+			if slices.ContainsFunc(seenOn[id], func(u string) bool { return slices.Contains(pollRelays, u) }) {
+				evs = append(evs, ev)
+			}
+		}
+		results[p.ID] = tallyPoll(p, evs)
+	}
+	return results, nil
+}
+
+// loadPollResults fetches the vote counts of the polls among evs that have
+// none yet, all in one query, and keeps them in cfg.pollResults for printPoll.
+func (cfg *Config) loadPollResults(ctx context.Context, evs []*nostr.Event) {
+	var polls []pollInfo
+	for _, ev := range evs {
+		if _, ok := cfg.pollResults[ev.ID]; ev.Kind == kindPoll && !ok {
+			polls = append(polls, parsePoll(ev))
+		}
+	}
+	if len(polls) == 0 {
+		return
+	}
+	results, err := cfg.fetchPollsResults(ctx, polls)
+	if err != nil {
+		if cfg.verbose {
+			fmt.Fprintln(os.Stderr, err)
+		}
+		return
+	}
+	if cfg.pollResults == nil {
+		cfg.pollResults = map[string]*pollResult{}
+	}
+	for id, res := range results {
+		cfg.pollResults[id] = &res
+	}
+}
+
+// printPoll prints the options of a poll with their vote counts, as part of
+// PrintEvent. Counts are left out when res is nil.
+func printPoll(ev *nostr.Event, res *pollResult) {
+	p := parsePoll(ev)
+	for i, o := range p.Options {
+		fmt.Printf("  %d. %s", i+1, o.Label)
+		if res != nil {
+			color.Set(color.FgHiBlack)
+			fmt.Printf(" (%d)", res.Counts[o.ID])
+			color.Set(color.Reset)
+		}
+		fmt.Println()
+	}
+	color.Set(color.FgHiBlack)
+	fmt.Print("  " + p.Type)
+	if res != nil {
+		fmt.Printf(", %d voters", res.Voters)
+	}
+	if p.EndsAt > 0 {
+		if p.EndsAt <= nostr.Now() {
+			fmt.Print(", ended " + p.EndsAt.Time().Format("2006-01-02T15:04:05"))
+		} else {
+			fmt.Print(", ends " + p.EndsAt.Time().Format("2006-01-02T15:04:05"))
+		}
+	}
+	fmt.Println()
+	color.Set(color.Reset)
+}
+
+func doPollCreate(cCtx *cli.Context) error {
+	if cCtx.Args().Len() == 0 {
+		return cli.ShowSubcommandHelp(cCtx)
+	}
+	cfg := cCtx.App.Metadata["config"].(*Config)
+	ctx := context.Background()
+
+	_, pub, err := getSkAndPub(cfg)
+	if err != nil {
+		return err
+	}
+
+	var endsAt nostr.Timestamp
+	if s := cCtx.String("ends-at"); s != "" {
+		t, err := parseScheduleTime(s)
+		if err != nil {
+			return err
+		}
+		if !t.After(time.Now()) {
+			return fmt.Errorf("--ends-at %s is in the past", s)
+		}
+		endsAt = nostr.Timestamp(t.Unix())
+	}
+
+	relays := cCtx.StringSlice("relay")
+	ev, err := buildPollEvent(pub, strings.Join(cCtx.Args().Slice(), " "), cCtx.StringSlice("option"), cCtx.String("type"), endsAt, relays, nostr.Now())
+	if err != nil {
+		return err
+	}
+	if err := cfg.signEvent(ev); err != nil {
+		return err
+	}
+
+	success := cfg.publishEvent(ctx, ev)
+	if len(relays) > 0 {
+		for res := range cfg.pool.PublishMany(ctx, relays, *ev) {
+			if res.Error != nil {
+				fmt.Fprintln(os.Stderr, res.RelayURL, res.Error)
+			} else {
+				success++
+			}
+		}
+	}
+	if success == 0 {
+		return errors.New("cannot create poll")
+	}
+
+	if nev, err := nip19.EncodeEvent(ev.ID, relays, pub); err == nil {
+		fmt.Println(nev)
+	} else {
+		fmt.Println(ev.ID)
+	}
+	return nil
+}
+
+func doPollVote(cCtx *cli.Context) error {
+	if cCtx.Args().Len() == 0 {
+		return cli.ShowSubcommandHelp(cCtx)
+	}
+	cfg := cCtx.App.Metadata["config"].(*Config)
+	ctx := context.Background()
+
+	_, pub, err := getSkAndPub(cfg)
+	if err != nil {
+		return err
+	}
+	pev, err := fetchPoll(ctx, cfg, cCtx.String("id"))
+	if err != nil {
+		return err
+	}
+	p := parsePoll(pev)
+	if p.EndsAt > 0 && p.EndsAt <= nostr.Now() {
+		return errors.New("this poll has ended")
+	}
+
+	var ids []string
+	for _, arg := range cCtx.Args().Slice() {
+		id, err := resolvePollOption(p, arg)
+		if err != nil {
+			return err
+		}
+		ids = append(ids, id)
+	}
+	ev, err := buildPollResponseEvent(pub, p, ids, nostr.Now())
+	if err != nil {
+		return err
+	}
+	if err := cfg.signEvent(ev); err != nil {
+		return err
+	}
+
+	// Responses go where the poll says they will be counted.
+	var success int64
+	if len(p.Relays) > 0 {
+		cfg.preAuth(ctx, p.Relays)
+		for res := range cfg.pool.PublishMany(ctx, p.Relays, *ev) {
+			if res.Error != nil {
+				fmt.Fprintln(os.Stderr, res.RelayURL, res.Error)
+			} else {
+				success++
+			}
+		}
+	} else {
+		success = cfg.publishEvent(ctx, ev)
+	}
+	if success == 0 {
+		return errors.New("cannot vote")
+	}
+	return nil
+}
+
+func doPollResults(cCtx *cli.Context) error {
+	cfg := cCtx.App.Metadata["config"].(*Config)
+	ctx := context.Background()
+
+	pev, err := fetchPoll(ctx, cfg, cCtx.String("id"))
+	if err != nil {
+		return err
+	}
+	p := parsePoll(pev)
+	res, err := cfg.fetchPollResults(ctx, p)
+	if err != nil {
+		return err
+	}
+
+	if cCtx.Bool("json") {
+		return json.NewEncoder(os.Stdout).Encode(struct {
+			Question string       `json:"question"`
+			Type     string       `json:"polltype"`
+			EndsAt   int64        `json:"endsAt,omitempty"`
+			Options  []pollOption `json:"options"`
+			pollResult
+		}{p.Question, p.Type, int64(p.EndsAt), p.Options, res})
+	}
+
+	fmt.Println(p.Question)
+	for i, o := range p.Options {
+		n := res.Counts[o.ID]
+		pct := 0
+		if res.Voters > 0 {
+			pct = n * 100 / res.Voters
+		}
+		fmt.Printf("  %d. %-20s %4d %3d%% ", i+1, o.Label, n, pct)
+		color.Set(color.FgHiBlue)
+		fmt.Println(strings.Repeat("#", pct/5))
+		color.Set(color.Reset)
+	}
+	fmt.Printf("%d voters (%s)", res.Voters, p.Type)
+	if p.EndsAt > 0 {
+		if p.EndsAt <= nostr.Now() {
+			fmt.Print(", ended " + p.EndsAt.Time().Format("2006-01-02T15:04:05"))
+		} else {
+			fmt.Print(", ends " + p.EndsAt.Time().Format("2006-01-02T15:04:05"))
+		}
+	}
+	fmt.Println()
+	return nil
+}
+
+// pollCommand returns the "poll" parent command with its subcommands (NIP-88).
+func pollCommand() *cli.Command {
+	return &cli.Command{
+		Name:  "poll",
+		Usage: "polls (NIP-88)",
+		Action: func(cCtx *cli.Context) error {
+			return cli.ShowSubcommandHelp(cCtx)
+		},
+		Subcommands: []*cli.Command{
+			{
+				Name: "create",
+				Flags: []cli.Flag{
+					&cli.StringSliceFlag{Name: "option", Aliases: []string{"o"}, Required: true, Usage: "option label (repeatable)"},
+					&cli.StringFlag{Name: "type", Value: pollSingleChoice, Usage: "singlechoice or multiplechoice"},
+					&cli.StringFlag{Name: "ends-at", Usage: "closing time (e.g. 2026-11-01T09:00, local time)"},
+					&cli.StringSliceFlag{Name: "relay", Usage: "relay where responses are collected (repeatable)"},
+				},
+				Usage:     "create a poll (kind 1068)",
+				UsageText: "algia poll create --option A --option B [--type multiplechoice] [--ends-at time] [question]",
+				ArgsUsage: "[question]",
+				Action:    doPollCreate,
+			},
+			{
+				Name: "vote",
+				Flags: []cli.Flag{
+					&cli.StringFlag{Name: "id", Required: true, Usage: "poll id (nevent/note/hex)"},
+				},
+				Usage:     "vote on a poll (kind 1018); options by id, label or number",
+				UsageText: "algia poll vote --id <nevent> <option> [option...]",
+				ArgsUsage: "<option> [option...]",
+				Action:    doPollVote,
+			},
+			{
+				Name: "results",
+				Flags: []cli.Flag{
+					&cli.StringFlag{Name: "id", Required: true, Usage: "poll id (nevent/note/hex)"},
+					&cli.BoolFlag{Name: "json", Usage: "output JSON"},
+				},
+				Usage:     "show poll results",
+				UsageText: "algia poll results --id <nevent>",
+				Action:    doPollResults,
+			},
+		},
+	}
+}
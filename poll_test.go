@@ -0,0 +1,164 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+func TestBuildPollEvent(t *testing.T) {
+	ev, err := buildPollEvent(testPub, "lunch?", []string{"ramen", "sushi"}, "", 500, []string{"wss://relay.example"}, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ev.Kind != kindPoll || ev.Content != "lunch?" {
+		t.Errorf("kind=%d content=%q", ev.Kind, ev.Content)
+	}
+	p := parsePoll(ev)
+	if len(p.Options) != 2 || p.Options[0].Label != "ramen" || p.Options[1].Label != "sushi" {
+		t.Errorf("options=%v", p.Options)
+	}
+	if p.Options[0].ID == "" || p.Options[0].ID == p.Options[1].ID {
+		t.Errorf("option ids=%v", p.Options)
+	}
+	if p.Type != pollSingleChoice || p.EndsAt != 500 {
+		t.Errorf("type=%q endsAt=%d", p.Type, p.EndsAt)
+	}
+	if len(p.Relays) != 1 || p.Relays[0] != "wss://relay.example" {
+		t.Errorf("relays=%v", p.Relays)
+	}
+
+	if _, err := buildPollEvent(testPub, "q", []string{"only"}, "", 0, nil, 100); err == nil {
+		t.Error("expected error for a single option")
+	}
+	if _, err := buildPollEvent(testPub, "q", []string{"a", "b"}, "ranked", 0, nil, 100); err == nil {
+		t.Error("expected error for an invalid type")
+	}
+	if _, err := buildPollEvent(testPub, " ", []string{"a", "b"}, "", 0, nil, 100); err == nil {
+		t.Error("expected error for an empty question")
+	}
+}
+
+func TestResolvePollOption(t *testing.T) {
+	p := pollInfo{Options: []pollOption{{"a1", "Yes"}, {"b2", "No"}}}
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{"a1", "a1"},
+		{"no", "b2"},
+		{"1", "a1"},
+		{"2", "b2"},
+	}
+	for _, tt := range tests {
+		got, err := resolvePollOption(p, tt.in)
+		if err != nil || got != tt.want {
+			t.Errorf("resolvePollOption(%q): got=%q err=%v want=%q", tt.in, got, err, tt.want)
+		}
+	}
+	for _, in := range []string{"maybe", "0", "3"} {
+		if _, err := resolvePollOption(p, in); err == nil {
+			t.Errorf("resolvePollOption(%q): expected error", in)
+		}
+	}
+}
+
+func TestBuildPollResponseEvent(t *testing.T) {
+	p := pollInfo{ID: testTargetID, Type: pollSingleChoice}
+	ev, err := buildPollResponseEvent(testPub, p, []string{"a1"}, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ev.Kind != kindPollResponse {
+		t.Errorf("kind=%d", ev.Kind)
+	}
+	if e := findTag(ev.Tags, "e"); e == nil || e[1] != testTargetID {
+		t.Errorf("e tag: %v", ev.Tags)
+	}
+	if r := findAllTags(ev.Tags, "response"); len(r) != 1 || r[0][1] != "a1" {
+		t.Errorf("response tags: %v", ev.Tags)
+	}
+
+	if _, err := buildPollResponseEvent(testPub, p, []string{"a1", "b2"}, 100); err == nil {
+		t.Error("expected error for two choices on a singlechoice poll")
+	}
+	p.Type = pollMultipleChoice
+	ev, err = buildPollResponseEvent(testPub, p, []string{"a1", "b2"}, 100)
+	if err != nil || len(findAllTags(ev.Tags, "response")) != 2 {
+		t.Errorf("multiplechoice: err=%v tags=%v", err, ev)
+	}
+}
+
+func TestTallyPoll(t *testing.T) {
+	vote := func(pubkey string, at nostr.Timestamp, opts ...string) *nostr.Event {
+		ev := &nostr.Event{PubKey: pubkey, CreatedAt: at, Kind: kindPollResponse, Tags: nostr.Tags{{"e", testTargetID}}}
+		for _, o := range opts {
+			ev.Tags = append(ev.Tags, nostr.Tag{"response", o})
+		}
+		return ev
+	}
+	options := []pollOption{{"a", "A"}, {"b", "B"}, {"c", "C"}}
+
+	responses := []*nostr.Event{
+		vote("alice", 10, "a"),
+		vote("alice", 20, "b"),     // latest wins
+		vote("bob", 10, "a", "c"),  // singlechoice: first only
+		vote("carol", 10, "zzz"),   // unknown option: not a voter
+		vote("dave", 200, "c"),     // after endsAt
+		vote("erin", 10, "c", "c"), // duplicate response tags
+		{PubKey: "frank", CreatedAt: 10, Kind: kindPollResponse, Tags: nostr.Tags{{"e", "other"}, {"response", "a"}}},
+	}
+
+	single := tallyPoll(pollInfo{ID: testTargetID, Options: options, Type: pollSingleChoice, EndsAt: 100}, responses)
+	if single.Voters != 3 {
+		t.Errorf("single voters=%d want 3", single.Voters)
+	}
+	if single.Counts["a"] != 1 || single.Counts["b"] != 1 || single.Counts["c"] != 1 {
+		t.Errorf("single counts=%v", single.Counts)
+	}
+
+	multi := tallyPoll(pollInfo{ID: testTargetID, Options: options, Type: pollMultipleChoice}, responses)
+	if multi.Voters != 4 {
+		t.Errorf("multi voters=%d want 4", multi.Voters)
+	}
+	if multi.Counts["a"] != 1 || multi.Counts["b"] != 1 || multi.Counts["c"] != 3 {
+		t.Errorf("multi counts=%v", multi.Counts)
+	}
+}
+
+func TestFetchPollsResults(t *testing.T) {
+	ours, oursURL := newTestRelay(t, nil)
+	theirs, theirsURL := newTestRelay(t, nil)
+	cfg := &Config{
+		Relays: map[string]Relay{oursURL: {Read: true}},
+		pool:   nostr.NewSimplePool(context.Background()),
+	}
+
+	open := pollInfo{ID: "1111111111111111111111111111111111111111111111111111111111111111", Type: pollSingleChoice, Options: []pollOption{{ID: "a"}, {ID: "b"}}}
+	pinned := pollInfo{ID: "2222222222222222222222222222222222222222222222222222222222222222", Type: pollSingleChoice, Options: []pollOption{{ID: "a"}, {ID: "b"}}, Relays: []string{theirsURL}, EndsAt: nostr.Now() - 10}
+	vote := func(tr *testRelay, p pollInfo, option string, at nostr.Timestamp) {
+		ev := &nostr.Event{Kind: kindPollResponse, CreatedAt: at, Tags: nostr.Tags{{"e", p.ID}, {"response", option}}}
+		if err := ev.Sign(nostr.GeneratePrivateKey()); err != nil {
+			t.Fatal(err)
+		}
+		tr.store(ev)
+	}
+	now := nostr.Now()
+	vote(ours, open, "a", now)
+	vote(theirs, open, "b", now)      // not one of our relays
+	vote(theirs, pinned, "a", now-20) // counted
+	vote(ours, pinned, "b", now-20)   // not one of the poll's relays
+	vote(theirs, pinned, "b", now)    // after endsAt
+
+	results, err := cfg.fetchPollsResults(context.Background(), []pollInfo{open, pinned})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := results[open.ID]; got.Voters != 1 || got.Counts["a"] != 1 {
+		t.Errorf("open: %+v", got)
+	}
+	if got := results[pinned.ID]; got.Voters != 1 || got.Counts["a"] != 1 {
+		t.Errorf("pinned: %+v", got)
+	}
+}
@@ -66,8 +66,15 @@ func doProfile(cCtx *cli.Context) error {
 	fmt.Printf("Picture: %v\n", profile.Picture)
 	fmt.Printf("NIP-05: %v\n", profile.Nip05)
 	fmt.Printf("LUD-16: %v\n", profile.Lud16)
+	if profile.Lud06 != "" {
+		fmt.Printf("LUD-06: %v\n", profile.Lud06)
+	}
 	fmt.Printf("About: %v\n", profile.About)
 	fmt.Printf("Bot: %v\n", profile.Bot)
+	if badges, err := fetchDisplayedBadges(context.Background(), cfg, pub); err == nil && len(badges) > 0 {
+		fmt.Println("Badges:")
+		printBadges(badges)
+	}
 	return nil
 }
 
@@ -0,0 +1,432 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/fatih/color"
+	"github.com/urfave/cli/v2"
+
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/nbd-wtf/go-nostr/nip19"
+	"github.com/nbd-wtf/go-nostr/sdk"
+)
+
+// scheduleTimeLayouts are the accepted formats for "post --at", interpreted in
+// the local timezone unless the value carries its own offset (RFC 3339).
+var scheduleTimeLayouts = []string{
+	"2006-01-02T15:04",
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04",
+	"2006-01-02 15:04:05",
+}
+
+// scheduledEvent is one entry of the local publish queue. The event is already
+// signed when it is queued, so the queue never holds anything but the final
+// event (images were uploaded at schedule time).
+type scheduledEvent struct {
+	Event     *nostr.Event    `json:"event"`
+	PublishAt nostr.Timestamp `json:"publish_at"`
+	Attempts  int             `json:"attempts,omitempty"`
+	LastError string          `json:"last_error,omitempty"`
+}
+
+// parseScheduleTime parses the value of "post --at". Times without an offset
+// are taken as local time.
+func parseScheduleTime(s string) (time.Time, error) {
+	s = strings.TrimSpace(s)
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	for _, layout := range scheduleTimeLayouts {
+		if t, err := time.ParseInLocation(layout, s, time.Local); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid time %q (use e.g. 2026-11-01T09:00)", s)
+}
+
+// scheduleFile returns the path of the publish queue for the given profile,
+// next to its config.json / config-<profile>.json.
+func scheduleFile(profile string) (string, error) {
+	dir, err := configDir()
+	if err != nil {
+		return "", err
+	}
+	dir = filepath.Join(dir, "algia")
+	if profile == "" {
+		return filepath.Join(dir, "schedule.json"), nil
+	}
+	return filepath.Join(dir, "schedule-"+profile+".json"), nil
+}
+
+// loadSchedule reads the queue at fp. A missing file is an empty queue.
+func loadSchedule(fp string) ([]scheduledEvent, error) {
+	b, err := os.ReadFile(fp)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var queue []scheduledEvent
+	if err := json.Unmarshal(b, &queue); err != nil {
+		return nil, fmt.Errorf("%s: %w", fp, err)
+	}
+	return queue, nil
+}
+
+// saveSchedule writes the queue to fp through a temporary file and a rename,
+// so the daemon never reads a half-written queue.
+func saveSchedule(fp string, queue []scheduledEvent) error {
+	sort.SliceStable(queue, func(i, j int) bool {
+		return queue[i].PublishAt < queue[j].PublishAt
+	})
+	b, err := json.MarshalIndent(queue, "", "  ")
+	if err != nil {
+		return err
+	}
+	os.MkdirAll(filepath.Dir(fp), 0700)
+	tmp := fp + ".tmp"
+	if err := os.WriteFile(tmp, b, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, fp)
+}
+
+// scheduleLockTimeout is how long to wait for another algia to finish with
+// the queue. A lock older than scheduleLockStale was left behind by a crash.
+const (
+	scheduleLockTimeout = 10 * time.Second
+	scheduleLockStale   = time.Minute
+)
+
+// lockSchedule takes the lock file next to the queue at fp and returns the
+// function that releases it.
+func lockSchedule(fp string) (func(), error) {
+	os.MkdirAll(filepath.Dir(fp), 0700)
+	lock := fp + ".lock"
+	deadline := time.Now().Add(scheduleLockTimeout)
+	for {
+		f, err := os.OpenFile(lock, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+		if err == nil {
+			f.Close()
+			return func() { os.Remove(lock) }, nil
+		}
+		if !errors.Is(err, os.ErrExist) {
+			return nil, err
+		}
+		if st, err := os.Stat(lock); err == nil && time.Since(st.ModTime()) > scheduleLockStale {
+			os.Remove(lock)
+			continue
+		}
+		if time.Now().After(deadline) {
+			return nil, fmt.Errorf("%s is locked by another algia (remove %s if not)", fp, lock)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+// updateSchedule applies edit to the queue at fp under the lock, so "post
+// --at", "schedule cancel" and "schedule run" never undo each other's changes.
+func updateSchedule(fp string, edit func([]scheduledEvent) ([]scheduledEvent, error)) error {
+	unlock, err := lockSchedule(fp)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	queue, err := loadSchedule(fp)
+	if err != nil {
+		return err
+	}
+	if queue, err = edit(queue); err != nil {
+		return err
+	}
+	return saveSchedule(fp, queue)
+}
+
+// enqueueScheduled appends a signed event to the queue of the profile.
+func enqueueScheduled(profile string, ev *nostr.Event) error {
+	fp, err := scheduleFile(profile)
+	if err != nil {
+		return err
+	}
+	return updateSchedule(fp, func(queue []scheduledEvent) ([]scheduledEvent, error) {
+		return append(queue, scheduledEvent{Event: ev, PublishAt: ev.CreatedAt}), nil
+	})
+}
+
+// dueScheduled splits the queue into the entries due at now and the rest.
+func dueScheduled(queue []scheduledEvent, now nostr.Timestamp) (due, pending []scheduledEvent) {
+	for _, se := range queue {
+		if se.PublishAt <= now {
+			due = append(due, se)
+		} else {
+			pending = append(pending, se)
+		}
+	}
+	return due, pending
+}
+
+// removeScheduled drops the entry whose event id matches id (hex, note or
+// nevent; a unique hex prefix is accepted too) and returns the removed entry.
+func removeScheduled(queue []scheduledEvent, id string) ([]scheduledEvent, *scheduledEvent, error) {
+	if evp := sdk.InputToEventPointer(id); evp != nil {
+		id = evp.ID
+	}
+	match := -1
+	for i, se := range queue {
+		if se.Event == nil || !strings.HasPrefix(se.Event.ID, id) {
+			continue
+		}
+		if match >= 0 {
+			return queue, nil, fmt.Errorf("ambiguous id %q", id)
+		}
+		match = i
+	}
+	if match < 0 {
+		return queue, nil, fmt.Errorf("no scheduled event %q", id)
+	}
+	removed := queue[match]
+	rest := append(append([]scheduledEvent{}, queue[:match]...), queue[match+1:]...)
+	return rest, &removed, nil
+}
+
+// publishEvent publishes a signed event to the write relays and returns how
+// many accepted it.
+func (cfg *Config) publishEvent(ctx context.Context, ev *nostr.Event) int64 {
+	var success atomic.Int64
+	cfg.Do(ctx, Relay{Write: true}, func(ctx context.Context, relay *nostr.Relay) bool {
+		if err := relay.Publish(ctx, *ev); err != nil {
+			fmt.Fprintln(os.Stderr, relay.URL, err)
+		} else {
+			success.Add(1)
+		}
+		return true
+	})
+	return success.Load()
+}
+
+func scheduleProfile(cCtx *cli.Context) string {
+	profile, _ := cCtx.App.Metadata["profile"].(string)
+	return profile
+}
+
+func doScheduleList(cCtx *cli.Context) error {
+	fp, err := scheduleFile(scheduleProfile(cCtx))
+	if err != nil {
+		return err
+	}
+	queue, err := loadSchedule(fp)
+	if err != nil {
+		return err
+	}
+
+	if cCtx.Bool("json") {
+		for _, se := range queue {
+			json.NewEncoder(os.Stdout).Encode(se)
+		}
+		return nil
+	}
+
+	for _, se := range queue {
+		if se.Event == nil {
+			continue
+		}
+		fmt.Print(se.PublishAt.Time().Format("2006-01-02T15:04:05") + " ")
+		color.Set(color.FgHiBlue)
+		if note, err := nip19.EncodeNote(se.Event.ID); err == nil {
+			fmt.Print(note)
+		} else {
+			fmt.Print(se.Event.ID)
+		}
+		color.Set(color.Reset)
+		fmt.Printf(" kind:%d", se.Event.Kind)
+		if se.LastError != "" {
+			color.Set(color.FgHiBlack)
+			fmt.Printf(" (%d attempts: %s)", se.Attempts, se.LastError)
+			color.Set(color.Reset)
+		}
+		fmt.Println()
+		fmt.Println(se.Event.Content)
+		fmt.Println()
+	}
+	return nil
+}
+
+func doScheduleCancel(cCtx *cli.Context) error {
+	if cCtx.Args().Len() == 0 {
+		return cli.ShowSubcommandHelp(cCtx)
+	}
+	fp, err := scheduleFile(scheduleProfile(cCtx))
+	if err != nil {
+		return err
+	}
+	var canceled []string
+	err = updateSchedule(fp, func(queue []scheduledEvent) ([]scheduledEvent, error) {
+		for _, id := range cCtx.Args().Slice() {
+			var removed *scheduledEvent
+			var err error
+			queue, removed, err = removeScheduled(queue, id)
+			if err != nil {
+				return nil, err
+			}
+			canceled = append(canceled, removed.Event.ID)
+		}
+		return queue, nil
+	})
+	if err != nil {
+		return err
+	}
+	for _, id := range canceled {
+		if note, err := nip19.EncodeNote(id); err == nil {
+			fmt.Println("canceled", note)
+		}
+	}
+	return nil
+}
+
+// runScheduleOnce publishes every due entry of the queue at fp. Published
+// entries are dropped; failed ones stay queued with their error until
+// maxAttempts is reached. The queue is not locked while publishing; the
+// results are applied by id to the queue as it is afterwards, so entries
+// added, edited or canceled meanwhile are kept as they are.
+func runScheduleOnce(ctx context.Context, cfg *Config, fp string, maxAttempts int) error {
+	queue, err := loadSchedule(fp)
+	if err != nil {
+		return err
+	}
+	due, _ := dueScheduled(queue, nostr.Now())
+	if len(due) == 0 {
+		return nil
+	}
+
+	done := map[string]bool{} // published or given up on
+	failed := map[string]scheduledEvent{}
+	for _, se := range due {
+		if se.Event == nil {
+			continue
+		}
+		note, err := nip19.EncodeNote(se.Event.ID)
+		if err != nil {
+			note = se.Event.ID
+		}
+		if n := cfg.publishEvent(ctx, se.Event); n > 0 {
+			fmt.Printf("%s published %s to %d relay(s)\n", time.Now().Format("2006-01-02T15:04:05"), note, n)
+			done[se.Event.ID] = true
+			continue
+		}
+		se.Attempts++
+		se.LastError = "no relay accepted the event"
+		if maxAttempts > 0 && se.Attempts >= maxAttempts {
+			fmt.Fprintf(os.Stderr, "%s giving up on %s after %d attempts\n", time.Now().Format("2006-01-02T15:04:05"), note, se.Attempts)
+			done[se.Event.ID] = true
+			continue
+		}
+		fmt.Fprintf(os.Stderr, "%s failed to publish %s (attempt %d), will retry\n", time.Now().Format("2006-01-02T15:04:05"), note, se.Attempts)
+		failed[se.Event.ID] = se
+	}
+
+	return updateSchedule(fp, func(latest []scheduledEvent) ([]scheduledEvent, error) {
+		var kept []scheduledEvent
+		for _, se := range latest {
+			if se.Event != nil {
+				if done[se.Event.ID] {
+					continue
+				}
+				if f, ok := failed[se.Event.ID]; ok {
+					se.Attempts = f.Attempts
+					se.LastError = f.LastError
+				}
+			}
+			kept = append(kept, se)
+		}
+		return kept, nil
+	})
+}
+
+func doScheduleRun(cCtx *cli.Context) error {
+	cfg := cCtx.App.Metadata["config"].(*Config)
+	fp, err := scheduleFile(scheduleProfile(cCtx))
+	if err != nil {
+		return err
+	}
+	interval := cCtx.Duration("interval")
+	if interval <= 0 {
+		return errors.New("--interval must be positive")
+	}
+	maxAttempts := cCtx.Int("max-attempts")
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if cCtx.Bool("once") {
+		return runScheduleOnce(ctx, cfg, fp, maxAttempts)
+	}
+
+	if cfg.verbose {
+		fmt.Fprintf(os.Stderr, "watching %s every %v\n", fp, interval)
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if err := runScheduleOnce(ctx, cfg, fp, maxAttempts); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// scheduleCommand returns the "schedule" parent command with its subcommands.
+func scheduleCommand() *cli.Command {
+	return &cli.Command{
+		Name:  "schedule",
+		Usage: "scheduled posts (queued by post --at)",
+		Action: func(cCtx *cli.Context) error {
+			return cli.ShowSubcommandHelp(cCtx)
+		},
+		Subcommands: []*cli.Command{
+			{
+				Name: "list",
+				Flags: []cli.Flag{
+					&cli.BoolFlag{Name: "json", Usage: "output JSON"},
+				},
+				Usage:     "show queued events",
+				UsageText: "algia schedule list",
+				Action:    doScheduleList,
+			},
+			{
+				Name:      "cancel",
+				Usage:     "remove queued event(s) without publishing",
+				UsageText: "algia schedule cancel <id> [id...]",
+				ArgsUsage: "<id> [id...]",
+				Action:    doScheduleCancel,
+			},
+			{
+				Name: "run",
+				Flags: []cli.Flag{
+					&cli.DurationFlag{Name: "interval", Value: 30 * time.Second, Usage: "how often to check the queue"},
+					&cli.IntFlag{Name: "max-attempts", Value: 10, Usage: "drop an event after this many failed publishes (0: never)"},
+					&cli.BoolFlag{Name: "once", Usage: "publish what is due and exit (for cron)"},
+				},
+				Usage:     "publish queued events when they are due (runs in the foreground)",
+				UsageText: "algia schedule run [--interval 30s] [--once]",
+				Action:    doScheduleRun,
+			},
+		},
+	}
+}
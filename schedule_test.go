@@ -0,0 +1,208 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+func TestParseScheduleTime(t *testing.T) {
+	tests := []struct {
+		in   string
+		want time.Time
+	}{
+		{"2026-11-01T09:00", time.Date(2026, 11, 1, 9, 0, 0, 0, time.Local)},
+		{"2026-11-01T09:00:30", time.Date(2026, 11, 1, 9, 0, 30, 0, time.Local)},
+		{"2026-11-01 09:00", time.Date(2026, 11, 1, 9, 0, 0, 0, time.Local)},
+		{"2026-11-01T09:00:00Z", time.Date(2026, 11, 1, 9, 0, 0, 0, time.UTC)},
+		{"2026-11-01T09:00:00+09:00", time.Date(2026, 11, 1, 0, 0, 0, 0, time.UTC)},
+	}
+	for _, tt := range tests {
+		got, err := parseScheduleTime(tt.in)
+		if err != nil {
+			t.Errorf("parseScheduleTime(%q): %v", tt.in, err)
+			continue
+		}
+		if !got.Equal(tt.want) {
+			t.Errorf("parseScheduleTime(%q): got=%v want=%v", tt.in, got, tt.want)
+		}
+	}
+
+	for _, in := range []string{"", "tomorrow", "2026-11-01", "09:00"} {
+		if _, err := parseScheduleTime(in); err == nil {
+			t.Errorf("parseScheduleTime(%q): expected error", in)
+		}
+	}
+}
+
+func TestDueScheduled(t *testing.T) {
+	queue := []scheduledEvent{
+		{Event: &nostr.Event{ID: "a"}, PublishAt: 100},
+		{Event: &nostr.Event{ID: "b"}, PublishAt: 200},
+		{Event: &nostr.Event{ID: "c"}, PublishAt: 300},
+	}
+	due, pending := dueScheduled(queue, 200)
+	if len(due) != 2 || due[0].Event.ID != "a" || due[1].Event.ID != "b" {
+		t.Errorf("due=%v", due)
+	}
+	if len(pending) != 1 || pending[0].Event.ID != "c" {
+		t.Errorf("pending=%v", pending)
+	}
+}
+
+func TestRemoveScheduled(t *testing.T) {
+	queue := []scheduledEvent{
+		{Event: &nostr.Event{ID: testTargetID}, PublishAt: 100},
+		{Event: &nostr.Event{ID: "ab" + testTargetID[2:]}, PublishAt: 200},
+	}
+
+	rest, removed, err := removeScheduled(queue, testTargetID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if removed.Event.ID != testTargetID || len(rest) != 1 {
+		t.Errorf("removed=%v rest=%v", removed, rest)
+	}
+	if len(queue) != 2 {
+		t.Errorf("input queue modified: %v", queue)
+	}
+
+	if _, _, err := removeScheduled(queue, "ab"); err != nil {
+		t.Errorf("unique prefix: %v", err)
+	}
+	if _, _, err := removeScheduled(queue, ""); err == nil {
+		t.Error("expected ambiguous error for empty id")
+	}
+	if _, _, err := removeScheduled(queue, "ffff"); err == nil {
+		t.Error("expected error for unknown id")
+	}
+}
+
+func TestScheduleRoundTrip(t *testing.T) {
+	fp := filepath.Join(t.TempDir(), "algia", "schedule.json")
+
+	queue, err := loadSchedule(fp)
+	if err != nil || len(queue) != 0 {
+		t.Fatalf("missing file: queue=%v err=%v", queue, err)
+	}
+
+	queue = []scheduledEvent{
+		{Event: &nostr.Event{ID: "late", Kind: 1, Content: "later"}, PublishAt: 300},
+		{Event: &nostr.Event{ID: "early", Kind: 1, Content: "sooner"}, PublishAt: 100, Attempts: 1, LastError: "boom"},
+	}
+	if err := saveSchedule(fp, queue); err != nil {
+		t.Fatal(err)
+	}
+	got, err := loadSchedule(fp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 2 {
+		t.Fatalf("len=%d want 2", len(got))
+	}
+	if got[0].Event.ID != "early" || got[0].Attempts != 1 || got[0].LastError != "boom" {
+		t.Errorf("got[0]=%+v", got[0])
+	}
+	if got[1].Event.Content != "later" || got[1].PublishAt != 300 {
+		t.Errorf("got[1]=%+v", got[1])
+	}
+}
+
+func TestRunScheduleOnceKeepsConcurrentChanges(t *testing.T) {
+	fp := filepath.Join(t.TempDir(), "algia", "schedule.json")
+	sk := nostr.GeneratePrivateKey()
+	newEvent := func(content string) *nostr.Event {
+		ev := &nostr.Event{Kind: 1, CreatedAt: 100, Content: content}
+		if err := ev.Sign(sk); err != nil {
+			t.Fatal(err)
+		}
+		return ev
+	}
+	due, later, added := newEvent("due"), newEvent("later"), newEvent("added")
+
+	// While "due" is being published, "later" is canceled and "added" queued.
+	_, url := newTestRelay(t, func(ev *nostr.Event) []*nostr.Event {
+		if ev.ID == due.ID {
+			err := updateSchedule(fp, func(queue []scheduledEvent) ([]scheduledEvent, error) {
+				queue, _, err := removeScheduled(queue, later.ID)
+				return append(queue, scheduledEvent{Event: added, PublishAt: nostr.Now() + 3600}), err
+			})
+			if err != nil {
+				t.Error(err)
+			}
+		}
+		return nil
+	})
+	if err := saveSchedule(fp, []scheduledEvent{
+		{Event: due, PublishAt: 100},
+		{Event: later, PublishAt: nostr.Now() + 3600},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	cfg := &Config{Relays: map[string]Relay{url: {Read: true, Write: true}}}
+	if err := runScheduleOnce(context.Background(), cfg, fp, 3); err != nil {
+		t.Fatal(err)
+	}
+	queue, err := loadSchedule(fp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(queue) != 1 || queue[0].Event.ID != added.ID {
+		t.Errorf("queue=%+v", queue)
+	}
+
+	// A failed publish counts the attempt on the entry as it is now.
+	cfg.Relays = map[string]Relay{"ws://127.0.0.1:1": {Read: true, Write: true}}
+	queue[0].PublishAt = 100
+	if err := saveSchedule(fp, queue); err != nil {
+		t.Fatal(err)
+	}
+	if err := runScheduleOnce(context.Background(), cfg, fp, 3); err != nil {
+		t.Fatal(err)
+	}
+	queue, _ = loadSchedule(fp)
+	if len(queue) != 1 || queue[0].Attempts != 1 || queue[0].LastError == "" {
+		t.Errorf("queue=%+v", queue)
+	}
+	if _, err := os.Stat(fp + ".lock"); !os.IsNotExist(err) {
+		t.Errorf("lock left behind: %v", err)
+	}
+}
+
+func TestLockSchedule(t *testing.T) {
+	fp := filepath.Join(t.TempDir(), "algia", "schedule.json")
+	unlock, err := lockSchedule(fp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	released := make(chan struct{})
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		close(released)
+		unlock()
+	}()
+	unlock2, err := lockSchedule(fp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-released:
+	default:
+		t.Error("second lock taken while the first was held")
+	}
+	unlock2()
+
+	// A stale lock from a crashed process is taken over.
+	os.WriteFile(fp+".lock", nil, 0600)
+	old := time.Now().Add(-2 * scheduleLockStale)
+	os.Chtimes(fp+".lock", old, old)
+	unlock, err = lockSchedule(fp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	unlock()
+}
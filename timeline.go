@@ -51,6 +51,21 @@ func doPost(cCtx *cli.Context) error {
 		return cli.ShowSubcommandHelp(cCtx)
 	}
 
+	var at nostr.Timestamp
+	if s := cCtx.String("at"); s != "" {
+		if cCtx.IsSet("created-at") {
+			return errors.New("--at and --created-at cannot be used together")
+		}
+		t, err := parseScheduleTime(s)
+		if err != nil {
+			return err
+		}
+		if !t.After(time.Now()) {
+			return fmt.Errorf("--at %s is in the past", s)
+		}
+		at = nostr.Timestamp(t.Unix())
+	}
+
 	var content string
 	if stdin {
 		b, err := ioutil.ReadAll(os.Stdin)
@@ -61,6 +76,7 @@ func doPost(cCtx *cli.Context) error {
 	} else {
 		content = strings.Join(cCtx.Args().Slice(), "\n")
 	}
+	profile, _ := cCtx.App.Metadata["profile"].(string)
 	return callPost(&postArg{
 		ctx:            cCtx.Context,
 		cfg:            cCtx.App.Metadata["config"].(*Config),
@@ -76,6 +92,8 @@ func doPost(cCtx *cli.Context) error {
 		images:         images,
 		servers:        cCtx.StringSlice("server"),
 		createdAt:      nostr.Timestamp(cCtx.Int64("created-at")),
+		at:             at,
+		profile:        profile,
 	})
 }
 
@@ -89,11 +107,14 @@ type postArg struct {
 	articleTitle   string
 	articleSummary string
 	emoji          []string
+	emojis         map[string]string // shortcode→icon map for inline :name: expansion
 	us             []string
 	tags           []string
 	images         []string
 	servers        []string
 	createdAt      nostr.Timestamp
+	at             nostr.Timestamp // when set, queue the signed event instead of publishing it
+	profile        string          // selects the schedule queue used with at
 }
 
 func callPost(arg *postArg) error {
@@ -108,6 +129,9 @@ func callPost(arg *postArg) error {
 	}
 
 	createdAt := arg.createdAt
+	if arg.at != 0 {
+		createdAt = arg.at
+	}
 	if createdAt == 0 {
 		createdAt = nostr.Now()
 	}
@@ -120,6 +144,9 @@ func callPost(arg *postArg) error {
 	}
 	arg.content = appendImageURLs(arg.content, bds)
 
+	if arg.emojis == nil {
+		arg.emojis = arg.cfg.emojiMap(arg.ctx)
+	}
 	ev, err := buildPostEvent(arg, pub, mentionPubkeys, createdAt)
 	if err != nil {
 		return err
@@ -129,6 +156,16 @@ func callPost(arg *postArg) error {
 		return err
 	}
 
+	if arg.at != 0 {
+		if err := enqueueScheduled(arg.profile, ev); err != nil {
+			return err
+		}
+		if id, err := nip19.EncodeNote(ev.ID); err == nil {
+			fmt.Printf("scheduled %s for %s\n", id, arg.at.Time().Format("2006-01-02T15:04:05"))
+		}
+		return nil
+	}
+
 	var success atomic.Int64
 	arg.cfg.Do(arg.ctx, Relay{Write: true}, func(ctx context.Context, relay *nostr.Relay) bool {
 		err := relay.Publish(ctx, *ev)
@@ -265,7 +302,7 @@ func doReply(cCtx *cli.Context) error {
 		Sensitive: cCtx.String("sensitive"),
 		Geohash:   cCtx.String("geohash"),
 		Emojis:    cCtx.StringSlice("emoji"),
-	}, cfg.Emojis, nostr.Now())
+	}, cfg.emojiMap(context.Background()), nostr.Now())
 	if err != nil {
 		return err
 	}
@@ -406,7 +443,8 @@ func callLike(arg *likeArg) error {
 		})
 	}
 
-	ev, err := buildLikeEvent(pub, arg.id, firstRelayHint(hints, ""), arg.content, arg.emoji, mentionedPubkeys, nostr.Now())
+	content, emoji := reactionEmoji(arg.content, arg.emoji, arg.cfg.emojiMap(arg.ctx))
+	ev, err := buildLikeEvent(pub, arg.id, firstRelayHint(hints, ""), content, emoji, mentionedPubkeys, nostr.Now())
 	if err != nil {
 		return err
 	}
@@ -721,9 +759,18 @@ func doStream(cCtx *cli.Context) error {
 		return err
 	}
 
+	feed := &listFeed{}
+	if list := cCtx.String("list"); list != "" {
+		if feed, err = cfg.listFeed(context.Background(), list); err != nil {
+			return err
+		}
+	}
+
 	// get followers
 	var follows []string
-	if global {
+	if len(feed.authors) > 0 {
+		follows = feed.authors
+	} else if global || len(feed.hashtags) > 0 {
 		follows = nil
 	} else {
 		if len(authors) > 0 {
@@ -756,13 +803,18 @@ func doStream(cCtx *cli.Context) error {
 		}
 		filter.Tags[name] = tag
 	}
+	if len(feed.hashtags) > 0 {
+		filter.Tags["t"] = feed.hashtags
+	}
 
-	relays := []string{}
-	for rurl, relay := range cfg.Relays {
-		if !relay.Global {
-			continue
+	relays := feed.relays
+	if len(relays) == 0 {
+		for rurl, relay := range cfg.Relays {
+			if !relay.Global {
+				continue
+			}
+			relays = append(relays, rurl)
 		}
-		relays = append(relays, rurl)
 	}
 	if len(relays) == 0 {
 		for rurl, relay := range cfg.Relays {
@@ -827,6 +879,7 @@ func doTimeline(cCtx *cli.Context) error {
 		u:       cCtx.String("u"),
 		n:       cCtx.Int("n"),
 		article: cCtx.Bool("article"),
+		list:    cCtx.String("list"),
 	})
 
 	if err != nil {
@@ -850,11 +903,56 @@ type timelineArg struct {
 	j       bool
 	extra   bool
 	article bool
+	list    string
+}
+
+// listFeed is what --list contributes to a timeline or stream: the authors
+// of a follow set, the hashtags of an interest list or the relays of a relay
+// set.
+type listFeed struct {
+	authors  []string
+	hashtags []string
+	relays   []string
+}
+
+func (cfg *Config) listFeed(ctx context.Context, spec string) (*listFeed, error) {
+	ls, err := parseListSource(spec)
+	if err != nil {
+		return nil, err
+	}
+	items, err := cfg.resolveListSource(ctx, ls)
+	if err != nil {
+		return nil, err
+	}
+	if len(items) == 0 {
+		return nil, fmt.Errorf("list '%s' is empty", spec)
+	}
+	switch ls.itemTag() {
+	case "t":
+		return &listFeed{hashtags: items}, nil
+	case "relay":
+		return &listFeed{relays: items}, nil
+	}
+	return &listFeed{authors: items}, nil
 }
 
 func callTimeline(arg *timelineArg) ([]*nostr.Event, error) {
+	ctx := arg.ctx
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	feed := &listFeed{}
+	if arg.list != "" {
+		var err error
+		if feed, err = arg.cfg.listFeed(ctx, arg.list); err != nil {
+			return nil, err
+		}
+	}
+
 	var follows []string
-	if arg.global {
+	if len(feed.authors) > 0 {
+		follows = feed.authors
+	} else if arg.global || len(feed.hashtags) > 0 {
 		follows = nil
 	} else {
 		if arg.u == "" {
@@ -884,23 +982,35 @@ func callTimeline(arg *timelineArg) ([]*nostr.Event, error) {
 			Limit:   arg.n,
 		},
 	}
+	if len(feed.hashtags) > 0 {
+		filters[0].Tags = nostr.TagMap{"t": feed.hashtags}
+	}
 
 	// Collect all events
 	events := []*nostr.Event{}
-	arg.cfg.StreamEvents(filters, true, func(ev *nostr.Event) bool {
-		events = append(events, ev)
-		return true
-	})
+	if len(feed.relays) > 0 {
+		evs, err := arg.cfg.queryRelays(ctx, feed.relays, filters)
+		if err != nil {
+			return nil, err
+		}
+		events = evs
+	} else {
+		arg.cfg.StreamEvents(filters, true, func(ev *nostr.Event) bool {
+			events = append(events, ev)
+			return true
+		})
+	}
 
 	// Sort by timestamp descending (newest last)
 	sort.Slice(events, func(i, j int) bool {
 		return events[j].CreatedAt.Time().After(events[i].CreatedAt.Time())
 	})
 
+	// Hide labelled notes first so that up to n are left to show.
+	events = arg.cfg.applyLabels(ctx, events)
 	if len(events) > arg.n {
 		events = events[len(events)-arg.n:]
 	}
-
 	return events, nil
 }
 
@@ -982,7 +1092,7 @@ func callReply(arg *replyArg) error {
 		Content:   arg.content,
 		ReplyToID: id,
 		RelayHint: firstRelayHint(hints, firstWriteRelay(arg.cfg)),
-	}, arg.cfg.Emojis, nostr.Now())
+	}, arg.cfg.emojiMap(arg.ctx), nostr.Now())
 	if err != nil {
 		return err
 	}
@@ -1235,6 +1345,8 @@ type reportArg struct {
 	cfg        *Config
 	id         string
 	reportType string
+	labelNS    string   // NIP-32 namespace for labels
+	labels     []string // optional NIP-32 labels attached to the report
 }
 
 func callReport(arg *reportArg) error {
@@ -1308,6 +1420,7 @@ func callReport(arg *reportArg) error {
 	if targetEventID != "" {
 		report.Tags = append(report.Tags, nostr.Tag{"e", targetEventID})
 	}
+	report.Tags = appendLabelTags(report.Tags, arg.labelNS, arg.labels)
 
 	if err := arg.cfg.signEvent(report); err != nil {
 		return err
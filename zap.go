@@ -2,19 +2,27 @@ package main
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
+	"math"
 	"net/http"
 	"net/url"
 	"os"
+	"slices"
+	"strconv"
 	"strings"
+	"unicode/utf8"
+
+	"github.com/btcsuite/btcd/btcutil/bech32"
 
 	"github.com/mdp/qrterminal/v3"
 	"github.com/urfave/cli/v2"
 
 	"github.com/nbd-wtf/go-nostr"
-	"github.com/nbd-wtf/go-nostr/nip04"
 	"github.com/nbd-wtf/go-nostr/nip19"
 )
 
@@ -22,120 +30,58 @@ import (
 type Lnurlp struct {
 	Callback       string `json:"callback"`
 	MaxSendable    int64  `json:"maxSendable"`
-	MinSendable    int    `json:"minSendable"`
+	MinSendable    int64  `json:"minSendable"`
 	Metadata       string `json:"metadata"`
 	CommentAllowed int    `json:"commentAllowed"`
 	Tag            string `json:"tag"`
 	AllowsNostr    bool   `json:"allowsNostr"`
 	NostrPubkey    string `json:"nostrPubkey"`
+	Status         string `json:"status"`
+	Reason         string `json:"reason"`
 }
 
 // Invoice is
 type Invoice struct {
-	PR string `json:"pr"`
-}
-
-// PayRequest is
-type PayRequest struct {
-	Method string `json:"method"`
-	Params struct {
-		Invoice string   `json:"invoice"`
-		Routes  []string `json:"routes:"`
-	} `json:"params"`
-}
-
-// PayResponse is
-type PayResponse struct {
-	ResultType *string `json:"result_type"`
-	Err        *struct {
-		Code    string `json:"code"`
-		Message string `json:"message"`
-	} `json:"error"`
-	Result *struct {
-		Preimage string `json:"preimage"`
-	} `json:"result"`
+	PR     string `json:"pr"`
+	Status string `json:"status"`
+	Reason string `json:"reason"`
 }
 
-func pay(cfg *Config, invoice string) error {
-	uri, err := url.Parse(cfg.NwcURI)
-	if err != nil {
-		return err
-	}
-	wallet := uri.Host
-	host := uri.Query().Get("relay")
-	secret := uri.Query().Get("secret")
-	pub, err := nostr.GetPublicKey(secret)
-	if err != nil {
-		return err
-	}
-
-	relay, err := nostr.RelayConnect(context.Background(), host)
-	if err != nil {
-		return err
-	}
-	defer relay.Close()
-
-	ss, err := nip04.ComputeSharedSecret(wallet, secret)
-	if err != nil {
-		return err
-	}
-	var req PayRequest
-	req.Method = "pay_invoice"
-	req.Params.Invoice = invoice
-	b, err := json.Marshal(req)
-	if err != nil {
-		return err
-	}
-	content, err := nip04.Encrypt(string(b), ss)
-	if err != nil {
-		return err
-	}
-
-	ev := nostr.Event{
-		PubKey:    pub,
-		CreatedAt: nostr.Now(),
-		Kind:      nostr.KindNWCWalletRequest,
-		Tags:      nostr.Tags{nostr.Tag{"p", wallet}},
-		Content:   content,
-	}
-	err = ev.Sign(secret)
-	if err != nil {
-		return err
+// lnurlpURL returns the LNURL-pay endpoint of a profile: the lightning
+// address (lud16) if set, else the bech32 LNURL (lud06).
+func lnurlpURL(profile *Profile) (string, error) {
+	if profile.Lud16 != "" {
+		tok := strings.SplitN(profile.Lud16, "@", 2)
+		if len(tok) != 2 || tok[0] == "" || tok[1] == "" {
+			return "", errors.New("receipt address is not valid")
+		}
+		return "https://" + tok[1] + "/.well-known/lnurlp/" + tok[0], nil
 	}
-
-	filters := []nostr.Filter{{
-		Tags: nostr.TagMap{
-			"p": []string{pub},
-			"e": []string{ev.ID},
-		},
-		Kinds: []int{nostr.KindNWCWalletInfo, nostr.KindNWCWalletResponse, nostr.KindNWCWalletRequest},
-		Limit: 1,
-	}}
-	sub, err := relay.Subscribe(context.Background(), filters)
-	if err != nil {
-		return err
+	if profile.Lud06 != "" {
+		return decodeLNURL(profile.Lud06)
 	}
+	return "", errors.New("user has no lightning address (lud16 or lud06)")
+}
 
-	err = relay.Publish(context.Background(), ev)
+// decodeLNURL decodes a bech32 LNURL (LUD-01) to its URL.
+func decodeLNURL(s string) (string, error) {
+	s = strings.TrimPrefix(strings.ToLower(strings.TrimSpace(s)), "lightning:")
+	hrp, data, err := bech32.DecodeNoLimit(s)
 	if err != nil {
-		return err
+		return "", fmt.Errorf("invalid lud06: %w", err)
 	}
-
-	er := <-sub.Events
-	content, err = nip04.Decrypt(er.Content, ss)
-	if err != nil {
-		return err
+	if hrp != "lnurl" {
+		return "", errors.New("invalid lud06: not an LNURL")
 	}
-	var resp PayResponse
-	err = json.Unmarshal([]byte(content), &resp)
+	b, err := bech32.ConvertBits(data, 5, 8, false)
 	if err != nil {
-		return err
+		return "", fmt.Errorf("invalid lud06: %w", err)
 	}
-	if resp.Err != nil {
-		return errors.New(resp.Err.Message)
+	u, err := url.Parse(string(b))
+	if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+		return "", errors.New("invalid lud06: not a URL")
 	}
-	json.NewEncoder(os.Stdout).Encode(resp)
-	return nil
+	return u.String(), nil
 }
 
 // ZapInfo is
@@ -163,15 +109,11 @@ func (cfg *Config) ZapInfo(pub string) (*Lnurlp, error) {
 		return nil, err
 	}
 
-	tok := strings.SplitN(profile.Lud16, "@", 2)
+	u, err := lnurlpURL(&profile)
 	if err != nil {
 		return nil, err
 	}
-	if len(tok) != 2 {
-		return nil, errors.New("receipt address is not valid")
-	}
-
-	resp, err := http.Get("https://" + tok[1] + "/.well-known/lnurlp/" + tok[0])
+	resp, err := http.Get(u)
 	if err != nil {
 		return nil, err
 	}
@@ -180,11 +122,120 @@ func (cfg *Config) ZapInfo(pub string) (*Lnurlp, error) {
 	var lp Lnurlp
 	err = json.NewDecoder(resp.Body).Decode(&lp)
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("%s: %s: %w", u, resp.Status, err)
+	}
+	if strings.EqualFold(lp.Status, "ERROR") {
+		return nil, fmt.Errorf("%s: %s", u, lp.Reason)
+	}
+	if lp.Tag != "" && lp.Tag != "payRequest" {
+		return nil, fmt.Errorf("%s: not a pay request (%s)", u, lp.Tag)
+	}
+	if lp.Callback == "" {
+		return nil, fmt.Errorf("%s: no callback", u)
 	}
 	return &lp, nil
 }
 
+// checkZap checks a zap of msat with comment against what the LNURL server
+// accepts.
+func (lp *Lnurlp) checkZap(msat int64, comment string) error {
+	if !lp.AllowsNostr {
+		return errors.New("LNURL server does not support zaps")
+	}
+	if !nostr.IsValid32ByteHex(lp.NostrPubkey) {
+		return errors.New("LNURL server has an invalid nostrPubkey")
+	}
+	if lp.MinSendable > 0 && msat < lp.MinSendable {
+		return fmt.Errorf("%d sats is below the minimum of %d sats", msat/1000, (lp.MinSendable+999)/1000)
+	}
+	if lp.MaxSendable > 0 && msat > lp.MaxSendable {
+		return fmt.Errorf("%d sats is above the maximum of %d sats", msat/1000, lp.MaxSendable/1000)
+	}
+	if n := utf8.RuneCountInString(comment); n > lp.CommentAllowed {
+		if lp.CommentAllowed == 0 {
+			return errors.New("LNURL server does not accept comments")
+		}
+		return fmt.Errorf("comment is %d characters, the LNURL server accepts %d", n, lp.CommentAllowed)
+	}
+	return nil
+}
+
+// checkZapInvoice checks that the invoice from the callback is for msat and
+// commits to the zap request, as NIP-57 asks of the sender.
+func checkZapInvoice(pr string, msat int64, zapRequest []byte) error {
+	inv, err := decodeBolt11(pr)
+	if err != nil {
+		return err
+	}
+	if inv.MSat != msat {
+		return fmt.Errorf("invoice is for %d msat, expected %d msat", inv.MSat, msat)
+	}
+	sum := sha256.Sum256(zapRequest)
+	if inv.DescriptionHash != hex.EncodeToString(sum[:]) {
+		return errors.New("invoice description hash does not match the zap request")
+	}
+	return nil
+}
+
+// zapShare is the part of a zap that goes to one recipient.
+type zapShare struct {
+	Pubkey string
+	Sats   uint64
+}
+
+// zapSplits splits sats among the NIP-57 "zap" tags of an event by weight.
+// When some tags have a weight, tags without one are skipped; when none has,
+// the amount is split equally. Rounding leftovers go to the first recipients
+// and zero shares are dropped. It returns nil when tags has no usable zap tag.
+func zapSplits(tags nostr.Tags, sats uint64) []zapShare {
+	type split struct {
+		pubkey string
+		weight float64
+	}
+	var splits []split
+	weighted := false
+	for _, tag := range tags {
+		if len(tag) < 2 || tag[0] != "zap" || !nostr.IsValidPublicKey(tag[1]) {
+			continue
+		}
+		w := math.NaN()
+		if len(tag) >= 4 {
+			if f, err := strconv.ParseFloat(tag[3], 64); err == nil && f >= 0 && !math.IsInf(f, 0) {
+				w = f
+				weighted = true
+			}
+		}
+		splits = append(splits, split{pubkey: tag[1], weight: w})
+	}
+
+	var total float64
+	for i := range splits {
+		if !weighted {
+			splits[i].weight = 1
+		} else if math.IsNaN(splits[i].weight) {
+			splits[i].weight = 0
+		}
+		total += splits[i].weight
+	}
+	if total == 0 {
+		return nil
+	}
+
+	shares := make([]zapShare, len(splits))
+	var given uint64
+	for i, sp := range splits {
+		shares[i] = zapShare{Pubkey: sp.pubkey, Sats: uint64(float64(sats) * sp.weight / total)}
+		given += shares[i].Sats
+	}
+	for i := 0; given < sats; i = (i + 1) % len(shares) {
+		if splits[i].weight > 0 {
+			shares[i].Sats++
+			given++
+		}
+	}
+	return slices.DeleteFunc(shares, func(s zapShare) bool { return s.Sats == 0 })
+}
+
 func doZap(cCtx *cli.Context) error {
 	if cCtx.Args().Len() == 0 {
 		return cli.ShowSubcommandHelp(cCtx)
@@ -195,6 +246,10 @@ func doZap(cCtx *cli.Context) error {
 		amount:  cCtx.Uint64("amount"),
 		comment: cCtx.String("comment"),
 		id:      cCtx.Args().First(),
+		wait:    cCtx.Bool("wait"),
+		wallet:  cCtx.String("wallet"),
+		yes:     cCtx.Bool("yes"),
+		out:     os.Stdout,
 	})
 }
 
@@ -204,6 +259,20 @@ type zapArg struct {
 	amount  uint64
 	comment string
 	id      string
+	wait    bool      // wait for the zap receipt and check it
+	wallet  string    // named wallet to pay from, empty for nwc-uri
+	agent   bool      // zap from the MCP server, within the agent budget
+	yes     bool      // do not ask before paying above confirm-above
+	out     io.Writer // invoices, payment results and the summary; nil discards them
+}
+
+// output is where the zap writes what it has to tell. The MCP server speaks
+// JSON-RPC on stdout, so it collects this instead.
+func (arg *zapArg) output() io.Writer {
+	if arg.out == nil {
+		return io.Discard
+	}
+	return arg.out
 }
 
 func callZap(arg *zapArg) error {
@@ -213,107 +282,245 @@ func callZap(arg *zapArg) error {
 	} else {
 		return err
 	}
+	pub, err := nostr.GetPublicKey(sk)
+	if err != nil {
+		return err
+	}
+	walletURI, err := arg.cfg.walletURI(arg.wallet)
+	if err != nil {
+		return err
+	}
+	if walletURI == "" && arg.agent {
+		return errors.New("no wallet: set nwc-uri in the config")
+	}
 
 	receipt := ""
-	zr := nostr.Event{}
-	zr.Tags = nostr.Tags{}
-	clientTag(&zr)
-
-	if pub, err := nostr.GetPublicKey(sk); err == nil {
-		if _, err := nip19.EncodePublicKey(pub); err != nil {
+	eventID := ""
+	var target *nostr.Event
+	prefix, s, err := nip19.Decode(arg.id)
+	if err != nil && nostr.IsValid32ByteHex(arg.id) {
+		prefix, s, err = "note", arg.id, nil
+	}
+	if err != nil {
+		return errors.New("invalid argument")
+	}
+	switch prefix {
+	case "nevent", "note":
+		if prefix == "nevent" {
+			receipt = s.(nostr.EventPointer).Author
+			eventID = s.(nostr.EventPointer).ID
+		} else {
+			eventID = s.(string)
+		}
+		filters := nostr.Filters{
+			{
+				IDs: []string{eventID},
+			},
+		}
+		evs, err := arg.cfg.QueryEvents(context.Background(), filters)
+		if err != nil {
 			return err
 		}
-		zr.PubKey = pub
-	} else {
-		return err
+		if len(evs) != 0 {
+			target = evs[0]
+			receipt = target.PubKey
+		}
+	case "npub":
+		receipt = s.(string)
+	default:
+		return errors.New("invalid argument")
 	}
 
-	zr.Tags = zr.Tags.AppendUnique(nostr.Tag{"amount", fmt.Sprint(arg.amount * 1000)})
 	relays := nostr.Tag{"relays"}
 	for k, v := range arg.cfg.Relays {
 		if v.Write {
 			relays = append(relays, k)
 		}
 	}
-	zr.Tags = zr.Tags.AppendUnique(relays)
-	if prefix, s, err := nip19.Decode(arg.id); err == nil {
-		switch prefix {
-		case "nevent":
-			receipt = s.(nostr.EventPointer).Author
-			zr.Tags = zr.Tags.AppendUnique(nostr.Tag{"p", receipt})
-			zr.Tags = zr.Tags.AppendUnique(nostr.Tag{"e", s.(nostr.EventPointer).ID})
-		case "note":
-			filters := nostr.Filters{
-				{
-					IDs: []string{s.(string)},
-				},
+
+	shares := []zapShare{{Pubkey: receipt, Sats: arg.amount}}
+	if target != nil {
+		if splits := zapSplits(target.Tags, arg.amount); len(splits) > 0 {
+			shares = splits
+		}
+	}
+
+	// Check every recipient before paying anyone.
+	infos := make([]*Lnurlp, len(shares))
+	for i, share := range shares {
+		zi, err := arg.cfg.ZapInfo(share.Pubkey)
+		if err == nil {
+			err = zi.checkZap(int64(share.Sats)*1000, arg.comment)
+		}
+		if err != nil {
+			if len(shares) > 1 {
+				return fmt.Errorf("%s: %w", arg.cfg.displayName(share.Pubkey), err)
 			}
-			evs, err := arg.cfg.QueryEvents(context.Background(), filters)
-			if err != nil {
-				return err
+			return err
+		}
+		infos[i] = zi
+	}
+
+	// zapAll zaps every share and sets what it spent in e: paid shares, and
+	// shares whose payment timed out, which the wallet may still settle.
+	zapAll := func(e *ledgerEntry) error {
+		var paid int64
+		errs := make([]error, len(shares))
+		failed := 0
+		for i, share := range shares {
+			var ok bool
+			ok, errs[i] = arg.zapOne(pub, relays, eventID, share, infos[i], walletURI != "")
+			var pending *zapPendingError
+			if ok {
+				paid += int64(share.Sats)
+			} else if errors.As(errs[i], &pending) {
+				e.Pending = append(e.Pending, pendingPayment{Invoice: pending.Invoice, Sats: int64(share.Sats)})
+				paid += int64(share.Sats)
 			}
-			if len(evs) != 0 {
-				receipt = evs[0].PubKey
-				zr.Tags = zr.Tags.AppendUnique(nostr.Tag{"p", receipt})
+			if errs[i] != nil {
+				failed++
 			}
-			zr.Tags = zr.Tags.AppendUnique(nostr.Tag{"e", s.(string)})
-		case "npub":
-			receipt = s.(string)
-			zr.Tags = zr.Tags.AppendUnique(nostr.Tag{"p", receipt})
-		default:
-			return errors.New("invalid argument")
 		}
+		e.Sats = paid
+		if len(shares) == 1 {
+			return errs[0]
+		}
+		// Without a wallet nothing is paid here: each share only shows its
+		// invoice.
+		done := "invoice shown"
+		if walletURI != "" {
+			done = "ok"
+			fmt.Fprintf(arg.output(), "zapped %d of %d sats to %d recipients:\n", paid, arg.amount, len(shares))
+		}
+		for i, share := range shares {
+			status := done
+			if errs[i] != nil {
+				status = "failed: " + errs[i].Error()
+			}
+			fmt.Fprintf(arg.output(), "  %7d sats  %s  %s\n", share.Sats, arg.cfg.displayName(share.Pubkey), status)
+		}
+		if failed > 0 {
+			return fmt.Errorf("%d of %d zaps failed", failed, len(shares))
+		}
+		return nil
+	}
+
+	if walletURI == "" {
+		return zapAll(&ledgerEntry{})
 	}
+	spend := ledgerEntry{
+		Sats:      int64(arg.amount),
+		Recipient: receipt,
+		Event:     eventID,
+		Wallet:    arg.wallet,
+		Agent:     arg.agent,
+	}
+	return arg.cfg.spendZap(spend, arg.yes, zapAll)
+}
 
+// zapPendingError is returned by zapOne when the wallet did not answer
+// pay_invoice in time, so the invoice may or may not get paid.
+type zapPendingError struct {
+	Invoice string
+	err     error
+}
+
+func (e *zapPendingError) Error() string {
+	return "payment pending: " + e.err.Error()
+}
+
+func (e *zapPendingError) Unwrap() error { return e.err }
+
+// zapOne sends a zap request for share to the recipient's LNURL server, then
+// pays the invoice or shows it as a QR code, and waits for the receipt when
+// asked. paid reports whether the invoice was paid, even if waiting failed.
+func (arg *zapArg) zapOne(pub string, relays nostr.Tag, eventID string, share zapShare, zi *Lnurlp, withWallet bool) (paid bool, err error) {
+	msat := int64(share.Sats) * 1000
+	zr := nostr.Event{}
+	zr.Tags = nostr.Tags{}
+	clientTag(&zr)
+	zr.PubKey = pub
+	zr.Tags = append(zr.Tags, nostr.Tag{"amount", fmt.Sprint(msat)}, relays, nostr.Tag{"p", share.Pubkey})
+	if eventID != "" {
+		zr.Tags = append(zr.Tags, nostr.Tag{"e", eventID})
+	}
 	zr.Kind = nostr.KindZapRequest // 9734
 	zr.CreatedAt = nostr.Now()
 	zr.Content = arg.comment
 	if err := arg.cfg.signEvent(&zr); err != nil {
-		return err
+		return false, err
 	}
 	b, err := zr.MarshalJSON()
 	if err != nil {
-		return err
+		return false, err
 	}
 
-	zi, err := arg.cfg.ZapInfo(receipt)
-	if err != nil {
-		return err
-	}
 	u, err := url.Parse(zi.Callback)
 	if err != nil {
-		return err
+		return false, err
 	}
-	param := url.Values{}
-	param.Set("amount", fmt.Sprint(arg.amount*1000))
+	param := u.Query()
+	param.Set("amount", fmt.Sprint(msat))
 	param.Set("nostr", string(b))
 	u.RawQuery = param.Encode()
 	resp, err := http.Get(u.String())
 	if err != nil {
-		return err
+		return false, err
 	}
 	defer resp.Body.Close()
 
 	var iv Invoice
 	err = json.NewDecoder(resp.Body).Decode(&iv)
 	if err != nil {
-		return err
+		return false, err
+	}
+	if strings.EqualFold(iv.Status, "ERROR") {
+		return false, errors.New(iv.Reason)
+	}
+	if err := checkZapInvoice(iv.PR, msat, b); err != nil {
+		return false, err
 	}
 
-	if arg.cfg.NwcURI == "" {
+	if !withWallet {
 		config := qrterminal.Config{
 			HalfBlocks: false,
 			Level:      qrterminal.L,
-			Writer:     os.Stdout,
+			Writer:     arg.output(),
 			WhiteChar:  qrterminal.WHITE,
 			BlackChar:  qrterminal.BLACK,
 			QuietZone:  2,
 			WithSixel:  true,
 		}
-		fmt.Println("lightning:" + iv.PR)
+		fmt.Fprintln(arg.output(), "lightning:"+iv.PR)
 		qrterminal.GenerateWithConfig("lightning:"+iv.PR, config)
 	} else {
-		pay(arg.cfg, iv.PR)
+		if err := pay(arg.cfg, arg.wallet, iv.PR, arg.output()); err != nil {
+			if errors.Is(err, errNWCTimeout) {
+				return false, &zapPendingError{Invoice: iv.PR, err: err}
+			}
+			return false, err
+		}
+		paid = true
 	}
-	return nil
+
+	if !arg.wait {
+		return paid, nil
+	}
+	ctx := arg.ctx
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	// The LNURL server publishes the receipt to the relays of the request.
+	waitRelays := append([]string{}, relays[1:]...)
+	for k, v := range arg.cfg.Relays {
+		if v.Read && !slices.Contains(waitRelays, k) {
+			waitRelays = append(waitRelays, k)
+		}
+	}
+	zapReceipt, err := waitZapReceipt(ctx, arg.cfg, waitRelays, &zr, iv.PR, zi.NostrPubkey)
+	if err != nil {
+		return paid, err
+	}
+	fmt.Fprintf(arg.output(), "zap receipt %s: %d sats\n", zapReceipt.Event.ID, zapReceipt.MSat/1000)
+	return paid, nil
 }
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"crypto/sha256"
+	"strings"
+	"testing"
+
+	"github.com/btcsuite/btcd/btcutil/bech32"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+func TestLnurlpURL(t *testing.T) {
+	const endpoint = "https://service.example/api/v1/lnurl/pay/AbC?x=1"
+	groups, err := bech32.ConvertBits([]byte(endpoint), 8, 5, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lnurl, err := bech32.Encode("lnurl", groups)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		profile Profile
+		want    string
+	}{
+		{Profile{Lud16: "alice@example.com"}, "https://example.com/.well-known/lnurlp/alice"},
+		{Profile{Lud16: "alice@example.com", Lud06: lnurl}, "https://example.com/.well-known/lnurlp/alice"},
+		{Profile{Lud06: lnurl}, endpoint},
+		{Profile{Lud06: "lightning:" + strings.ToUpper(lnurl)}, endpoint},
+	} {
+		got, err := lnurlpURL(&tc.profile)
+		if err != nil || got != tc.want {
+			t.Errorf("%+v: got %q, %v", tc.profile, got, err)
+		}
+	}
+
+	notURL, _ := bech32.ConvertBits([]byte("not a url"), 8, 5, true)
+	notLnurl, _ := bech32.Encode("lnurl", notURL)
+	other, _ := bech32.Encode("lnbc", groups)
+	for _, profile := range []Profile{
+		{},
+		{Lud16: "alice"},
+		{Lud06: "lnurl1nope"},
+		{Lud06: notLnurl},
+		{Lud06: other},
+	} {
+		if _, err := lnurlpURL(&profile); err == nil {
+			t.Errorf("%+v: expected error", profile)
+		}
+	}
+}
+
+func TestLnurlpCheckZap(t *testing.T) {
+	server, _ := nostr.GetPublicKey(nostr.GeneratePrivateKey())
+	lp := &Lnurlp{AllowsNostr: true, NostrPubkey: server, MinSendable: 1000, MaxSendable: 10_000_000, CommentAllowed: 5}
+	for _, tc := range []struct {
+		msat    int64
+		comment string
+		want    string
+	}{
+		{1000, "", ""},
+		{10_000_000, "ありがとう", ""},
+		{999, "", "below the minimum of 1 sats"},
+		{10_001_000, "", "above the maximum of 10000 sats"},
+		{21000, "thanks!", "comment is 7 characters"},
+	} {
+		err := lp.checkZap(tc.msat, tc.comment)
+		if tc.want == "" && err != nil || tc.want != "" && (err == nil || !strings.Contains(err.Error(), tc.want)) {
+			t.Errorf("%d msat %q: err=%v, want %q", tc.msat, tc.comment, err, tc.want)
+		}
+	}
+
+	for _, bad := range []*Lnurlp{
+		{NostrPubkey: server},
+		{AllowsNostr: true},
+		{AllowsNostr: true, NostrPubkey: server, CommentAllowed: 0},
+	} {
+		if err := bad.checkZap(1000, "hi"); err == nil {
+			t.Errorf("%+v: expected error", bad)
+		}
+	}
+}
+
+func TestCheckZapInvoice(t *testing.T) {
+	zapRequest := []byte(`{"kind":9734}`)
+	sum := sha256.Sum256(zapRequest)
+	pr := testBolt11(t, "lnbc210n", sum[:])
+	if err := checkZapInvoice(pr, 21000, zapRequest); err != nil {
+		t.Fatal(err)
+	}
+	if err := checkZapInvoice(pr, 22000, zapRequest); err == nil {
+		t.Error("wrong amount must fail")
+	}
+	if err := checkZapInvoice(pr, 21000, []byte(`{"kind":1}`)); err == nil {
+		t.Error("wrong description hash must fail")
+	}
+}
+
+func TestZapSplits(t *testing.T) {
+	var pubs []string
+	for range 3 {
+		pub, _ := nostr.GetPublicKey(nostr.GeneratePrivateKey())
+		pubs = append(pubs, pub)
+	}
+	sum := func(shares []zapShare) (n uint64) {
+		for _, s := range shares {
+			n += s.Sats
+		}
+		return n
+	}
+
+	if shares := zapSplits(nostr.Tags{{"p", pubs[0]}}, 100); shares != nil {
+		t.Errorf("no zap tags: %+v", shares)
+	}
+
+	// Weighted, with a tag without weight that is skipped.
+	shares := zapSplits(nostr.Tags{
+		{"zap", pubs[0], "wss://relay.example", "1"},
+		{"zap", pubs[1], "wss://relay.example", "2"},
+		{"zap", pubs[2], "wss://relay.example"},
+		{"zap", "nope", "", "5"},
+	}, 100)
+	if len(shares) != 2 || shares[0].Pubkey != pubs[0] || shares[0].Sats != 34 || shares[1].Sats != 66 || sum(shares) != 100 {
+		t.Errorf("weighted: %+v", shares)
+	}
+
+	// Without weights the amount is split equally.
+	shares = zapSplits(nostr.Tags{{"zap", pubs[0]}, {"zap", pubs[1]}, {"zap", pubs[2]}}, 10)
+	if len(shares) != 3 || shares[0].Sats != 4 || shares[1].Sats != 3 || shares[2].Sats != 3 {
+		t.Errorf("equal: %+v", shares)
+	}
+
+	// Shares that round to nothing are dropped.
+	shares = zapSplits(nostr.Tags{{"zap", pubs[0], "", "98"}, {"zap", pubs[1], "", "1"}, {"zap", pubs[2], "", "1"}}, 2)
+	if len(shares) != 1 || shares[0].Pubkey != pubs[0] || shares[0].Sats != 2 {
+		t.Errorf("small: %+v", shares)
+	}
+
+	if shares := zapSplits(nostr.Tags{{"zap", pubs[0], "", "0"}}, 10); shares != nil {
+		t.Errorf("zero weight: %+v", shares)
+	}
+}
@@ -0,0 +1,317 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/nbd-wtf/go-nostr/nip19"
+	"github.com/nbd-wtf/go-nostr/sdk"
+	"github.com/urfave/cli/v2"
+)
+
+// zapReceiptTimeout is how long "zap --wait" waits for the receipt.
+const zapReceiptTimeout = 2 * time.Minute
+
+// zapReceipt is a kind 9735 receipt with its embedded zap request. Err is set
+// when the receipt fails NIP-57 validation.
+type zapReceipt struct {
+	Event     *nostr.Event
+	Request   *nostr.Event
+	Sender    string // pubkey of the zap request
+	Recipient string
+	Target    string // zapped event id or address, empty for a profile zap
+	MSat      int64
+	Comment   string
+	Invoice   string
+	Err       error
+}
+
+// parseZapReceipt reads and validates a zap receipt per NIP-57 appendix F:
+// the receipt must come from nostrPubkey, the recipient's LNURL server; the
+// bolt11 description hash must match the embedded zap request, and the
+// invoice amount must match the amount the request asked for.
+func parseZapReceipt(ev *nostr.Event, nostrPubkey string) *zapReceipt {
+	zr := &zapReceipt{Event: ev, Recipient: tagValue(ev.Tags, "p")}
+	zr.Err = zr.check(nostrPubkey)
+	return zr
+}
+
+func (zr *zapReceipt) check(nostrPubkey string) error {
+	ev := zr.Event
+	if ev.Kind != nostr.KindZap {
+		return fmt.Errorf("kind %d is not a zap receipt", ev.Kind)
+	}
+	zr.Invoice = tagValue(ev.Tags, "bolt11")
+	if zr.Invoice == "" {
+		return errors.New("no bolt11 invoice")
+	}
+	inv, err := decodeBolt11(zr.Invoice)
+	if err != nil {
+		return err
+	}
+	zr.MSat = inv.MSat
+
+	description := tagValue(ev.Tags, "description")
+	var req nostr.Event
+	if err := json.Unmarshal([]byte(description), &req); err != nil {
+		return errors.New("no zap request in description")
+	}
+	zr.Request = &req
+	zr.Sender = req.PubKey
+	zr.Comment = req.Content
+	if zr.Target = tagValue(req.Tags, "e"); zr.Target == "" {
+		zr.Target = tagValue(req.Tags, "a")
+	}
+	if req.Kind != nostr.KindZapRequest {
+		return fmt.Errorf("description is kind %d, not a zap request", req.Kind)
+	}
+	if ok, _ := req.CheckSignature(); !ok {
+		return errors.New("zap request signature is invalid")
+	}
+
+	sum := sha256.Sum256([]byte(description))
+	if inv.DescriptionHash != hex.EncodeToString(sum[:]) {
+		return errors.New("description hash does not match the zap request")
+	}
+	if nostrPubkey == "" {
+		return errors.New("recipient's LNURL server does not support zaps")
+	}
+	if ev.PubKey != nostrPubkey {
+		return errors.New("receipt is not from the recipient's LNURL server")
+	}
+	if p := tagValue(req.Tags, "p"); p != zr.Recipient {
+		return errors.New("zap request is for someone else")
+	}
+	if inv.MSat == 0 {
+		return errors.New("invoice has no amount")
+	}
+	if amount := tagValue(req.Tags, "amount"); amount != "" {
+		if n, err := strconv.ParseInt(amount, 10, 64); err != nil || n != inv.MSat {
+			return fmt.Errorf("invoice is for %d msat, the zap request asked for %s", inv.MSat, amount)
+		}
+	}
+	return nil
+}
+
+// zapPeriod returns the label of the period t falls in.
+func zapPeriod(t time.Time, period string) (string, error) {
+	switch period {
+	case "day":
+		return t.Format("2006-01-02"), nil
+	case "week":
+		y, w := t.ISOWeek()
+		return fmt.Sprintf("%d-W%02d", y, w), nil
+	case "month":
+		return t.Format("2006-01"), nil
+	case "year":
+		return t.Format("2006"), nil
+	}
+	return "", fmt.Errorf("unknown period %q (use day, week, month or year)", period)
+}
+
+type zapTotal struct {
+	Period string `json:"period"`
+	Count  int    `json:"count"`
+	Sats   int64  `json:"sats"`
+}
+
+// zapTotals sums the valid receipts per period, newest period first.
+func zapTotals(receipts []*zapReceipt, period string) ([]zapTotal, error) {
+	byPeriod := map[string]*zapTotal{}
+	for _, zr := range receipts {
+		if zr.Err != nil {
+			continue
+		}
+		label, err := zapPeriod(zr.Event.CreatedAt.Time(), period)
+		if err != nil {
+			return nil, err
+		}
+		t, ok := byPeriod[label]
+		if !ok {
+			t = &zapTotal{Period: label}
+			byPeriod[label] = t
+		}
+		t.Count++
+		t.Sats += zr.MSat / 1000
+	}
+	totals := make([]zapTotal, 0, len(byPeriod))
+	for _, t := range byPeriod {
+		totals = append(totals, *t)
+	}
+	sort.Slice(totals, func(i, j int) bool {
+		return totals[i].Period > totals[j].Period
+	})
+	return totals, nil
+}
+
+// zapServerPubkeys looks up the LNURL server key of each recipient once.
+type zapServerPubkeys struct {
+	cfg  *Config
+	keys map[string]string
+}
+
+func (z *zapServerPubkeys) get(pubkey string) string {
+	if key, ok := z.keys[pubkey]; ok {
+		return key
+	}
+	key := ""
+	if lp, err := z.cfg.ZapInfo(pubkey); err == nil && lp.AllowsNostr {
+		key = lp.NostrPubkey
+	} else if err != nil && z.cfg.verbose {
+		fmt.Fprintln(os.Stderr, err)
+	}
+	z.keys[pubkey] = key
+	return key
+}
+
+func zapTargetString(target string) string {
+	if nostr.IsValid32ByteHex(target) {
+		if note, err := nip19.EncodeNote(target); err == nil {
+			return note
+		}
+	}
+	return target
+}
+
+func doZapList(cCtx *cli.Context) error {
+	cfg := cCtx.App.Metadata["config"].(*Config)
+	_, pub, err := getSkAndPub(cfg)
+	if err != nil {
+		return err
+	}
+	if cCtx.Bool("sent") && cCtx.Bool("received") {
+		return errors.New("use either --sent or --received")
+	}
+	period := cCtx.String("period")
+	if _, err := zapPeriod(time.Now(), period); err != nil {
+		return err
+	}
+
+	filter := nostr.Filter{Kinds: []int{nostr.KindZap}, Limit: cCtx.Int("n")}
+	switch {
+	case cCtx.String("note") != "":
+		evp := sdk.InputToEventPointer(cCtx.String("note"))
+		if evp == nil {
+			return fmt.Errorf("failed to parse event from '%s'", cCtx.String("note"))
+		}
+		filter.Tags = nostr.TagMap{"e": []string{evp.ID}}
+	case cCtx.Bool("sent"):
+		// NIP-57 receipts carry the sender in an uppercase P tag.
+		filter.Tags = nostr.TagMap{"P": []string{pub}}
+	default:
+		filter.Tags = nostr.TagMap{"p": []string{pub}}
+	}
+	evs, err := cfg.QueryEvents(context.Background(), nostr.Filters{filter})
+	if err != nil {
+		return err
+	}
+
+	servers := &zapServerPubkeys{cfg: cfg, keys: map[string]string{}}
+	var receipts []*zapReceipt
+	for i := len(evs) - 1; i >= 0; i-- {
+		ev := evs[i]
+		zr := parseZapReceipt(ev, servers.get(tagValue(ev.Tags, "p")))
+		if cCtx.Bool("sent") && zr.Sender != pub {
+			continue
+		}
+		receipts = append(receipts, zr)
+	}
+	totals, err := zapTotals(receipts, period)
+	if err != nil {
+		return err
+	}
+
+	if cCtx.Bool("json") {
+		type jsonReceipt struct {
+			ID        string `json:"id"`
+			CreatedAt int64  `json:"created_at"`
+			Sender    string `json:"sender"`
+			Recipient string `json:"recipient"`
+			Target    string `json:"target,omitempty"`
+			Sats      int64  `json:"sats"`
+			Comment   string `json:"comment,omitempty"`
+			Error     string `json:"error,omitempty"`
+		}
+		out := struct {
+			Receipts []jsonReceipt `json:"receipts"`
+			Totals   []zapTotal    `json:"totals"`
+		}{Receipts: []jsonReceipt{}, Totals: totals}
+		for _, zr := range receipts {
+			r := jsonReceipt{
+				ID:        zr.Event.ID,
+				CreatedAt: int64(zr.Event.CreatedAt),
+				Sender:    zr.Sender,
+				Recipient: zr.Recipient,
+				Target:    zr.Target,
+				Sats:      zr.MSat / 1000,
+				Comment:   zr.Comment,
+			}
+			if zr.Err != nil {
+				r.Error = zr.Err.Error()
+			}
+			out.Receipts = append(out.Receipts, r)
+		}
+		return json.NewEncoder(os.Stdout).Encode(out)
+	}
+
+	for _, zr := range receipts {
+		who := cfg.displayName(zr.Sender)
+		if cCtx.Bool("sent") {
+			who = "to " + cfg.displayName(zr.Recipient)
+		}
+		if zr.Sender == "" {
+			who = "?"
+		}
+		line := fmt.Sprintf("%s %7d sats  %s", zr.Event.CreatedAt.Time().Format("2006-01-02 15:04"), zr.MSat/1000, who)
+		if zr.Target != "" {
+			line += "  " + zapTargetString(zr.Target)
+		}
+		if zr.Comment != "" {
+			line += "  " + strconv.Quote(zr.Comment)
+		}
+		if zr.Err != nil {
+			line += "  (invalid: " + zr.Err.Error() + ")"
+		}
+		fmt.Println(line)
+	}
+	if len(totals) > 0 {
+		fmt.Println()
+	}
+	for _, t := range totals {
+		fmt.Printf("%s %4d zaps %9d sats\n", t.Period, t.Count, t.Sats)
+	}
+	return nil
+}
+
+// waitZapReceipt waits for the receipt of the invoice paid for zap request
+// zr on relays and validates it.
+func waitZapReceipt(ctx context.Context, cfg *Config, relays []string, zr *nostr.Event, invoice, nostrPubkey string) (*zapReceipt, error) {
+	ctx, cancel := context.WithTimeout(ctx, zapReceiptTimeout)
+	defer cancel()
+
+	filter := nostr.Filter{
+		Kinds: []int{nostr.KindZap},
+		Tags:  nostr.TagMap{"p": []string{tagValue(zr.Tags, "p")}},
+		Since: &zr.CreatedAt,
+	}
+	for ie := range cfg.pool.SubMany(ctx, relays, nostr.Filters{filter}) {
+		if ie.Event == nil || tagValue(ie.Event.Tags, "bolt11") != invoice {
+			continue
+		}
+		receipt := parseZapReceipt(ie.Event, nostrPubkey)
+		if receipt.Err != nil {
+			return receipt, receipt.Err
+		}
+		return receipt, nil
+	}
+	return nil, errors.New("no zap receipt received")
+}
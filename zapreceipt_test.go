@@ -0,0 +1,113 @@
+package main
+
+import (
+	"crypto/sha256"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// testZapReceipt returns a receipt from server for a zap request by sender
+// asking for amount msat, paid with an invoice for hrp.
+func testZapReceipt(t *testing.T, sender, server, recipient, amount, hrp string) *nostr.Event {
+	t.Helper()
+	req := nostr.Event{
+		Kind:      nostr.KindZapRequest,
+		CreatedAt: 100,
+		Content:   "great post",
+		Tags:      nostr.Tags{{"p", recipient}, {"e", testTargetID}, {"amount", amount}, {"relays", "wss://relay.example"}},
+	}
+	if err := req.Sign(sender); err != nil {
+		t.Fatal(err)
+	}
+	description := req.String()
+	sum := sha256.Sum256([]byte(description))
+	ev := &nostr.Event{
+		Kind:      nostr.KindZap,
+		CreatedAt: 110,
+		Tags: nostr.Tags{
+			{"p", recipient},
+			{"P", req.PubKey},
+			{"e", testTargetID},
+			{"bolt11", testBolt11(t, hrp, sum[:])},
+			{"description", description},
+		},
+	}
+	if err := ev.Sign(server); err != nil {
+		t.Fatal(err)
+	}
+	return ev
+}
+
+func TestParseZapReceipt(t *testing.T) {
+	sender, server := nostr.GeneratePrivateKey(), nostr.GeneratePrivateKey()
+	senderPub, _ := nostr.GetPublicKey(sender)
+	serverPub, _ := nostr.GetPublicKey(server)
+	recipient, _ := nostr.GetPublicKey(nostr.GeneratePrivateKey())
+
+	ev := testZapReceipt(t, sender, server, recipient, "21000", "lnbc210n")
+	zr := parseZapReceipt(ev, serverPub)
+	if zr.Err != nil {
+		t.Fatal(zr.Err)
+	}
+	if zr.MSat != 21000 || zr.Sender != senderPub || zr.Recipient != recipient || zr.Target != testTargetID || zr.Comment != "great post" {
+		t.Errorf("zr=%+v", zr)
+	}
+
+	tests := []struct {
+		name   string
+		ev     *nostr.Event
+		server string
+		want   string
+	}{
+		{"wrong server", ev, recipient, "LNURL server"},
+		{"no zap support", ev, "", "does not support zaps"},
+		{"amount mismatch", testZapReceipt(t, sender, server, recipient, "1000", "lnbc210n"), serverPub, "asked for 1000"},
+		{"no amount", testZapReceipt(t, sender, server, recipient, "21000", "lnbc"), serverPub, "no amount"},
+	}
+	tampered := *ev
+	tampered.Tags = append(nostr.Tags{}, ev.Tags...)
+	tampered.Tags[4] = nostr.Tag{"description", strings.Replace(ev.Tags[4][1], "great post", "great post!", 1)}
+	tests = append(tests, struct {
+		name   string
+		ev     *nostr.Event
+		server string
+		want   string
+	}{"tampered request", &tampered, serverPub, "signature"})
+
+	for _, tt := range tests {
+		zr := parseZapReceipt(tt.ev, tt.server)
+		if zr.Err == nil || !strings.Contains(zr.Err.Error(), tt.want) {
+			t.Errorf("%s: err=%v want %q", tt.name, zr.Err, tt.want)
+		}
+	}
+}
+
+func TestZapTotals(t *testing.T) {
+	at := func(s string) nostr.Timestamp {
+		tm, _ := time.Parse(time.DateTime, s+" 12:00:00")
+		return nostr.Timestamp(tm.Unix())
+	}
+	receipts := []*zapReceipt{
+		{Event: &nostr.Event{CreatedAt: at("2026-09-30")}, MSat: 21000},
+		{Event: &nostr.Event{CreatedAt: at("2026-10-01")}, MSat: 1000},
+		{Event: &nostr.Event{CreatedAt: at("2026-10-18")}, MSat: 5000},
+		{Event: &nostr.Event{CreatedAt: at("2026-10-18")}, MSat: 99000, Err: errors.New("invalid")},
+	}
+	totals, err := zapTotals(receipts, "month")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(totals) != 2 || totals[0] != (zapTotal{"2026-10", 2, 6}) || totals[1] != (zapTotal{"2026-09", 1, 21}) {
+		t.Errorf("totals=%v", totals)
+	}
+	if _, err := zapTotals(receipts, "fortnight"); err == nil {
+		t.Error("expected error for an unknown period")
+	}
+	if got, _ := zapPeriod(at("2026-10-18").Time().UTC(), "week"); got != "2026-W42" {
+		t.Errorf("week=%q", got)
+	}
+}